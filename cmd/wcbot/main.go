@@ -6,9 +6,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/BigOokie/skywire-wing-commander/internal/telegrambot"
 	"github.com/BigOokie/skywire-wing-commander/internal/utils"
@@ -18,6 +21,9 @@ import (
 
 var wc wcBotApp
 
+// stopTimeout is how long the updates and messages already queued have to be handled when terminating
+const stopTimeout = 30 * time.Second
+
 func main() {
 	// Setup and initialise application logging
 	wc.initLogging()
@@ -33,13 +39,35 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Run any requested maintenance mode and exit
+	if wc.cmdFlags.migrate {
+		os.Exit(wc.runMigrate())
+	}
+	if wc.cmdFlags.rotateMasterKey {
+		os.Exit(wc.runRotateMasterKey())
+	}
+	if wc.cmdFlags.verifyDerivations {
+		os.Exit(wc.runVerifyDerivations())
+	}
+
 	// Check and setup application instance control. Only allow a single instance to run
 	appInstance := utils.InitAppInstance(wcconst.AppInstanceID)
 	defer utils.ReleaseAppInstance(appInstance)
 
-	// Setup OS Notification for Interrupt or Kill signal - to cleanly terminate the app
-	osSignal := make(chan os.Signal, 1)
-	signal.Notify(osSignal, os.Interrupt)
+	// Setup OS Notification for Interrupt or Terminate signal - to cleanly terminate the app.
+	// A second signal exits without waiting for the Bot to shut down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	osSignal := make(chan os.Signal, 2)
+	signal.Notify(osSignal, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-osSignal
+		log.Infoln(wcconst.MsgOSInteruptSig, sig)
+		cancel()
+		sig = <-osSignal
+		log.Warnln(wcconst.MsgOSForcedExit, sig)
+		os.Exit(1)
+	}()
 
 	log.Infoln("Skywire Wing Commander Telegram Bot - Starting.")
 	defer log.Infoln("Skywire Wing Commander Telegram Bot - Stopped.")
@@ -70,10 +98,9 @@ func main() {
 		log.Fatalf("Failed to Send Main Menu: %v", err)
 	}
 
+	// Run the Bot until the app is signaled to terminate, and then shut it down
 	log.Infoln("Starting Bot instance.")
-	go bot.Start()
-
-	// Wait for the app to be signaled to terminate
-	signal := <-osSignal
-	log.Debugln(wcconst.MsgOSInteruptSig, signal)
+	if err := bot.Run(ctx, stopTimeout); err != nil {
+		log.Error(err)
+	}
 }
@@ -0,0 +1,218 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/keyvault"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	log "github.com/sirupsen/logrus"
+)
+
+// runMigrate applies any pending database schema migrations.
+// The returned value is used as the process exit code.
+func (ba *wcBotApp) runMigrate() int {
+	log.Debugln("wcBotApp.runMigrate: Start")
+	defer log.Debugln("wcBotApp.runMigrate: Complete")
+
+	s, err := store.Open(ba.config.SQLdatabase)
+	if err != nil {
+		log.Errorf("wcBotApp.runMigrate: Error opening database: %v", err)
+		return 1
+	}
+	defer s.Close()
+
+	applied, err := s.Migrate(context.Background())
+	if err != nil {
+		log.Errorf("wcBotApp.runMigrate: %v", err)
+		return 1
+	}
+
+	version, err := s.SchemaVersion(context.Background())
+	if err != nil {
+		log.Errorf("wcBotApp.runMigrate: Error reading schema version: %v", err)
+		return 1
+	}
+
+	fmt.Printf("Applied %d migration(s). Database schema version is %d.\n", applied, version)
+	return 0
+}
+
+// runRotateMasterKey re-encrypts every stored secret key and the wallet seed with a new master key.
+// The current master key is taken from the configuration. The new master key is read
+// from the file provided by -new-master-key-file, or from the master key environment
+// variable with a _NEW suffix. The returned value is used as the process exit code.
+func (ba *wcBotApp) runRotateMasterKey() int {
+	log.Debugln("wcBotApp.runRotateMasterKey: Start")
+	defer log.Debugln("wcBotApp.runRotateMasterKey: Complete")
+
+	oldVault, err := loadVault(ba.config.Wallet.MasterKeyEnv, ba.config.Wallet.MasterKeyFile)
+	if err != nil {
+		log.Errorf("wcBotApp.runRotateMasterKey: Error loading current master key: %v", err)
+		return 1
+	}
+
+	newEnv := ""
+	if ba.config.Wallet.MasterKeyEnv != "" {
+		newEnv = ba.config.Wallet.MasterKeyEnv + "_NEW"
+	}
+	newVault, err := loadVault(newEnv, ba.cmdFlags.newMasterKeyFile)
+	if err != nil {
+		log.Errorf("wcBotApp.runRotateMasterKey: Error loading new master key: %v", err)
+		return 1
+	}
+
+	// The re-encrypted seed is written next to the seed file and only moved into
+	// place once the secret keys have been re-encrypted
+	seedFile := ba.config.Wallet.SeedFile
+	newSeedFile := ""
+	if sealed, err := keyvault.ReadSeedFile(seedFile); err == nil {
+		seed, err := oldVault.OpenSeed(sealed)
+		if err != nil {
+			log.Errorf("wcBotApp.runRotateMasterKey: Error decrypting wallet seed: %v", err)
+			return 1
+		}
+		if sealed, err = newVault.SealSeed(seed); err == nil {
+			newSeedFile = seedFile + ".new"
+			err = keyvault.WriteSeedFile(newSeedFile, sealed)
+		}
+		if err != nil {
+			log.Errorf("wcBotApp.runRotateMasterKey: Error encrypting wallet seed: %v", err)
+			return 1
+		}
+	} else if !os.IsNotExist(err) {
+		log.Errorf("wcBotApp.runRotateMasterKey: Error reading wallet seed: %v", err)
+		return 1
+	}
+
+	s, err := store.Open(ba.config.SQLdatabase)
+	if err == nil {
+		defer s.Close()
+		_, err = s.Migrate(context.Background())
+	}
+
+	// Check the two-factor secrets can be opened before any key is changed, as they are
+	// re-encrypted in a separate transaction
+	if err == nil {
+		_, err = s.TwoFactor().RewriteSecrets(context.Background(), func(id, secret string) (string, error) {
+			_, err := oldVault.Open(secret, id)
+			return secret, err
+		})
+	}
+
+	rotated := 0
+	if err == nil {
+		rotated, err = keyvault.RotateSecretKeys(context.Background(), s.Addresses(), oldVault, newVault)
+	}
+	rotatedTwoFactor := 0
+	if err == nil {
+		rotatedTwoFactor, err = keyvault.RotateTwoFactorSecrets(context.Background(), s.TwoFactor(), oldVault, newVault)
+		if err != nil {
+			log.Errorf("wcBotApp.runRotateMasterKey: The secret keys were re-encrypted but the two-factor secrets could not be: %v", err)
+			return 1
+		}
+	}
+	if err != nil {
+		if newSeedFile != "" {
+			os.Remove(newSeedFile)
+		}
+		log.Errorf("wcBotApp.runRotateMasterKey: No keys were changed: %v", err)
+		return 1
+	}
+
+	if newSeedFile != "" {
+		if err := os.Rename(newSeedFile, seedFile); err != nil {
+			log.Errorf("wcBotApp.runRotateMasterKey: The secret keys were re-encrypted but the wallet seed could not be replaced, move %s to %s: %v",
+				newSeedFile, seedFile, err)
+			return 1
+		}
+		fmt.Printf("Re-encrypted the wallet seed in %s.\n", seedFile)
+	}
+
+	fmt.Printf("Re-encrypted %d secret key(s) and %d two-factor secret(s). Configure the new master key before starting the Bot.\n",
+		rotated, rotatedTwoFactor)
+	return 0
+}
+
+// runVerifyDerivations checks every address derived from the wallet seed matches the
+// address stored in the database. The returned value is used as the process exit code.
+func (ba *wcBotApp) runVerifyDerivations() int {
+	log.Debugln("wcBotApp.runVerifyDerivations: Start")
+	defer log.Debugln("wcBotApp.runVerifyDerivations: Complete")
+
+	vault, err := loadVault(ba.config.Wallet.MasterKeyEnv, ba.config.Wallet.MasterKeyFile)
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error loading master key: %v", err)
+		return 1
+	}
+
+	sealed, err := keyvault.ReadSeedFile(ba.config.Wallet.SeedFile)
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error reading wallet seed: %v", err)
+		return 1
+	}
+	seed, err := vault.OpenSeed(sealed)
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error decrypting wallet seed: %v", err)
+		return 1
+	}
+
+	s, err := store.Open(ba.config.SQLdatabase)
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error opening database: %v", err)
+		return 1
+	}
+	defer s.Close()
+
+	// Only read from the database, it must already be up to date
+	version, err := s.SchemaVersion(context.Background())
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error reading schema version: %v", err)
+		return 1
+	}
+	if version < store.LatestSchemaVersion {
+		log.Errorf("wcBotApp.runVerifyDerivations: Database schema version %d is out of date, run wcbot -migrate first", version)
+		return 1
+	}
+
+	derived, err := s.Addresses().ListDerived(context.Background())
+	if err != nil {
+		log.Errorf("wcBotApp.runVerifyDerivations: Error reading addresses: %v", err)
+		return 1
+	}
+
+	mismatches := 0
+	for _, a := range derived {
+		addr, err := wallet.DeriveAddress(seed, a.DerivationIndex)
+		if err != nil {
+			log.Errorf("wcBotApp.runVerifyDerivations: Error deriving index %d: %v", a.DerivationIndex, err)
+			return 1
+		}
+		if addr.Address != a.Address || addr.PublicKey != a.PublicKey {
+			mismatches++
+			fmt.Printf("Mismatch at index %d (user %d): stored %s, derived %s\n", a.DerivationIndex, a.UserID, a.Address, addr.Address)
+		}
+	}
+
+	fmt.Printf("Verified %d derived address(es), %d mismatch(es).\n", len(derived), mismatches)
+	if mismatches > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadVault creates a Vault using the master key read from the environment variable or key file
+func loadVault(envName, keyFile string) (*keyvault.Vault, error) {
+	masterKey, err := keyvault.LoadMasterKey(envName, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return keyvault.New(masterKey)
+}
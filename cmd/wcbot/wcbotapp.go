@@ -18,11 +18,15 @@ import (
 )
 
 type cmdlineFlags struct {
-	dumpconfig       bool
-	version          bool
-	help             bool
-	about            bool
-	upgradecompleted bool
+	dumpconfig        bool
+	version           bool
+	help              bool
+	about             bool
+	upgradecompleted  bool
+	migrate           bool
+	rotateMasterKey   bool
+	newMasterKeyFile  string
+	verifyDerivations bool
 }
 
 type wcBotApp struct {
@@ -37,13 +41,57 @@ func (ba *wcBotApp) loadConfig() {
 	defer log.Debugln("wcBotApp.loadConfig: Complete")
 	// Load configuration
 	c, err := wcconfig.LoadConfigParameters("config", filepath.Join(utils.UserHome(), ".wingcommander"), map[string]interface{}{
-		"wingcommander.analyticsenabled": true,
-		"telegram.debug":                 false,
-		"monitor.intervalsec":            10,
-		"monitor.heartbeatintmin":        120,
-		"monitor.discoverymonitorintmin": 120,
-		"skymanager.address":             "127.0.0.1:8000",
-		"skymanager.discoveryaddress":    "discovery.skycoin.net:8001",
+		"wingcommander.analyticsenabled":  true,
+		"telegram.debug":                  false,
+		"telegram.workers":                8,
+		"telegram.queuesize":              1000,
+		"telegram.chatqueuesize":          20,
+		"telegram.handlertimeoutsec":      60,
+		"telegram.webhook.enabled":        false,
+		"telegram.webhook.listen":         "127.0.0.1:8443",
+		"telegram.webhook.maxconnections": 40,
+		"telegram.outbox.globalpersec":    30,
+		"telegram.outbox.chatpersec":      1,
+		"telegram.outbox.grouppermin":     20,
+		"telegram.outbox.burst":           3,
+		"telegram.outbox.maxattempts":     5,
+		"telegram.outbox.queuesize":       1000,
+		"monitor.intervalsec":             10,
+		"monitor.heartbeatintmin":         120,
+		"monitor.discoverymonitorintmin":  120,
+		"deposits.confirmations":          3,
+		"deposits.intervalsec":            30,
+		"limits.mintip":                   "0.001",
+		"limits.maxtip":                   "0.1",
+		"limits.dailycap":                 "10",
+		"limits.hourlyoutflowcap":         "100",
+		"limits.tipsperminute":            5,
+		"twofactor.issuer":                "Wing Commander",
+		"twofactor.largetip":              "1",
+		"twofactor.maxfailures":           5,
+		"twofactor.lockoutmin":            15,
+		"twofactor.adminsessionmin":       10,
+		"skymanager.address":              "127.0.0.1:8000",
+		"skymanager.discoveryaddress":     "discovery.skycoin.net:8001",
+		"skymanager.timeoutsec":           30,
+		"wallet.backend":                  "node",
+		"wallet.clipath":                  "skycoin-cli",
+		"wallet.clitimeoutsec":            30,
+		"wallet.masterkeyenv":             "WINGCOMMANDER_MASTER_KEY",
+		"wallet.masterkeyfile":            filepath.Join(utils.UserHome(), ".wingcommander", "master.key"),
+		"wallet.seedfile":                 filepath.Join(utils.UserHome(), ".wingcommander", "wallet.seed"),
+		"skycoinnode.address":             "http://127.0.0.1:6420",
+		"skycoinnode.timeoutsec":          30,
+		"sqldatabase.driver":              "postgres",
+		"sqldatabase.host":                "localhost",
+		"sqldatabase.port":                5432,
+		"sqldatabase.user":                "postgres",
+		"sqldatabase.dbname":              "skycoinbot",
+		"sqldatabase.sslmode":             "disable",
+		"sqldatabase.path":                filepath.Join(utils.UserHome(), ".wingcommander", "wingcommander.db"),
+		"sqldatabase.maxopenconns":        10,
+		"sqldatabase.maxidleconns":        5,
+		"sqldatabase.connmaxlifetimemin":  30,
 	})
 
 	if err != nil {
@@ -59,6 +107,10 @@ func (cf *cmdlineFlags) parseCmdLineFlags() {
 	flag.BoolVar(&cf.help, "help", false, "print application help")
 	flag.BoolVar(&cf.about, "about", false, "print application information")
 	flag.BoolVar(&cf.upgradecompleted, "upgradecompleted", false, "signals the application has been restarted following an upgrade")
+	flag.BoolVar(&cf.migrate, "migrate", false, "apply database schema migrations and exit")
+	flag.BoolVar(&cf.rotateMasterKey, "rotate-master-key", false, "re-encrypt every stored secret key with a new master key and exit")
+	flag.StringVar(&cf.newMasterKeyFile, "new-master-key-file", "", "file holding the new master key used by -rotate-master-key")
+	flag.BoolVar(&cf.verifyDerivations, "verify-derivations", false, "check every stored address matches the address derived from the wallet seed and exit")
 
 	flag.Parse()
 }
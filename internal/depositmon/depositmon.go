@@ -0,0 +1,268 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package depositmon watches the addresses of the Bot users for incoming SKY
+// and credits confirmed deposits to the ledger.
+package depositmon
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	log "github.com/sirupsen/logrus"
+)
+
+// userPageSize is the number of users whose addresses are checked in a single request
+const userPageSize = 100
+
+// Deposit models a deposit which has been credited to the ledger
+type Deposit struct {
+	UserID  int64
+	ChatID  int64
+	Address string
+	TxID    string
+	Coins   uint64
+	Hours   uint64
+}
+
+// DepositMonitor polls the wallet backend for the outputs held by the user addresses.
+// Each output is credited to the ledger once it has the required number of confirmations,
+// except the change of withdrawals.
+// The ledger rejects a second transfer for the same output, so restarting never credits an
+// output twice. The outputs credited are remembered until they are spent, so they aren't
+// checked against the store on every poll.
+type DepositMonitor struct {
+	store         store.Store
+	wallet        wallet.WalletBackend
+	confirmations uint64
+	cancelFunc    func()
+	credited      map[string]bool
+	m             sync.Mutex
+}
+
+// NewMonitor creates a DepositMonitor which credits outputs with at least confirmations confirmations
+func NewMonitor(s store.Store, w wallet.WalletBackend, confirmations uint64) *DepositMonitor {
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	return &DepositMonitor{
+		store:         s,
+		wallet:        w,
+		confirmations: confirmations,
+		credited:      make(map[string]bool),
+	}
+}
+
+// SetCancelFunc is a thread-safe function for setting the cancelFunc
+// on the DepositMonitor struct
+func (dm *DepositMonitor) SetCancelFunc(cf func()) {
+	dm.m.Lock()
+	defer dm.m.Unlock()
+	dm.cancelFunc = cf
+}
+
+// GetCancelFunc is a thread-safe function for accessing (getting) the
+// value of cancelFunc on the DepositMonitor struct
+func (dm *DepositMonitor) GetCancelFunc() func() {
+	dm.m.Lock()
+	defer dm.m.Unlock()
+	return dm.cancelFunc
+}
+
+// IsRunning determines if the DepositMonitor is running or not.
+func (dm *DepositMonitor) IsRunning() bool {
+	return dm.GetCancelFunc() != nil
+}
+
+// RunDepositMonitor starts polling the user addresses for deposits. Credited deposits are
+// sent to depositChan. The monitor stops when it receives the runctx.Done() signal.
+func (dm *DepositMonitor) RunDepositMonitor(runctx context.Context, doCancelFunc func(), depositChan chan<- Deposit, pollInt time.Duration) {
+	log.Debugf("DepositMonitor.RunDepositMonitor: Start (Interval: %v, Confirmations: %d)", pollInt, dm.confirmations)
+	defer log.Debugln("DepositMonitor.RunDepositMonitor: End")
+
+	dm.SetCancelFunc(doCancelFunc)
+	defer dm.SetCancelFunc(nil)
+
+	ticker := time.NewTicker(pollInt)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deposits, err := dm.Poll(runctx)
+			if err != nil {
+				log.Errorf("DepositMonitor.RunDepositMonitor: %v", err)
+			}
+			for _, d := range deposits {
+				select {
+				case depositChan <- d:
+				case <-runctx.Done():
+					return
+				}
+			}
+		case <-runctx.Done():
+			log.Debugln("DepositMonitor.RunDepositMonitor: Done Event.")
+			return
+		}
+	}
+}
+
+// StopDepositMonitor stops the DepositMonitor. The deposit channel is left open,
+// as it is owned by the caller of RunDepositMonitor.
+func (dm *DepositMonitor) StopDepositMonitor() {
+	if cf := dm.GetCancelFunc(); cf != nil {
+		cf()
+	}
+}
+
+// Poll checks the addresses of every user once and credits the outputs which have
+// enough confirmations. The credited deposits are returned.
+func (dm *DepositMonitor) Poll(ctx context.Context) ([]Deposit, error) {
+	var deposits []Deposit
+	credited := make(map[string]bool)
+	for offset := 0; ; offset += userPageSize {
+		users, err := dm.store.Users().List(ctx, offset, userPageSize)
+		if err != nil {
+			dm.rememberCredited(credited, false)
+			return deposits, err
+		}
+
+		found, err := dm.pollUsers(ctx, users, credited)
+		deposits = append(deposits, found...)
+		if err != nil {
+			dm.rememberCredited(credited, false)
+			return deposits, err
+		}
+		if len(users) < userPageSize {
+			dm.rememberCredited(credited, true)
+			return deposits, nil
+		}
+	}
+}
+
+// pollUsers checks the addresses of the provided users. The outputs they hold which
+// have been credited are added to credited.
+func (dm *DepositMonitor) pollUsers(ctx context.Context, users []store.User, credited map[string]bool) ([]Deposit, error) {
+	owners := make(map[string]*store.User, len(users))
+	addrs := make([]string, 0, len(users))
+	for i := range users {
+		if users[i].Address == "" {
+			continue
+		}
+		owners[users[i].Address] = &users[i]
+		addrs = append(addrs, users[i].Address)
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+
+	outputs, err := dm.wallet.GetOutputs(ctx, addrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var deposits []Deposit
+	for _, out := range outputs.Outputs {
+		owner, found := owners[out.Address]
+		if !found || outputs.Confirmations(out) < dm.confirmations {
+			continue
+		}
+		if dm.isCredited(out.Hash) {
+			credited[out.Hash] = true
+			continue
+		}
+
+		// The change of a withdrawal is returned to a user address, it isn't a deposit
+		if isChange, err := isWithdrawalChange(ctx, dm.store, out); err != nil {
+			return deposits, err
+		} else if isChange {
+			credited[out.Hash] = true
+			continue
+		}
+
+		err := dm.store.Ledger().Transfer(ctx, &store.Transfer{
+			Kind:      store.TransferDeposit,
+			From:      store.AccountOnChain,
+			To:        store.UserAccount(owner.ID),
+			Amount:    out.Coins,
+			Memo:      out.TxID,
+			Reference: outputReference(out.Hash),
+		})
+		if err == store.ErrDuplicate {
+			// Credited before a restart
+			credited[out.Hash] = true
+			continue
+		} else if err != nil {
+			return deposits, err
+		}
+
+		credited[out.Hash] = true
+		log.Infof("DepositMonitor.pollUsers: Credited %s SKY to %s (output: %s, txid: %s)",
+			wallet.FormatDroplets(out.Coins), owner.UserName, out.Hash, out.TxID)
+		deposits = append(deposits, Deposit{
+			UserID:  owner.ID,
+			ChatID:  owner.ChatID,
+			Address: out.Address,
+			TxID:    out.TxID,
+			Coins:   out.Coins,
+			Hours:   out.Hours,
+		})
+	}
+	return deposits, nil
+}
+
+// Spendable reports whether a withdrawal can spend the output. Only outputs which have been
+// credited to the ledger, or are the change of a withdrawal, can be spent: a deposit spent
+// before it has been credited would never be credited. Outputs already spent by an
+// unconfirmed transaction can't be spent again.
+func Spendable(ctx context.Context, s store.Store, out wallet.Output) (bool, error) {
+	if out.Spending {
+		return false, nil
+	}
+	credited, err := s.Ledger().HasReference(ctx, outputReference(out.Hash))
+	if err != nil || credited {
+		return credited, err
+	}
+	return isWithdrawalChange(ctx, s, out)
+}
+
+// isWithdrawalChange reports whether the output is the change of a withdrawal, which is
+// returned to the address the withdrawal was made from
+func isWithdrawalChange(ctx context.Context, s store.Store, out wallet.Output) (bool, error) {
+	t, err := s.Transactions().GetByTxID(ctx, out.TxID)
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return t.Kind == store.TxKindWithdrawal && t.FromAddress == out.Address, nil
+}
+
+// outputReference returns the ledger reference of a deposited output
+func outputReference(hash string) string {
+	return "output:" + hash
+}
+
+func (dm *DepositMonitor) isCredited(hash string) bool {
+	dm.m.Lock()
+	defer dm.m.Unlock()
+	return dm.credited[hash]
+}
+
+// rememberCredited records the credited outputs found by a poll. The outputs found by a
+// complete poll replace the outputs remembered before, so spent outputs are forgotten.
+func (dm *DepositMonitor) rememberCredited(credited map[string]bool, complete bool) {
+	dm.m.Lock()
+	defer dm.m.Unlock()
+	if complete {
+		dm.credited = credited
+		return
+	}
+	for hash := range credited {
+		dm.credited[hash] = true
+	}
+}
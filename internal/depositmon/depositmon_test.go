@@ -0,0 +1,183 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package depositmon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+)
+
+func newTestMonitor(t *testing.T) (*DepositMonitor, store.Store, *wallet.FakeBackend) {
+	s := store.NewMemoryStore()
+	for n, addr := range []string{"addr1", "addr2"} {
+		err := s.Users().Create(context.Background(), &store.User{
+			TelegramID: n + 1,
+			ChatID:     int64(n + 1),
+			Address:    addr,
+			PublicKey:  "pub" + addr,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := wallet.NewFakeBackend()
+	return NewMonitor(s, fake, 3), s, fake
+}
+
+func Test_DepositMonitor_Poll(t *testing.T) {
+	ctx := context.Background()
+	dm, s, fake := newTestMonitor(t)
+
+	fake.SetHeadSeq(10)
+	fake.AddOutput(wallet.Output{Hash: "out1", TxID: "tx1", Address: "addr1", Coins: 2000000, BlockSeq: 8})
+	fake.AddOutput(wallet.Output{Hash: "out2", TxID: "tx2", Address: "addr2", Coins: 1000000, BlockSeq: 9})
+	fake.AddOutput(wallet.Output{Hash: "out3", TxID: "tx3", Address: "unknown", Coins: 1000000, BlockSeq: 1})
+
+	deposits, err := dm.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 1 {
+		t.Fatalf("Expected 1 deposit, got %+v", deposits)
+	}
+	if d := deposits[0]; d.UserID != 1 || d.ChatID != 1 || d.TxID != "tx1" || d.Coins != 2000000 {
+		t.Errorf("Unexpected deposit: %+v", d)
+	}
+
+	// The second output is credited once it has enough confirmations
+	fake.SetHeadSeq(11)
+	deposits, err = dm.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deposits) != 1 || deposits[0].TxID != "tx2" {
+		t.Fatalf("Unexpected deposits: %+v", deposits)
+	}
+
+	for id, expect := range map[int64]int64{1: 2000000, 2: 1000000} {
+		if balance, _ := s.Ledger().Balance(ctx, store.UserAccount(id)); balance != expect {
+			t.Errorf("Expected user %d balance %d, got %d", id, expect, balance)
+		}
+	}
+}
+
+func Test_DepositMonitor_Restart(t *testing.T) {
+	ctx := context.Background()
+	dm, s, fake := newTestMonitor(t)
+
+	fake.SetHeadSeq(10)
+	fake.AddOutput(wallet.Output{Hash: "out1", TxID: "tx1", Address: "addr1", Coins: 2000000, BlockSeq: 1})
+	if deposits, err := dm.Poll(ctx); err != nil || len(deposits) != 1 {
+		t.Fatalf("Unexpected deposits %+v: %v", deposits, err)
+	}
+
+	// A new monitor has no memory of the credited outputs
+	restarted := NewMonitor(s, fake, 3)
+	if deposits, err := restarted.Poll(ctx); err != nil || len(deposits) != 0 {
+		t.Fatalf("Expected no deposits after a restart, got %+v: %v", deposits, err)
+	}
+	if balance, _ := s.Ledger().Balance(ctx, store.UserAccount(1)); balance != 2000000 {
+		t.Errorf("Expected the output to be credited once, balance is %d", balance)
+	}
+}
+
+func Test_DepositMonitor_ForgetsSpentOutputs(t *testing.T) {
+	ctx := context.Background()
+	dm, _, fake := newTestMonitor(t)
+
+	fake.SetHeadSeq(10)
+	fake.AddOutput(wallet.Output{Hash: "out1", TxID: "tx1", Address: "addr1", Coins: 2000000, BlockSeq: 1})
+	fake.SetBalance("addr1", wallet.Balance{Coins: 2000000})
+	if deposits, err := dm.Poll(ctx); err != nil || len(deposits) != 1 {
+		t.Fatalf("Unexpected deposits %+v: %v", deposits, err)
+	}
+	if !dm.isCredited("out1") {
+		t.Fatal("Expected the output to be remembered as credited")
+	}
+
+	// Spend the output
+	tx, err := fake.CreateTransaction(ctx, wallet.TxRequest{
+		From:    []wallet.Address{{Address: "addr1"}},
+		To:      "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+		Coins:   2000000,
+		Outputs: []string{"out1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.BroadcastTransaction(ctx, tx.RawTx); err != nil {
+		t.Fatal(err)
+	}
+
+	if deposits, err := dm.Poll(ctx); err != nil || len(deposits) != 0 {
+		t.Fatalf("Unexpected deposits %+v: %v", deposits, err)
+	}
+	if dm.isCredited("out1") {
+		t.Error("Expected the spent output to be forgotten")
+	}
+}
+
+func Test_DepositMonitor_Run(t *testing.T) {
+	dm, _, fake := newTestMonitor(t)
+	fake.SetHeadSeq(10)
+	fake.AddOutput(wallet.Output{Hash: "out1", TxID: "tx1", Address: "addr1", Coins: 2000000, BlockSeq: 1})
+
+	runctx, cancel := context.WithCancel(context.Background())
+	depositChan := make(chan Deposit)
+	done := make(chan struct{})
+	go func() {
+		dm.RunDepositMonitor(runctx, cancel, depositChan, 10*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case d := <-depositChan:
+		if d.TxID != "tx1" {
+			t.Errorf("Unexpected deposit: %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a deposit")
+	}
+
+	dm.StopDepositMonitor()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the monitor to stop")
+	}
+	if dm.IsRunning() {
+		t.Error("Expected the monitor to be stopped")
+	}
+}
+
+func Test_DepositMonitor_WithdrawalChange(t *testing.T) {
+	ctx := context.Background()
+	dm, s, fake := newTestMonitor(t)
+
+	err := s.Transactions().Create(ctx, &store.Transaction{
+		UserID:      1,
+		Kind:        store.TxKindWithdrawal,
+		Status:      store.TxStatusBroadcast,
+		TxID:        "withdrawaltx",
+		FromAddress: "addr1",
+		Coins:       1000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.SetHeadSeq(10)
+	fake.AddOutput(wallet.Output{Hash: "change", TxID: "withdrawaltx", Address: "addr1", Coins: 5000000, BlockSeq: 1})
+	if deposits, err := dm.Poll(ctx); err != nil || len(deposits) != 0 {
+		t.Fatalf("Expected the change not to be credited, got %+v: %v", deposits, err)
+	}
+	if balance, _ := s.Ledger().Balance(ctx, store.UserAccount(1)); balance != 0 {
+		t.Errorf("Unexpected balance: %d", balance)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package keyvault encrypts the secret keys held by the Bot using AES-256-GCM
+// and a master key which is never stored in the database.
+package keyvault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// MasterKeySize is the size (in bytes) of the master key
+	MasterKeySize = 32
+	// sealedPrefix identifies values sealed by this version of the vault
+	sealedPrefix = "v1:"
+)
+
+var (
+	// ErrDecrypt is returned when a sealed value can't be opened, either because
+	// it was sealed with a different master key or it has been tampered with
+	ErrDecrypt = errors.New("keyvault: unable to decrypt, wrong master key or corrupt data")
+	// ErrNoMasterKey is returned when no master key has been configured
+	ErrNoMasterKey = errors.New("keyvault: no master key configured")
+)
+
+// Vault seals and opens secrets using a master key
+type Vault struct {
+	aead      cipher.AEAD
+	deriveKey []byte
+}
+
+// New creates a Vault using the provided master key, which must be MasterKeySize bytes
+func New(masterKey []byte) (*Vault, error) {
+	if len(masterKey) != MasterKeySize {
+		return nil, fmt.Errorf("keyvault: master key must be %d bytes, got %d", MasterKeySize, len(masterKey))
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{aead: aead, deriveKey: hmacSHA256(masterKey, "keyvault-derive")}, nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// DeriveKey returns a 32 byte key for the provided purpose (i.e. signing the data of
+// Telegram buttons), derived from the master key. The same master key and purpose always
+// give the same key, and the master key can't be recovered from a derived key.
+func (v *Vault) DeriveKey(purpose string) []byte {
+	return hmacSHA256(v.deriveKey, purpose)
+}
+
+// Seal encrypts the secret. The additional data (i.e. the address the secret key belongs to)
+// is authenticated but not encrypted, so a sealed secret can't be moved to another address.
+func (v *Vault) Seal(secret, additionalData string) (string, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := v.aead.Seal(nonce, nonce, []byte(secret), []byte(additionalData))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed using Seal with the same additional data
+func (v *Vault) Open(sealed, additionalData string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrDecrypt
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil || len(b) < v.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+
+	nonce, ciphertext := b[:v.aead.NonceSize()], b[v.aead.NonceSize():]
+	secret, err := v.aead.Open(nil, nonce, ciphertext, []byte(additionalData))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(secret), nil
+}
+
+// IsSealed reports whether the value has been sealed by a Vault (as opposed to a
+// legacy plaintext secret)
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// ParseMasterKey decodes a master key provided as hex (i.e. `openssl rand -hex 32`)
+// or base64 (i.e. `openssl rand -base64 32`)
+func ParseMasterKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == MasterKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == MasterKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("keyvault: master key must be %d bytes encoded as hex or base64", MasterKeySize)
+}
+
+// LoadMasterKey reads the master key from the environment variable envName. If the
+// variable is not set the key is read from the file keyFile.
+func LoadMasterKey(envName, keyFile string) ([]byte, error) {
+	if envName != "" {
+		if encoded := os.Getenv(envName); encoded != "" {
+			return ParseMasterKey(encoded)
+		}
+	}
+
+	if keyFile == "" {
+		return nil, ErrNoMasterKey
+	}
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("keyvault: unable to read master key file: %v", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("keyvault: master key file %s must not be accessible by other users (chmod 600)", keyFile)
+	}
+
+	encoded, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("keyvault: unable to read master key file: %v", err)
+	}
+	return ParseMasterKey(string(encoded))
+}
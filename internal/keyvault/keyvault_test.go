@@ -0,0 +1,204 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package keyvault
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, MasterKeySize)
+
+func Test_SealOpen(t *testing.T) {
+	v, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := v.Seal("secret", "addr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "secret") {
+		t.Errorf("Unexpected sealed value: %s", sealed)
+	}
+
+	secret, err := v.Open(sealed, "addr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "secret" {
+		t.Errorf("Unexpected secret: %s", secret)
+	}
+
+	// Each seal uses a new nonce
+	if again, _ := v.Seal("secret", "addr1"); again == sealed {
+		t.Error("Expected a different sealed value")
+	}
+}
+
+func Test_Open_Failures(t *testing.T) {
+	v, _ := New(testKey)
+	sealed, _ := v.Seal("secret", "addr1")
+
+	if _, err := v.Open(sealed, "addr2"); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for wrong additional data, got %v", err)
+	}
+
+	other, _ := New(bytes.Repeat([]byte{0x24}, MasterKeySize))
+	if _, err := other.Open(sealed, "addr1"); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt for wrong master key, got %v", err)
+	}
+
+	for _, bad := range []string{"secret", "v1:", "v1:!!!", sealed[:len(sealed)-4]} {
+		if _, err := v.Open(bad, "addr1"); err != ErrDecrypt {
+			t.Errorf("Expected ErrDecrypt for %q, got %v", bad, err)
+		}
+	}
+}
+
+func Test_New_InvalidKeySize(t *testing.T) {
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("Expected an error for a short master key")
+	}
+}
+
+func Test_DeriveKey(t *testing.T) {
+	v1, _ := New(testKey)
+	v2, _ := New(testKey)
+	other, _ := New(bytes.Repeat([]byte{0x43}, MasterKeySize))
+
+	key := v1.DeriveKey("callbacks")
+	if len(key) != 32 || !bytes.Equal(key, v2.DeriveKey("callbacks")) {
+		t.Errorf("Expected the same 32 byte key from the same master key, got %x", key)
+	}
+	if bytes.Equal(key, v1.DeriveKey("other")) || bytes.Equal(key, other.DeriveKey("callbacks")) {
+		t.Error("Expected different keys for a different purpose or master key")
+	}
+	if bytes.Equal(key, testKey) {
+		t.Error("Expected the derived key not to be the master key")
+	}
+}
+
+func Test_ParseMasterKey(t *testing.T) {
+	hexKey := strings.Repeat("42", MasterKeySize)
+	key, err := ParseMasterKey(hexKey + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Error("Unexpected hex master key")
+	}
+
+	key, err = ParseMasterKey("QkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkI=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Error("Unexpected base64 master key")
+	}
+
+	if _, err := ParseMasterKey("4242"); err == nil {
+		t.Error("Expected an error for a short master key")
+	}
+}
+
+func Test_LoadMasterKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyvault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "master.key")
+	if err := ioutil.WriteFile(keyFile, []byte(strings.Repeat("42", MasterKeySize)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadMasterKey("WC_TEST_MASTER_KEY_UNSET", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, testKey) {
+		t.Error("Unexpected master key from file")
+	}
+
+	os.Setenv("WC_TEST_MASTER_KEY", strings.Repeat("24", MasterKeySize))
+	defer os.Unsetenv("WC_TEST_MASTER_KEY")
+	key, err = LoadMasterKey("WC_TEST_MASTER_KEY", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key[0] != 0x24 {
+		t.Error("Expected the environment variable to take precedence")
+	}
+
+	if _, err := LoadMasterKey("", ""); err != ErrNoMasterKey {
+		t.Errorf("Expected ErrNoMasterKey, got %v", err)
+	}
+
+	if err := os.Chmod(keyFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMasterKey("", keyFile); err == nil {
+		t.Error("Expected an error for a world readable key file")
+	}
+}
+
+func Test_SeedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyvault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	seedFile := filepath.Join(dir, "wallet", "wallet.seed")
+	if _, err := ReadSeedFile(seedFile); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error, got %v", err)
+	}
+
+	v, _ := New(testKey)
+	sealed, err := v.SealSeed("my seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteSeedFile(seedFile, sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(seedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected seed file mode: %v", info.Mode())
+	}
+
+	read, err := ReadSeedFile(seedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := v.OpenSeed(read)
+	if err != nil || seed != "my seed" {
+		t.Errorf("Unexpected seed %q: %v", seed, err)
+	}
+
+	// A sealed secret key can't be used as the seed
+	sealedKey, _ := v.Seal("my seed", "addr1")
+	if _, err := v.OpenSeed(sealedKey); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(seedFile, []byte("my seed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSeedFile(seedFile); err == nil {
+		t.Error("Expected an error for a plaintext seed file")
+	}
+}
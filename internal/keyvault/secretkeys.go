@@ -0,0 +1,65 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package keyvault
+
+import (
+	"context"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+)
+
+// SealSecretKeys seals any plaintext (legacy) secret keys held by the store.
+// The first key which is already sealed is opened to check v holds the same master
+// key, otherwise ErrDecrypt is returned and no key is changed. Returns the number
+// of keys sealed.
+func SealSecretKeys(ctx context.Context, addresses store.AddressRepository, v *Vault) (int, error) {
+	checked := false
+	return addresses.RewriteSecretKeys(ctx, func(address, secretKey string) (string, error) {
+		if secretKey == "" {
+			return secretKey, nil
+		}
+		if !IsSealed(secretKey) {
+			return v.Seal(secretKey, address)
+		}
+		if !checked {
+			if _, err := v.Open(secretKey, address); err != nil {
+				return "", err
+			}
+			checked = true
+		}
+		return secretKey, nil
+	})
+}
+
+// RotateSecretKeys seals every secret key held by the store with newVault. Sealed keys
+// are opened using oldVault and plaintext (legacy) keys are sealed as well. If any key
+// can't be opened no key is changed. Returns the number of keys sealed.
+func RotateSecretKeys(ctx context.Context, addresses store.AddressRepository, oldVault, newVault *Vault) (int, error) {
+	return addresses.RewriteSecretKeys(ctx, rotate(oldVault, newVault))
+}
+
+// RotateTwoFactorSecrets seals every two-factor secret held by the store with newVault.
+// If any secret can't be opened using oldVault no secret is changed. Returns the number
+// of secrets sealed.
+func RotateTwoFactorSecrets(ctx context.Context, twoFactor store.TwoFactorRepository, oldVault, newVault *Vault) (int, error) {
+	return twoFactor.RewriteSecrets(ctx, rotate(oldVault, newVault))
+}
+
+// rotate returns a SecretKeyRewriter which opens secrets using oldVault and seals them using newVault
+func rotate(oldVault, newVault *Vault) store.SecretKeyRewriter {
+	return func(address, secretKey string) (string, error) {
+		if secretKey == "" {
+			return secretKey, nil
+		}
+		if IsSealed(secretKey) {
+			var err error
+			if secretKey, err = oldVault.Open(secretKey, address); err != nil {
+				return "", err
+			}
+		}
+		return newVault.Seal(secretKey, address)
+	}
+}
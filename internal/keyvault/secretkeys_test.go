@@ -0,0 +1,131 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package keyvault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+)
+
+func newTestStore(t *testing.T, secretKeys ...string) store.Store {
+	s := store.NewMemoryStore()
+	for n, secretKey := range secretKeys {
+		err := s.Users().Create(context.Background(), &store.User{
+			TelegramID: n + 1,
+			Address:    fmt.Sprintf("addr%d", n+1),
+			PublicKey:  fmt.Sprintf("pub%d", n+1),
+			SecretKey:  secretKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func secretKeyOf(t *testing.T, s store.Store, telegramID int) string {
+	u, err := s.Users().GetByTelegramID(context.Background(), telegramID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.SecretKey
+}
+
+func Test_SealSecretKeys(t *testing.T) {
+	ctx := context.Background()
+	v, _ := New(testKey)
+	sealed, _ := v.Seal("sec1", "addr1")
+	s := newTestStore(t, sealed, "sec2")
+
+	n, err := SealSecretKeys(ctx, s.Addresses(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 sealed key, got %d", n)
+	}
+	if secretKeyOf(t, s, 1) != sealed {
+		t.Error("Expected sealed key to be unchanged")
+	}
+	if secret, err := v.Open(secretKeyOf(t, s, 2), "addr2"); err != nil || secret != "sec2" {
+		t.Errorf("Unexpected secret %q: %v", secret, err)
+	}
+
+	// Nothing left to seal
+	if n, err := SealSecretKeys(ctx, s.Addresses(), v); err != nil || n != 0 {
+		t.Errorf("Expected nothing to seal, got %d: %v", n, err)
+	}
+}
+
+func Test_SealSecretKeys_WrongMasterKey(t *testing.T) {
+	v, _ := New(testKey)
+	sealed, _ := v.Seal("sec1", "addr1")
+	s := newTestStore(t, sealed, "sec2")
+
+	other, _ := New(bytes.Repeat([]byte{0x24}, MasterKeySize))
+	if _, err := SealSecretKeys(context.Background(), s.Addresses(), other); err != ErrDecrypt {
+		t.Fatalf("Expected ErrDecrypt, got %v", err)
+	}
+	if secretKeyOf(t, s, 2) != "sec2" {
+		t.Error("Expected no key to be changed")
+	}
+}
+
+func Test_RotateSecretKeys(t *testing.T) {
+	ctx := context.Background()
+	oldVault, _ := New(testKey)
+	newVault, _ := New(bytes.Repeat([]byte{0x24}, MasterKeySize))
+	sealed, _ := oldVault.Seal("sec1", "addr1")
+	s := newTestStore(t, sealed, "sec2")
+
+	n, err := RotateSecretKeys(ctx, s.Addresses(), oldVault, newVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 sealed keys, got %d", n)
+	}
+	for id, expect := range map[int]string{1: "sec1", 2: "sec2"} {
+		secret, err := newVault.Open(secretKeyOf(t, s, id), fmt.Sprintf("addr%d", id))
+		if err != nil || secret != expect {
+			t.Errorf("Unexpected secret %q: %v", secret, err)
+		}
+	}
+
+	// The keys are no longer sealed with the old master key
+	if _, err := RotateSecretKeys(ctx, s.Addresses(), oldVault, newVault); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+}
+
+func Test_RotateTwoFactorSecrets(t *testing.T) {
+	ctx := context.Background()
+	oldVault, _ := New(testKey)
+	newVault, _ := New(bytes.Repeat([]byte{0x24}, MasterKeySize))
+	s := store.NewMemoryStore()
+	sealed, _ := oldVault.Seal("JBSWY3DPEHPK3PXP", store.TwoFactorSecretID(1000))
+	if err := s.TwoFactor().Save(ctx, &store.TwoFactor{TelegramID: 1000, Secret: sealed}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := RotateTwoFactorSecrets(ctx, s.TwoFactor(), oldVault, newVault)
+	if err != nil || n != 1 {
+		t.Fatalf("Unexpected result: %d, %v", n, err)
+	}
+	tf, err := s.TwoFactor().Get(ctx, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := newVault.Open(tf.Secret, store.TwoFactorSecretID(1000)); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Unexpected secret %q: %v", secret, err)
+	}
+	if _, err := RotateTwoFactorSecrets(ctx, s.TwoFactor(), oldVault, newVault); err != ErrDecrypt {
+		t.Errorf("Expected ErrDecrypt, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package keyvault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// seedAdditionalData binds a sealed value to its use as the wallet seed
+const seedAdditionalData = "wallet-seed"
+
+// SealSeed encrypts the wallet seed
+func (v *Vault) SealSeed(seed string) (string, error) {
+	return v.Seal(seed, seedAdditionalData)
+}
+
+// OpenSeed decrypts a wallet seed sealed using SealSeed
+func (v *Vault) OpenSeed(sealed string) (string, error) {
+	return v.Open(sealed, seedAdditionalData)
+}
+
+// ReadSeedFile reads the sealed wallet seed from path. The seed is returned sealed,
+// use OpenSeed to decrypt it when it is needed. If the file does not exist the
+// returned error satisfies os.IsNotExist.
+func ReadSeedFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := strings.TrimSpace(string(b))
+	if !IsSealed(sealed) {
+		return "", fmt.Errorf("keyvault: %s does not hold an encrypted seed", path)
+	}
+	return sealed, nil
+}
+
+// WriteSeedFile writes the sealed wallet seed to path, replacing any existing file.
+// The file is only readable by its owner.
+func WriteSeedFile(path, sealed string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so an existing seed is never left half written
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(sealed+"\n"), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
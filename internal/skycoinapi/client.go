@@ -0,0 +1,265 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package skycoinapi provides a client for the REST API (/api/v1) of a Skycoin node.
+package skycoinapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nodeAPIBalance      = "/api/v1/balance"
+	nodeAPIOutputs      = "/api/v1/outputs"
+	nodeAPITransaction  = "/api/v1/transaction"
+	nodeAPIInjectTx     = "/api/v1/injectTransaction"
+	nodeAPIHealth       = "/api/v1/health"
+	nodeAPICSRF         = "/api/v1/csrf"
+	nodeAPICSRFHeader   = "X-CSRF-Token"
+	nodeAPIContentJSON  = "application/json"
+	maxErrorMessageSize = 1024
+)
+
+// APIError is returned when the Skycoin node responds with a non-200 status code
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error satisfies the error interface for the APIError type
+func (e *APIError) Error() string {
+	return fmt.Sprintf("skycoin node API error %d: %s", e.StatusCode, e.Message)
+}
+
+// Client provides access to the REST API of a Skycoin node
+type Client struct {
+	baseURL    string
+	timeout    time.Duration
+	httpClient *http.Client
+	userAgent  string
+}
+
+// NewClient creates a Client for the Skycoin node found at baseURL (i.e. "http://127.0.0.1:6420").
+// Requests which are not already bound by a context deadline will be cancelled after timeout.
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		timeout:    timeout,
+		httpClient: &http.Client{},
+		userAgent:  "Wing Commander Telegram Bot " + wcconst.BotVersion,
+	}
+}
+
+// BaseURL returns the base URL of the Skycoin node used by the Client
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// do sends a request to the node API and decodes the JSON response into v (if not nil)
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}, v interface{}) error {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	apiURL := c.baseURL + path
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+	log.Debugf("skycoinapi.Client: %s %s", method, apiURL)
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, apiURL, reqBody)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", nodeAPIContentJSON)
+	}
+
+	if method == http.MethodPost {
+		token, err := c.csrfToken(ctx)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set(nodeAPICSRFHeader, token)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %v", path, err)
+	}
+	return nil
+}
+
+// csrfToken requests a CSRF token from the node. An empty token is returned if
+// CSRF protection is disabled on the node.
+func (c *Client) csrfToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+nodeAPICSRF, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", newAPIError(resp)
+	}
+
+	var token struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode CSRF token: %v", err)
+	}
+	return token.CSRFToken, nil
+}
+
+// newAPIError builds an APIError from a failed response. The node reports errors either
+// as plain text or as a JSON object of the form {"error": {"message": "..."}}.
+func newAPIError(resp *http.Response) *APIError {
+	apierr := &APIError{StatusCode: resp.StatusCode}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorMessageSize))
+	if err != nil {
+		apierr.Message = resp.Status
+		return apierr
+	}
+
+	var jsonErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(b, &jsonErr) == nil && jsonErr.Error.Message != "" {
+		apierr.Message = jsonErr.Error.Message
+	} else {
+		apierr.Message = strings.TrimSpace(string(b))
+	}
+	if apierr.Message == "" {
+		apierr.Message = resp.Status
+	}
+	return apierr
+}
+
+// Health returns the health status of the node
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	var health HealthResponse
+	if err := c.do(ctx, http.MethodGet, nodeAPIHealth, nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Balance returns the combined balance of the provided addresses
+func (c *Client) Balance(ctx context.Context, addrs ...string) (*BalanceResponse, error) {
+	query := url.Values{}
+	query.Set("addrs", strings.Join(addrs, ","))
+
+	var balance BalanceResponse
+	if err := c.do(ctx, http.MethodGet, nodeAPIBalance, query, nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// Outputs returns the unspent outputs owned by the provided addresses
+func (c *Client) Outputs(ctx context.Context, addrs ...string) (*OutputsResponse, error) {
+	query := url.Values{}
+	query.Set("addrs", strings.Join(addrs, ","))
+
+	var outputs OutputsResponse
+	if err := c.do(ctx, http.MethodGet, nodeAPIOutputs, query, nil, &outputs); err != nil {
+		return nil, err
+	}
+	return &outputs, nil
+}
+
+// Transaction returns the transaction identified by txid
+func (c *Client) Transaction(ctx context.Context, txid string) (*TransactionResponse, error) {
+	query := url.Values{}
+	query.Set("txid", txid)
+
+	var txn TransactionResponse
+	if err := c.do(ctx, http.MethodGet, nodeAPITransaction, query, nil, &txn); err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// CreateTransaction asks the node to create an unsigned transaction spending the outputs
+// of the requested addresses. The transaction must be signed before it can be injected.
+func (c *Client) CreateTransaction(ctx context.Context, req CreateTransactionRequest) (*CreateTransactionResponse, error) {
+	var resp struct {
+		Data CreateTransactionResponse `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, nodeAPITransaction, nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// InjectTransaction broadcasts a signed, hex encoded transaction and returns its txid
+func (c *Client) InjectTransaction(ctx context.Context, rawtx string) (string, error) {
+	body := struct {
+		RawTx string `json:"rawtx"`
+	}{rawtx}
+
+	var txid string
+	if err := c.do(ctx, http.MethodPost, nodeAPIInjectTx, nil, body, &txid); err != nil {
+		return "", err
+	}
+	return txid, nil
+}
@@ -0,0 +1,222 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package skycoinapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestNode starts an httptest server standing in for a Skycoin node
+func newTestNode(t *testing.T, mux *http.ServeMux) (*httptest.Server, *Client) {
+	srv := httptest.NewServer(mux)
+	return srv, NewClient(srv.URL, time.Second)
+}
+
+func Test_Client_Health(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPIHealth, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"blockchain":{"head":{"seq":42}},"version":{"version":"0.26.0"},"open_connections":8}`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	health, err := client.Health(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.BlockchainMetadata.Head.BkSeq != 42 {
+		t.Errorf("Unexpected head seq: %d", health.BlockchainMetadata.Head.BkSeq)
+	}
+	if health.Version.Version != "0.26.0" {
+		t.Errorf("Unexpected version: %s", health.Version.Version)
+	}
+	if health.OpenConnections != 8 {
+		t.Errorf("Unexpected open connections: %d", health.OpenConnections)
+	}
+}
+
+func Test_Client_Balance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPIBalance, func(w http.ResponseWriter, r *http.Request) {
+		if addrs := r.URL.Query().Get("addrs"); addrs != "addrA,addrB" {
+			t.Errorf("Unexpected addrs query: %s", addrs)
+		}
+		if ua := r.Header.Get("User-Agent"); ua == "" {
+			t.Error("Expected a User-Agent header")
+		}
+		w.Write([]byte(`{"confirmed":{"coins":2000000,"hours":10},"predicted":{"coins":1000000,"hours":5},` +
+			`"addresses":{"addrA":{"confirmed":{"coins":2000000,"hours":10},"predicted":{"coins":1000000,"hours":5}}}}`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	balance, err := client.Balance(context.Background(), "addrA", "addrB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Confirmed.Coins != 2000000 || balance.Confirmed.Hours != 10 {
+		t.Errorf("Unexpected confirmed balance: %+v", balance.Confirmed)
+	}
+	if balance.Predicted.Coins != 1000000 || balance.Predicted.Hours != 5 {
+		t.Errorf("Unexpected predicted balance: %+v", balance.Predicted)
+	}
+	if _, found := balance.Addresses["addrA"]; !found {
+		t.Error("Expected balance for addrA")
+	}
+}
+
+func Test_Client_Outputs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPIOutputs, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"head":{"seq":100},"head_outputs":[{"hash":"abc","block_seq":99,"src_tx":"tx1","address":"addrA","coins":"1.5","calculated_hours":3}]}`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	outputs, err := client.Outputs(context.Background(), "addrA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs.Head.BkSeq != 100 {
+		t.Errorf("Unexpected head seq: %d", outputs.Head.BkSeq)
+	}
+	if len(outputs.HeadOutputs) != 1 {
+		t.Fatalf("Expected 1 output, got %d", len(outputs.HeadOutputs))
+	}
+	ux := outputs.HeadOutputs[0]
+	if ux.Hash != "abc" || ux.BlockSeq != 99 || ux.SourceTransaction != "tx1" || ux.Coins != "1.5" || ux.CalculatedHours != 3 {
+		t.Errorf("Unexpected output: %+v", ux)
+	}
+}
+
+func Test_Client_Transaction_NotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPITransaction, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	_, err := client.Transaction(context.Background(), "missing")
+	apierr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected *APIError, got: %v", err)
+	}
+	if apierr.StatusCode != http.StatusNotFound {
+		t.Errorf("Unexpected status code: %d", apierr.StatusCode)
+	}
+	if apierr.Message != "404 Not Found" {
+		t.Errorf("Unexpected message: %q", apierr.Message)
+	}
+}
+
+func Test_Client_CreateTransaction(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPICSRF, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"csrf_token":"token123"}`))
+	})
+	mux.HandleFunc(nodeAPITransaction, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Unexpected method: %s", r.Method)
+		}
+		if token := r.Header.Get(nodeAPICSRFHeader); token != "token123" {
+			t.Errorf("Unexpected CSRF token: %s", token)
+		}
+
+		var req CreateTransactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if len(req.To) != 1 || req.To[0].Address != "addrB" || req.To[0].Coins != "1" {
+			t.Errorf("Unexpected receivers: %+v", req.To)
+		}
+
+		w.Write([]byte(`{"data":{"transaction":{"txid":"tx1","fee":"5","inputs":[{"uxid":"ux1","address":"addrA"}]},"encoded_transaction":"00ff"}}`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	resp, err := client.CreateTransaction(context.Background(), CreateTransactionRequest{
+		HoursSelection: HoursSelection{Type: "auto", Mode: "share", ShareFactor: "0.5"},
+		Addresses:      []string{"addrA"},
+		To:             []Receiver{{Address: "addrB", Coins: "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.EncodedTransaction != "00ff" || resp.Transaction.TxID != "tx1" || resp.Transaction.Fee != "5" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if len(resp.Transaction.In) != 1 || resp.Transaction.In[0].Address != "addrA" {
+		t.Errorf("Unexpected inputs: %+v", resp.Transaction.In)
+	}
+}
+
+func Test_Client_InjectTransaction_NoCSRF(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPIInjectTx, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RawTx string `json:"rawtx"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.RawTx != "00ff" {
+			t.Errorf("Unexpected rawtx: %s", body.RawTx)
+		}
+		w.Write([]byte(`"tx1"`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	txid, err := client.InjectTransaction(context.Background(), "00ff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != "tx1" {
+		t.Errorf("Unexpected txid: %s", txid)
+	}
+}
+
+func Test_Client_JSONError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPITransaction, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"balance is not sufficient","code":400}}`))
+	})
+	srv, client := newTestNode(t, mux)
+	defer srv.Close()
+
+	_, err := client.CreateTransaction(context.Background(), CreateTransactionRequest{})
+	apierr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected *APIError, got: %v", err)
+	}
+	if apierr.Message != "balance is not sufficient" {
+		t.Errorf("Unexpected message: %q", apierr.Message)
+	}
+}
+
+func Test_Client_Timeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(nodeAPIHealth, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewClient(srv.URL, 50*time.Millisecond)
+	if _, err := client.Health(context.Background()); err == nil {
+		t.Error("Expected a timeout error")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package skycoinapi
+
+// Balance models the coins (in droplets) and coin hours reported by the node
+type Balance struct {
+	Coins uint64 `json:"coins"`
+	Hours uint64 `json:"hours"`
+}
+
+// BalancePair models the confirmed and predicted balance reported by the node
+type BalancePair struct {
+	Confirmed Balance `json:"confirmed"`
+	Predicted Balance `json:"predicted"`
+}
+
+// BalanceResponse models the JSON response from /api/v1/balance
+type BalanceResponse struct {
+	BalancePair
+	Addresses map[string]BalancePair `json:"addresses"`
+}
+
+// BlockHeader models the header of a block as reported by the node
+type BlockHeader struct {
+	BkSeq     uint64 `json:"seq"`
+	BlockHash string `json:"block_hash"`
+	Time      uint64 `json:"timestamp"`
+}
+
+// UnspentOutput models a single unspent output as reported by the node.
+// Coins are reported as a decimal SKY string.
+type UnspentOutput struct {
+	Hash              string `json:"hash"`
+	Time              uint64 `json:"time"`
+	BlockSeq          uint64 `json:"block_seq"`
+	SourceTransaction string `json:"src_tx"`
+	Address           string `json:"address"`
+	Coins             string `json:"coins"`
+	Hours             uint64 `json:"hours"`
+	CalculatedHours   uint64 `json:"calculated_hours"`
+}
+
+// OutputsResponse models the JSON response from /api/v1/outputs
+type OutputsResponse struct {
+	Head            BlockHeader     `json:"head"`
+	HeadOutputs     []UnspentOutput `json:"head_outputs"`
+	OutgoingOutputs []UnspentOutput `json:"outgoing_outputs"`
+	IncomingOutputs []UnspentOutput `json:"incoming_outputs"`
+}
+
+// TransactionStatus models the confirmation status of a transaction
+type TransactionStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	Unconfirmed bool   `json:"unconfirmed"`
+	Height      uint64 `json:"height"`
+	BlockSeq    uint64 `json:"block_seq"`
+}
+
+// TransactionOutput models an output of a transaction.
+// Coins are reported as a decimal SKY string.
+type TransactionOutput struct {
+	Hash    string `json:"uxid"`
+	Address string `json:"dst"`
+	Coins   string `json:"coins"`
+	Hours   uint64 `json:"hours"`
+}
+
+// Transaction models a transaction as reported by the node
+type Transaction struct {
+	Length    uint32              `json:"length"`
+	Type      uint8               `json:"type"`
+	Hash      string              `json:"txid"`
+	InnerHash string              `json:"inner_hash"`
+	Timestamp uint64              `json:"timestamp"`
+	Sigs      []string            `json:"sigs"`
+	In        []string            `json:"inputs"`
+	Out       []TransactionOutput `json:"outputs"`
+}
+
+// TransactionResponse models the JSON response from /api/v1/transaction
+type TransactionResponse struct {
+	Status      TransactionStatus `json:"status"`
+	Time        uint64            `json:"time"`
+	Transaction Transaction       `json:"txn"`
+}
+
+// HoursSelection defines how coin hours are distributed between the outputs of a new transaction
+type HoursSelection struct {
+	Type        string `json:"type"`
+	Mode        string `json:"mode,omitempty"`
+	ShareFactor string `json:"share_factor,omitempty"`
+}
+
+// Receiver defines a destination of a new transaction. Coins are a decimal SKY string.
+type Receiver struct {
+	Address string `json:"address"`
+	Coins   string `json:"coins"`
+	Hours   string `json:"hours,omitempty"`
+}
+
+// CreateTransactionRequest models the JSON request to create a new transaction
+type CreateTransactionRequest struct {
+	HoursSelection    HoursSelection `json:"hours_selection"`
+	Addresses         []string       `json:"addresses,omitempty"`
+	UxOuts            []string       `json:"unspents,omitempty"`
+	ChangeAddress     string         `json:"change_address,omitempty"`
+	To                []Receiver     `json:"to"`
+	IgnoreUnconfirmed bool           `json:"ignore_unconfirmed"`
+}
+
+// CreatedTransactionInput models an input of a newly created transaction
+type CreatedTransactionInput struct {
+	UxID            string `json:"uxid"`
+	Address         string `json:"address"`
+	Coins           string `json:"coins"`
+	CalculatedHours string `json:"calculated_hours"`
+}
+
+// CreatedTransactionOutput models an output of a newly created transaction
+type CreatedTransactionOutput struct {
+	UxID    string `json:"uxid"`
+	Address string `json:"address"`
+	Coins   string `json:"coins"`
+	Hours   string `json:"hours"`
+}
+
+// CreatedTransaction models a newly created (unsigned) transaction
+type CreatedTransaction struct {
+	Length    uint32                     `json:"length"`
+	Type      uint8                      `json:"type"`
+	TxID      string                     `json:"txid"`
+	InnerHash string                     `json:"inner_hash"`
+	Fee       string                     `json:"fee"`
+	In        []CreatedTransactionInput  `json:"inputs"`
+	Out       []CreatedTransactionOutput `json:"outputs"`
+}
+
+// CreateTransactionResponse models the JSON response to a create transaction request
+type CreateTransactionResponse struct {
+	Transaction        CreatedTransaction `json:"transaction"`
+	EncodedTransaction string             `json:"encoded_transaction"`
+}
+
+// HealthResponse models the JSON response from /api/v1/health
+type HealthResponse struct {
+	BlockchainMetadata struct {
+		Head               BlockHeader `json:"head"`
+		Unspents           uint64      `json:"unspents"`
+		Unconfirmed        uint64      `json:"unconfirmed"`
+		TimeSinceLastBlock string      `json:"time_since_last_block"`
+	} `json:"blockchain"`
+	Version struct {
+		Version string `json:"version"`
+		Commit  string `json:"commit"`
+		Branch  string `json:"branch"`
+	} `json:"version"`
+	OpenConnections int    `json:"open_connections"`
+	Uptime          string `json:"uptime"`
+}
@@ -0,0 +1,307 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package skymanager provides a client for the API of the Skywire Manager, and of the
+// Nodes connected to it (whose requests are relayed by the Manager).
+//
+// The Client logs in with the Manager password (keeping the session cookie) and requests
+// a token, which is sent with every request. When the Manager rejects a request because
+// the session or token has expired, the Client logs in again, requests a new token and
+// retries the request once.
+package skymanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	managerAPILogin     = "/login"
+	managerAPIGetToken  = "/req/getToken"
+	managerAPIGetAll    = "/conn/getAll"
+	managerAPIGetNode   = "/conn/getNode"
+	managerAPINode      = "/node"
+	nodeAPIGetInfo      = "/node/getInfo"
+	nodeAPIGetApps      = "/node/getApps"
+	maxErrorMessageSize = 1024
+)
+
+var (
+	// ErrLoginFailed is returned when the Manager rejects the password
+	ErrLoginFailed = errors.New("skywire manager login failed")
+	// ErrNodeNotFound is returned by GetNode when no Node with the key is connected to the Manager
+	ErrNodeNotFound = errors.New("node not connected to the skywire manager")
+)
+
+// APIError is returned when the Manager responds with a non-200 status code
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error satisfies the error interface for the APIError type
+func (e *APIError) Error() string {
+	return fmt.Sprintf("skywire manager API error %d: %s", e.StatusCode, e.Message)
+}
+
+// unauthorized reports whether err is the Manager rejecting the session or token
+func unauthorized(err error) bool {
+	apierr, ok := err.(*APIError)
+	return ok && (apierr.StatusCode == http.StatusUnauthorized || apierr.StatusCode == http.StatusForbidden)
+}
+
+// Client provides access to the API of a Skywire Manager. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	password   string
+	timeout    time.Duration
+	httpClient *http.Client
+	userAgent  string
+
+	// mutex guards the session, so only one request logs in at a time. session counts
+	// the logins, so a request rejected by the Manager can tell if it has logged in since.
+	mutex    sync.Mutex
+	loggedIn bool
+	session  int
+	hasToken bool
+	token    string
+}
+
+// NewClient creates a Client for the Manager found at address (i.e. "127.0.0.1:8000"),
+// logging in with password. Without a password the Client doesn't log in. Requests which
+// are not already bound by a context deadline will be cancelled after timeout.
+func NewClient(address, password string, timeout time.Duration) *Client {
+	baseURL := address
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	// cookiejar.New only fails for invalid options
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		password:   password,
+		timeout:    timeout,
+		httpClient: &http.Client{Jar: jar},
+		userAgent:  "Wing Commander Telegram Bot " + wcconst.BotVersion,
+	}
+}
+
+// BaseURL returns the base URL of the Manager used by the Client
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// do sends a request to the Manager API, with the form (if not nil) as its body, and
+// decodes the JSON response into v (if not nil)
+func (c *Client) do(ctx context.Context, path string, query, form url.Values, v interface{}) error {
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	apiURL := c.baseURL + path
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+	log.Debugf("skymanager.Client: POST %s", apiURL)
+
+	req, err := http.NewRequest(http.MethodPost, apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %v", path, err)
+	}
+	return nil
+}
+
+// newAPIError builds an APIError from a failed response, whose body is the message
+func newAPIError(resp *http.Response) *APIError {
+	apierr := &APIError{StatusCode: resp.StatusCode}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorMessageSize))
+	if err == nil {
+		apierr.Message = strings.TrimSpace(string(b))
+	}
+	if apierr.Message == "" {
+		apierr.Message = resp.Status
+	}
+	return apierr
+}
+
+// login starts a new session. The caller must hold the mutex.
+func (c *Client) login(ctx context.Context) error {
+	c.loggedIn, c.hasToken, c.token = false, false, ""
+	if c.password != "" {
+		var ok bool
+		if err := c.do(ctx, managerAPILogin, nil, url.Values{"pass": {c.password}}, &ok); err != nil {
+			return err
+		}
+		if !ok {
+			return ErrLoginFailed
+		}
+	}
+	c.loggedIn = true
+	c.session++
+	return nil
+}
+
+// getToken requests a new token for the session. The caller must hold the mutex.
+func (c *Client) getToken(ctx context.Context) (string, error) {
+	var token string
+	err := c.do(ctx, managerAPIGetToken, nil, url.Values{}, &token)
+	if apierr, ok := err.(*APIError); ok && apierr.StatusCode == http.StatusNotFound {
+		// The Manager doesn't use tokens
+		token = ""
+	} else if err != nil {
+		return "", err
+	}
+	c.hasToken, c.token = true, token
+	return token, nil
+}
+
+// Login starts a new session with the Manager, using the password of the Client
+func (c *Client) Login(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.login(ctx)
+}
+
+// GetToken requests a new token from the Manager, logging in first if needed
+func (c *Client) GetToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.loggedIn {
+		if err := c.login(ctx); err != nil {
+			return "", err
+		}
+	}
+	return c.getToken(ctx)
+}
+
+// authorize returns the token and number of the session, logging in and requesting a token
+// if needed. If stale is the number of the session (i.e. the Manager rejected
+// it), a new session is started.
+func (c *Client) authorize(ctx context.Context, stale int) (string, int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.loggedIn || c.session == stale {
+		if err := c.login(ctx); err != nil {
+			return "", 0, err
+		}
+	}
+	if !c.hasToken {
+		if _, err := c.getToken(ctx); err != nil {
+			return "", 0, err
+		}
+	}
+	return c.token, c.session, nil
+}
+
+// call sends an authorized request to the Manager API, starting a new session and retrying
+// once if the Manager rejects the session or token
+func (c *Client) call(ctx context.Context, path string, query, form url.Values, v interface{}) error {
+	if form == nil {
+		form = url.Values{}
+	}
+	session := 0
+	for attempt := 1; ; attempt++ {
+		token, current, err := c.authorize(ctx, session)
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			form.Set("token", token)
+		}
+		err = c.do(ctx, path, query, form, v)
+		if !unauthorized(err) || attempt == 2 {
+			return err
+		}
+		log.Debugf("skymanager.Client: Session expired, logging in again: %v", err)
+		session = current
+	}
+}
+
+// GetAll returns the Nodes connected to the Manager
+func (c *Client) GetAll(ctx context.Context) (skynode.NodeInfoSlice, error) {
+	var nodes skynode.NodeInfoSlice
+	if err := c.call(ctx, managerAPIGetAll, nil, nil, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// GetNode returns the connection of the Node with the key, including the address of its API
+func (c *Client) GetNode(ctx context.Context, key string) (*Node, error) {
+	var node *Node
+	if err := c.call(ctx, managerAPIGetNode, nil, url.Values{"key": {key}}, &node); err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+	if node.Key == "" {
+		node.Key = key
+	}
+	return node, nil
+}
+
+// nodeQuery returns the query asking the Manager to relay a request to the API of the
+// Node at addr (see Node.Addr)
+func nodeQuery(addr, path string) url.Values {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return url.Values{"addr": {strings.TrimRight(addr, "/") + path}}
+}
+
+// GetInfo returns the discoveries, transports, app feedback and version of the Node at addr
+func (c *Client) GetInfo(ctx context.Context, addr string) (*NodeInfo, error) {
+	var info NodeInfo
+	if err := c.call(ctx, managerAPINode, nodeQuery(addr, nodeAPIGetInfo), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// GetApps returns the apps registered with the Node at addr
+func (c *Client) GetApps(ctx context.Context, addr string) ([]App, error) {
+	var apps []App
+	if err := c.call(ctx, managerAPINode, nodeQuery(addr, nodeAPIGetApps), nil, &apps); err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
@@ -0,0 +1,245 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package skymanager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testManager is an httptest stub of a Skywire Manager with a password, sessions and
+// tokens, and a single Node listening on testNodeAddr
+type testManager struct {
+	t        *testing.T
+	mutex    sync.Mutex
+	password string
+	sessions map[string]bool
+	tokens   map[string]bool
+	logins   int
+	next     int
+}
+
+const testNodeKey = "02b9d1cab7467771ce2bc8fd7c7340bba0c2a511004650064bcb368386263694fd"
+const testNodeAddr = "127.0.0.1:6001"
+
+func newTestManager(t *testing.T) (*testManager, *httptest.Server) {
+	m := &testManager{t: t, password: "secret", sessions: make(map[string]bool), tokens: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(managerAPILogin, m.login)
+	mux.HandleFunc(managerAPIGetToken, m.authorized(false, func(w http.ResponseWriter, r *http.Request) {
+		m.next++
+		token := fmt.Sprintf("token-%d", m.next)
+		m.tokens[token] = true
+		fmt.Fprintf(w, "%q", token)
+	}))
+	mux.HandleFunc(managerAPIGetAll, m.authorized(true, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"key":%q,"type":"TCP","send_bytes":1,"recv_bytes":2,"last_ack_time":3,"start_time":4}]`, testNodeKey)
+	}))
+	mux.HandleFunc(managerAPIGetNode, m.authorized(true, func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("key") != testNodeKey {
+			fmt.Fprint(w, "null")
+			return
+		}
+		fmt.Fprintf(w, `{"type":"TCP","addr":%q,"send_bytes":1,"recv_bytes":2,"last_ack_time":3,"start_time":4}`, testNodeAddr)
+	}))
+	mux.HandleFunc(managerAPINode, m.authorized(true, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("addr") {
+		case "http://" + testNodeAddr + nodeAPIGetInfo:
+			fmt.Fprint(w, `{"discoveries":{"discovery.skycoin.net:5999":true},"transports":[{"from_node":"a","to_node":"b",`+
+				`"from_app":"c","to_app":"d","upload_total":10,"download_total":20}],"app_feedbacks":[{"port":1,"app_feedback":{"port":1,"status":1}}],`+
+				`"version":"0.1.0","tag":"dev","os":"linux"}`)
+		case "http://" + testNodeAddr + nodeAPIGetApps:
+			fmt.Fprint(w, `[{"key":"app1","attributes":["sockss"],"allow_nodes":null},{"key":"app2","attributes":["sshc"]}]`)
+		default:
+			http.Error(w, "node not found", http.StatusBadRequest)
+		}
+	}))
+	return m, httptest.NewServer(mux)
+}
+
+func (m *testManager) login(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.logins++
+	if r.FormValue("pass") != m.password {
+		fmt.Fprint(w, "false")
+		return
+	}
+	m.next++
+	session := fmt.Sprintf("session-%d", m.next)
+	m.sessions[session] = true
+	http.SetCookie(w, &http.Cookie{Name: "SWSId", Value: session, Path: "/"})
+	fmt.Fprint(w, "true")
+}
+
+// authorized checks the session (and the token, if needToken) of a request
+func (m *testManager) authorized(needToken bool, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if !strings.HasPrefix(r.Header.Get("User-Agent"), "Wing Commander Telegram Bot") {
+			m.t.Errorf("Unexpected User-Agent %q", r.Header.Get("User-Agent"))
+		}
+		cookie, err := r.Cookie("SWSId")
+		if err != nil || !m.sessions[cookie.Value] {
+			http.Error(w, "login required", http.StatusUnauthorized)
+			return
+		}
+		if needToken && !m.tokens[r.FormValue("token")] {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+// loginCount returns the number of logins so far
+func (m *testManager) loginCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.logins
+}
+
+// expire ends every session and token
+func (m *testManager) expire() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions = make(map[string]bool)
+	m.tokens = make(map[string]bool)
+}
+
+func Test_Client_Login(t *testing.T) {
+	m, srv := newTestManager(t)
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "wrong", time.Second)
+	if err := client.Login(context.Background()); err != ErrLoginFailed {
+		t.Errorf("Expected ErrLoginFailed, got %v", err)
+	}
+	if _, err := client.GetAll(context.Background()); err != ErrLoginFailed {
+		t.Errorf("Expected ErrLoginFailed, got %v", err)
+	}
+
+	client = NewClient(strings.TrimPrefix(srv.URL, "http://"), m.password, time.Second)
+	token, err := client.GetToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "token-") {
+		t.Errorf("Unexpected token %q", token)
+	}
+}
+
+func Test_Client_GetAll(t *testing.T) {
+	m, srv := newTestManager(t)
+	defer srv.Close()
+	client := NewClient(srv.URL, m.password, time.Second)
+
+	nodes, err := client.GetAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Key != testNodeKey || nodes[0].RecvBytes != 2 {
+		t.Errorf("Unexpected nodes %+v", nodes)
+	}
+
+	// The session is reused
+	if _, err := client.GetAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.loginCount(); n != 1 {
+		t.Errorf("Expected 1 login, got %d", n)
+	}
+}
+
+func Test_Client_SessionRefresh(t *testing.T) {
+	m, srv := newTestManager(t)
+	defer srv.Close()
+	client := NewClient(srv.URL, m.password, time.Second)
+
+	if _, err := client.GetAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.expire()
+	if _, err := client.GetAll(context.Background()); err != nil {
+		t.Fatalf("Expected the session to be refreshed, got %v", err)
+	}
+	if n := m.loginCount(); n != 2 {
+		t.Errorf("Expected 2 logins, got %d", n)
+	}
+
+	// A password changed on the Manager isn't retried forever
+	m.mutex.Lock()
+	m.password = "changed"
+	m.mutex.Unlock()
+	m.expire()
+	if _, err := client.GetAll(context.Background()); err != ErrLoginFailed {
+		t.Errorf("Expected ErrLoginFailed, got %v", err)
+	}
+}
+
+func Test_Client_GetNode(t *testing.T) {
+	m, srv := newTestManager(t)
+	defer srv.Close()
+	client := NewClient(srv.URL, m.password, time.Second)
+	ctx := context.Background()
+
+	node, err := client.GetNode(ctx, testNodeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Key != testNodeKey || node.Addr != testNodeAddr || node.Type != "TCP" {
+		t.Errorf("Unexpected node %+v", node)
+	}
+	if _, err := client.GetNode(ctx, "03aa"); err != ErrNodeNotFound {
+		t.Errorf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	info, err := client.GetInfo(ctx, node.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "0.1.0" || !info.Discoveries["discovery.skycoin.net:5999"] || len(info.Transports) != 1 ||
+		info.Transports[0].DownloadTotal != 20 || len(info.AppFeedbacks) != 1 || info.AppFeedbacks[0].Feedback.Status != 1 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	apps, err := client.GetApps(ctx, node.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || apps[0].Name() != "sockss" || apps[1].Name() != "sshc" {
+		t.Errorf("Unexpected apps %+v", apps)
+	}
+
+	_, err = client.GetApps(ctx, "127.0.0.1:6002")
+	if apierr, ok := err.(*APIError); !ok || apierr.StatusCode != http.StatusBadRequest || apierr.Message != "node not found" {
+		t.Errorf("Expected an APIError, got %v", err)
+	}
+}
+
+func Test_Client_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := NewClient(srv.URL, "", 20*time.Millisecond)
+	start := time.Now()
+	if _, err := client.GetAll(context.Background()); err == nil {
+		t.Error("Expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request to time out after 20ms, took %v", elapsed)
+	}
+}
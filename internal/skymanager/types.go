@@ -0,0 +1,67 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package skymanager
+
+// Node models the connection of a Node to the Manager, as reported by conn/getNode.
+// Addr is the address of the API of the Node (used by GetInfo and GetApps). The times
+// are the seconds elapsed since the last ack and the start of the connection.
+type Node struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Addr        string `json:"addr"`
+	SendBytes   int    `json:"send_bytes"`
+	RecvBytes   int    `json:"recv_bytes"`
+	LastAckTime int    `json:"last_ack_time"`
+	StartTime   int    `json:"start_time"`
+}
+
+// Transport models a connection between an app of the Node and an app of another Node
+type Transport struct {
+	FromNode          string `json:"from_node"`
+	ToNode            string `json:"to_node"`
+	FromApp           string `json:"from_app"`
+	ToApp             string `json:"to_app"`
+	UploadBandwidth   uint64 `json:"upload_bandwidth"`
+	DownloadBandwidth uint64 `json:"download_bandwidth"`
+	UploadTotal       uint64 `json:"upload_total"`
+	DownloadTotal     uint64 `json:"download_total"`
+}
+
+// AppFeedback models the status an app running on the Node reports on its port
+type AppFeedback struct {
+	Port     int `json:"port"`
+	Feedback struct {
+		Port   int `json:"port"`
+		Status int `json:"status"`
+	} `json:"app_feedback"`
+	UnreadMessages int `json:"unread"`
+}
+
+// NodeInfo models the JSON response from node/getInfo
+type NodeInfo struct {
+	Discoveries  map[string]bool `json:"discoveries"`
+	Transports   []Transport     `json:"transports"`
+	AppFeedbacks []AppFeedback   `json:"app_feedbacks"`
+	Version      string          `json:"version"`
+	Tag          string          `json:"tag"`
+	OS           string          `json:"os"`
+}
+
+// App models an app registered with the Node, as reported by node/getApps. The
+// attributes of an app name the service it provides (i.e. "sockss" or "sshc").
+type App struct {
+	Key        string   `json:"key"`
+	Attributes []string `json:"attributes"`
+	AllowNodes []string `json:"allow_nodes"`
+}
+
+// Name returns the name of the app, which is its first attribute
+func (a App) Name() string {
+	if len(a.Attributes) == 0 {
+		return ""
+	}
+	return a.Attributes[0]
+}
@@ -0,0 +1,259 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package skymgrmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
+	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+// EventKind identifies a kind of event reported by the monitor, so it can be muted
+type EventKind string
+
+// The kinds of events reported by the monitor
+const (
+	EventNodeConnected    EventKind = "nodeconnected"
+	EventNodeDisconnected EventKind = "nodedisconnected"
+	EventAppStarted       EventKind = "appstarted"
+	EventAppStopped       EventKind = "appstopped"
+	EventConnOpened       EventKind = "connopened"
+	EventConnClosed       EventKind = "connclosed"
+)
+
+// EventKinds lists every kind of event
+var EventKinds = []EventKind{
+	EventNodeConnected,
+	EventNodeDisconnected,
+	EventAppStarted,
+	EventAppStopped,
+	EventConnOpened,
+	EventConnClosed,
+}
+
+var (
+	// ErrUnknownEventKind is returned by ParseEventKind for a name which isn't a kind of event
+	ErrUnknownEventKind = errors.New("unknown event kind")
+	// ErrNoManagerClient is returned when the apps of a Node are requested without a Manager API client
+	ErrNoManagerClient = errors.New("no skywire manager API client")
+)
+
+// ParseEventKind returns the kind of event with the name
+func ParseEventKind(name string) (EventKind, error) {
+	for _, kind := range EventKinds {
+		if string(kind) == name {
+			return kind, nil
+		}
+	}
+	return "", ErrUnknownEventKind
+}
+
+// event is a status message of the monitor. Messages without a kind (i.e. errors) can't be muted.
+type event struct {
+	kind EventKind
+	msg  string
+}
+
+// connection identifies a connection between an app of a Node and an app of another Node
+type connection struct {
+	fromNode, fromApp string
+	toNode, toApp     string
+}
+
+// nodeState holds the names of the apps and the connections of a Node at the last poll
+type nodeState struct {
+	apps  map[string]bool
+	conns map[connection]bool
+}
+
+// SetManagerClient sets the client of the Manager API used to list the Nodes and to monitor
+// the apps and connections of each Node. Without a client only the Nodes are monitored.
+func (smm *SkyManagerMonitor) SetManagerClient(client *skymanager.Client) {
+	smm.m.Lock()
+	defer smm.m.Unlock()
+	smm.client = client
+}
+
+// getManagerClient is a thread-safe function for getting the Manager API client
+func (smm *SkyManagerMonitor) getManagerClient() *skymanager.Client {
+	smm.m.Lock()
+	defer smm.m.Unlock()
+	return smm.client
+}
+
+// SetMuted mutes (or unmutes) a kind of event, so the monitor stops (or starts) reporting it
+func (smm *SkyManagerMonitor) SetMuted(kind EventKind, muted bool) {
+	smm.m.Lock()
+	defer smm.m.Unlock()
+	if smm.muted == nil {
+		smm.muted = make(map[EventKind]bool)
+	}
+	smm.muted[kind] = muted
+}
+
+// IsMuted reports whether a kind of event is muted
+func (smm *SkyManagerMonitor) IsMuted(kind EventKind) bool {
+	smm.m.Lock()
+	defer smm.m.Unlock()
+	return smm.muted[kind]
+}
+
+// getNodes requests the Nodes connected to the Manager, using the Manager API client if set
+func (smm *SkyManagerMonitor) getNodes(ctx context.Context) (skynode.NodeInfoSlice, error) {
+	if client := smm.getManagerClient(); client != nil {
+		return client.GetAll(ctx)
+	}
+	return getAllNodesList(smm.ManagerAddress)
+}
+
+// GetNodeApps requests the apps registered with the connected Node with the key, sorted by name
+func (smm *SkyManagerMonitor) GetNodeApps(ctx context.Context, key string) ([]skymanager.App, error) {
+	client := smm.getManagerClient()
+	if client == nil {
+		return nil, ErrNoManagerClient
+	}
+	node, err := client.GetNode(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := client.GetApps(ctx, node.Addr)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name() < apps[j].Name() })
+	return apps, nil
+}
+
+// getNodeState requests the apps and connections of the connected Node with the key
+func getNodeState(ctx context.Context, client *skymanager.Client, key string) (*nodeState, error) {
+	node, err := client.GetNode(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := client.GetApps(ctx, node.Addr)
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.GetInfo(ctx, node.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &nodeState{apps: make(map[string]bool), conns: make(map[connection]bool)}
+	for _, app := range apps {
+		state.apps[app.Name()] = true
+	}
+	for _, t := range info.Transports {
+		state.conns[connection{t.FromNode, t.FromApp, t.ToNode, t.ToApp}] = true
+	}
+	return state, nil
+}
+
+// sortedKeys returns the keys of a set of names in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// connectionEvent returns the event of a connection of the Node with the key being opened or closed
+func connectionEvent(key string, c connection, opened bool) event {
+	kind := EventConnClosed
+	if opened {
+		kind = EventConnOpened
+	}
+	if c.fromNode == key {
+		format := wcconst.MsgConnOutboundClosed
+		if opened {
+			format = wcconst.MsgConnOutboundOpened
+		}
+		return event{kind, fmt.Sprintf(format, c.fromApp, key, c.toApp, c.toNode)}
+	}
+	format := wcconst.MsgConnInboundClosed
+	if opened {
+		format = wcconst.MsgConnInboundOpened
+	}
+	return event{kind, fmt.Sprintf(format, c.toApp, key, c.fromApp, c.fromNode)}
+}
+
+// diffNodeState returns the events of the apps and connections of the Node with the key
+// which changed between the polls
+func diffNodeState(key string, prev, cur *nodeState) (events []event) {
+	for _, name := range sortedKeys(prev.apps) {
+		if !cur.apps[name] {
+			events = append(events, event{EventAppStopped, fmt.Sprintf(wcconst.MsgAppStopped, name, key)})
+		}
+	}
+	for _, name := range sortedKeys(cur.apps) {
+		if !prev.apps[name] {
+			events = append(events, event{EventAppStarted, fmt.Sprintf(wcconst.MsgAppStarted, name, key)})
+		}
+	}
+
+	var closed, opened []event
+	for c := range prev.conns {
+		if !cur.conns[c] {
+			closed = append(closed, connectionEvent(key, c, false))
+		}
+	}
+	for c := range cur.conns {
+		if !prev.conns[c] {
+			opened = append(opened, connectionEvent(key, c, true))
+		}
+	}
+	for _, list := range [][]event{closed, opened} {
+		sort.Slice(list, func(i, j int) bool { return list[i].msg < list[j].msg })
+		events = append(events, list...)
+	}
+	return events
+}
+
+// pollNodeStates requests the apps and connections of each connected Node and returns the
+// events of what changed since the last poll. The first poll of a Node doesn't report any
+// events, and a Node which can't be polled keeps its last state.
+func (smm *SkyManagerMonitor) pollNodeStates(ctx context.Context, nodes skynode.NodeInfoSlice) (events []event) {
+	client := smm.getManagerClient()
+	if client == nil {
+		return nil
+	}
+
+	connected := make(map[string]bool)
+	for _, n := range nodes {
+		connected[n.Key] = true
+		cur, err := getNodeState(ctx, client, n.Key)
+		if err != nil {
+			log.Warnf("SkyManagerMonitor.pollNodeStates: Error getting the apps of Node %s: %v", n.Key, err)
+			continue
+		}
+
+		smm.m.Lock()
+		prev := smm.nodeStates[n.Key]
+		smm.nodeStates[n.Key] = cur
+		smm.m.Unlock()
+		if prev != nil {
+			events = append(events, diffNodeState(n.Key, prev, cur)...)
+		}
+	}
+
+	// The state of a disconnected Node is dropped, its disconnection is reported instead
+	smm.m.Lock()
+	for key := range smm.nodeStates {
+		if !connected[key] {
+			delete(smm.nodeStates, key)
+		}
+	}
+	smm.m.Unlock()
+	return events
+}
@@ -0,0 +1,165 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package skymgrmon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
+	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
+)
+
+const testNodeKey = "02b9d1cab7467771ce2bc8fd7c7340bba0c2a511004650064bcb368386263694fd"
+
+// testManager is an httptest stub of a Skywire Manager (without a password) with a single
+// Node, whose apps and transports can be changed between polls
+type testManager struct {
+	mutex      sync.Mutex
+	apps       string
+	transports string
+}
+
+func (m *testManager) set(apps, transports string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.apps, m.transports = apps, transports
+}
+
+func newTestManager() (*testManager, *httptest.Server) {
+	m := &testManager{apps: "[]", transports: "[]"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/conn/getAll", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"key":%q,"type":"TCP"}]`, testNodeKey)
+	})
+	mux.HandleFunc("/conn/getNode", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"type":"TCP","addr":"127.0.0.1:6001"}`)
+	})
+	mux.HandleFunc("/node", func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		switch r.URL.Query().Get("addr") {
+		case "http://127.0.0.1:6001/node/getApps":
+			fmt.Fprint(w, m.apps)
+		case "http://127.0.0.1:6001/node/getInfo":
+			fmt.Fprintf(w, `{"transports":%s}`, m.transports)
+		default:
+			http.Error(w, "node not found", http.StatusBadRequest)
+		}
+	})
+	return m, httptest.NewServer(mux)
+}
+
+func Test_ParseEventKind(t *testing.T) {
+	for _, kind := range EventKinds {
+		if k, err := ParseEventKind(string(kind)); err != nil || k != kind {
+			t.Errorf("ParseEventKind(%q) returned %q, %v", kind, k, err)
+		}
+	}
+	if _, err := ParseEventKind("appstop"); err != ErrUnknownEventKind {
+		t.Errorf("Expected ErrUnknownEventKind, got %v", err)
+	}
+}
+
+func Test_diffNodeState(t *testing.T) {
+	key, other := "02aa", "03bb"
+	prev := &nodeState{
+		apps: map[string]bool{"socksc": true, "sshs": true},
+		conns: map[connection]bool{
+			{key, "socksc", other, "sockss"}: true,
+		},
+	}
+	cur := &nodeState{
+		apps: map[string]bool{"sshs": true, "sockss": true},
+		conns: map[connection]bool{
+			{other, "sshc", key, "sshs"}: true,
+		},
+	}
+
+	events := diffNodeState(key, prev, cur)
+	expected := []event{
+		{EventAppStopped, "⚠️ *socksc stopped* on Node 02aa"},
+		{EventAppStarted, "✅ *sockss started* on Node 02aa"},
+		{EventConnClosed, "*Outbound connection closed* from socksc on Node 02aa to sockss on 03bb"},
+		{EventConnOpened, "🔗 *New inbound connection* to sshs on Node 02aa from sshc on 03bb"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %+v", len(expected), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Event %d expected %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+
+	if events := diffNodeState(key, cur, cur); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+}
+
+func Test_pollNodeStates(t *testing.T) {
+	m, srv := newTestManager()
+	defer srv.Close()
+	smm := NewMonitor(srv.URL, "")
+	ctx := context.Background()
+
+	// Without a client only the Nodes are monitored
+	nodes := skynode.NodeInfoSlice{{Key: testNodeKey}}
+	if events := smm.pollNodeStates(ctx, nodes); events != nil {
+		t.Errorf("Expected no events without a client, got %+v", events)
+	}
+
+	smm.SetManagerClient(skymanager.NewClient(srv.URL, "", time.Second))
+	nodes, err := smm.GetNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first poll is the baseline
+	m.set(`[{"key":"app1","attributes":["socksc"]}]`, "[]")
+	if events := smm.pollNodeStates(ctx, nodes); len(events) != 0 {
+		t.Errorf("Expected no events on the first poll, got %+v", events)
+	}
+
+	m.set(`[{"key":"app2","attributes":["sshs"]}]`,
+		`[{"from_node":"03bb","from_app":"sshc","to_node":"`+testNodeKey+`","to_app":"sshs"}]`)
+	events := smm.pollNodeStates(ctx, nodes)
+	if len(events) != 3 || events[0].kind != EventAppStopped || events[1].kind != EventAppStarted ||
+		events[2].kind != EventConnOpened || !strings.HasPrefix(events[2].msg, "🔗 *New inbound connection* to sshs") {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	apps, err := smm.GetNodeApps(ctx, testNodeKey)
+	if err != nil || len(apps) != 1 || apps[0].Name() != "sshs" {
+		t.Errorf("Unexpected apps %+v, %v", apps, err)
+	}
+
+	// The state of a disconnected Node is dropped
+	smm.pollNodeStates(ctx, nil)
+	if len(smm.nodeStates) != 0 {
+		t.Errorf("Expected the state of the Node to be dropped, got %+v", smm.nodeStates)
+	}
+}
+
+func Test_SetMuted(t *testing.T) {
+	smm := NewMonitor("", "")
+	if smm.IsMuted(EventConnOpened) {
+		t.Error("Expected the events not to be muted")
+	}
+	smm.SetMuted(EventConnOpened, true)
+	if !smm.IsMuted(EventConnOpened) || smm.IsMuted(EventConnClosed) {
+		t.Error("Expected only connopened to be muted")
+	}
+	smm.SetMuted(EventConnOpened, false)
+	if smm.IsMuted(EventConnOpened) {
+		t.Error("Expected connopened to be unmuted")
+	}
+}
@@ -11,9 +11,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
 	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
 	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
 	log "github.com/sirupsen/logrus"
@@ -35,6 +37,10 @@ type SkyManagerMonitor struct {
 	m                    sync.Mutex
 	updateStarted        bool
 	updateMsgChan        chan string
+	done                 chan struct{}
+	client               *skymanager.Client
+	nodeStates           map[string]*nodeState
+	muted                map[EventKind]bool
 }
 
 // SetCancelFunc is a thread-safe function for setting the cancelFunc
@@ -90,31 +96,55 @@ func NewMonitor(manageraddress, discoveryaddress string) *SkyManagerMonitor {
 		discConnNodeCount:    0,
 		updateStarted:        false,
 		updateMsgChan:        nil,
+		nodeStates:           make(map[string]*nodeState),
+		muted:                make(map[EventKind]bool),
 	}
 }
 
 // RunManagerMonitor starts the SkyManagerMonitor monitoring of the local Manager Node.
 // If `ctx` is not nil, the monitor will listen to ctx.Done() and stop monitoring
-// when it receives the signal.
+// when it receives the signal. statusMsgChan is owned by the caller, the monitor
+// never closes it and stops sending to it once ctx is done.
 func (smm *SkyManagerMonitor) RunManagerMonitor(runctx context.Context, doCancelFunc func(), statusMsgChan chan<- string, pollInt time.Duration) {
 	log.Debugf("SkyManagerMonitor.RunManagerMonitor: Start (Interval: %v)", pollInt)
 	defer log.Debugln("SkyManagerMonitor.RunManagerMonitor: End")
 
-	smm.SetCancelFunc(doCancelFunc)
+	done := make(chan struct{})
+	defer close(done)
+	smm.m.Lock()
+	smm.cancelFunc = doCancelFunc
 	smm.monitorStatusMsgChan = statusMsgChan
+	smm.done = done
+	smm.m.Unlock()
 
 	ticker := time.NewTicker(pollInt)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			newcns, err := getAllNodesList(smm.ManagerAddress)
+			var events []event
+			newcns, err := smm.getNodes(runctx)
 			if err != nil {
 				log.Error(err)
-				statusMsgChan <- wcconst.MsgErrorGetNodes
+				events = []event{{msg: wcconst.MsgErrorGetNodes}}
 			} else {
-				// Maintain the list of connected nodes
-				smm.maintainConnectedNodesList(newcns, statusMsgChan)
+				// Maintain the list of connected nodes, and the apps and connections of each node
+				events = smm.maintainConnectedNodesList(newcns)
+				events = append(events, smm.pollNodeStates(runctx, newcns)...)
+			}
+			// The messages are sent without holding the lock, as the receiver may need it
+			for _, e := range events {
+				if e.kind != "" && smm.IsMuted(e.kind) {
+					log.Debugf("SkyManagerMonitor.RunManagerMonitor: Muted %s event: %s", e.kind, e.msg)
+					continue
+				}
+				select {
+				case statusMsgChan <- e.msg:
+				case <-runctx.Done():
+					log.Debugln("SkyManagerMonitor.RunManagerMonitor: Done Event.")
+					return
+				}
 			}
 		case <-runctx.Done():
 			log.Debugln("SkyManagerMonitor.RunManagerMonitor: Done Event.")
@@ -123,18 +153,27 @@ func (smm *SkyManagerMonitor) RunManagerMonitor(runctx context.Context, doCancel
 	}
 }
 
-// StopManagerMonitor stops the SkyManagerMonitor monitoring of the local Manager Node.
-// If `ctx` is not nil, the monitor will listen to ctx.Done() and stop monitoring
-// when it receives the signal.
+// StopManagerMonitor stops the SkyManagerMonitor monitoring of the local Manager Node,
+// and waits for RunManagerMonitor to return. The status message channel is left open,
+// as it is owned by the caller of RunManagerMonitor.
 func (smm *SkyManagerMonitor) StopManagerMonitor() {
 	log.Debugln("SkyManagerMonitor.StopManagerMonitor: Start")
 	defer log.Debugln("SkyManagerMonitor.StopManagerMonitor: End")
 
 	if smm.IsRunning() {
 		smm.DoCancelFunc()
-		smm.SetCancelFunc(nil)
-		close(smm.monitorStatusMsgChan)
+		smm.m.Lock()
+		done := smm.done
+		smm.m.Unlock()
+		if done != nil {
+			<-done
+		}
+
+		smm.m.Lock()
+		smm.cancelFunc = nil
 		smm.monitorStatusMsgChan = nil
+		smm.done = nil
+		smm.m.Unlock()
 		log.Debug(wcconst.MsgMonitorStopped)
 	}
 }
@@ -283,9 +322,8 @@ func getAllNodesList(managerAddr string) (cns skynode.NodeInfoSlice, err error)
 }
 
 // maintainConnectedNodeList is responsible for maintaining (adding, updating and deleting) Nodes from the
-// Monitors internal connectedNodeList.
-// TODO: Support use of channels for signaling of change events
-func (smm *SkyManagerMonitor) maintainConnectedNodesList(newcns skynode.NodeInfoSlice, statusMsgChan chan<- string) {
+// Monitors internal connectedNodeList. The events of the connected and disconnected Nodes are returned.
+func (smm *SkyManagerMonitor) maintainConnectedNodesList(newcns skynode.NodeInfoSlice) (events []event) {
 	log.Debug("SkyManagerMonitor.maintainConnectedNodesList: Start")
 	defer log.Debug("SkyManagerMonitor.maintainConnectedNodesList: End")
 
@@ -295,7 +333,7 @@ func (smm *SkyManagerMonitor) maintainConnectedNodesList(newcns skynode.NodeInfo
 	// Make sure the newcns structure is not nil, and return if it is (do nothing)
 	if newcns == nil {
 		log.Error("SkyManagerMonitor.maintainConnectedNodesList: newcns is nil.")
-		return
+		return nil
 	}
 
 	// Compare the new connected node list (newcns) against the current list.
@@ -313,7 +351,7 @@ func (smm *SkyManagerMonitor) maintainConnectedNodesList(newcns skynode.NodeInfo
 			smm.connectedNodes[v.Key] = v
 			msg := fmt.Sprintf(wcconst.MsgNodeConnected, v.Key, len(smm.connectedNodes))
 			log.Debugln(msg)
-			statusMsgChan <- msg
+			events = append(events, event{EventNodeConnected, msg})
 		}
 	}
 
@@ -333,10 +371,11 @@ func (smm *SkyManagerMonitor) maintainConnectedNodesList(newcns skynode.NodeInfo
 				delete(smm.connectedNodes, v.Key)
 				msg := fmt.Sprintf(wcconst.MsgNodeDisconnected, v.Key, len(smm.connectedNodes))
 				log.Debugln(msg)
-				statusMsgChan <- msg
+				events = append(events, event{EventNodeDisconnected, msg})
 			}
 		}
 	}
+	return events
 }
 
 /*
@@ -425,6 +464,17 @@ func (smm *SkyManagerMonitor) BuildConnectionStatusMsg(msgTitle string) string {
 	return msg
 }
 
+// GetNodes requests the list of Nodes connected to the Manager, sorted by their keys.
+// The Manager is asked directly, so the Nodes are available when the monitor isn't running.
+func (smm *SkyManagerMonitor) GetNodes(ctx context.Context) (skynode.NodeInfoSlice, error) {
+	cns, err := smm.getNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(cns, func(i, j int) bool { return cns[i].Key < cns[j].Key })
+	return cns, nil
+}
+
 // GetNodeKeyList returns a []string (slice) containing the currently connected node keys
 func (smm *SkyManagerMonitor) GetNodeKeyList() []string {
 	var nodekeyslice []string
@@ -6,7 +6,12 @@ package skymgrmon
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
 	"github.com/go-test/deep"
@@ -120,3 +125,77 @@ func Test_GetCancelFunc(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_StopManagerMonitor(t *testing.T) {
+	testmon := NewMonitor("127.0.0.1:1", "127.0.0.1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	// Nobody reads the status messages, so the monitor is blocked sending the error
+	statusMsgChan := make(chan string)
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		testmon.RunManagerMonitor(ctx, cancel, statusMsgChan, time.Millisecond)
+	}()
+	for !testmon.IsRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		testmon.StopManagerMonitor()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected StopManagerMonitor to return")
+	}
+	select {
+	case <-running:
+	default:
+		t.Error("Expected RunManagerMonitor to have returned")
+	}
+	if testmon.IsRunning() {
+		t.Error("Expected the monitor to be stopped")
+	}
+
+	// The channel is left open for its owner
+	select {
+	case _, ok := <-statusMsgChan:
+		if !ok {
+			t.Error("Expected the status message channel to be left open")
+		}
+	default:
+	}
+}
+
+func Test_GetNodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+managerAPIGetAllConnectedNodes {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `[{"key":"03bb","type":"TCP","send_bytes":10,"recv_bytes":20,"last_ack_time":3,"start_time":60},`+
+			`{"key":"02aa","type":"TCP","send_bytes":1,"recv_bytes":2,"last_ack_time":1,"start_time":5}]`)
+	}))
+	defer server.Close()
+
+	testmon := NewMonitor(strings.TrimPrefix(server.URL, "http://"), "")
+	nodes, err := testmon.GetNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := skynode.NodeInfoSlice{
+		{Key: "02aa", Conntype: "TCP", SendBytes: 1, RecvBytes: 2, LastAckTime: 1, StartTime: 5},
+		{Key: "03bb", Conntype: "TCP", SendBytes: 10, RecvBytes: 20, LastAckTime: 3, StartTime: 60},
+	}
+	if diff := deep.Equal(nodes, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	testmon = NewMonitor("127.0.0.1:1", "")
+	if _, err := testmon.GetNodes(context.Background()); err == nil {
+		t.Error("Expected an error when the Manager can't be reached")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditBan            = "ban"
+	AuditUnban          = "unban"
+	AuditPromote        = "promote"
+	AuditDemote         = "demote"
+	AuditAddUser        = "adduser"
+	AuditUsers          = "users"
+	AuditWhois          = "whois"
+	AuditLimit          = "setlimit"
+	AuditResetTwoFactor = "reset2fa"
+)
+
+// AuditEntry models an action taken by an admin or moderator. Target identifies the
+// user the action was taken on (if any) and Details describes the outcome.
+type AuditEntry struct {
+	ID              int64
+	ActorTelegramID int
+	Action          string
+	Target          string
+	Details         string
+	CreatedAt       time.Time
+}
+
+// AuditRepository provides access to the audit log. Entries are never changed or removed.
+type AuditRepository interface {
+	// Record stores a new entry and sets its ID
+	Record(ctx context.Context, e *AuditEntry) error
+	// List returns up to limit entries, newest first, starting at offset
+	List(ctx context.Context, offset, limit int) ([]AuditEntry, error)
+}
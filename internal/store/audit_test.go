@@ -0,0 +1,45 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_AuditRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		audit := s.AuditLog()
+		for _, action := range []string{AuditBan, AuditUnban, AuditPromote} {
+			e := &AuditEntry{ActorTelegramID: 1001, Action: action, Target: "@alice (1002)", Details: "test"}
+			if err := audit.Record(ctx, e); err != nil {
+				t.Fatalf("%s: Record: %v", name, err)
+			}
+			if e.ID == 0 || e.CreatedAt.IsZero() {
+				t.Errorf("%s: Expected the ID and time to be set: %+v", name, e)
+			}
+		}
+
+		entries, err := audit.List(ctx, 0, 2)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(entries) != 2 || entries[0].Action != AuditPromote || entries[1].Action != AuditUnban {
+			t.Errorf("%s: Unexpected entries: %+v", name, entries)
+		}
+		if entries[0].ActorTelegramID != 1001 || entries[0].Target != "@alice (1002)" || entries[0].Details != "test" {
+			t.Errorf("%s: Unexpected entry: %+v", name, entries[0])
+		}
+
+		entries, err = audit.List(ctx, 2, 2)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(entries) != 1 || entries[0].Action != AuditBan {
+			t.Errorf("%s: Unexpected entries: %+v", name, entries)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Conversation models a multi-step command waiting for the next message of a user.
+// Command is the command which started the conversation, Step names the handler of
+// the next message and Data holds the answers collected by the previous steps.
+// The conversation ends if the user doesn't reply before ExpiresAt.
+type Conversation struct {
+	TelegramID int
+	Command    string
+	Step       string
+	Data       map[string]string
+	ExpiresAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Expired reports whether the user didn't reply to the conversation in time
+func (c *Conversation) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+// ConversationRepository provides access to the conversations waiting for a reply.
+// Each user has at most one conversation.
+type ConversationRepository interface {
+	// Get returns the conversation of the user. ErrNotFound is returned if there isn't one.
+	Get(ctx context.Context, telegramID int) (*Conversation, error)
+	// Save stores the conversation, replacing any existing conversation of the user
+	Save(ctx context.Context, c *Conversation) error
+	// Delete ends the conversation of the user. ErrNotFound is returned if there isn't one.
+	Delete(ctx context.Context, telegramID int) error
+	// DeleteExpired ends the conversations which expired before the provided time
+	// and returns how many were ended
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
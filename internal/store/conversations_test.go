@@ -0,0 +1,70 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_ConversationRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, s := range testStores(t) {
+		repo := s.Conversations()
+
+		if _, err := repo.Get(ctx, 1002); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+		if err := repo.Delete(ctx, 1002); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		c := &Conversation{TelegramID: 1002, Command: "withdraw", Step: "withdraw-amount", Data: map[string]string{},
+			ExpiresAt: now.Add(5 * time.Minute)}
+		if err := repo.Save(ctx, c); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		// The next step replaces the conversation
+		c.Step, c.Data["amount"] = "withdraw-address", "1.5"
+		if err := repo.Save(ctx, c); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		c.Data["amount"] = "changed"
+
+		got, err := repo.Get(ctx, 1002)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		if got.Command != "withdraw" || got.Step != "withdraw-address" || got.Data["amount"] != "1.5" || len(got.Data) != 1 ||
+			!got.ExpiresAt.Equal(now.Add(5*time.Minute)) || got.UpdatedAt.IsZero() {
+			t.Errorf("%s: Unexpected conversation: %+v", name, got)
+		}
+		if got.Expired(now) || !got.Expired(now.Add(5*time.Minute)) {
+			t.Errorf("%s: Unexpected expiry of %+v", name, got)
+		}
+
+		if err := repo.Save(ctx, &Conversation{TelegramID: 1003, Command: "2fa", Step: "2fa-code", ExpiresAt: now.Add(-time.Minute)}); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		if got, err := repo.Get(ctx, 1003); err != nil || got.Data == nil {
+			t.Errorf("%s: Expected empty data, got %+v, %v", name, got, err)
+		}
+		if n, err := repo.DeleteExpired(ctx, now); err != nil || n != 1 {
+			t.Errorf("%s: Expected 1 expired conversation, got %d, %v", name, n, err)
+		}
+		if _, err := repo.Get(ctx, 1003); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		if err := repo.Delete(ctx, 1002); err != nil {
+			t.Fatalf("%s: Delete: %v", name, err)
+		}
+		if _, err := repo.Get(ctx, 1002); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
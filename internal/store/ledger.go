@@ -0,0 +1,113 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInsufficientFunds is returned when a transfer would overdraw a user account
+var ErrInsufficientFunds = errors.New("store: insufficient funds")
+
+// Transfer kinds
+const (
+	TransferTip        = "tip"
+	TransferDeposit    = "deposit"
+	TransferWithdrawal = "withdrawal"
+)
+
+// LedgerAccount identifies an account of the ledger. Every user has their own account,
+// the system accounts hold the other side of the movements in and out of the ledger.
+type LedgerAccount string
+
+const (
+	// AccountOnChain is the system account holding the other side of deposits and
+	// withdrawals. Its balance is the negative of the SKY held on-chain for users.
+	AccountOnChain LedgerAccount = "system:onchain"
+)
+
+const userAccountPrefix = "user:"
+
+// UserAccount returns the ledger account of the stored user with the provided ID
+func UserAccount(userID int64) LedgerAccount {
+	return LedgerAccount(userAccountPrefix + strconv.FormatInt(userID, 10))
+}
+
+// UserID returns the ID of the user owning the account, or 0 for system accounts
+func (a LedgerAccount) UserID() int64 {
+	if !strings.HasPrefix(string(a), userAccountPrefix) {
+		return 0
+	}
+	id, _ := strconv.ParseInt(strings.TrimPrefix(string(a), userAccountPrefix), 10, 64)
+	return id
+}
+
+// Transfer models a movement of SKY (in droplets) between two ledger accounts.
+// Every transfer is recorded as two entries, debiting From and crediting To.
+// A Reference (i.e. the deposited output) can only be used by a single transfer.
+// Limits, if provided, are checked before the transfer is recorded.
+type Transfer struct {
+	ID        int64
+	Kind      string
+	From      LedgerAccount
+	To        LedgerAccount
+	Amount    uint64
+	Memo      string
+	Reference string
+	Limits    *TransferLimits
+	CreatedAt time.Time
+}
+
+// validate checks the transfer can be recorded
+func (t *Transfer) validate() error {
+	switch {
+	case t.Amount == 0:
+		return errors.New("store: transfer amount must be greater than 0")
+	case t.From == "" || t.To == "":
+		return errors.New("store: transfer accounts must be provided")
+	case t.From == t.To:
+		return errors.New("store: can't transfer to the same account")
+	}
+	return nil
+}
+
+// Movement models a transfer as seen from one of its accounts. Amount is positive
+// when the account was credited and negative when it was debited, Counterparty is
+// the other account of the transfer.
+type Movement struct {
+	TransferID   int64
+	Kind         string
+	Amount       int64
+	Counterparty LedgerAccount
+	Memo         string
+	Reference    string
+	CreatedAt    time.Time
+}
+
+// LedgerRepository provides access to the double-entry ledger of SKY held for users
+type LedgerRepository interface {
+	// Balance returns the balance (in droplets) of the account. Accounts
+	// without any entries have a balance of 0.
+	Balance(ctx context.Context, account LedgerAccount) (int64, error)
+	// Transfer records the transfer and sets its ID. The balances are checked and
+	// updated in the same transaction, ErrInsufficientFunds is returned if a user
+	// account would be overdrawn and ErrDuplicate if the Reference was already used.
+	// ErrDailyCapExceeded, ErrOutflowCapExceeded or ErrTipRateExceeded is returned if
+	// the transfer would exceed its Limits.
+	Transfer(ctx context.Context, t *Transfer) error
+	// Sent returns the amount (in droplets) sent from the user account since the provided
+	// time, counting tips and withdrawals less refunded withdrawals. This is the amount
+	// counted against the DailyCap of TransferLimits.
+	Sent(ctx context.Context, account LedgerAccount, since time.Time) (uint64, error)
+	// Movements returns up to limit transfers to and from the account, newest first, starting at offset
+	Movements(ctx context.Context, account LedgerAccount, offset, limit int) ([]Movement, error)
+	// HasReference reports whether a transfer with the Reference has been recorded
+	HasReference(ctx context.Context, reference string) (bool, error)
+}
@@ -0,0 +1,237 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func Test_LedgerAccount(t *testing.T) {
+	if a := UserAccount(42); a != "user:42" || a.UserID() != 42 {
+		t.Errorf("Unexpected user account: %s (%d)", a, a.UserID())
+	}
+	if id := AccountOnChain.UserID(); id != 0 {
+		t.Errorf("Expected system account to have no user, got %d", id)
+	}
+}
+
+func Test_LedgerRepository_Transfer(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		alice, bob := UserAccount(1), UserAccount(2)
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+		}
+
+		deposit := &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: alice, Amount: 5000000, Reference: "output1"}
+		if err := ledger.Transfer(ctx, deposit); err != nil {
+			t.Fatalf("%s: Deposit: %v", name, err)
+		}
+		if deposit.ID == 0 {
+			t.Errorf("%s: Expected ID to be set", name)
+		}
+
+		// A reference can only be credited once
+		again := &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: alice, Amount: 5000000, Reference: "output1"}
+		if err := ledger.Transfer(ctx, again); err != ErrDuplicate {
+			t.Errorf("%s: Expected ErrDuplicate, got %v", name, err)
+		}
+		for reference, expected := range map[string]bool{"output1": true, "output2": false} {
+			if found, err := ledger.HasReference(ctx, reference); err != nil || found != expected {
+				t.Errorf("%s: HasReference(%q) expected %v, got %v, %v", name, reference, expected, found, err)
+			}
+		}
+
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: alice, To: bob, Amount: 2000000, Memo: "thanks"}); err != nil {
+			t.Fatalf("%s: Tip: %v", name, err)
+		}
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: bob, To: alice, Amount: 2000001}); err != ErrInsufficientFunds {
+			t.Errorf("%s: Expected ErrInsufficientFunds, got %v", name, err)
+		}
+
+		expect := map[LedgerAccount]int64{alice: 3000000, bob: 2000000, AccountOnChain: -5000000}
+		var sum int64
+		for account, balance := range expect {
+			actual, err := ledger.Balance(ctx, account)
+			if err != nil {
+				t.Fatalf("%s: Balance: %v", name, err)
+			}
+			if actual != balance {
+				t.Errorf("%s: Expected %s balance %d, got %d", name, account, balance, actual)
+			}
+			sum += actual
+		}
+		if sum != 0 {
+			t.Errorf("%s: Expected the balances to sum to 0, got %d", name, sum)
+		}
+
+		if balance, err := ledger.Balance(ctx, UserAccount(99)); err != nil || balance != 0 {
+			t.Errorf("%s: Expected an empty account, got %d: %v", name, balance, err)
+		}
+		s.Close()
+	}
+}
+
+func Test_LedgerRepository_Movements(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		alice, bob := UserAccount(1), UserAccount(2)
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+		}
+
+		for _, tr := range []*Transfer{
+			{Kind: TransferDeposit, From: AccountOnChain, To: alice, Amount: 5000000, Memo: "txid1", Reference: "output1"},
+			{Kind: TransferTip, From: alice, To: bob, Amount: 2000000, Memo: "thanks"},
+			{Kind: TransferTip, From: bob, To: alice, Amount: 1000000},
+			{Kind: TransferWithdrawal, From: alice, To: AccountOnChain, Amount: 3000000, Reference: "withdrawal:1"},
+		} {
+			if err := ledger.Transfer(ctx, tr); err != nil {
+				t.Fatalf("%s: Transfer: %v", name, err)
+			}
+		}
+
+		movements, err := ledger.Movements(ctx, alice, 0, 10)
+		if err != nil {
+			t.Fatalf("%s: Movements: %v", name, err)
+		}
+		if len(movements) != 4 {
+			t.Fatalf("%s: Expected 4 movements, got %+v", name, movements)
+		}
+		if m := movements[0]; m.Kind != TransferWithdrawal || m.Amount != -3000000 || m.Counterparty != AccountOnChain || m.Reference != "withdrawal:1" {
+			t.Errorf("%s: Unexpected withdrawal: %+v", name, m)
+		}
+		if m := movements[1]; m.Kind != TransferTip || m.Amount != 1000000 || m.Counterparty != bob || m.Reference != "" {
+			t.Errorf("%s: Unexpected tip received: %+v", name, m)
+		}
+		if m := movements[2]; m.Amount != -2000000 || m.Counterparty != bob || m.Memo != "thanks" || m.CreatedAt.IsZero() {
+			t.Errorf("%s: Unexpected tip sent: %+v", name, m)
+		}
+		if m := movements[3]; m.Kind != TransferDeposit || m.Amount != 5000000 || m.Memo != "txid1" {
+			t.Errorf("%s: Unexpected deposit: %+v", name, m)
+		}
+
+		page, err := ledger.Movements(ctx, bob, 1, 1)
+		if err != nil {
+			t.Fatalf("%s: Movements: %v", name, err)
+		}
+		if len(page) != 1 || page[0].Amount != 2000000 || page[0].Counterparty != alice {
+			t.Errorf("%s: Unexpected page: %+v", name, page)
+		}
+		if page, err = ledger.Movements(ctx, UserAccount(99), 0, 10); err != nil || len(page) != 0 {
+			t.Errorf("%s: Expected no movements, got %+v, %v", name, page, err)
+		}
+		s.Close()
+	}
+}
+
+func Test_LedgerRepository_Invalid(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		for _, tr := range []*Transfer{
+			{Kind: TransferTip, From: UserAccount(1), To: UserAccount(2)},
+			{Kind: TransferTip, From: UserAccount(1), To: UserAccount(1), Amount: 1},
+			{Kind: TransferTip, To: UserAccount(1), Amount: 1},
+		} {
+			if err := s.Ledger().Transfer(ctx, tr); err == nil {
+				t.Errorf("%s: Expected an error for %+v", name, tr)
+			}
+		}
+		s.Close()
+	}
+}
+
+func Test_LedgerRepository_ConcurrentTips(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+		}
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: UserAccount(1), Amount: 10}); err != nil {
+			t.Fatalf("%s: Deposit: %v", name, err)
+		}
+
+		// Only 10 of the tips can be covered by the balance
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		succeeded := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: UserAccount(1), To: UserAccount(2), Amount: 1})
+				if err == nil {
+					mutex.Lock()
+					succeeded++
+					mutex.Unlock()
+				} else if err != ErrInsufficientFunds {
+					t.Errorf("%s: Transfer: %v", name, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 10 {
+			t.Errorf("%s: Expected 10 tips, got %d", name, succeeded)
+		}
+		if balance, _ := ledger.Balance(ctx, UserAccount(1)); balance != 0 {
+			t.Errorf("%s: Expected an empty balance, got %d", name, balance)
+		}
+		s.Close()
+	}
+}
+
+func Test_LedgerRepository_ConcurrentOppositeTips(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+			if err := ledger.Transfer(ctx, &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: UserAccount(int64(n)), Amount: 100}); err != nil {
+				t.Fatalf("%s: Deposit: %v", name, err)
+			}
+		}
+
+		// The users tip each other at the same time, with limits so the accounts are
+		// locked before they are counted on
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			from, to := UserAccount(1), UserAccount(2)
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: from, To: to, Amount: 1,
+					Limits: &TransferLimits{DailyCap: 1000}})
+				if err != nil {
+					t.Errorf("%s: Transfer: %v", name, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for n := int64(1); n <= 2; n++ {
+			if balance, _ := ledger.Balance(ctx, UserAccount(n)); balance != 100 {
+				t.Errorf("%s: Expected a balance of 100 for user %d, got %d", name, n, balance)
+			}
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDailyCapExceeded is returned when a transfer would take the user over their daily cap
+	ErrDailyCapExceeded = errors.New("store: daily cap exceeded")
+	// ErrOutflowCapExceeded is returned when a withdrawal would take the Bot over its hourly outflow cap
+	ErrOutflowCapExceeded = errors.New("store: hourly outflow cap exceeded")
+	// ErrTipRateExceeded is returned when a user has already sent the maximum number of tips in the last minute
+	ErrTipRateExceeded = errors.New("store: tip rate exceeded")
+)
+
+// Limits which can be overridden for a user
+const (
+	LimitMinTip        = "mintip"
+	LimitMaxTip        = "maxtip"
+	LimitDailyCap      = "dailycap"
+	LimitTipsPerMinute = "tipsperminute"
+)
+
+// Limit windows
+const (
+	DailyCapWindow      = 24 * time.Hour
+	OutflowCapWindow    = time.Hour
+	TipsPerMinuteWindow = time.Minute
+)
+
+// TransferLimits are checked in the same database transaction as a transfer, so concurrent
+// transfers can't both pass them. A limit of 0 is unlimited.
+//
+// DailyCap is the amount (in droplets) the From user account may send in DailyCapWindow,
+// counting tips and withdrawals less refunded withdrawals. HourlyOutflowCap is the amount
+// all users may withdraw in OutflowCapWindow, and only applies to transfers to AccountOnChain.
+// TipsPerMinute is the number of tips the From user account may send in TipsPerMinuteWindow.
+type TransferLimits struct {
+	DailyCap         uint64
+	HourlyOutflowCap uint64
+	TipsPerMinute    int
+}
+
+// LimitOverride models a limit set for a single user by an admin, which replaces the
+// configured limit. A Value of 0 is unlimited. Amounts are in droplets.
+type LimitOverride struct {
+	TelegramID int
+	Name       string
+	Value      uint64
+	UpdatedBy  int
+	UpdatedAt  time.Time
+}
+
+// LimitRepository provides access to the limits overridden for users
+type LimitRepository interface {
+	// List returns the limits overridden for the user, ordered by name
+	List(ctx context.Context, telegramID int) ([]LimitOverride, error)
+	// Set stores the override, replacing any existing override of the same limit
+	Set(ctx context.Context, o *LimitOverride) error
+	// Delete removes the override so the configured limit applies again.
+	// ErrNotFound is returned if the limit isn't overridden.
+	Delete(ctx context.Context, telegramID int, name string) error
+}
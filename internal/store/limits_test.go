@@ -0,0 +1,159 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_LedgerRepository_TransferLimits(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		alice, bob := UserAccount(1), UserAccount(2)
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+		}
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: alice, Amount: 100000000, Reference: "output1"}); err != nil {
+			t.Fatalf("%s: Deposit: %v", name, err)
+		}
+
+		// Tips from more than a day ago don't count against the daily cap
+		old := &Transfer{Kind: TransferTip, From: alice, To: bob, Amount: 9000000, CreatedAt: time.Now().UTC().Add(-25 * time.Hour)}
+		if err := ledger.Transfer(ctx, old); err != nil {
+			t.Fatalf("%s: Tip: %v", name, err)
+		}
+
+		limits := &TransferLimits{DailyCap: 10000000, HourlyOutflowCap: 5000000, TipsPerMinute: 2}
+		tip := func(amount uint64) error {
+			return ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: alice, To: bob, Amount: amount, Limits: limits})
+		}
+		if err := tip(3000000); err != nil {
+			t.Fatalf("%s: Tip: %v", name, err)
+		}
+		if err := tip(1000000); err != nil {
+			t.Fatalf("%s: Tip: %v", name, err)
+		}
+		if err := tip(1000000); err != ErrTipRateExceeded {
+			t.Errorf("%s: Expected ErrTipRateExceeded, got %v", name, err)
+		}
+
+		// Withdrawals count against the daily cap and the hourly outflow cap, refunds are given back
+		withdraw := func(ref string, amount uint64) error {
+			return ledger.Transfer(ctx, &Transfer{Kind: TransferWithdrawal, From: alice, To: AccountOnChain, Amount: amount, Reference: ref, Limits: limits})
+		}
+		if err := withdraw("withdrawal:1", 5000001); err != ErrOutflowCapExceeded {
+			t.Errorf("%s: Expected ErrOutflowCapExceeded, got %v", name, err)
+		}
+		if err := withdraw("withdrawal:1", 5000000); err != nil {
+			t.Fatalf("%s: Withdraw: %v", name, err)
+		}
+		if err := withdraw("withdrawal:2", 2000000); err != ErrDailyCapExceeded {
+			t.Errorf("%s: Expected ErrDailyCapExceeded, got %v", name, err)
+		}
+		refund := &Transfer{Kind: TransferWithdrawal, From: AccountOnChain, To: alice, Amount: 5000000, Reference: "withdrawal-refund:1"}
+		if err := ledger.Transfer(ctx, refund); err != nil {
+			t.Fatalf("%s: Refund: %v", name, err)
+		}
+		if err := withdraw("withdrawal:2", 5000000); err != nil {
+			t.Errorf("%s: Expected the refund to be given back, got %v", name, err)
+		}
+
+		sent, err := ledger.Sent(ctx, alice, time.Now().Add(-DailyCapWindow))
+		if err != nil {
+			t.Fatalf("%s: Sent: %v", name, err)
+		}
+		if sent != 9000000 {
+			t.Errorf("%s: Expected 9000000 sent, got %d", name, sent)
+		}
+		if sent, err = ledger.Sent(ctx, bob, time.Now().Add(-DailyCapWindow)); err != nil || sent != 0 {
+			t.Errorf("%s: Expected nothing sent by bob, got %d, %v", name, sent, err)
+		}
+
+		// Limits are only checked when provided
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: alice, To: bob, Amount: 5000000}); err != nil {
+			t.Errorf("%s: Expected an unlimited tip, got %v", name, err)
+		}
+		if balance, _ := ledger.Balance(ctx, alice); balance != 100000000-9000000-4000000-5000000-5000000 {
+			t.Errorf("%s: Unexpected balance %d", name, balance)
+		}
+	}
+}
+
+func Test_LedgerRepository_TransferLimitsConcurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		ledger := s.Ledger()
+		alice, bob := UserAccount(1), UserAccount(2)
+		for n := 1; n <= 2; n++ {
+			if err := s.Users().Create(ctx, newTestUser(n)); err != nil {
+				t.Fatalf("%s: Create: %v", name, err)
+			}
+		}
+		if err := ledger.Transfer(ctx, &Transfer{Kind: TransferDeposit, From: AccountOnChain, To: alice, Amount: 100000000, Reference: "output1"}); err != nil {
+			t.Fatalf("%s: Deposit: %v", name, err)
+		}
+
+		// Only 5 of the concurrent tips fit in the daily cap
+		limits := &TransferLimits{DailyCap: 5000000}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ledger.Transfer(ctx, &Transfer{Kind: TransferTip, From: alice, To: bob, Amount: 1000000, Limits: limits})
+			}()
+		}
+		wg.Wait()
+
+		if balance, _ := ledger.Balance(ctx, bob); balance != 5000000 {
+			t.Errorf("%s: Expected 5000000 to be tipped, got %d", name, balance)
+		}
+	}
+}
+
+func Test_LimitRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		limits := s.Limits()
+
+		if err := limits.Set(ctx, &LimitOverride{TelegramID: 1002, Name: LimitMaxTip, Value: 5000000, UpdatedBy: 1000}); err != nil {
+			t.Fatalf("%s: Set: %v", name, err)
+		}
+		if err := limits.Set(ctx, &LimitOverride{TelegramID: 1002, Name: LimitDailyCap, Value: 0, UpdatedBy: 1000}); err != nil {
+			t.Fatalf("%s: Set: %v", name, err)
+		}
+		if err := limits.Set(ctx, &LimitOverride{TelegramID: 1002, Name: LimitMaxTip, Value: 7000000, UpdatedBy: 1001}); err != nil {
+			t.Fatalf("%s: Set: %v", name, err)
+		}
+
+		overrides, err := limits.List(ctx, 1002)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(overrides) != 2 || overrides[0].Name != LimitDailyCap || overrides[1].Name != LimitMaxTip ||
+			overrides[1].Value != 7000000 || overrides[1].UpdatedBy != 1001 {
+			t.Errorf("%s: Unexpected overrides: %+v", name, overrides)
+		}
+
+		if err := limits.Delete(ctx, 1002, LimitMaxTip); err != nil {
+			t.Fatalf("%s: Delete: %v", name, err)
+		}
+		if err := limits.Delete(ctx, 1002, LimitMaxTip); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+		if overrides, err = limits.List(ctx, 1002); err != nil || len(overrides) != 1 {
+			t.Errorf("%s: Unexpected overrides: %+v, %v", name, overrides, err)
+		}
+		if overrides, err = limits.List(ctx, 1003); err != nil || len(overrides) != 0 {
+			t.Errorf("%s: Unexpected overrides: %+v, %v", name, overrides, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role is the role of a Telegram user, which determines the commands they may use
+type Role string
+
+// Roles, from least to most privileged. Banned users are ignored by the Bot.
+const (
+	RoleBanned    Role = "banned"
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRanks orders the roles. Unknown roles rank below every known role.
+var roleRanks = map[Role]int{
+	RoleBanned:    1,
+	RoleUser:      2,
+	RoleModerator: 3,
+	RoleAdmin:     4,
+}
+
+// AtLeast reports whether the role is at least as privileged as min
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] >= roleRanks[min]
+}
+
+// ParseRole returns the Role named by s (not case sensitive)
+func ParseRole(s string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, found := roleRanks[r]; !found {
+		return "", fmt.Errorf("unknown role: %s", s)
+	}
+	return r, nil
+}
+
+// Member models a Telegram user who has interacted with the Bot, and their role.
+// Members are keyed by their numeric Telegram ID, usernames are only kept for display
+// and lookups as they can change (or be taken over by someone else).
+type Member struct {
+	TelegramID int
+	UserName   string
+	FirstName  string
+	Role       Role
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// MemberRepository provides access to the stored members
+type MemberRepository interface {
+	// Get returns the member with the provided numeric Telegram user ID
+	Get(ctx context.Context, telegramID int) (*Member, error)
+	// GetByUserName returns the most recently seen member with the provided Telegram username (not case sensitive)
+	GetByUserName(ctx context.Context, username string) (*Member, error)
+	// Touch records activity by the member. New members are stored with RoleUser, the UserName,
+	// FirstName and LastSeenAt of existing members are updated. m is set to the stored member.
+	Touch(ctx context.Context, m *Member) error
+	// SetRole stores the role of the member with the provided Telegram user ID, creating the
+	// member if needed
+	SetRole(ctx context.Context, telegramID int, role Role) error
+	// List returns up to limit members ordered by Telegram user ID, starting at offset
+	List(ctx context.Context, offset, limit int) ([]Member, error)
+}
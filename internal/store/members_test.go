@@ -0,0 +1,89 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_Role_AtLeast(t *testing.T) {
+	if !RoleAdmin.AtLeast(RoleModerator) || !RoleModerator.AtLeast(RoleModerator) || !RoleUser.AtLeast(RoleBanned) {
+		t.Error("Expected higher roles to be at least as privileged as lower roles")
+	}
+	if RoleBanned.AtLeast(RoleUser) || RoleUser.AtLeast(RoleModerator) || Role("").AtLeast(RoleBanned) {
+		t.Error("Expected lower (and unknown) roles not to be as privileged as higher roles")
+	}
+}
+
+func Test_ParseRole(t *testing.T) {
+	if r, err := ParseRole(" Moderator "); err != nil || r != RoleModerator {
+		t.Errorf("Unexpected result: %q, %v", r, err)
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Error("Expected an error for an unknown role")
+	}
+}
+
+func Test_MemberRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		members := s.Members()
+
+		if _, err := members.Get(ctx, 1001); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		m := &Member{TelegramID: 1001, UserName: "alice", FirstName: "Alice"}
+		if err := members.Touch(ctx, m); err != nil {
+			t.Fatalf("%s: Touch: %v", name, err)
+		}
+		if m.Role != RoleUser || m.UserName != "@alice" || m.CreatedAt.IsZero() {
+			t.Errorf("%s: Unexpected new member: %+v", name, m)
+		}
+
+		if err := members.SetRole(ctx, 1001, RoleModerator); err != nil {
+			t.Fatalf("%s: SetRole: %v", name, err)
+		}
+		m = &Member{TelegramID: 1001, UserName: "alice2", FirstName: "Alice"}
+		if err := members.Touch(ctx, m); err != nil {
+			t.Fatalf("%s: Touch: %v", name, err)
+		}
+		if m.Role != RoleModerator || m.UserName != "@alice2" {
+			t.Errorf("%s: Expected the role to be kept and the username updated: %+v", name, m)
+		}
+
+		got, err := members.GetByUserName(ctx, "ALICE2")
+		if err != nil {
+			t.Fatalf("%s: GetByUserName: %v", name, err)
+		}
+		if got.TelegramID != 1001 || got.Role != RoleModerator || got.FirstName != "Alice" {
+			t.Errorf("%s: Unexpected member: %+v", name, got)
+		}
+
+		// Members can be given a role before they talk to the Bot
+		if err := members.SetRole(ctx, 1000, RoleBanned); err != nil {
+			t.Fatalf("%s: SetRole: %v", name, err)
+		}
+		got, err = members.Get(ctx, 1000)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		if got.Role != RoleBanned {
+			t.Errorf("%s: Expected banned, got %q", name, got.Role)
+		}
+
+		list, err := members.List(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(list) != 2 || list[0].TelegramID != 1000 || list[1].TelegramID != 1001 {
+			t.Errorf("%s: Unexpected members: %+v", name, list)
+		}
+		if list, err = members.List(ctx, 1, 10); err != nil || len(list) != 1 || list[0].TelegramID != 1001 {
+			t.Errorf("%s: Unexpected second page: %+v, %v", name, list, err)
+		}
+	}
+}
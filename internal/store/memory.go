@@ -0,0 +1,943 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store which keeps all records in memory. It is intended for
+// tests and for running the Bot without a database. Nothing is persisted.
+type MemoryStore struct {
+	mutex           sync.RWMutex
+	users           []User
+	nextID          int64
+	derivationIndex uint32
+	balances        map[LedgerAccount]int64
+	transfers       []Transfer
+	transactions    []Transaction
+	members         map[int]Member
+	auditLog        []AuditEntry
+	limits          map[int]map[string]LimitOverride
+	twoFactor       map[int]TwoFactor
+	conversations   map[int]Conversation
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nextID: 1, balances: make(map[LedgerAccount]int64), members: make(map[int]Member),
+		limits: make(map[int]map[string]LimitOverride), twoFactor: make(map[int]TwoFactor),
+		conversations: make(map[int]Conversation)}
+}
+
+// Users returns the UserRepository of the store
+func (m *MemoryStore) Users() UserRepository {
+	return memoryUserRepository{m}
+}
+
+// Addresses returns the AddressRepository of the store
+func (m *MemoryStore) Addresses() AddressRepository {
+	return memoryAddressRepository{m}
+}
+
+// Ledger returns the LedgerRepository of the store
+func (m *MemoryStore) Ledger() LedgerRepository {
+	return memoryLedgerRepository{m}
+}
+
+// Transactions returns the TransactionRepository of the store
+func (m *MemoryStore) Transactions() TransactionRepository {
+	return memoryTransactionRepository{m}
+}
+
+// Members returns the MemberRepository of the store
+func (m *MemoryStore) Members() MemberRepository {
+	return memoryMemberRepository{m}
+}
+
+// Limits returns the LimitRepository of the store
+func (m *MemoryStore) Limits() LimitRepository {
+	return memoryLimitRepository{m: m}
+}
+
+// TwoFactor returns the TwoFactorRepository of the store
+func (m *MemoryStore) TwoFactor() TwoFactorRepository {
+	return memoryTwoFactorRepository{m}
+}
+
+// Conversations returns the ConversationRepository of the store
+func (m *MemoryStore) Conversations() ConversationRepository {
+	return memoryConversationRepository{m}
+}
+
+// AuditLog returns the AuditRepository of the store
+func (m *MemoryStore) AuditLog() AuditRepository {
+	return memoryAuditRepository{m}
+}
+
+// Migrate satisfies the Store interface. There is no schema to migrate.
+func (m *MemoryStore) Migrate(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// SchemaVersion satisfies the Store interface. A MemoryStore is always up to date.
+func (m *MemoryStore) SchemaVersion(ctx context.Context) (int, error) {
+	return LatestSchemaVersion, nil
+}
+
+// Close satisfies the Store interface. The records are kept.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// memoryUserRepository is a UserRepository backed by a MemoryStore
+type memoryUserRepository struct {
+	m *MemoryStore
+}
+
+// find returns the index of the first user matching fn, or -1.
+// The caller must hold the store mutex.
+func (r memoryUserRepository) find(fn func(u *User) bool) int {
+	for i := range r.m.users {
+		if fn(&r.m.users[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// conflicts reports whether u clashes with the unique fields of another user,
+// other than the users with the ignored IDs. The caller must hold the store mutex.
+func (r memoryUserRepository) conflicts(u *User, ignore ...int64) bool {
+	return r.find(func(other *User) bool {
+		if other.ID == u.ID {
+			return false
+		}
+		for _, id := range ignore {
+			if other.ID == id {
+				return false
+			}
+		}
+		return (u.UserName != "" && strings.EqualFold(other.UserName, u.UserName)) ||
+			(u.TelegramID != 0 && other.TelegramID == u.TelegramID) ||
+			(u.Address != "" && (other.Address == u.Address || other.PublicKey == u.PublicKey)) ||
+			(u.DerivationIndex != 0 && other.DerivationIndex == u.DerivationIndex)
+	}) >= 0
+}
+
+func (r memoryUserRepository) get(fn func(u *User) bool) (*User, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	i := r.find(fn)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	u := r.m.users[i]
+	return &u, nil
+}
+
+// Get returns the user with the provided ID
+func (r memoryUserRepository) Get(ctx context.Context, id int64) (*User, error) {
+	return r.get(func(u *User) bool { return u.ID == id })
+}
+
+// GetByTelegramID returns the user with the provided numeric Telegram user ID
+func (r memoryUserRepository) GetByTelegramID(ctx context.Context, telegramID int) (*User, error) {
+	if telegramID == 0 {
+		return nil, ErrNotFound
+	}
+	return r.get(func(u *User) bool { return u.TelegramID == telegramID })
+}
+
+// GetByUserName returns the user with the provided Telegram username (not case sensitive)
+func (r memoryUserRepository) GetByUserName(ctx context.Context, username string) (*User, error) {
+	username = NormalizeUserName(username)
+	if username == "" {
+		return nil, ErrNotFound
+	}
+	return r.get(func(u *User) bool { return strings.EqualFold(u.UserName, username) })
+}
+
+// Create stores a new user and sets its ID
+func (r memoryUserRepository) Create(ctx context.Context, u *User) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	u.UserName = NormalizeUserName(u.UserName)
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+	u.ID = 0
+	if r.conflicts(u) {
+		return ErrDuplicate
+	}
+
+	u.ID = r.m.nextID
+	r.m.nextID++
+	r.m.users = append(r.m.users, *u)
+	return nil
+}
+
+// update applies fn to the stored copy of u, rejecting changes which conflict with other users
+func (r memoryUserRepository) update(u *User, fn func(stored *User)) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	i := r.find(func(other *User) bool { return other.ID == u.ID })
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	updated := r.m.users[i]
+	fn(&updated)
+	if r.conflicts(&updated) {
+		return ErrDuplicate
+	}
+	r.m.users[i] = updated
+	return nil
+}
+
+// Update stores the Telegram details (TelegramID, ChatID and UserName) of an existing user
+func (r memoryUserRepository) Update(ctx context.Context, u *User) error {
+	u.UserName = NormalizeUserName(u.UserName)
+	return r.update(u, func(stored *User) {
+		stored.TelegramID = u.TelegramID
+		stored.ChatID = u.ChatID
+		stored.UserName = u.UserName
+	})
+}
+
+// UpdateWallet stores the wallet details (Address, PublicKey, SecretKey and DerivationIndex) of an existing user
+func (r memoryUserRepository) UpdateWallet(ctx context.Context, u *User) error {
+	return r.update(u, func(stored *User) {
+		stored.Address = u.Address
+		stored.PublicKey = u.PublicKey
+		stored.SecretKey = u.SecretKey
+		stored.DerivationIndex = u.DerivationIndex
+	})
+}
+
+// MergePlaceholder moves the ledger account of the placeholder user to u, deletes the
+// placeholder and stores the Telegram details of u
+func (r memoryUserRepository) MergePlaceholder(ctx context.Context, u *User, placeholderID int64) error {
+	u.UserName = NormalizeUserName(u.UserName)
+
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	p := r.find(func(other *User) bool { return other.ID == placeholderID })
+	if p < 0 || placeholderID == u.ID || r.m.users[p].TelegramID != 0 {
+		return ErrNotFound
+	}
+	i := r.find(func(other *User) bool { return other.ID == u.ID })
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	updated := r.m.users[i]
+	updated.TelegramID = u.TelegramID
+	updated.ChatID = u.ChatID
+	updated.UserName = u.UserName
+	if r.conflicts(&updated, placeholderID) {
+		return ErrDuplicate
+	}
+
+	from, to := UserAccount(placeholderID), UserAccount(u.ID)
+	for j := range r.m.transfers {
+		if r.m.transfers[j].From == from {
+			r.m.transfers[j].From = to
+		}
+		if r.m.transfers[j].To == from {
+			r.m.transfers[j].To = to
+		}
+	}
+	if balance, ok := r.m.balances[from]; ok {
+		r.m.balances[to] += balance
+		delete(r.m.balances, from)
+	}
+	for j := range r.m.transactions {
+		if r.m.transactions[j].UserID == placeholderID {
+			r.m.transactions[j].UserID = u.ID
+		}
+	}
+
+	r.m.users[i] = updated
+	r.m.users = append(r.m.users[:p], r.m.users[p+1:]...)
+	return nil
+}
+
+// List returns up to limit users ordered by ID, starting at offset
+func (r memoryUserRepository) List(ctx context.Context, offset, limit int) ([]User, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	if offset >= len(r.m.users) {
+		return nil, nil
+	}
+	end := offset + limit
+	if end > len(r.m.users) {
+		end = len(r.m.users)
+	}
+
+	users := make([]User, end-offset)
+	copy(users, r.m.users[offset:end])
+	return users, nil
+}
+
+// memoryAddressRepository is an AddressRepository backed by a MemoryStore
+type memoryAddressRepository struct {
+	m *MemoryStore
+}
+
+// RewriteSecretKeys calls fn for every stored address and replaces its secret key with the result.
+// If fn fails no key is changed.
+func (r memoryAddressRepository) RewriteSecretKeys(ctx context.Context, fn SecretKeyRewriter) (int, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	secretKeys := make([]string, len(r.m.users))
+	changed := 0
+	for i, u := range r.m.users {
+		secretKeys[i] = u.SecretKey
+		if u.Address == "" {
+			continue
+		}
+		secretKey, err := fn(u.Address, u.SecretKey)
+		if err != nil {
+			return 0, err
+		}
+		if secretKey != u.SecretKey {
+			secretKeys[i] = secretKey
+			changed++
+		}
+	}
+
+	for i := range r.m.users {
+		r.m.users[i].SecretKey = secretKeys[i]
+	}
+	return changed, nil
+}
+
+// ReserveDerivationIndex returns the next unused derivation index
+func (r memoryAddressRepository) ReserveDerivationIndex(ctx context.Context) (uint32, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	r.m.derivationIndex++
+	return r.m.derivationIndex, nil
+}
+
+// ListDerived returns every address derived from the wallet seed ordered by derivation index
+func (r memoryAddressRepository) ListDerived(ctx context.Context) ([]DerivedAddress, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var addresses []DerivedAddress
+	for _, u := range r.m.users {
+		if u.Address == "" || u.DerivationIndex == 0 {
+			continue
+		}
+		addresses = append(addresses, DerivedAddress{
+			UserID:          u.ID,
+			Address:         u.Address,
+			PublicKey:       u.PublicKey,
+			DerivationIndex: u.DerivationIndex,
+		})
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].DerivationIndex < addresses[j].DerivationIndex
+	})
+	return addresses, nil
+}
+
+// memoryLedgerRepository is a LedgerRepository backed by a MemoryStore
+type memoryLedgerRepository struct {
+	m *MemoryStore
+}
+
+// Balance returns the balance (in droplets) of the account
+func (r memoryLedgerRepository) Balance(ctx context.Context, account LedgerAccount) (int64, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	return r.m.balances[account], nil
+}
+
+// Transfer records the transfer and updates the balances of both accounts
+func (r memoryLedgerRepository) Transfer(ctx context.Context, t *Transfer) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if t.Reference != "" {
+		for _, other := range r.m.transfers {
+			if other.Reference == t.Reference {
+				return ErrDuplicate
+			}
+		}
+	}
+	if t.Limits != nil {
+		if err := r.checkLimits(t); err != nil {
+			return err
+		}
+	}
+	if t.From.UserID() != 0 && r.m.balances[t.From] < int64(t.Amount) {
+		return ErrInsufficientFunds
+	}
+
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	t.ID = int64(len(r.m.transfers) + 1)
+	r.m.balances[t.From] -= int64(t.Amount)
+	r.m.balances[t.To] += int64(t.Amount)
+	r.m.transfers = append(r.m.transfers, *t)
+	return nil
+}
+
+// checkLimits checks the transfer against its Limits. The caller must hold the lock.
+func (r memoryLedgerRepository) checkLimits(t *Transfer) error {
+	now := time.Now().UTC()
+
+	if t.Limits.DailyCap > 0 && r.sent(t.From, now.Add(-DailyCapWindow))+t.Amount > t.Limits.DailyCap {
+		return ErrDailyCapExceeded
+	}
+
+	if t.Limits.TipsPerMinute > 0 && t.Kind == TransferTip {
+		tips := 0
+		since := now.Add(-TipsPerMinuteWindow)
+		for _, other := range r.m.transfers {
+			if other.Kind == TransferTip && other.From == t.From && !other.CreatedAt.Before(since) {
+				tips++
+			}
+		}
+		if tips >= t.Limits.TipsPerMinute {
+			return ErrTipRateExceeded
+		}
+	}
+
+	if t.Limits.HourlyOutflowCap > 0 && t.To == AccountOnChain {
+		// Withdrawals credit the on-chain account, refunds debit it
+		var withdrawn int64
+		since := now.Add(-OutflowCapWindow)
+		for _, other := range r.m.transfers {
+			if other.Kind != TransferWithdrawal || other.CreatedAt.Before(since) {
+				continue
+			}
+			if other.To == AccountOnChain {
+				withdrawn += int64(other.Amount)
+			} else if other.From == AccountOnChain {
+				withdrawn -= int64(other.Amount)
+			}
+		}
+		if withdrawn < 0 {
+			withdrawn = 0
+		}
+		if uint64(withdrawn)+t.Amount > t.Limits.HourlyOutflowCap {
+			return ErrOutflowCapExceeded
+		}
+	}
+	return nil
+}
+
+// sent returns the amount sent from the account since the provided time. The caller must hold the lock.
+func (r memoryLedgerRepository) sent(account LedgerAccount, since time.Time) uint64 {
+	// Tips sent are debits, withdrawals are debits and their refunds credits
+	var sent int64
+	for _, t := range r.m.transfers {
+		if t.CreatedAt.Before(since) {
+			continue
+		}
+		switch {
+		case (t.Kind == TransferTip || t.Kind == TransferWithdrawal) && t.From == account:
+			sent += int64(t.Amount)
+		case t.Kind == TransferWithdrawal && t.To == account:
+			sent -= int64(t.Amount)
+		}
+	}
+	if sent < 0 {
+		return 0
+	}
+	return uint64(sent)
+}
+
+// Sent returns the amount (in droplets) sent from the user account since the provided time
+func (r memoryLedgerRepository) Sent(ctx context.Context, account LedgerAccount, since time.Time) (uint64, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	return r.sent(account, since), nil
+}
+
+// Movements returns up to limit transfers to and from the account, newest first, starting at offset
+func (r memoryLedgerRepository) Movements(ctx context.Context, account LedgerAccount, offset, limit int) ([]Movement, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var movements []Movement
+	for i := len(r.m.transfers) - 1; i >= 0 && len(movements) < limit; i-- {
+		t := r.m.transfers[i]
+		m := Movement{TransferID: t.ID, Kind: t.Kind, Memo: t.Memo, Reference: t.Reference, CreatedAt: t.CreatedAt}
+		switch account {
+		case t.From:
+			m.Amount, m.Counterparty = -int64(t.Amount), t.To
+		case t.To:
+			m.Amount, m.Counterparty = int64(t.Amount), t.From
+		default:
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		movements = append(movements, m)
+	}
+	return movements, nil
+}
+
+// HasReference reports whether a transfer with the Reference has been recorded
+func (r memoryLedgerRepository) HasReference(ctx context.Context, reference string) (bool, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	for _, t := range r.m.transfers {
+		if t.Reference == reference {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memoryTransactionRepository is a TransactionRepository backed by a MemoryStore
+type memoryTransactionRepository struct {
+	m *MemoryStore
+}
+
+// Create stores a new transaction and sets its ID
+func (r memoryTransactionRepository) Create(ctx context.Context, t *Transaction) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	t.UpdatedAt = t.CreatedAt
+	t.ID = int64(len(r.m.transactions) + 1)
+	r.m.transactions = append(r.m.transactions, *t)
+	return nil
+}
+
+// get returns a copy of the first transaction matching fn
+func (r memoryTransactionRepository) get(fn func(t *Transaction) bool) (*Transaction, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	for i := range r.m.transactions {
+		if fn(&r.m.transactions[i]) {
+			t := r.m.transactions[i]
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Get returns the transaction with the provided ID
+func (r memoryTransactionRepository) Get(ctx context.Context, id int64) (*Transaction, error) {
+	return r.get(func(t *Transaction) bool { return t.ID == id })
+}
+
+// GetByTxID returns the transaction with the provided txid
+func (r memoryTransactionRepository) GetByTxID(ctx context.Context, txid string) (*Transaction, error) {
+	if txid == "" {
+		return nil, ErrNotFound
+	}
+	return r.get(func(t *Transaction) bool { return t.TxID == txid })
+}
+
+// UpdateStatus stores the Status, TxID, Hours and Error of the transaction if it is still in the from status
+func (r memoryTransactionRepository) UpdateStatus(ctx context.Context, t *Transaction, from string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	for i := range r.m.transactions {
+		stored := &r.m.transactions[i]
+		if stored.ID != t.ID {
+			continue
+		}
+		if stored.Status != from {
+			return ErrStatusChanged
+		}
+		t.UpdatedAt = time.Now().UTC()
+		stored.Status, stored.TxID, stored.Hours, stored.Error, stored.UpdatedAt = t.Status, t.TxID, t.Hours, t.Error, t.UpdatedAt
+		return nil
+	}
+	return ErrNotFound
+}
+
+// List returns up to limit transactions matching the filter, newest first, starting at offset
+func (r memoryTransactionRepository) List(ctx context.Context, filter TransactionFilter, offset, limit int) ([]Transaction, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var result []Transaction
+	for i := len(r.m.transactions) - 1; i >= 0 && len(result) < limit; i-- {
+		t := r.m.transactions[i]
+		if (filter.UserID != 0 && t.UserID != filter.UserID) ||
+			(filter.Kind != "" && t.Kind != filter.Kind) ||
+			(filter.Status != "" && t.Status != filter.Status) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// memoryMemberRepository is a MemberRepository backed by a MemoryStore
+type memoryMemberRepository struct {
+	m *MemoryStore
+}
+
+// Get returns the member with the provided numeric Telegram user ID
+func (r memoryMemberRepository) Get(ctx context.Context, telegramID int) (*Member, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	m, found := r.m.members[telegramID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &m, nil
+}
+
+// GetByUserName returns the most recently seen member with the provided Telegram username (not case sensitive)
+func (r memoryMemberRepository) GetByUserName(ctx context.Context, username string) (*Member, error) {
+	username = NormalizeUserName(username)
+	if username == "" {
+		return nil, ErrNotFound
+	}
+
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var result *Member
+	for _, m := range r.m.members {
+		if strings.EqualFold(m.UserName, username) && (result == nil || m.LastSeenAt.After(result.LastSeenAt)) {
+			m := m
+			result = &m
+		}
+	}
+	if result == nil {
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
+
+// Touch records activity by the member, storing new members with RoleUser
+func (r memoryMemberRepository) Touch(ctx context.Context, m *Member) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	now := time.Now().UTC()
+	stored, found := r.m.members[m.TelegramID]
+	if !found {
+		stored = Member{TelegramID: m.TelegramID, Role: RoleUser, CreatedAt: now}
+	}
+	stored.UserName = NormalizeUserName(m.UserName)
+	stored.FirstName = m.FirstName
+	stored.LastSeenAt = now
+	r.m.members[m.TelegramID] = stored
+	*m = stored
+	return nil
+}
+
+// SetRole stores the role of the member, creating the member if needed
+func (r memoryMemberRepository) SetRole(ctx context.Context, telegramID int, role Role) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	stored, found := r.m.members[telegramID]
+	if !found {
+		now := time.Now().UTC()
+		stored = Member{TelegramID: telegramID, CreatedAt: now, LastSeenAt: now}
+	}
+	stored.Role = role
+	r.m.members[telegramID] = stored
+	return nil
+}
+
+// List returns up to limit members ordered by Telegram user ID, starting at offset
+func (r memoryMemberRepository) List(ctx context.Context, offset, limit int) ([]Member, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	members := make([]Member, 0, len(r.m.members))
+	for _, m := range r.m.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].TelegramID < members[j].TelegramID })
+
+	if offset >= len(members) {
+		return nil, nil
+	}
+	members = members[offset:]
+	if len(members) > limit {
+		members = members[:limit]
+	}
+	return members, nil
+}
+
+// memoryAuditRepository is an AuditRepository backed by a MemoryStore
+type memoryAuditRepository struct {
+	m *MemoryStore
+}
+
+// Record stores a new entry and sets its ID
+func (r memoryAuditRepository) Record(ctx context.Context, e *AuditEntry) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	e.ID = int64(len(r.m.auditLog) + 1)
+	r.m.auditLog = append(r.m.auditLog, *e)
+	return nil
+}
+
+// List returns up to limit entries, newest first, starting at offset
+func (r memoryAuditRepository) List(ctx context.Context, offset, limit int) ([]AuditEntry, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var result []AuditEntry
+	for i := len(r.m.auditLog) - 1 - offset; i >= 0 && len(result) < limit; i-- {
+		result = append(result, r.m.auditLog[i])
+	}
+	return result, nil
+}
+
+// memoryLimitRepository is a LimitRepository backed by a MemoryStore
+type memoryLimitRepository struct {
+	m *MemoryStore
+}
+
+// List returns the limits overridden for the user, ordered by name
+func (r memoryLimitRepository) List(ctx context.Context, telegramID int) ([]LimitOverride, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	var overrides []LimitOverride
+	for _, o := range r.m.limits[telegramID] {
+		overrides = append(overrides, o)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name < overrides[j].Name })
+	return overrides, nil
+}
+
+// Set stores the override, replacing any existing override of the same limit
+func (r memoryLimitRepository) Set(ctx context.Context, o *LimitOverride) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if o.UpdatedAt.IsZero() {
+		o.UpdatedAt = time.Now().UTC()
+	}
+	if r.m.limits[o.TelegramID] == nil {
+		r.m.limits[o.TelegramID] = make(map[string]LimitOverride)
+	}
+	r.m.limits[o.TelegramID][o.Name] = *o
+	return nil
+}
+
+// Delete removes the override so the configured limit applies again
+func (r memoryLimitRepository) Delete(ctx context.Context, telegramID int, name string) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if _, found := r.m.limits[telegramID][name]; !found {
+		return ErrNotFound
+	}
+	delete(r.m.limits[telegramID], name)
+	return nil
+}
+
+// memoryTwoFactorRepository is a TwoFactorRepository backed by a MemoryStore
+type memoryTwoFactorRepository struct {
+	m *MemoryStore
+}
+
+// Get returns the second factor of the user
+func (r memoryTwoFactorRepository) Get(ctx context.Context, telegramID int) (*TwoFactor, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	t, found := r.m.twoFactor[telegramID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+// Save stores a new (not yet enabled) secret for the user, replacing any existing second factor
+func (r memoryTwoFactorRepository) Save(ctx context.Context, t *TwoFactor) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	now := time.Now().UTC()
+	t.Enabled, t.Failures, t.LockedUntil, t.LastCounter = false, 0, time.Time{}, 0
+	t.CreatedAt, t.UpdatedAt = now, now
+	r.m.twoFactor[t.TelegramID] = *t
+	return nil
+}
+
+// Delete removes the second factor of the user
+func (r memoryTwoFactorRepository) Delete(ctx context.Context, telegramID int) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if _, found := r.m.twoFactor[telegramID]; !found {
+		return ErrNotFound
+	}
+	delete(r.m.twoFactor, telegramID)
+	return nil
+}
+
+// RecordFailure counts a wrong code, locking the user out once maxFailures have been counted
+func (r memoryTwoFactorRepository) RecordFailure(ctx context.Context, telegramID int, maxFailures int, lockUntil time.Time) (*TwoFactor, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	t, found := r.m.twoFactor[telegramID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	t.Failures++
+	if t.Failures >= maxFailures {
+		t.Failures, t.LockedUntil = 0, lockUntil.UTC()
+	}
+	t.UpdatedAt = time.Now().UTC()
+	r.m.twoFactor[telegramID] = t
+	return &t, nil
+}
+
+// RecordSuccess enables the second factor, resets the failures and records the time step of the valid code
+func (r memoryTwoFactorRepository) RecordSuccess(ctx context.Context, telegramID int, counter int64) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	t, found := r.m.twoFactor[telegramID]
+	if !found {
+		return ErrNotFound
+	}
+	if counter <= t.LastCounter {
+		return ErrCodeReused
+	}
+	t.Enabled, t.Failures, t.LastCounter, t.UpdatedAt = true, 0, counter, time.Now().UTC()
+	r.m.twoFactor[telegramID] = t
+	return nil
+}
+
+// RewriteSecrets calls fn for every stored secret and replaces it with the result.
+// If fn fails no secret is changed.
+func (r memoryTwoFactorRepository) RewriteSecrets(ctx context.Context, fn SecretKeyRewriter) (int, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	rewritten := make(map[int]string)
+	for id, t := range r.m.twoFactor {
+		secret, err := fn(TwoFactorSecretID(id), t.Secret)
+		if err != nil {
+			return 0, err
+		}
+		if secret != t.Secret {
+			rewritten[id] = secret
+		}
+	}
+	for id, secret := range rewritten {
+		t := r.m.twoFactor[id]
+		t.Secret = secret
+		r.m.twoFactor[id] = t
+	}
+	return len(rewritten), nil
+}
+
+// memoryConversationRepository is a ConversationRepository backed by a MemoryStore
+type memoryConversationRepository struct {
+	m *MemoryStore
+}
+
+// copyConversationData returns a copy of data, so stored conversations don't share their data
+func copyConversationData(data map[string]string) map[string]string {
+	c := make(map[string]string, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c
+}
+
+// Get returns the conversation of the user
+func (r memoryConversationRepository) Get(ctx context.Context, telegramID int) (*Conversation, error) {
+	r.m.mutex.RLock()
+	defer r.m.mutex.RUnlock()
+
+	c, found := r.m.conversations[telegramID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	c.Data = copyConversationData(c.Data)
+	return &c, nil
+}
+
+// Save stores the conversation, replacing any existing conversation of the user
+func (r memoryConversationRepository) Save(ctx context.Context, c *Conversation) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	c.ExpiresAt, c.UpdatedAt = c.ExpiresAt.UTC(), time.Now().UTC()
+	stored := *c
+	stored.Data = copyConversationData(c.Data)
+	r.m.conversations[c.TelegramID] = stored
+	return nil
+}
+
+// Delete ends the conversation of the user
+func (r memoryConversationRepository) Delete(ctx context.Context, telegramID int) error {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	if _, found := r.m.conversations[telegramID]; !found {
+		return ErrNotFound
+	}
+	delete(r.m.conversations, telegramID)
+	return nil
+}
+
+// DeleteExpired ends the conversations which expired before the provided time
+func (r memoryConversationRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	r.m.mutex.Lock()
+	defer r.m.mutex.Unlock()
+
+	var n int
+	for id, c := range r.m.conversations {
+		if c.ExpiresAt.Before(before) {
+			delete(r.m.conversations, id)
+			n++
+		}
+	}
+	return n, nil
+}
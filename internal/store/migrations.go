@@ -0,0 +1,455 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// migration is a single schema change. Migrations are applied in order and each
+// one is applied in its own transaction together with its schema_version record.
+type migration struct {
+	Version     int
+	Description string
+	Postgres    []string
+	SQLite      []string
+}
+
+// LatestSchemaVersion is the schema version this build of Wing Commander expects
+var LatestSchemaVersion = migrations[len(migrations)-1].Version
+
+// SchemaTooNewError is returned when the database has been migrated by a newer
+// build of Wing Commander than the one running
+type SchemaTooNewError struct {
+	DatabaseVersion int
+	BinaryVersion   int
+}
+
+// Error satisfies the error interface for the SchemaTooNewError type
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than the version supported by this build (%d), please upgrade Wing Commander",
+		e.DatabaseVersion, e.BinaryVersion)
+}
+
+const sqlCreateSchemaVersion = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create users table",
+		// Databases created using the schema from the README already have a users
+		// table, so it is upgraded to the same shape as a new table
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id SERIAL PRIMARY KEY,
+				telegram_id BIGINT UNIQUE,
+				chatid BIGINT,
+				telegram_username TEXT UNIQUE NOT NULL,
+				public_wallet TEXT UNIQUE NOT NULL,
+				public_key TEXT UNIQUE NOT NULL,
+				private_key TEXT UNIQUE NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS telegram_id BIGINT UNIQUE`,
+			`ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT now()`,
+			`ALTER TABLE users ALTER COLUMN chatid TYPE BIGINT`,
+			`DO $$ BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'users'::regclass AND contype = 'p') THEN
+					ALTER TABLE users ADD PRIMARY KEY (id);
+				END IF;
+			END $$`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_idx ON users (lower(telegram_username))`,
+		},
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				telegram_id INTEGER UNIQUE,
+				chatid INTEGER,
+				telegram_username TEXT UNIQUE NOT NULL COLLATE NOCASE,
+				public_wallet TEXT UNIQUE NOT NULL,
+				public_key TEXT UNIQUE NOT NULL,
+				private_key TEXT UNIQUE NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "move wallets into the addresses table",
+		Postgres: []string{
+			`CREATE TABLE addresses (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER REFERENCES users (id),
+				address TEXT UNIQUE NOT NULL,
+				public_key TEXT UNIQUE NOT NULL,
+				secret_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX addresses_user_id_idx ON addresses (user_id)`,
+			`INSERT INTO addresses (user_id, address, public_key, secret_key, created_at)
+				SELECT id, public_wallet, public_key, private_key, created_at FROM users ORDER BY id`,
+			`ALTER TABLE users DROP COLUMN public_wallet, DROP COLUMN public_key, DROP COLUMN private_key`,
+			`ALTER TABLE users ALTER COLUMN telegram_username DROP NOT NULL`,
+		},
+		// SQLite can't drop constrained columns, so the users table is rebuilt
+		SQLite: []string{
+			`ALTER TABLE users RENAME TO users_v1`,
+			`CREATE TABLE users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				telegram_id INTEGER UNIQUE,
+				chatid INTEGER,
+				telegram_username TEXT UNIQUE COLLATE NOCASE,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`INSERT INTO users (id, telegram_id, chatid, telegram_username, created_at)
+				SELECT id, telegram_id, chatid, telegram_username, created_at FROM users_v1`,
+			`CREATE TABLE addresses (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER REFERENCES users (id),
+				address TEXT UNIQUE NOT NULL,
+				public_key TEXT UNIQUE NOT NULL,
+				secret_key TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX addresses_user_id_idx ON addresses (user_id)`,
+			`INSERT INTO addresses (user_id, address, public_key, secret_key, created_at)
+				SELECT id, public_wallet, public_key, private_key, created_at FROM users_v1 ORDER BY id`,
+			`DROP TABLE users_v1`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "create transactions table",
+		Postgres: []string{
+			`CREATE TABLE transactions (
+				id SERIAL PRIMARY KEY,
+				user_id INTEGER REFERENCES users (id),
+				kind TEXT NOT NULL,
+				status TEXT NOT NULL,
+				txid TEXT,
+				from_address TEXT,
+				to_address TEXT,
+				coins BIGINT NOT NULL,
+				hours BIGINT NOT NULL DEFAULT 0,
+				memo TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT now(),
+				updated_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX transactions_user_id_idx ON transactions (user_id, created_at)`,
+			`CREATE INDEX transactions_txid_idx ON transactions (txid)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE transactions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER REFERENCES users (id),
+				kind TEXT NOT NULL,
+				status TEXT NOT NULL,
+				txid TEXT,
+				from_address TEXT,
+				to_address TEXT,
+				coins INTEGER NOT NULL,
+				hours INTEGER NOT NULL DEFAULT 0,
+				memo TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX transactions_user_id_idx ON transactions (user_id, created_at)`,
+			`CREATE INDEX transactions_txid_idx ON transactions (txid)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create audit log table",
+		Postgres: []string{
+			`CREATE TABLE audit_log (
+				id SERIAL PRIMARY KEY,
+				actor_telegram_id BIGINT NOT NULL,
+				action TEXT NOT NULL,
+				target TEXT NOT NULL DEFAULT '',
+				details TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX audit_log_created_at_idx ON audit_log (created_at)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				actor_telegram_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				target TEXT NOT NULL DEFAULT '',
+				details TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX audit_log_created_at_idx ON audit_log (created_at)`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "add derivation index to addresses",
+		// Addresses derived from the wallet seed record their derivation index.
+		// Standalone addresses created by earlier versions have no index.
+		Postgres: []string{
+			`ALTER TABLE addresses ADD COLUMN derivation_index BIGINT`,
+			`CREATE UNIQUE INDEX addresses_derivation_index_idx ON addresses (derivation_index)`,
+			`CREATE TABLE counters (
+				name TEXT PRIMARY KEY,
+				value BIGINT NOT NULL
+			)`,
+			`INSERT INTO counters (name, value) VALUES ('derivation_index', 0)`,
+		},
+		SQLite: []string{
+			`ALTER TABLE addresses ADD COLUMN derivation_index INTEGER`,
+			`CREATE UNIQUE INDEX addresses_derivation_index_idx ON addresses (derivation_index)`,
+			`CREATE TABLE counters (
+				name TEXT PRIMARY KEY,
+				value INTEGER NOT NULL
+			)`,
+			`INSERT INTO counters (name, value) VALUES ('derivation_index', 0)`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "create ledger tables",
+		// The balance of each account is kept alongside its entries so it can be
+		// checked and updated atomically. It always equals the sum of the entries.
+		Postgres: []string{
+			`CREATE TABLE ledger_accounts (
+				id SERIAL PRIMARY KEY,
+				name TEXT UNIQUE NOT NULL,
+				user_id INTEGER UNIQUE REFERENCES users (id),
+				balance BIGINT NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE TABLE ledger_transfers (
+				id SERIAL PRIMARY KEY,
+				kind TEXT NOT NULL,
+				amount BIGINT NOT NULL,
+				memo TEXT NOT NULL DEFAULT '',
+				reference TEXT UNIQUE,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE TABLE ledger_entries (
+				id SERIAL PRIMARY KEY,
+				transfer_id INTEGER NOT NULL REFERENCES ledger_transfers (id),
+				account_id INTEGER NOT NULL REFERENCES ledger_accounts (id),
+				amount BIGINT NOT NULL,
+				created_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX ledger_entries_account_id_idx ON ledger_entries (account_id, created_at)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE ledger_accounts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT UNIQUE NOT NULL,
+				user_id INTEGER UNIQUE REFERENCES users (id),
+				balance INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE ledger_transfers (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				kind TEXT NOT NULL,
+				amount INTEGER NOT NULL,
+				memo TEXT NOT NULL DEFAULT '',
+				reference TEXT UNIQUE,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE ledger_entries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				transfer_id INTEGER NOT NULL REFERENCES ledger_transfers (id),
+				account_id INTEGER NOT NULL REFERENCES ledger_accounts (id),
+				amount INTEGER NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX ledger_entries_account_id_idx ON ledger_entries (account_id, created_at)`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "create members table",
+		// Every user who has talked to the Bot is a member with the user role
+		Postgres: []string{
+			`CREATE TABLE members (
+				telegram_id BIGINT PRIMARY KEY,
+				telegram_username TEXT,
+				first_name TEXT NOT NULL DEFAULT '',
+				role TEXT NOT NULL DEFAULT 'user',
+				created_at TIMESTAMP NOT NULL DEFAULT now(),
+				last_seen_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+			`CREATE INDEX members_username_idx ON members (lower(telegram_username))`,
+			`INSERT INTO members (telegram_id, telegram_username, role, created_at, last_seen_at)
+				SELECT telegram_id, telegram_username, 'user', created_at, created_at FROM users WHERE telegram_id IS NOT NULL`,
+		},
+		SQLite: []string{
+			`CREATE TABLE members (
+				telegram_id INTEGER PRIMARY KEY,
+				telegram_username TEXT COLLATE NOCASE,
+				first_name TEXT NOT NULL DEFAULT '',
+				role TEXT NOT NULL DEFAULT 'user',
+				created_at TIMESTAMP NOT NULL,
+				last_seen_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX members_username_idx ON members (telegram_username)`,
+			`INSERT INTO members (telegram_id, telegram_username, role, created_at, last_seen_at)
+				SELECT telegram_id, telegram_username, 'user', created_at, created_at FROM users WHERE telegram_id IS NOT NULL`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "create limit overrides table",
+		// Limits set by admins for individual users, replacing the configured limits
+		Postgres: []string{
+			`CREATE TABLE limit_overrides (
+				telegram_id BIGINT NOT NULL,
+				name TEXT NOT NULL,
+				value BIGINT NOT NULL,
+				updated_by BIGINT NOT NULL,
+				updated_at TIMESTAMP NOT NULL DEFAULT now(),
+				PRIMARY KEY (telegram_id, name)
+			)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE limit_overrides (
+				telegram_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				value INTEGER NOT NULL,
+				updated_by INTEGER NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (telegram_id, name)
+			)`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "create two factor table",
+		// TOTP secrets (sealed with the master key), keyed by Telegram ID so moderators
+		// and admins without a wallet can set up a second factor
+		Postgres: []string{
+			`CREATE TABLE two_factor (
+				telegram_id BIGINT PRIMARY KEY,
+				secret TEXT NOT NULL,
+				enabled BOOLEAN NOT NULL DEFAULT FALSE,
+				failures INTEGER NOT NULL DEFAULT 0,
+				locked_until TIMESTAMP NOT NULL,
+				last_counter BIGINT NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL DEFAULT now(),
+				updated_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE two_factor (
+				telegram_id INTEGER PRIMARY KEY,
+				secret TEXT NOT NULL,
+				enabled INTEGER NOT NULL DEFAULT 0,
+				failures INTEGER NOT NULL DEFAULT 0,
+				locked_until TIMESTAMP NOT NULL,
+				last_counter INTEGER NOT NULL DEFAULT 0,
+				created_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "create conversations table",
+		// The multi-step command each user is in the middle of, so the Bot can
+		// carry on with it after a restart
+		Postgres: []string{
+			`CREATE TABLE conversations (
+				telegram_id BIGINT PRIMARY KEY,
+				command TEXT NOT NULL,
+				step TEXT NOT NULL,
+				data TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL DEFAULT now()
+			)`,
+		},
+		SQLite: []string{
+			`CREATE TABLE conversations (
+				telegram_id INTEGER PRIMARY KEY,
+				command TEXT NOT NULL,
+				step TEXT NOT NULL,
+				data TEXT NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+}
+
+// SchemaVersion returns the version of the latest migration applied to the database
+func (s *sqlStore) SchemaVersion(ctx context.Context) (int, error) {
+	if _, err := s.db.ExecContext(ctx, sqlCreateSchemaVersion); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// Migrate applies the migrations which have not been applied to the database yet
+// and returns how many were applied. A *SchemaTooNewError is returned if the
+// database has been migrated by a newer build.
+func (s *sqlStore) Migrate(ctx context.Context) (int, error) {
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current > LatestSchemaVersion {
+		return 0, &SchemaTooNewError{DatabaseVersion: current, BinaryVersion: LatestSchemaVersion}
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := s.applyMigration(ctx, m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		log.Infof("store.Migrate: Applied migration %d: %s", m.Version, m.Description)
+		applied++
+	}
+	return applied, nil
+}
+
+// applyMigration runs the statements of the migration and records its version in a single transaction
+func (s *sqlStore) applyMigration(ctx context.Context, m migration) error {
+	stmts := m.SQLite
+	if s.dialect == dialectPostgres {
+		stmts = m.Postgres
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`),
+		m.Version, m.Description, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,110 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func openTestSQLite(t *testing.T) *sqlStore {
+	s, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	return s.(*sqlStore)
+}
+
+func Test_Migrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration %d has version %d", i, m.Version)
+		}
+		if len(m.Postgres) == 0 || len(m.SQLite) == 0 {
+			t.Errorf("Migration %d is missing statements for a dialect", m.Version)
+		}
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t)
+	defer s.Close()
+
+	applied, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Expected %d migrations to be applied, got %d", len(migrations), applied)
+	}
+
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion, version)
+	}
+
+	// Migrating again is a no-op
+	applied, err = s.Migrate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no migrations to be applied, got %d", applied)
+	}
+}
+
+func Test_Migrate_SchemaTooNew(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t)
+	defer s.Close()
+
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, 'from the future', CURRENT_TIMESTAMP)`,
+		LatestSchemaVersion+1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Migrate(ctx)
+	tooNew, ok := err.(*SchemaTooNewError)
+	if !ok {
+		t.Fatalf("Expected *SchemaTooNewError, got %v", err)
+	}
+	if tooNew.DatabaseVersion != LatestSchemaVersion+1 || tooNew.BinaryVersion != LatestSchemaVersion {
+		t.Errorf("Unexpected error: %+v", tooNew)
+	}
+}
+
+func Test_Migrate_LegacyUsers(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t)
+	defer s.Close()
+
+	// A users table holding wallets, as created before migrations existed
+	if _, err := s.db.Exec(migrations[0].SQLite[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO users (chatid, telegram_username, public_wallet, public_key, private_key, created_at)
+		VALUES (666666, '@testing', 'pubwallet123', 'pubkey123', 'privkey123', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := s.Users().GetByUserName(ctx, "testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ChatID != 666666 || u.Address != "pubwallet123" || u.PublicKey != "pubkey123" || u.SecretKey != "privkey123" {
+		t.Errorf("Unexpected user after migration: %+v", u)
+	}
+}
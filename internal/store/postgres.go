@@ -0,0 +1,60 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenPostgres creates a Store backed by a pooled connection to the
+// PostgreSQL database described by the configuration
+func OpenPostgres(cfg wcconfig.SQLdatabase) (Store, error) {
+	db, err := sql.Open("postgres", postgresDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetimeMin)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to connect to database %s on %s:%d: %v", cfg.Dbname, cfg.Host, cfg.Port, err)
+	}
+	log.Debugf("store.OpenPostgres: Connected to database %s on %s:%d", cfg.Dbname, cfg.Host, cfg.Port)
+
+	return newSQLStore(db, dialectPostgres), nil
+}
+
+// postgresDSN returns the connection URL for the configuration. Building a URL
+// escapes any special characters in the user, password and database name.
+func postgresDSN(cfg wcconfig.SQLdatabase) string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.User, cfg.Password),
+		Host:   net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:   "/" + cfg.Dbname,
+	}
+	if cfg.SSLMode != "" {
+		u.RawQuery = url.Values{"sslmode": {cfg.SSLMode}}.Encode()
+	}
+	return u.String()
+}
+
+// isPostgresUniqueViolation reports whether err is a PostgreSQL unique constraint violation
+func isPostgresUniqueViolation(err error) bool {
+	pqerr, ok := err.(*pq.Error)
+	return ok && pqerr.Code == "23505"
+}
@@ -0,0 +1,1258 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite3"
+)
+
+// selectUsers selects users together with their primary (first) address
+const selectUsers = `SELECT u.id, u.telegram_id, u.chatid, u.telegram_username,
+		a.address, a.public_key, a.secret_key, a.derivation_index, u.created_at
+	FROM users u
+	LEFT JOIN addresses a ON a.id = (SELECT MIN(id) FROM addresses WHERE user_id = u.id)`
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlStore is a Store backed by a database/sql connection pool.
+// Queries are written using "?" placeholders and rebound for the dialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+	users   *sqlUserRepository
+}
+
+func newSQLStore(db *sql.DB, dialect string) *sqlStore {
+	s := &sqlStore{db: db, dialect: dialect}
+	s.users = &sqlUserRepository{store: s}
+	return s
+}
+
+// Users returns the UserRepository of the store
+func (s *sqlStore) Users() UserRepository {
+	return s.users
+}
+
+// Addresses returns the AddressRepository of the store
+func (s *sqlStore) Addresses() AddressRepository {
+	return sqlAddressRepository{store: s}
+}
+
+// Ledger returns the LedgerRepository of the store
+func (s *sqlStore) Ledger() LedgerRepository {
+	return sqlLedgerRepository{store: s}
+}
+
+// Transactions returns the TransactionRepository of the store
+func (s *sqlStore) Transactions() TransactionRepository {
+	return sqlTransactionRepository{store: s}
+}
+
+// Members returns the MemberRepository of the store
+func (s *sqlStore) Members() MemberRepository {
+	return sqlMemberRepository{store: s}
+}
+
+// Limits returns the LimitRepository of the store
+func (s *sqlStore) Limits() LimitRepository {
+	return sqlLimitRepository{store: s}
+}
+
+// TwoFactor returns the TwoFactorRepository of the store
+func (s *sqlStore) TwoFactor() TwoFactorRepository {
+	return sqlTwoFactorRepository{store: s}
+}
+
+// Conversations returns the ConversationRepository of the store
+func (s *sqlStore) Conversations() ConversationRepository {
+	return sqlConversationRepository{store: s}
+}
+
+// AuditLog returns the AuditRepository of the store
+func (s *sqlStore) AuditLog() AuditRepository {
+	return sqlAuditRepository{store: s}
+}
+
+// Close closes the connection pool
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// rebind converts the "?" placeholders of query into the form used by the dialect
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// mapError converts driver specific errors into the errors of the store package
+func (s *sqlStore) mapError(err error) error {
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrNotFound
+	case s.dialect == dialectPostgres && isPostgresUniqueViolation(err):
+		return ErrDuplicate
+	case s.dialect == dialectSQLite && isSQLiteUniqueViolation(err):
+		return ErrDuplicate
+	}
+	return err
+}
+
+// insert runs an INSERT statement and returns the ID of the new row
+func (s *sqlStore) insert(ctx context.Context, q queryer, query string, args ...interface{}) (int64, error) {
+	if s.dialect == dialectPostgres {
+		var id int64
+		err := q.QueryRowContext(ctx, s.rebind(query+` RETURNING id`), args...).Scan(&id)
+		return id, s.mapError(err)
+	}
+
+	result, err := q.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return 0, s.mapError(err)
+	}
+	return result.LastInsertId()
+}
+
+// withTx runs fn in a transaction which is committed if fn succeeds
+func (s *sqlStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlUserRepository is a UserRepository backed by the users and addresses tables
+type sqlUserRepository struct {
+	store *sqlStore
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	var u User
+	var telegramID, chatID sql.NullInt64
+	var username, address, publicKey, secretKey sql.NullString
+	var derivationIndex sql.NullInt64
+	err := row.Scan(&u.ID, &telegramID, &chatID, &username, &address, &publicKey, &secretKey, &derivationIndex, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	u.TelegramID = int(telegramID.Int64)
+	u.ChatID = chatID.Int64
+	u.UserName = username.String
+	u.Address = address.String
+	u.PublicKey = publicKey.String
+	u.SecretKey = secretKey.String
+	u.DerivationIndex = uint32(derivationIndex.Int64)
+	return &u, nil
+}
+
+// nullInt64 stores 0 as NULL, so unknown Telegram IDs don't collide
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+// nullString stores "" as NULL, so users without a username don't collide
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+func (r *sqlUserRepository) getOne(ctx context.Context, where string, arg interface{}) (*User, error) {
+	query := r.store.rebind(selectUsers + ` WHERE ` + where)
+	u, err := scanUser(r.store.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		return nil, r.store.mapError(err)
+	}
+	return u, nil
+}
+
+// Get returns the user with the provided ID
+func (r *sqlUserRepository) Get(ctx context.Context, id int64) (*User, error) {
+	return r.getOne(ctx, `u.id = ?`, id)
+}
+
+// GetByTelegramID returns the user with the provided numeric Telegram user ID
+func (r *sqlUserRepository) GetByTelegramID(ctx context.Context, telegramID int) (*User, error) {
+	if telegramID == 0 {
+		return nil, ErrNotFound
+	}
+	return r.getOne(ctx, `u.telegram_id = ?`, telegramID)
+}
+
+// GetByUserName returns the user with the provided Telegram username (not case sensitive)
+func (r *sqlUserRepository) GetByUserName(ctx context.Context, username string) (*User, error) {
+	username = NormalizeUserName(username)
+	if username == "" {
+		return nil, ErrNotFound
+	}
+	return r.getOne(ctx, `lower(u.telegram_username) = lower(?)`, username)
+}
+
+// Create stores a new user (and their address if set) and sets its ID
+func (r *sqlUserRepository) Create(ctx context.Context, u *User) error {
+	u.UserName = NormalizeUserName(u.UserName)
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		id, err := r.store.insert(ctx, tx, `INSERT INTO users (telegram_id, chatid, telegram_username, created_at) VALUES (?, ?, ?, ?)`,
+			nullInt64(int64(u.TelegramID)), nullInt64(u.ChatID), nullString(u.UserName), u.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		if u.Address != "" {
+			if err := r.insertAddress(ctx, tx, id, u); err != nil {
+				return err
+			}
+		}
+		u.ID = id
+		return nil
+	})
+}
+
+func (r *sqlUserRepository) insertAddress(ctx context.Context, q queryer, userID int64, u *User) error {
+	_, err := r.store.insert(ctx, q, `INSERT INTO addresses (user_id, address, public_key, secret_key, derivation_index, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, u.Address, u.PublicKey, u.SecretKey, nullInt64(int64(u.DerivationIndex)), time.Now().UTC())
+	return err
+}
+
+// Update stores the Telegram details (TelegramID, ChatID and UserName) of an existing user
+func (r *sqlUserRepository) Update(ctx context.Context, u *User) error {
+	u.UserName = NormalizeUserName(u.UserName)
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`UPDATE users SET telegram_id = ?, chatid = ?, telegram_username = ? WHERE id = ?`),
+		nullInt64(int64(u.TelegramID)), nullInt64(u.ChatID), nullString(u.UserName), u.ID)
+	if err != nil {
+		return r.store.mapError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateWallet stores the wallet details (Address, PublicKey, SecretKey and DerivationIndex) of an existing user.
+// The primary address of the user is replaced, or added if they don't have one.
+func (r *sqlUserRepository) UpdateWallet(ctx context.Context, u *User) error {
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRowContext(ctx, r.store.rebind(`SELECT 1 FROM users WHERE id = ?`), u.ID).Scan(&exists)
+		if err != nil {
+			return r.store.mapError(err)
+		}
+
+		var addressID int64
+		err = tx.QueryRowContext(ctx, r.store.rebind(`SELECT id FROM addresses WHERE user_id = ? ORDER BY id LIMIT 1`), u.ID).Scan(&addressID)
+		if err == sql.ErrNoRows {
+			return r.insertAddress(ctx, tx, u.ID, u)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE addresses SET address = ?, public_key = ?, secret_key = ?, derivation_index = ? WHERE id = ?`),
+			u.Address, u.PublicKey, u.SecretKey, nullInt64(int64(u.DerivationIndex)), addressID)
+		return r.store.mapError(err)
+	})
+}
+
+// MergePlaceholder moves the ledger account of the placeholder user to u, deletes the
+// placeholder and stores the Telegram details of u in a single transaction.
+// The ledger entries of the placeholder are moved too, so its history stays with the
+// balance. Its addresses are deleted, nobody was shown them.
+func (r *sqlUserRepository) MergePlaceholder(ctx context.Context, u *User, placeholderID int64) error {
+	u.UserName = NormalizeUserName(u.UserName)
+	ledger := sqlLedgerRepository{store: r.store}
+
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		var telegramID sql.NullInt64
+		err := tx.QueryRowContext(ctx, r.store.rebind(`SELECT telegram_id FROM users WHERE id = ?`), placeholderID).Scan(&telegramID)
+		if err != nil {
+			return r.store.mapError(err)
+		}
+		if telegramID.Valid || placeholderID == u.ID {
+			return ErrNotFound
+		}
+
+		var fromID, balance int64
+		err = tx.QueryRowContext(ctx, r.store.rebind(`SELECT id, balance FROM ledger_accounts WHERE user_id = ?`), placeholderID).Scan(&fromID, &balance)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			toID, err := ledger.accountID(ctx, tx, UserAccount(u.ID))
+			if err != nil {
+				return err
+			}
+			if err := ledger.lockAccounts(ctx, tx, fromID, toID); err != nil {
+				return err
+			}
+			for _, stmt := range []struct {
+				query string
+				args  []interface{}
+			}{
+				{`UPDATE ledger_entries SET account_id = ? WHERE account_id = ?`, []interface{}{toID, fromID}},
+				{`UPDATE ledger_accounts SET balance = balance + ? WHERE id = ?`, []interface{}{balance, toID}},
+				{`DELETE FROM ledger_accounts WHERE id = ?`, []interface{}{fromID}},
+			} {
+				if _, err := tx.ExecContext(ctx, r.store.rebind(stmt.query), stmt.args...); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE transactions SET user_id = ? WHERE user_id = ?`), u.ID, placeholderID)
+		if err != nil {
+			return err
+		}
+		for _, query := range []string{`DELETE FROM addresses WHERE user_id = ?`, `DELETE FROM users WHERE id = ?`} {
+			if _, err := tx.ExecContext(ctx, r.store.rebind(query), placeholderID); err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, r.store.rebind(`UPDATE users SET telegram_id = ?, chatid = ?, telegram_username = ? WHERE id = ?`),
+			nullInt64(int64(u.TelegramID)), nullInt64(u.ChatID), nullString(u.UserName), u.ID)
+		if err != nil {
+			return r.store.mapError(err)
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// List returns up to limit users ordered by ID, starting at offset
+func (r *sqlUserRepository) List(ctx context.Context, offset, limit int) ([]User, error) {
+	query := r.store.rebind(selectUsers + ` ORDER BY u.id LIMIT ? OFFSET ?`)
+	rows, err := r.store.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// sqlAddressRepository is an AddressRepository backed by the addresses table
+type sqlAddressRepository struct {
+	store *sqlStore
+}
+
+// RewriteSecretKeys calls fn for every stored address and replaces its secret key with the result.
+// All keys are rewritten in a single transaction, if fn fails no key is changed.
+func (r sqlAddressRepository) RewriteSecretKeys(ctx context.Context, fn SecretKeyRewriter) (int, error) {
+	changed := 0
+	err := r.store.withTx(ctx, func(tx *sql.Tx) error {
+		type key struct {
+			id                 int64
+			address, secretKey string
+		}
+
+		// Read all keys before updating, as sqlite can't update while rows are open
+		rows, err := tx.QueryContext(ctx, `SELECT id, address, secret_key FROM addresses ORDER BY id`)
+		if err != nil {
+			return err
+		}
+		var keys []key
+		for rows.Next() {
+			var k key
+			if err := rows.Scan(&k.id, &k.address, &k.secretKey); err != nil {
+				rows.Close()
+				return err
+			}
+			keys = append(keys, k)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			secretKey, err := fn(k.address, k.secretKey)
+			if err != nil {
+				return err
+			}
+			if secretKey == k.secretKey {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE addresses SET secret_key = ? WHERE id = ?`), secretKey, k.id)
+			if err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// ReserveDerivationIndex returns the next unused derivation index
+func (r sqlAddressRepository) ReserveDerivationIndex(ctx context.Context) (uint32, error) {
+	var index int64
+	err := r.store.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE counters SET value = value + 1 WHERE name = 'derivation_index'`)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, `SELECT value FROM counters WHERE name = 'derivation_index'`).Scan(&index)
+	})
+	if err != nil {
+		return 0, r.store.mapError(err)
+	}
+	return uint32(index), nil
+}
+
+// ListDerived returns every address derived from the wallet seed ordered by derivation index
+func (r sqlAddressRepository) ListDerived(ctx context.Context) ([]DerivedAddress, error) {
+	rows, err := r.store.db.QueryContext(ctx, `SELECT user_id, address, public_key, derivation_index
+		FROM addresses WHERE derivation_index IS NOT NULL ORDER BY derivation_index`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []DerivedAddress
+	for rows.Next() {
+		var a DerivedAddress
+		var index int64
+		if err := rows.Scan(&a.UserID, &a.Address, &a.PublicKey, &index); err != nil {
+			return nil, err
+		}
+		a.DerivationIndex = uint32(index)
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
+
+// sqlLedgerRepository is a LedgerRepository backed by the ledger tables
+type sqlLedgerRepository struct {
+	store *sqlStore
+}
+
+// Balance returns the balance (in droplets) of the account
+func (r sqlLedgerRepository) Balance(ctx context.Context, account LedgerAccount) (int64, error) {
+	var balance int64
+	err := r.store.db.QueryRowContext(ctx, r.store.rebind(`SELECT balance FROM ledger_accounts WHERE name = ?`), string(account)).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// accountID returns the ID of the account, creating it if needed
+func (r sqlLedgerRepository) accountID(ctx context.Context, tx *sql.Tx, account LedgerAccount) (int64, error) {
+	_, err := tx.ExecContext(ctx, r.store.rebind(`INSERT INTO ledger_accounts (name, user_id, balance, created_at) VALUES (?, ?, 0, ?)
+		ON CONFLICT (name) DO NOTHING`), string(account), nullInt64(account.UserID()), time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, r.store.rebind(`SELECT id FROM ledger_accounts WHERE name = ?`), string(account)).Scan(&id)
+	return id, err
+}
+
+// Transfer records the transfer and updates the balances of both accounts in a single transaction
+func (r sqlLedgerRepository) Transfer(ctx context.Context, t *Transfer) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		fromID, err := r.accountID(ctx, tx, t.From)
+		if err != nil {
+			return err
+		}
+		toID, err := r.accountID(ctx, tx, t.To)
+		if err != nil {
+			return err
+		}
+		if err := r.lockAccounts(ctx, tx, fromID, toID); err != nil {
+			return err
+		}
+		if t.Limits != nil {
+			if err := r.checkLimits(ctx, tx, t, fromID, toID); err != nil {
+				return err
+			}
+		}
+
+		// The balance check and debit are a single statement, so concurrent
+		// transfers can't both pass the check
+		debit := `UPDATE ledger_accounts SET balance = balance - ? WHERE id = ?`
+		args := []interface{}{int64(t.Amount), fromID}
+		if t.From.UserID() != 0 {
+			debit += ` AND balance >= ?`
+			args = append(args, int64(t.Amount))
+		}
+		result, err := tx.ExecContext(ctx, r.store.rebind(debit), args...)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInsufficientFunds
+		}
+
+		_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE ledger_accounts SET balance = balance + ? WHERE id = ?`), int64(t.Amount), toID)
+		if err != nil {
+			return err
+		}
+
+		id, err := r.store.insert(ctx, tx, `INSERT INTO ledger_transfers (kind, amount, memo, reference, created_at) VALUES (?, ?, ?, ?, ?)`,
+			t.Kind, int64(t.Amount), t.Memo, nullString(t.Reference), t.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range []struct {
+			accountID int64
+			amount    int64
+		}{{fromID, -int64(t.Amount)}, {toID, int64(t.Amount)}} {
+			_, err = tx.ExecContext(ctx, r.store.rebind(`INSERT INTO ledger_entries (transfer_id, account_id, amount, created_at) VALUES (?, ?, ?, ?)`),
+				id, entry.accountID, entry.amount, t.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		t.ID = id
+		return nil
+	})
+}
+
+// lockAccounts locks the rows of both accounts of a transfer in ascending ID order, so
+// concurrent transfers between the same accounts in opposite directions can't deadlock.
+// SQLite has no row locks, its writes are serialized by the single connection of the store.
+func (r sqlLedgerRepository) lockAccounts(ctx context.Context, tx *sql.Tx, fromID, toID int64) error {
+	if r.store.dialect != dialectPostgres {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx, r.store.rebind(`SELECT id FROM ledger_accounts WHERE id IN (?, ?) ORDER BY id FOR UPDATE`), fromID, toID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// checkLimits checks the transfer against its Limits. The accounts the limits are counted on
+// are locked by Transfer first, so concurrent transfers from the same account (or withdrawals
+// by any user for the hourly outflow cap) wait for this transaction and count this transfer.
+func (r sqlLedgerRepository) checkLimits(ctx context.Context, tx *sql.Tx, t *Transfer, fromID, toID int64) error {
+	now := time.Now().UTC()
+
+	if t.Limits.DailyCap > 0 {
+		sent, err := r.sent(ctx, tx, t.From, now.Add(-DailyCapWindow))
+		if err != nil {
+			return err
+		}
+		if sent+t.Amount > t.Limits.DailyCap {
+			return ErrDailyCapExceeded
+		}
+	}
+
+	if t.Limits.TipsPerMinute > 0 && t.Kind == TransferTip {
+		var tips int
+		err := tx.QueryRowContext(ctx, r.store.rebind(`SELECT COUNT(*) FROM ledger_entries e
+			JOIN ledger_transfers t ON t.id = e.transfer_id
+			WHERE e.account_id = ? AND e.created_at >= ? AND t.kind = ? AND e.amount < 0`),
+			fromID, now.Add(-TipsPerMinuteWindow), TransferTip).Scan(&tips)
+		if err != nil {
+			return err
+		}
+		if tips >= t.Limits.TipsPerMinute {
+			return ErrTipRateExceeded
+		}
+	}
+
+	if t.Limits.HourlyOutflowCap > 0 && t.To == AccountOnChain {
+		// Withdrawals credit the on-chain account, refunds debit it
+		var withdrawn int64
+		err := tx.QueryRowContext(ctx, r.store.rebind(`SELECT COALESCE(SUM(e.amount), 0) FROM ledger_entries e
+			JOIN ledger_transfers t ON t.id = e.transfer_id
+			WHERE e.account_id = ? AND e.created_at >= ? AND t.kind = ?`),
+			toID, now.Add(-OutflowCapWindow), TransferWithdrawal).Scan(&withdrawn)
+		if err != nil {
+			return err
+		}
+		if withdrawn < 0 {
+			withdrawn = 0
+		}
+		if uint64(withdrawn)+t.Amount > t.Limits.HourlyOutflowCap {
+			return ErrOutflowCapExceeded
+		}
+	}
+	return nil
+}
+
+// sent returns the amount sent from the account since the provided time
+func (r sqlLedgerRepository) sent(ctx context.Context, q queryer, account LedgerAccount, since time.Time) (uint64, error) {
+	// Tips sent are debits, withdrawals are debits and their refunds credits
+	var sent int64
+	err := q.QueryRowContext(ctx, r.store.rebind(`SELECT COALESCE(SUM(-e.amount), 0) FROM ledger_entries e
+		JOIN ledger_accounts a ON a.id = e.account_id
+		JOIN ledger_transfers t ON t.id = e.transfer_id
+		WHERE a.name = ? AND e.created_at >= ? AND ((t.kind = ? AND e.amount < 0) OR t.kind = ?)`),
+		string(account), since.UTC(), TransferTip, TransferWithdrawal).Scan(&sent)
+	if err != nil || sent < 0 {
+		return 0, err
+	}
+	return uint64(sent), nil
+}
+
+// Sent returns the amount (in droplets) sent from the user account since the provided time
+func (r sqlLedgerRepository) Sent(ctx context.Context, account LedgerAccount, since time.Time) (uint64, error) {
+	return r.sent(ctx, r.store.db, account, since)
+}
+
+// Movements returns up to limit transfers to and from the account, newest first, starting at offset
+func (r sqlLedgerRepository) Movements(ctx context.Context, account LedgerAccount, offset, limit int) ([]Movement, error) {
+	rows, err := r.store.db.QueryContext(ctx, r.store.rebind(`SELECT t.id, t.kind, e.amount, c.name, t.memo, t.reference, t.created_at
+		FROM ledger_entries e
+		JOIN ledger_accounts a ON a.id = e.account_id
+		JOIN ledger_transfers t ON t.id = e.transfer_id
+		JOIN ledger_entries ce ON ce.transfer_id = e.transfer_id AND ce.id <> e.id
+		JOIN ledger_accounts c ON c.id = ce.account_id
+		WHERE a.name = ? ORDER BY t.id DESC LIMIT ? OFFSET ?`), string(account), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movements []Movement
+	for rows.Next() {
+		var m Movement
+		var counterparty string
+		var reference sql.NullString
+		if err := rows.Scan(&m.TransferID, &m.Kind, &m.Amount, &counterparty, &m.Memo, &reference, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.Counterparty, m.Reference = LedgerAccount(counterparty), reference.String
+		movements = append(movements, m)
+	}
+	return movements, rows.Err()
+}
+
+// HasReference reports whether a transfer with the Reference has been recorded
+func (r sqlLedgerRepository) HasReference(ctx context.Context, reference string) (bool, error) {
+	var exists int
+	err := r.store.db.QueryRowContext(ctx, r.store.rebind(`SELECT 1 FROM ledger_transfers WHERE reference = ?`), reference).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// sqlTransactionRepository is a TransactionRepository backed by the transactions table
+type sqlTransactionRepository struct {
+	store *sqlStore
+}
+
+const selectTransactions = `SELECT id, user_id, kind, status, txid, from_address, to_address, coins, hours, memo, error, created_at, updated_at
+	FROM transactions`
+
+func scanTransaction(row rowScanner) (*Transaction, error) {
+	var t Transaction
+	var userID sql.NullInt64
+	var txid, fromAddress, toAddress sql.NullString
+	var coins, hours int64
+	err := row.Scan(&t.ID, &userID, &t.Kind, &t.Status, &txid, &fromAddress, &toAddress, &coins, &hours,
+		&t.Memo, &t.Error, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.UserID = userID.Int64
+	t.TxID = txid.String
+	t.FromAddress = fromAddress.String
+	t.ToAddress = toAddress.String
+	t.Coins = uint64(coins)
+	t.Hours = uint64(hours)
+	return &t, nil
+}
+
+// Create stores a new transaction and sets its ID
+func (r sqlTransactionRepository) Create(ctx context.Context, t *Transaction) error {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	t.UpdatedAt = t.CreatedAt
+
+	id, err := r.store.insert(ctx, r.store.db, `INSERT INTO transactions (user_id, kind, status, txid, from_address, to_address, coins, hours, memo, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullInt64(t.UserID), t.Kind, t.Status, nullString(t.TxID), nullString(t.FromAddress), nullString(t.ToAddress),
+		int64(t.Coins), int64(t.Hours), t.Memo, t.Error, t.CreatedAt, t.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	return nil
+}
+
+func (r sqlTransactionRepository) getOne(ctx context.Context, where string, arg interface{}) (*Transaction, error) {
+	t, err := scanTransaction(r.store.db.QueryRowContext(ctx, r.store.rebind(selectTransactions+` WHERE `+where), arg))
+	if err != nil {
+		return nil, r.store.mapError(err)
+	}
+	return t, nil
+}
+
+// Get returns the transaction with the provided ID
+func (r sqlTransactionRepository) Get(ctx context.Context, id int64) (*Transaction, error) {
+	return r.getOne(ctx, `id = ?`, id)
+}
+
+// GetByTxID returns the transaction with the provided txid
+func (r sqlTransactionRepository) GetByTxID(ctx context.Context, txid string) (*Transaction, error) {
+	if txid == "" {
+		return nil, ErrNotFound
+	}
+	return r.getOne(ctx, `txid = ? ORDER BY id LIMIT 1`, txid)
+}
+
+// UpdateStatus stores the Status, TxID, Hours and Error of the transaction if it is still in the from status.
+// The status check and update are a single statement, so only one of two concurrent updates succeeds.
+func (r sqlTransactionRepository) UpdateStatus(ctx context.Context, t *Transaction, from string) error {
+	updatedAt := time.Now().UTC()
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`UPDATE transactions SET status = ?, txid = ?, hours = ?, error = ?, updated_at = ?
+		WHERE id = ? AND status = ?`), t.Status, nullString(t.TxID), int64(t.Hours), t.Error, updatedAt, t.ID, from)
+	if err != nil {
+		return r.store.mapError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if _, err := r.Get(ctx, t.ID); err != nil {
+			return err
+		}
+		return ErrStatusChanged
+	}
+	t.UpdatedAt = updatedAt
+	return nil
+}
+
+// List returns up to limit transactions matching the filter, newest first, starting at offset
+func (r sqlTransactionRepository) List(ctx context.Context, filter TransactionFilter, offset, limit int) ([]Transaction, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if filter.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, filter.Kind)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	args = append(args, limit, offset)
+
+	query := selectTransactions + ` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := r.store.db.QueryContext(ctx, r.store.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, *t)
+	}
+	return transactions, rows.Err()
+}
+
+// sqlMemberRepository is a MemberRepository backed by the members table
+type sqlMemberRepository struct {
+	store *sqlStore
+}
+
+const selectMembers = `SELECT telegram_id, telegram_username, first_name, role, created_at, last_seen_at FROM members`
+
+func scanMember(row rowScanner) (*Member, error) {
+	var m Member
+	var telegramID int64
+	var username sql.NullString
+	var role string
+	if err := row.Scan(&telegramID, &username, &m.FirstName, &role, &m.CreatedAt, &m.LastSeenAt); err != nil {
+		return nil, err
+	}
+	m.TelegramID = int(telegramID)
+	m.UserName = username.String
+	m.Role = Role(role)
+	return &m, nil
+}
+
+func (r sqlMemberRepository) getOne(ctx context.Context, q queryer, where string, arg interface{}) (*Member, error) {
+	m, err := scanMember(q.QueryRowContext(ctx, r.store.rebind(selectMembers+` WHERE `+where), arg))
+	if err != nil {
+		return nil, r.store.mapError(err)
+	}
+	return m, nil
+}
+
+// Get returns the member with the provided numeric Telegram user ID
+func (r sqlMemberRepository) Get(ctx context.Context, telegramID int) (*Member, error) {
+	return r.getOne(ctx, r.store.db, `telegram_id = ?`, int64(telegramID))
+}
+
+// GetByUserName returns the most recently seen member with the provided Telegram username (not case sensitive)
+func (r sqlMemberRepository) GetByUserName(ctx context.Context, username string) (*Member, error) {
+	username = NormalizeUserName(username)
+	if username == "" {
+		return nil, ErrNotFound
+	}
+	return r.getOne(ctx, r.store.db, `lower(telegram_username) = lower(?) ORDER BY last_seen_at DESC LIMIT 1`, username)
+}
+
+// Touch records activity by the member, storing new members with RoleUser
+func (r sqlMemberRepository) Touch(ctx context.Context, m *Member) error {
+	now := time.Now().UTC()
+	username := NormalizeUserName(m.UserName)
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		stored, err := r.getOne(ctx, tx, `telegram_id = ?`, int64(m.TelegramID))
+		if err == ErrNotFound {
+			_, err = tx.ExecContext(ctx, r.store.rebind(`INSERT INTO members (telegram_id, telegram_username, first_name, role, created_at, last_seen_at)
+				VALUES (?, ?, ?, ?, ?, ?)`), int64(m.TelegramID), nullString(username), m.FirstName, string(RoleUser), now, now)
+			if err != nil {
+				return r.store.mapError(err)
+			}
+			m.UserName, m.Role, m.CreatedAt, m.LastSeenAt = username, RoleUser, now, now
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE members SET telegram_username = ?, first_name = ?, last_seen_at = ? WHERE telegram_id = ?`),
+			nullString(username), m.FirstName, now, int64(m.TelegramID))
+		if err != nil {
+			return r.store.mapError(err)
+		}
+		m.UserName, m.Role, m.CreatedAt, m.LastSeenAt = username, stored.Role, stored.CreatedAt, now
+		return nil
+	})
+}
+
+// SetRole stores the role of the member, creating the member if needed
+func (r sqlMemberRepository) SetRole(ctx context.Context, telegramID int, role Role) error {
+	return r.store.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, r.store.rebind(`UPDATE members SET role = ? WHERE telegram_id = ?`), string(role), int64(telegramID))
+		if err != nil {
+			return r.store.mapError(err)
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		now := time.Now().UTC()
+		_, err = tx.ExecContext(ctx, r.store.rebind(`INSERT INTO members (telegram_id, role, created_at, last_seen_at) VALUES (?, ?, ?, ?)`),
+			int64(telegramID), string(role), now, now)
+		return r.store.mapError(err)
+	})
+}
+
+// List returns up to limit members ordered by Telegram user ID, starting at offset
+func (r sqlMemberRepository) List(ctx context.Context, offset, limit int) ([]Member, error) {
+	rows, err := r.store.db.QueryContext(ctx, r.store.rebind(selectMembers+` ORDER BY telegram_id LIMIT ? OFFSET ?`), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *m)
+	}
+	return members, rows.Err()
+}
+
+// sqlAuditRepository is an AuditRepository backed by the audit_log table
+type sqlAuditRepository struct {
+	store *sqlStore
+}
+
+// Record stores a new entry and sets its ID
+func (r sqlAuditRepository) Record(ctx context.Context, e *AuditEntry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	id, err := r.store.insert(ctx, r.store.db, `INSERT INTO audit_log (actor_telegram_id, action, target, details, created_at) VALUES (?, ?, ?, ?, ?)`,
+		int64(e.ActorTelegramID), e.Action, e.Target, e.Details, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// List returns up to limit entries, newest first, starting at offset
+func (r sqlAuditRepository) List(ctx context.Context, offset, limit int) ([]AuditEntry, error) {
+	rows, err := r.store.db.QueryContext(ctx, r.store.rebind(`SELECT id, actor_telegram_id, action, target, details, created_at
+		FROM audit_log ORDER BY id DESC LIMIT ? OFFSET ?`), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var actor int64
+		if err := rows.Scan(&e.ID, &actor, &e.Action, &e.Target, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.ActorTelegramID = int(actor)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// sqlLimitRepository is a LimitRepository backed by the limit_overrides table
+type sqlLimitRepository struct {
+	store *sqlStore
+}
+
+// List returns the limits overridden for the user, ordered by name
+func (r sqlLimitRepository) List(ctx context.Context, telegramID int) ([]LimitOverride, error) {
+	rows, err := r.store.db.QueryContext(ctx, r.store.rebind(`SELECT name, value, updated_by, updated_at
+		FROM limit_overrides WHERE telegram_id = ? ORDER BY name`), int64(telegramID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []LimitOverride
+	for rows.Next() {
+		o := LimitOverride{TelegramID: telegramID}
+		var value, updatedBy int64
+		if err := rows.Scan(&o.Name, &value, &updatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		o.Value, o.UpdatedBy = uint64(value), int(updatedBy)
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// Set stores the override, replacing any existing override of the same limit
+func (r sqlLimitRepository) Set(ctx context.Context, o *LimitOverride) error {
+	if o.UpdatedAt.IsZero() {
+		o.UpdatedAt = time.Now().UTC()
+	}
+	_, err := r.store.db.ExecContext(ctx, r.store.rebind(`INSERT INTO limit_overrides (telegram_id, name, value, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (telegram_id, name) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at`),
+		int64(o.TelegramID), o.Name, int64(o.Value), int64(o.UpdatedBy), o.UpdatedAt)
+	return r.store.mapError(err)
+}
+
+// Delete removes the override so the configured limit applies again
+func (r sqlLimitRepository) Delete(ctx context.Context, telegramID int, name string) error {
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`DELETE FROM limit_overrides WHERE telegram_id = ? AND name = ?`),
+		int64(telegramID), name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqlTwoFactorRepository is a TwoFactorRepository backed by the two_factor table
+type sqlTwoFactorRepository struct {
+	store *sqlStore
+}
+
+// get returns the second factor of the user using q
+func (r sqlTwoFactorRepository) get(ctx context.Context, q queryer, telegramID int) (*TwoFactor, error) {
+	t := TwoFactor{TelegramID: telegramID}
+	err := q.QueryRowContext(ctx, r.store.rebind(`SELECT secret, enabled, failures, locked_until, last_counter, created_at, updated_at
+		FROM two_factor WHERE telegram_id = ?`), int64(telegramID)).Scan(
+		&t.Secret, &t.Enabled, &t.Failures, &t.LockedUntil, &t.LastCounter, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, r.store.mapError(err)
+	}
+	return &t, nil
+}
+
+// Get returns the second factor of the user
+func (r sqlTwoFactorRepository) Get(ctx context.Context, telegramID int) (*TwoFactor, error) {
+	return r.get(ctx, r.store.db, telegramID)
+}
+
+// Save stores a new (not yet enabled) secret for the user, replacing any existing second factor
+func (r sqlTwoFactorRepository) Save(ctx context.Context, t *TwoFactor) error {
+	now := time.Now().UTC()
+	t.Enabled, t.Failures, t.LockedUntil, t.LastCounter = false, 0, time.Time{}, 0
+	t.CreatedAt, t.UpdatedAt = now, now
+	_, err := r.store.db.ExecContext(ctx, r.store.rebind(`INSERT INTO two_factor
+		(telegram_id, secret, enabled, failures, locked_until, last_counter, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, 0, ?, ?)
+		ON CONFLICT (telegram_id) DO UPDATE SET secret = excluded.secret, enabled = excluded.enabled,
+			failures = 0, locked_until = excluded.locked_until, last_counter = 0,
+			created_at = excluded.created_at, updated_at = excluded.updated_at`),
+		int64(t.TelegramID), t.Secret, false, t.LockedUntil, now, now)
+	return r.store.mapError(err)
+}
+
+// Delete removes the second factor of the user
+func (r sqlTwoFactorRepository) Delete(ctx context.Context, telegramID int) error {
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`DELETE FROM two_factor WHERE telegram_id = ?`), int64(telegramID))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordFailure counts a wrong code, locking the user out once maxFailures have been counted
+func (r sqlTwoFactorRepository) RecordFailure(ctx context.Context, telegramID int, maxFailures int, lockUntil time.Time) (*TwoFactor, error) {
+	var t *TwoFactor
+	err := r.store.withTx(ctx, func(tx *sql.Tx) error {
+		// The update locks the row, so concurrent failures are all counted
+		now := time.Now().UTC()
+		result, err := tx.ExecContext(ctx, r.store.rebind(`UPDATE two_factor SET failures = failures + 1, updated_at = ? WHERE telegram_id = ?`),
+			now, int64(telegramID))
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotFound
+		}
+
+		if t, err = r.get(ctx, tx, telegramID); err != nil {
+			return err
+		}
+		if t.Failures < maxFailures {
+			return nil
+		}
+		t.Failures, t.LockedUntil = 0, lockUntil.UTC()
+		_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE two_factor SET failures = 0, locked_until = ? WHERE telegram_id = ?`),
+			t.LockedUntil, int64(telegramID))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RecordSuccess enables the second factor, resets the failures and records the time step of the valid code
+func (r sqlTwoFactorRepository) RecordSuccess(ctx context.Context, telegramID int, counter int64) error {
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`UPDATE two_factor SET enabled = ?, failures = 0, last_counter = ?, updated_at = ?
+		WHERE telegram_id = ? AND last_counter < ?`), true, counter, time.Now().UTC(), int64(telegramID), counter)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		return nil
+	}
+
+	// Either the user has no second factor or the code was already used
+	if _, err := r.Get(ctx, telegramID); err != nil {
+		return err
+	}
+	return ErrCodeReused
+}
+
+// RewriteSecrets calls fn for every stored secret and replaces it with the result.
+// All secrets are rewritten in a single transaction, if fn fails no secret is changed.
+func (r sqlTwoFactorRepository) RewriteSecrets(ctx context.Context, fn SecretKeyRewriter) (int, error) {
+	changed := 0
+	err := r.store.withTx(ctx, func(tx *sql.Tx) error {
+		type secret struct {
+			telegramID int64
+			secret     string
+		}
+
+		// Read all secrets before updating, as sqlite can't update while rows are open
+		rows, err := tx.QueryContext(ctx, `SELECT telegram_id, secret FROM two_factor ORDER BY telegram_id`)
+		if err != nil {
+			return err
+		}
+		var secrets []secret
+		for rows.Next() {
+			var s secret
+			if err := rows.Scan(&s.telegramID, &s.secret); err != nil {
+				rows.Close()
+				return err
+			}
+			secrets = append(secrets, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, s := range secrets {
+			sealed, err := fn(TwoFactorSecretID(int(s.telegramID)), s.secret)
+			if err != nil {
+				return err
+			}
+			if sealed == s.secret {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, r.store.rebind(`UPDATE two_factor SET secret = ? WHERE telegram_id = ?`), sealed, s.telegramID)
+			if err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// sqlConversationRepository is a ConversationRepository backed by the conversations table.
+// The data of a conversation is stored as a JSON object.
+type sqlConversationRepository struct {
+	store *sqlStore
+}
+
+// Get returns the conversation of the user
+func (r sqlConversationRepository) Get(ctx context.Context, telegramID int) (*Conversation, error) {
+	c := Conversation{TelegramID: telegramID}
+	var data string
+	err := r.store.db.QueryRowContext(ctx, r.store.rebind(`SELECT command, step, data, expires_at, updated_at
+		FROM conversations WHERE telegram_id = ?`), int64(telegramID)).Scan(
+		&c.Command, &c.Step, &data, &c.ExpiresAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, r.store.mapError(err)
+	}
+	if err := json.Unmarshal([]byte(data), &c.Data); err != nil {
+		return nil, fmt.Errorf("invalid data of the conversation of %d: %v", telegramID, err)
+	}
+	if c.Data == nil {
+		c.Data = make(map[string]string)
+	}
+	return &c, nil
+}
+
+// Save stores the conversation, replacing any existing conversation of the user
+func (r sqlConversationRepository) Save(ctx context.Context, c *Conversation) error {
+	data, err := json.Marshal(c.Data)
+	if err != nil {
+		return err
+	}
+	c.ExpiresAt, c.UpdatedAt = c.ExpiresAt.UTC(), time.Now().UTC()
+	_, err = r.store.db.ExecContext(ctx, r.store.rebind(`INSERT INTO conversations (telegram_id, command, step, data, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (telegram_id) DO UPDATE SET command = excluded.command, step = excluded.step, data = excluded.data,
+			expires_at = excluded.expires_at, updated_at = excluded.updated_at`),
+		int64(c.TelegramID), c.Command, c.Step, string(data), c.ExpiresAt, c.UpdatedAt)
+	return r.store.mapError(err)
+}
+
+// Delete ends the conversation of the user
+func (r sqlConversationRepository) Delete(ctx context.Context, telegramID int) error {
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`DELETE FROM conversations WHERE telegram_id = ?`), int64(telegramID))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteExpired ends the conversations which expired before the provided time
+func (r sqlConversationRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.store.db.ExecContext(ctx, r.store.rebind(`DELETE FROM conversations WHERE expires_at < ?`), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,41 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"database/sql"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenSQLite creates a Store backed by the SQLite database file found at path.
+// The file is created if it does not exist. Use ":memory:" for a temporary database.
+func OpenSQLite(path string) (Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only supports a single writer. A single connection also keeps
+	// ":memory:" databases alive for the lifetime of the store.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Debugf("store.OpenSQLite: Opened database %s", path)
+
+	return newSQLStore(db, dialectSQLite), nil
+}
+
+// isSQLiteUniqueViolation reports whether err is a SQLite unique constraint violation
+func isSQLiteUniqueViolation(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
@@ -0,0 +1,132 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package store provides persistence for the Telegram users of the Bot and the
+// Skycoin wallets held on their behalf. PostgreSQL, SQLite and in-memory
+// implementations are provided.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+)
+
+var (
+	// ErrNotFound is returned when a requested record does not exist
+	ErrNotFound = errors.New("store: record not found")
+	// ErrDuplicate is returned when a record conflicts with an existing record
+	// (i.e. the username or address is already in use)
+	ErrDuplicate = errors.New("store: record already exists")
+)
+
+// User models a Telegram user and the Skycoin wallet held on their behalf.
+// A TelegramID or ChatID of 0 means the user has not talked to the Bot yet
+// (i.e. they were created as the recipient of /sendsky).
+// Addresses derived from the wallet seed have a DerivationIndex and no SecretKey.
+// A DerivationIndex of 0 means the address has its own (standalone) key pair.
+type User struct {
+	ID              int64
+	TelegramID      int
+	ChatID          int64
+	UserName        string
+	Address         string
+	PublicKey       string
+	SecretKey       string
+	DerivationIndex uint32
+	CreatedAt       time.Time
+}
+
+// DerivedAddress models a stored address which was derived from the wallet seed
+type DerivedAddress struct {
+	UserID          int64
+	Address         string
+	PublicKey       string
+	DerivationIndex uint32
+}
+
+// UserRepository provides access to the stored users
+type UserRepository interface {
+	// Get returns the user with the provided ID
+	Get(ctx context.Context, id int64) (*User, error)
+	// GetByTelegramID returns the user with the provided numeric Telegram user ID
+	GetByTelegramID(ctx context.Context, telegramID int) (*User, error)
+	// GetByUserName returns the user with the provided Telegram username (not case sensitive)
+	GetByUserName(ctx context.Context, username string) (*User, error)
+	// Create stores a new user and sets its ID
+	Create(ctx context.Context, u *User) error
+	// Update stores the Telegram details (TelegramID, ChatID and UserName) of an existing user
+	Update(ctx context.Context, u *User) error
+	// UpdateWallet stores the wallet details (Address, PublicKey, SecretKey and DerivationIndex) of an existing user
+	UpdateWallet(ctx context.Context, u *User) error
+	// MergePlaceholder moves the ledger account of the placeholder user (who has not talked
+	// to the Bot yet) to u, deletes the placeholder and stores the Telegram details of u, all
+	// in a single transaction. ErrNotFound is returned if placeholderID is not a placeholder.
+	MergePlaceholder(ctx context.Context, u *User, placeholderID int64) error
+	// List returns up to limit users ordered by ID, starting at offset
+	List(ctx context.Context, offset, limit int) ([]User, error)
+}
+
+// SecretKeyRewriter returns the new secret key to store for an address
+type SecretKeyRewriter func(address, secretKey string) (string, error)
+
+// AddressRepository provides access to the stored addresses
+type AddressRepository interface {
+	// RewriteSecretKeys calls fn for every stored address and replaces its secret key with the result.
+	// All keys are rewritten in a single transaction, if fn fails no key is changed.
+	// The number of changed keys is returned.
+	RewriteSecretKeys(ctx context.Context, fn SecretKeyRewriter) (int, error)
+	// ReserveDerivationIndex returns the next unused derivation index. Indexes start at 1
+	// and are never returned twice, even if the reserved index is never stored.
+	ReserveDerivationIndex(ctx context.Context) (uint32, error)
+	// ListDerived returns every address derived from the wallet seed ordered by derivation index
+	ListDerived(ctx context.Context) ([]DerivedAddress, error)
+}
+
+// Store provides access to the repositories of a single database
+type Store interface {
+	Users() UserRepository
+	Addresses() AddressRepository
+	Ledger() LedgerRepository
+	Transactions() TransactionRepository
+	Members() MemberRepository
+	AuditLog() AuditRepository
+	Limits() LimitRepository
+	TwoFactor() TwoFactorRepository
+	Conversations() ConversationRepository
+	// Migrate applies any pending schema migrations and returns how many were applied
+	Migrate(ctx context.Context) (int, error)
+	// SchemaVersion returns the schema version of the database
+	SchemaVersion(ctx context.Context) (int, error)
+	Close() error
+}
+
+// NormalizeUserName returns the Telegram username in the "@username" form used by the store
+func NormalizeUserName(username string) string {
+	username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+	if username == "" {
+		return ""
+	}
+	return "@" + username
+}
+
+// Open creates the Store described by the database configuration.
+// Migrate must be called before the Store is used.
+func Open(cfg wcconfig.SQLdatabase) (Store, error) {
+	switch cfg.Driver {
+	case "postgres", "":
+		return OpenPostgres(cfg)
+	case "sqlite3":
+		return OpenSQLite(cfg.Path)
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Driver)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStatusChanged is returned when a transaction is no longer in the expected status
+// (i.e. a withdrawal was confirmed twice)
+var ErrStatusChanged = errors.New("store: transaction status has changed")
+
+// Transaction kinds
+const (
+	TxKindWithdrawal = "withdrawal"
+)
+
+// Transaction statuses. A withdrawal is pending until the user confirms it, then
+// broadcast once it has been sent to the network and confirmed once it is in a block.
+// Withdrawals which could not be sent are failed, unconfirmed ones which were
+// abandoned are cancelled.
+const (
+	TxStatusPending   = "pending"
+	TxStatusBroadcast = "broadcast"
+	TxStatusConfirmed = "confirmed"
+	TxStatusFailed    = "failed"
+	TxStatusCancelled = "cancelled"
+)
+
+// Transaction models an on-chain transaction made on behalf of a user.
+// Coins are in droplets, Hours is the number of coin hours burned.
+type Transaction struct {
+	ID          int64
+	UserID      int64
+	Kind        string
+	Status      string
+	TxID        string
+	FromAddress string
+	ToAddress   string
+	Coins       uint64
+	Hours       uint64
+	Memo        string
+	Error       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TransactionFilter selects the transactions returned by TransactionRepository.List.
+// Empty fields match every transaction.
+type TransactionFilter struct {
+	UserID int64
+	Kind   string
+	Status string
+}
+
+// TransactionRepository provides access to the stored transactions
+type TransactionRepository interface {
+	// Create stores a new transaction and sets its ID
+	Create(ctx context.Context, t *Transaction) error
+	// Get returns the transaction with the provided ID
+	Get(ctx context.Context, id int64) (*Transaction, error)
+	// GetByTxID returns the transaction with the provided txid
+	GetByTxID(ctx context.Context, txid string) (*Transaction, error)
+	// UpdateStatus stores the Status, TxID, Hours and Error of the transaction, provided it
+	// is still in the from status. ErrStatusChanged is returned if it is not.
+	UpdateStatus(ctx context.Context, t *Transaction, from string) error
+	// List returns up to limit transactions matching the filter, newest first, starting at offset
+	List(ctx context.Context, filter TransactionFilter, offset, limit int) ([]Transaction, error)
+}
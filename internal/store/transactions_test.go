@@ -0,0 +1,92 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_TransactionRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		u := newTestUser(1)
+		if err := s.Users().Create(ctx, u); err != nil {
+			t.Fatalf("%s: Create user: %v", name, err)
+		}
+
+		txns := s.Transactions()
+		first := &Transaction{
+			UserID:      u.ID,
+			Kind:        TxKindWithdrawal,
+			Status:      TxStatusPending,
+			FromAddress: u.Address,
+			ToAddress:   "external",
+			Coins:       1000000,
+			Hours:       5,
+		}
+		if err := txns.Create(ctx, first); err != nil {
+			t.Fatalf("%s: Create: %v", name, err)
+		}
+		second := &Transaction{UserID: u.ID, Kind: TxKindWithdrawal, Status: TxStatusPending, ToAddress: "external", Coins: 2000000}
+		if err := txns.Create(ctx, second); err != nil {
+			t.Fatalf("%s: Create: %v", name, err)
+		}
+
+		got, err := txns.Get(ctx, first.ID)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		if got.UserID != u.ID || got.ToAddress != "external" || got.Coins != 1000000 || got.Hours != 5 || got.Status != TxStatusPending {
+			t.Errorf("%s: Unexpected transaction: %+v", name, got)
+		}
+
+		first.Status, first.TxID, first.Hours = TxStatusBroadcast, "tx1", 4
+		if err := txns.UpdateStatus(ctx, first, TxStatusPending); err != nil {
+			t.Fatalf("%s: UpdateStatus: %v", name, err)
+		}
+		if err := txns.UpdateStatus(ctx, first, TxStatusPending); err != ErrStatusChanged {
+			t.Errorf("%s: Expected ErrStatusChanged, got %v", name, err)
+		}
+		if err := txns.UpdateStatus(ctx, &Transaction{ID: 99, Status: TxStatusFailed}, TxStatusPending); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		got, err = txns.GetByTxID(ctx, "tx1")
+		if err != nil {
+			t.Fatalf("%s: GetByTxID: %v", name, err)
+		}
+		if got.ID != first.ID || got.Status != TxStatusBroadcast || got.Hours != 4 {
+			t.Errorf("%s: Unexpected transaction: %+v", name, got)
+		}
+		if _, err := txns.GetByTxID(ctx, "unknown"); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		list, err := txns.List(ctx, TransactionFilter{UserID: u.ID}, 0, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(list) != 2 || list[0].ID != second.ID || list[1].ID != first.ID {
+			t.Errorf("%s: Expected newest first, got %+v", name, list)
+		}
+
+		list, err = txns.List(ctx, TransactionFilter{Kind: TxKindWithdrawal, Status: TxStatusPending}, 0, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(list) != 1 || list[0].ID != second.ID {
+			t.Errorf("%s: Unexpected pending transactions: %+v", name, list)
+		}
+
+		list, err = txns.List(ctx, TransactionFilter{}, 1, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if len(list) != 1 || list[0].ID != first.ID {
+			t.Errorf("%s: Unexpected page: %+v", name, list)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrCodeReused is returned by TwoFactorRepository.RecordSuccess when a code at or
+// before the last accepted time step is used again
+var ErrCodeReused = errors.New("store: two-factor code already used")
+
+// TwoFactor models the TOTP second factor of a user. Secret is sealed by the keyvault
+// using TwoFactorSecretID as the additional data. Enabled is set once the user has
+// entered their first valid code. Failures counts the wrong codes entered since the
+// last valid code, and codes are refused until LockedUntil. LastCounter is the TOTP
+// time step of the last valid code, which can't be used again.
+type TwoFactor struct {
+	TelegramID  int
+	Secret      string
+	Enabled     bool
+	Failures    int
+	LockedUntil time.Time
+	LastCounter int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TwoFactorSecretID returns the ID the two-factor secret of the user is sealed with,
+// which is passed as the address to the SecretKeyRewriter of RewriteSecrets
+func TwoFactorSecretID(telegramID int) string {
+	return "2fa:" + strconv.Itoa(telegramID)
+}
+
+// TwoFactorRepository provides access to the two-factor secrets of users
+type TwoFactorRepository interface {
+	// Get returns the second factor of the user. ErrNotFound is returned if they haven't set one up.
+	Get(ctx context.Context, telegramID int) (*TwoFactor, error)
+	// Save stores a new (not yet enabled) secret for the user, replacing any existing second factor
+	Save(ctx context.Context, t *TwoFactor) error
+	// Delete removes the second factor of the user. ErrNotFound is returned if there isn't one.
+	Delete(ctx context.Context, telegramID int) error
+	// RecordFailure counts a wrong code. Once maxFailures wrong codes have been counted the
+	// user is locked out until lockUntil and the count starts again. Returns the updated second factor.
+	RecordFailure(ctx context.Context, telegramID int, maxFailures int, lockUntil time.Time) (*TwoFactor, error)
+	// RecordSuccess enables the second factor, resets the failures and records the time
+	// step of the valid code. ErrCodeReused is returned if counter isn't after LastCounter.
+	RecordSuccess(ctx context.Context, telegramID int, counter int64) error
+	// RewriteSecrets calls fn for every stored secret and replaces it with the result.
+	// All secrets are rewritten in a single transaction, if fn fails no secret is changed.
+	// The number of changed secrets is returned.
+	RewriteSecrets(ctx context.Context, fn SecretKeyRewriter) (int, error)
+}
@@ -0,0 +1,116 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_TwoFactorRepository(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		repo := s.TwoFactor()
+
+		if _, err := repo.Get(ctx, 1002); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+		if err := repo.RecordSuccess(ctx, 1002, 1); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+
+		if err := repo.Save(ctx, &TwoFactor{TelegramID: 1002, Secret: "sealed1"}); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		tf, err := repo.Get(ctx, 1002)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", name, err)
+		}
+		if tf.Secret != "sealed1" || tf.Enabled || tf.Failures != 0 || !tf.LockedUntil.IsZero() || tf.CreatedAt.IsZero() {
+			t.Errorf("%s: Unexpected second factor: %+v", name, tf)
+		}
+
+		// The third failure locks the user out
+		lockUntil := time.Now().Add(15 * time.Minute).UTC().Truncate(time.Second)
+		for i := 1; i <= 3; i++ {
+			tf, err = repo.RecordFailure(ctx, 1002, 3, lockUntil)
+			if err != nil {
+				t.Fatalf("%s: RecordFailure: %v", name, err)
+			}
+			if i < 3 && (tf.Failures != i || !tf.LockedUntil.IsZero()) {
+				t.Errorf("%s: %d: Unexpected second factor: %+v", name, i, tf)
+			}
+		}
+		if tf.Failures != 0 || !tf.LockedUntil.Equal(lockUntil) {
+			t.Errorf("%s: Expected a lockout until %v, got %+v", name, lockUntil, tf)
+		}
+
+		if err := repo.RecordSuccess(ctx, 1002, 100); err != nil {
+			t.Fatalf("%s: RecordSuccess: %v", name, err)
+		}
+		if err := repo.RecordSuccess(ctx, 1002, 100); err != ErrCodeReused {
+			t.Errorf("%s: Expected ErrCodeReused, got %v", name, err)
+		}
+		if tf, err = repo.Get(ctx, 1002); err != nil || !tf.Enabled || tf.LastCounter != 100 {
+			t.Errorf("%s: Unexpected second factor: %+v, %v", name, tf, err)
+		}
+
+		// Saving a new secret starts again
+		if err := repo.Save(ctx, &TwoFactor{TelegramID: 1002, Secret: "sealed2"}); err != nil {
+			t.Fatalf("%s: Save: %v", name, err)
+		}
+		if tf, err = repo.Get(ctx, 1002); err != nil || tf.Secret != "sealed2" || tf.Enabled || tf.LastCounter != 0 || !tf.LockedUntil.IsZero() {
+			t.Errorf("%s: Unexpected second factor: %+v, %v", name, tf, err)
+		}
+
+		if err := repo.Delete(ctx, 1002); err != nil {
+			t.Fatalf("%s: Delete: %v", name, err)
+		}
+		if err := repo.Delete(ctx, 1002); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+		if _, err := repo.RecordFailure(ctx, 1002, 3, lockUntil); err != ErrNotFound {
+			t.Errorf("%s: Expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
+
+func Test_TwoFactorRepository_RewriteSecrets(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range testStores(t) {
+		repo := s.TwoFactor()
+		for _, id := range []int{1000, 1001} {
+			if err := repo.Save(ctx, &TwoFactor{TelegramID: id, Secret: "old"}); err != nil {
+				t.Fatalf("%s: Save: %v", name, err)
+			}
+		}
+
+		// Nothing is changed if fn fails
+		_, err := repo.RewriteSecrets(ctx, func(id, secret string) (string, error) {
+			if id == TwoFactorSecretID(1001) {
+				return "", errors.New("failed")
+			}
+			return "new", nil
+		})
+		if err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+		if tf, _ := repo.Get(ctx, 1000); tf.Secret != "old" {
+			t.Errorf("%s: Expected the secret not to change, got %q", name, tf.Secret)
+		}
+
+		n, err := repo.RewriteSecrets(ctx, func(id, secret string) (string, error) {
+			return secret + ":" + id, nil
+		})
+		if err != nil || n != 2 {
+			t.Fatalf("%s: RewriteSecrets: %d, %v", name, n, err)
+		}
+		if tf, _ := repo.Get(ctx, 1001); tf.Secret != "old:2fa:1001" {
+			t.Errorf("%s: Unexpected secret %q", name, tf.Secret)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// usersPageSize is the number of members listed on each page of /users
+const usersPageSize = 10
+
+// errInvalidTarget is returned by resolveMember when the argument isn't a @username or Telegram user ID
+var errInvalidTarget = errors.New("expected a @username or Telegram user ID")
+
+// nextRoleFunc returns the role a member is changed to by an admin command. If the
+// command can't be applied to the current role, the message to send is returned instead.
+type nextRoleFunc func(current store.Role) (store.Role, string)
+
+func banRole(current store.Role) (store.Role, string) {
+	if current == store.RoleBanned {
+		return "", wcconst.MsgAdminAlreadyBanned
+	}
+	return store.RoleBanned, ""
+}
+
+func unbanRole(current store.Role) (store.Role, string) {
+	if current != store.RoleBanned {
+		return "", wcconst.MsgAdminNotBanned
+	}
+	return store.RoleUser, ""
+}
+
+func promoteRole(current store.Role) (store.Role, string) {
+	switch current {
+	case store.RoleBanned:
+		return "", wcconst.MsgAdminPromoteBanned
+	case store.RoleUser:
+		return store.RoleModerator, ""
+	case store.RoleModerator:
+		return store.RoleAdmin, ""
+	}
+	return "", wcconst.MsgAdminCantPromote
+}
+
+func demoteRole(current store.Role) (store.Role, string) {
+	switch current {
+	case store.RoleAdmin:
+		return store.RoleModerator, ""
+	case store.RoleModerator:
+		return store.RoleUser, ""
+	}
+	return "", wcconst.MsgAdminCantDemote
+}
+
+// memberName returns the name used to identify a member in admin messages and the audit log
+func memberName(m *store.Member) string {
+	switch {
+	case m.UserName != "":
+		return fmt.Sprintf("%s (%d)", m.UserName, m.TelegramID)
+	case m.FirstName != "":
+		return fmt.Sprintf("%s (%d)", m.FirstName, m.TelegramID)
+	}
+	return strconv.Itoa(m.TelegramID)
+}
+
+// formatTime formats the times shown by the admin commands
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// resolveMember returns the member named by the argument of an admin command, either a
+// @username or a numeric Telegram user ID. Members can be given a role by their Telegram
+// user ID before they talk to the Bot, in which case a new member with the user role is returned.
+func (bot *Bot) resolveMember(ctx context.Context, arg string) (*store.Member, error) {
+	fields := strings.Fields(arg)
+	if len(fields) != 1 {
+		return nil, errInvalidTarget
+	}
+
+	if strings.HasPrefix(fields[0], "@") {
+		return bot.store.Members().GetByUserName(ctx, fields[0])
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil || id <= 0 {
+		return nil, errInvalidTarget
+	}
+	m, err := bot.store.Members().Get(ctx, id)
+	if err == store.ErrNotFound {
+		return &store.Member{TelegramID: id, Role: store.RoleUser}, nil
+	}
+	return m, err
+}
+
+// auditTimeout is how long an action has to be recorded in the audit log
+const auditTimeout = 10 * time.Second
+
+// audit records an action taken by the user interacting with the Bot in the audit log.
+// The action has already been taken, so it is recorded even if the handler timed out.
+func (bot *Bot) audit(ctx *BotContext, action, target, details string) {
+	auditctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	err := bot.store.AuditLog().Record(auditctx, &store.AuditEntry{
+		ActorTelegramID: ctx.User.ID,
+		Action:          action,
+		Target:          target,
+		Details:         details,
+	})
+	if err != nil {
+		log.Errorf("Bot.audit: Error recording %s of %s by %s: %v", action, target, ctx.User.NameAndTags(), err)
+	}
+}
+
+// changeRole changes the role of the member named by args using next, and records the change
+// in the audit log. Nobody can change their own role or the role of the owner, and (other than
+// the owner) users can only change the role of members they outrank, to a role no higher than
+// their own. The message to send to the user is returned.
+func (bot *Bot) changeRole(ctx *BotContext, command, args, action string, next nextRoleFunc) (string, error) {
+	storectx := ctx.Context()
+
+	target, err := bot.resolveMember(storectx, args)
+	if err == errInvalidTarget {
+		return fmt.Sprintf(wcconst.MsgAdminUsage, command), nil
+	} else if err == store.ErrNotFound {
+		return fmt.Sprintf(wcconst.MsgAdminUnknownUser, EscapeMarkdown(args)), nil
+	} else if err != nil {
+		return "", err
+	}
+	name := memberName(target)
+
+	if target.TelegramID == ctx.User.ID {
+		return wcconst.MsgAdminSelf, nil
+	}
+	if bot.isOwner(target.TelegramID) {
+		return wcconst.MsgAdminOwner, nil
+	}
+
+	role, msg := next(target.Role)
+	if msg != "" {
+		return fmt.Sprintf(msg, EscapeMarkdown(name)), nil
+	}
+	if !bot.isOwner(ctx.User.ID) && (target.Role.AtLeast(ctx.User.Role) || !ctx.User.Role.AtLeast(role)) {
+		return fmt.Sprintf(wcconst.MsgAdminOutranked, EscapeMarkdown(name)), nil
+	}
+
+	if err := bot.store.Members().SetRole(storectx, target.TelegramID, role); err != nil {
+		return "", err
+	}
+	bot.audit(ctx, action, name, fmt.Sprintf("%s -> %s", target.Role, role))
+	log.Infof("Bot.changeRole: %s changed the role of %s from %s to %s", ctx.User.NameAndTags(), name, target.Role, role)
+	return fmt.Sprintf(wcconst.MsgAdminRoleChanged, EscapeMarkdown(name), role), nil
+}
+
+// handleRoleCommand handles the admin commands which change the role of a member
+func (bot *Bot) handleRoleCommand(ctx *BotContext, command, args, action string, next nextRoleFunc) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg, err := bot.changeRole(ctx, command, args, action, next)
+	if err != nil {
+		log.Errorf("Bot.handleRoleCommand: Error handling /%s %s: %v", command, args, err)
+		msg = wcconst.MsgErrorStore
+	}
+	if senderr := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg); senderr != nil {
+		logSendError("Bot.handleRoleCommand", senderr)
+		return senderr
+	}
+	return nil
+}
+
+// Handler for ban command
+func (bot *Bot) handleCommandBan(ctx *BotContext, command, args string) error {
+	return bot.handleRoleCommand(ctx, command, args, store.AuditBan, banRole)
+}
+
+// Handler for unban command
+func (bot *Bot) handleCommandUnban(ctx *BotContext, command, args string) error {
+	return bot.handleRoleCommand(ctx, command, args, store.AuditUnban, unbanRole)
+}
+
+// Handler for promote command
+func (bot *Bot) handleCommandPromote(ctx *BotContext, command, args string) error {
+	return bot.handleRoleCommand(ctx, command, args, store.AuditPromote, promoteRole)
+}
+
+// Handler for demote command
+func (bot *Bot) handleCommandDemote(ctx *BotContext, command, args string) error {
+	return bot.handleRoleCommand(ctx, command, args, store.AuditDemote, demoteRole)
+}
+
+// usersPage returns the text and inline keyboard of a page (from 1) of /users.
+// The keyboard is nil if every member fits on a single page.
+func (bot *Bot) usersPage(ctx context.Context, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	// Ask for one extra member to find out if there is a next page
+	members, err := bot.store.Members().List(ctx, (page-1)*usersPageSize, usersPageSize+1)
+	if err != nil {
+		return "", nil, err
+	}
+	hasNext := len(members) > usersPageSize
+	if hasNext {
+		members = members[:usersPageSize]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, wcconst.MsgUsersHeader, page)
+	if len(members) == 0 {
+		b.WriteString(wcconst.MsgUsersNone)
+	}
+	for i := range members {
+		m := &members[i]
+		fmt.Fprintf(&b, wcconst.MsgUsersLine, EscapeMarkdown(memberName(m)), m.Role, formatTime(m.LastSeenAt))
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("« prev", fmt.Sprintf("users %d", page-1)))
+	}
+	if hasNext {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("next »", fmt.Sprintf("users %d", page+1)))
+	}
+	if len(row) == 0 {
+		return b.String(), nil, nil
+	}
+	kb := tgbotapi.NewInlineKeyboardMarkup(row)
+	return b.String(), &kb, nil
+}
+
+// Handler for users command: /users [page]
+// Pressing the prev and next buttons edits the list in place.
+func (bot *Bot) handleCommandUsers(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	page := 1
+	if args != "" {
+		var err error
+		if page, err = strconv.Atoi(strings.TrimSpace(args)); err != nil || page < 1 {
+			return bot.Send(ctx, getSendModeforContext(ctx), "markdown", wcconst.MsgUsersUsage)
+		}
+	}
+
+	text, kb, err := bot.usersPage(ctx.Context(), page)
+	if err != nil {
+		log.Errorf("Bot.handleCommandUsers: %v", err)
+		return bot.Send(ctx, getSendModeforContext(ctx), "markdown", wcconst.MsgErrorStore)
+	}
+	bot.audit(ctx, store.AuditUsers, "", fmt.Sprintf("page %d", page))
+
+	switch {
+	case ctx.IsCallBackQuery():
+		err = bot.EditMessage(ctx, kb, text)
+	case kb != nil:
+		err = bot.SendReplyInlineKeyboard(ctx, *kb, text)
+	default:
+		err = bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+	}
+	if err != nil {
+		logSendError("Bot.handleCommandUsers", err)
+	}
+	return err
+}
+
+// whois returns the details of the member (or wallet user) named by args
+func (bot *Bot) whois(ctx context.Context, args string) (string, error) {
+	m, err := bot.resolveMember(ctx, args)
+	if err == store.ErrNotFound {
+		m = nil
+	} else if err != nil {
+		return "", err
+	}
+
+	// Find the wallet of the member. Users who were tipped by username may
+	// have a wallet without having talked to the Bot.
+	var u *store.User
+	if m != nil {
+		u, err = bot.store.Users().GetByTelegramID(ctx, m.TelegramID)
+		if m.CreatedAt.IsZero() {
+			// Unknown Telegram user ID
+			m = nil
+		}
+	}
+	if (m == nil || err == store.ErrNotFound) && strings.HasPrefix(args, "@") {
+		u, err = bot.store.Users().GetByUserName(ctx, strings.TrimSpace(args))
+	}
+	if err == store.ErrNotFound {
+		u = nil
+	} else if err != nil {
+		return "", err
+	}
+	if m == nil && u == nil {
+		return "", store.ErrNotFound
+	}
+
+	var b strings.Builder
+	if m != nil {
+		fmt.Fprintf(&b, wcconst.MsgWhoisMember, EscapeMarkdown(memberName(m)), m.TelegramID, m.Role,
+			formatTime(m.CreatedAt), formatTime(m.LastSeenAt))
+	} else {
+		fmt.Fprintf(&b, wcconst.MsgWhoisNotMember, EscapeMarkdown(userDisplayName(u)))
+	}
+	if u == nil {
+		b.WriteString(wcconst.MsgWhoisNoWallet)
+		return b.String(), nil
+	}
+
+	balance, err := bot.store.Ledger().Balance(ctx, store.UserAccount(u.ID))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&b, wcconst.MsgWhoisWallet, u.Address, wallet.FormatDroplets(uint64(balance)), formatTime(u.CreatedAt))
+	return b.String(), nil
+}
+
+// Handler for whois command: /whois @user
+func (bot *Bot) handleCommandWhois(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg, err := bot.whois(ctx.Context(), args)
+	switch err {
+	case nil:
+		bot.audit(ctx, store.AuditWhois, strings.TrimSpace(args), "")
+	case errInvalidTarget:
+		msg = fmt.Sprintf(wcconst.MsgAdminUsage, command)
+	case store.ErrNotFound:
+		msg = fmt.Sprintf(wcconst.MsgAdminUnknownUser, EscapeMarkdown(args))
+	default:
+		log.Errorf("Bot.handleCommandWhois: %v", err)
+		msg = wcconst.MsgErrorStore
+	}
+
+	err = bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg)
+	if err != nil {
+		logSendError("Bot.handleCommandWhois", err)
+	}
+	return err
+}
+
+// addForwardedUser adds the author of a message forwarded to the Bot by a moderator or admin
+// as a member with the user role. The message to send is returned.
+func (bot *Bot) addForwardedUser(ctx *BotContext, from *tgbotapi.User) (string, error) {
+	if from.IsBot {
+		return wcconst.MsgAdminForwardBot, nil
+	}
+
+	storectx := ctx.Context()
+	m, err := bot.store.Members().Get(storectx, from.ID)
+	if err == nil {
+		return fmt.Sprintf(wcconst.MsgAdminUserKnown, EscapeMarkdown(memberName(m)), m.Role), nil
+	} else if err != store.ErrNotFound {
+		return "", err
+	}
+
+	m = &store.Member{TelegramID: from.ID, UserName: from.UserName, FirstName: from.FirstName}
+	if err := bot.store.Members().Touch(storectx, m); err != nil {
+		return "", err
+	}
+	bot.audit(ctx, store.AuditAddUser, memberName(m), "added by forward")
+	log.Infof("Bot.addForwardedUser: %s added %s", ctx.User.NameAndTags(), memberName(m))
+	return fmt.Sprintf(wcconst.MsgAdminUserAdded, EscapeMarkdown(memberName(m))), nil
+}
+
+// isAddUserForward reports whether the private message is forwarded by an admin to add its
+// sender as a user. Messages forwarded as the reply to a conversation are left to it.
+func (bot *Bot) isAddUserForward(ctx *BotContext) (bool, error) {
+	if ctx.message.ForwardFrom == nil || ctx.User == nil || !ctx.User.Role.AtLeast(store.RoleAdmin) {
+		return false, nil
+	}
+	c, err := bot.store.Conversations().Get(ctx.Context(), ctx.User.ID)
+	if err == store.ErrNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get conversation: %v", err)
+	}
+	return c.Expired(time.Now()), nil
+}
+
+// handleForwardedMessageFrom lets admins add users by forwarding one of their messages
+func (bot *Bot) handleForwardedMessageFrom(ctx *BotContext, command, args string) error {
+	from := ctx.message.ForwardFrom
+	log.Debugf("Bot.handleForwardedMessageFrom: %d", from.ID)
+	bot.SendGAEvent("BotCommand", command, "HandleForwardedMessage")
+
+	msg, err := bot.addForwardedUser(ctx, from)
+	if err != nil {
+		log.Errorf("Bot.handleForwardedMessageFrom: %v", err)
+		msg = wcconst.MsgErrorStore
+	}
+	return bot.Reply(ctx, "markdown", msg)
+}
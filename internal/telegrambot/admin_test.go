@@ -0,0 +1,258 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// newTestAdminBot creates a Bot with an in-memory store holding the owner (1000) and the
+// members @mod (1001, moderator), @alice (1002) and @bob (1003)
+func newTestAdminBot(t *testing.T) *Bot {
+	bot := &Bot{
+		config:         wcconfig.Config{Telegram: wcconfig.TelegramParameters{ChatID: 1000}},
+		store:          store.NewMemoryStore(),
+		handlerContext: context.Background(),
+	}
+	for i, name := range []string{"owner", "mod", "alice", "bob"} {
+		m := &store.Member{TelegramID: 1000 + i, UserName: name}
+		if err := bot.store.Members().Touch(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bot.store.Members().SetRole(context.Background(), 1000, store.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.store.Members().SetRole(context.Background(), 1001, store.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	return bot
+}
+
+func memberRole(t *testing.T, bot *Bot, id int) store.Role {
+	m, err := bot.store.Members().Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Role
+}
+
+func Test_changeRole(t *testing.T) {
+	bot := newTestAdminBot(t)
+	owner := &BotContext{User: &User{ID: 1000, UserName: "owner", Role: store.RoleAdmin}}
+	mod := &BotContext{User: &User{ID: 1001, UserName: "mod", Role: store.RoleModerator}}
+
+	tests := []struct {
+		ctx    *BotContext
+		action string
+		args   string
+		next   nextRoleFunc
+		msg    string
+		id     int
+		role   store.Role
+	}{
+		{mod, store.AuditBan, "@alice", banRole, wcconst.MsgAdminRoleChanged, 1002, store.RoleBanned},
+		{mod, store.AuditBan, "@alice", banRole, wcconst.MsgAdminAlreadyBanned, 1002, store.RoleBanned},
+		{owner, store.AuditPromote, "@alice", promoteRole, wcconst.MsgAdminPromoteBanned, 1002, store.RoleBanned},
+		{mod, store.AuditUnban, "1002", unbanRole, wcconst.MsgAdminRoleChanged, 1002, store.RoleUser},
+		{mod, store.AuditBan, "@owner", banRole, wcconst.MsgAdminOwner, 1000, store.RoleAdmin},
+		{mod, store.AuditDemote, "@mod", demoteRole, wcconst.MsgAdminSelf, 1001, store.RoleModerator},
+		{mod, store.AuditPromote, "@bob", promoteRole, wcconst.MsgAdminRoleChanged, 1003, store.RoleModerator},
+		{mod, store.AuditBan, "@bob", banRole, wcconst.MsgAdminOutranked, 1003, store.RoleModerator},
+		{owner, store.AuditPromote, "@bob", promoteRole, wcconst.MsgAdminRoleChanged, 1003, store.RoleAdmin},
+		{owner, store.AuditPromote, "@bob", promoteRole, wcconst.MsgAdminCantPromote, 1003, store.RoleAdmin},
+		{owner, store.AuditDemote, "@bob", demoteRole, wcconst.MsgAdminRoleChanged, 1003, store.RoleModerator},
+		// Members can be banned by ID before they talk to the Bot
+		{mod, store.AuditBan, "2000", banRole, wcconst.MsgAdminRoleChanged, 2000, store.RoleBanned},
+	}
+
+	for i, tc := range tests {
+		msg, err := bot.changeRole(tc.ctx, tc.action, tc.args, tc.action, tc.next)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		prefix := tc.msg
+		if j := strings.Index(prefix, "%"); j != -1 {
+			prefix = prefix[:j]
+		}
+		if !strings.HasPrefix(msg, prefix) {
+			t.Errorf("%d: /%s %s: unexpected message %q", i, tc.action, tc.args, msg)
+		}
+		if role := memberRole(t, bot, tc.id); role != tc.role {
+			t.Errorf("%d: /%s %s: expected %q, got %q", i, tc.action, tc.args, tc.role, role)
+		}
+	}
+
+	msg, err := bot.changeRole(mod, "ban", "@nobody", store.AuditBan, banRole)
+	if err != nil || msg != fmt.Sprintf(wcconst.MsgAdminUnknownUser, "@nobody") {
+		t.Errorf("Unexpected result: %q, %v", msg, err)
+	}
+	msg, err = bot.changeRole(mod, "ban", "", store.AuditBan, banRole)
+	if err != nil || msg != fmt.Sprintf(wcconst.MsgAdminUsage, "ban") {
+		t.Errorf("Unexpected result: %q, %v", msg, err)
+	}
+
+	// Only the role changes are audited
+	entries, err := bot.store.AuditLog().List(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("Expected 6 audit entries, got %+v", entries)
+	}
+	if e := entries[0]; e.ActorTelegramID != 1001 || e.Action != store.AuditBan || e.Target != "2000" || e.Details != "user -> banned" {
+		t.Errorf("Unexpected audit entry: %+v", e)
+	}
+}
+
+func Test_usersPage(t *testing.T) {
+	bot := newTestAdminBot(t)
+	for i := 0; i < usersPageSize; i++ {
+		if err := bot.store.Members().SetRole(context.Background(), 2000+i, store.RoleUser); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	text, kb, err := bot.usersPage(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "@owner (1000) *admin*") || strings.Count(text, "\n- ") != usersPageSize {
+		t.Errorf("Unexpected first page: %q", text)
+	}
+	if kb == nil || len(kb.InlineKeyboard) != 1 || len(kb.InlineKeyboard[0]) != 1 || *kb.InlineKeyboard[0][0].CallbackData != "users 2" {
+		t.Errorf("Expected a next button only, got %+v", kb)
+	}
+
+	text, kb, err = bot.usersPage(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(text, "- ") != 4 {
+		t.Errorf("Unexpected second page: %q", text)
+	}
+	if kb == nil || len(kb.InlineKeyboard[0]) != 1 || *kb.InlineKeyboard[0][0].CallbackData != "users 1" {
+		t.Errorf("Expected a prev button only, got %+v", kb)
+	}
+
+	text, kb, err = bot.usersPage(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, wcconst.MsgUsersNone) || kb == nil {
+		t.Errorf("Unexpected third page: %q %+v", text, kb)
+	}
+}
+
+func Test_whois(t *testing.T) {
+	bot, _, users := newTestWalletBot(t)
+	alice := users[0]
+	alice.TelegramID = 1002
+	if err := bot.store.Users().Update(context.Background(), alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.store.Members().Touch(context.Background(), &store.Member{TelegramID: 1002, UserName: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := bot.whois(context.Background(), "@alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, "`1002`") || !strings.Contains(msg, alice.Address) || !strings.Contains(msg, "*Balance:* 0 SKY") {
+		t.Errorf("Unexpected whois: %q", msg)
+	}
+
+	// Bob was tipped by username and hasn't talked to the Bot
+	msg, err = bot.whois(context.Background(), "@bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, "Hasn't talked to me") || !strings.Contains(msg, users[1].Address) {
+		t.Errorf("Unexpected whois: %q", msg)
+	}
+
+	if _, err := bot.whois(context.Background(), "@nobody"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := bot.whois(context.Background(), "5000"); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func Test_addForwardedUser(t *testing.T) {
+	bot := newTestAdminBot(t)
+	mod := &BotContext{User: &User{ID: 1001, UserName: "mod", Role: store.RoleModerator}}
+
+	msg, err := bot.addForwardedUser(mod, &tgbotapi.User{ID: 3000, UserName: "carol"})
+	if err != nil || msg != fmt.Sprintf(wcconst.MsgAdminUserAdded, "@carol (3000)") {
+		t.Errorf("Unexpected result: %q, %v", msg, err)
+	}
+	if role := memberRole(t, bot, 3000); role != store.RoleUser {
+		t.Errorf("Expected user, got %q", role)
+	}
+
+	msg, err = bot.addForwardedUser(mod, &tgbotapi.User{ID: 3000, UserName: "carol"})
+	if err != nil || msg != fmt.Sprintf(wcconst.MsgAdminUserKnown, "@carol (3000)", store.RoleUser) {
+		t.Errorf("Unexpected result: %q, %v", msg, err)
+	}
+
+	if msg, _ := bot.addForwardedUser(mod, &tgbotapi.User{ID: 3001, IsBot: true}); msg != wcconst.MsgAdminForwardBot {
+		t.Errorf("Unexpected result: %q", msg)
+	}
+
+	entries, err := bot.store.AuditLog().List(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != store.AuditAddUser || entries[0].Target != "@carol (3000)" {
+		t.Errorf("Unexpected audit entries: %+v", entries)
+	}
+}
+
+func Test_isAddUserForward(t *testing.T) {
+	bot := newTestAdminBot(t)
+	forward := func(id int, role store.Role) *BotContext {
+		return &BotContext{
+			message: &tgbotapi.Message{Text: "hello", ForwardFrom: &tgbotapi.User{ID: 3000, UserName: "carol"}},
+			User:    &User{ID: id, Role: role},
+		}
+	}
+
+	if ok, err := bot.isAddUserForward(forward(1000, store.RoleAdmin)); err != nil || !ok {
+		t.Errorf("Expected the forward of an admin to add a user, got %v, %v", ok, err)
+	}
+	if ok, _ := bot.isAddUserForward(forward(1001, store.RoleModerator)); ok {
+		t.Error("Expected the forward of a moderator not to add a user")
+	}
+	admin := forward(1000, store.RoleAdmin)
+	admin.message.ForwardFrom = nil
+	if ok, _ := bot.isAddUserForward(admin); ok {
+		t.Error("Expected a message which isn't forwarded not to add a user")
+	}
+
+	// A forward is the reply to the conversation the admin is in
+	err := bot.store.Conversations().Save(context.Background(), &store.Conversation{
+		TelegramID: 1000,
+		Command:    "withdraw",
+		Step:       "withdraw-address",
+		ExpiresAt:  time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bot.isAddUserForward(forward(1000, store.RoleAdmin)); err != nil || ok {
+		t.Errorf("Expected the forward to be left to the conversation, got %v, %v", ok, err)
+	}
+}
@@ -5,9 +5,20 @@
 package telegrambot
 
 import (
+	"strings"
+
 	"gopkg.in/telegram-bot-api.v4"
 )
 
+// markdownEscaper escapes the characters which have a special meaning in Telegram Markdown
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// EscapeMarkdown will escape user supplied text (i.e. usernames or memos) so it can
+// be safely included in a message sent using the "markdown" format
+func EscapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
 // CreateMarkup will return a tgbotapi.InlineKeyboardMarkup. Supports a single button row only
 func CreateMarkup(btns ...string) tgbotapi.InlineKeyboardMarkup {
 	row := tgbotapi.NewInlineKeyboardRow()
@@ -126,3 +126,18 @@ func Test_CreateMultiLineMarkup_ThreeLines(t *testing.T) {
 	}
 
 }
+
+func Test_EscapeMarkdown(t *testing.T) {
+	tests := map[string]string{
+		"@some_user":    "@some\\_user",
+		"*bold* `code`": "\\*bold\\* \\`code\\`",
+		"[link](url)":   "\\[link](url)",
+		"plain text":    "plain text",
+	}
+
+	for text, expect := range tests {
+		if actual := EscapeMarkdown(text); actual != expect {
+			t.Errorf("EscapeMarkdown(%q) = %q, expected %q", text, actual, expect)
+		}
+	}
+}
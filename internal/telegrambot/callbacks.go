@@ -0,0 +1,206 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// The data of inline keyboard buttons is signed so it can't be forged by a modified
+// Telegram client, and expires so old buttons stop working. Version 1 of the data is
+//
+//	"1" <mac> <expiry> ":" <command> [" " <args>]
+//
+// where expiry is the Unix time (in base 36) after which the button stops working, or
+// "0" if it never expires, and mac is the first callbackMACSize bytes of the HMAC-SHA256
+// of everything but the mac (base64url encoded), using a key derived from the master key.
+const (
+	// callbackVersion is the version of the callback data created by this build
+	callbackVersion = "1"
+	// callbackMACSize is the size (in bytes) of the truncated HMAC of the callback data
+	callbackMACSize = 6
+	// callbackMaxSize is the most bytes Telegram accepts as the data of a button
+	callbackMaxSize = 64
+	// callbackTTL is how long buttons keep working unless they are given their own expiry
+	callbackTTL = 24 * time.Hour
+	// callbackKeyPurpose is the purpose of the key derived from the master key to sign the data of buttons
+	callbackKeyPurpose = "telegram-callback-data"
+	// callbackAnswerTimeout is how long a callback query waits for its handler before it is
+	// answered. Telegram refuses answers sent more than about 15 seconds after the button was pressed.
+	callbackAnswerTimeout = 10 * time.Second
+)
+
+var (
+	// errCallbackTooLong is returned by encodeCallback when the data doesn't fit in a button
+	errCallbackTooLong = errors.New("callback data too long")
+	// errCallbackInvalid is returned by decodeCallback for data which isn't signed by the Bot
+	errCallbackInvalid = errors.New("invalid callback data")
+	// errCallbackExpired is returned by decodeCallback for data of an expired button
+	errCallbackExpired = errors.New("callback data expired")
+)
+
+// callbackMACLength is the length of the encoded mac in the callback data
+var callbackMACLength = base64.RawURLEncoding.EncodedLen(callbackMACSize)
+
+// Callback is the command run when an inline keyboard button is pressed. A zero
+// Expires never expires.
+type Callback struct {
+	Command string
+	Args    string
+	Expires time.Time
+}
+
+// callbackMAC returns the encoded mac of the signed part of the callback data
+func callbackMAC(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackMACSize])
+}
+
+// encodeCallback returns the signed data of a button running the callback
+func encodeCallback(key []byte, cb Callback) (string, error) {
+	expiry := "0"
+	if !cb.Expires.IsZero() {
+		expiry = strconv.FormatInt(cb.Expires.Unix(), 36)
+	}
+	payload := cb.Command
+	if cb.Args != "" {
+		payload += " " + cb.Args
+	}
+
+	data := callbackVersion + callbackMAC(key, callbackVersion+expiry+":"+payload) + expiry + ":" + payload
+	if len(data) > callbackMaxSize {
+		return "", errCallbackTooLong
+	}
+	return data, nil
+}
+
+// decodeCallback checks the signature and expiry of the data of a button and returns its callback
+func decodeCallback(key []byte, data string, now time.Time) (*Callback, error) {
+	if !strings.HasPrefix(data, callbackVersion) || len(data) < len(callbackVersion)+callbackMACLength {
+		return nil, errCallbackInvalid
+	}
+	mac, rest := data[len(callbackVersion):len(callbackVersion)+callbackMACLength], data[len(callbackVersion)+callbackMACLength:]
+	expected := callbackMAC(key, callbackVersion+rest)
+	if !hmac.Equal([]byte(mac), []byte(expected)) {
+		return nil, errCallbackInvalid
+	}
+
+	i := strings.Index(rest, ":")
+	if i == -1 {
+		return nil, errCallbackInvalid
+	}
+	expiry, err := strconv.ParseInt(rest[:i], 36, 64)
+	if err != nil {
+		return nil, errCallbackInvalid
+	}
+
+	cb := &Callback{Command: rest[i+1:]}
+	if j := strings.Index(cb.Command, " "); j != -1 {
+		cb.Command, cb.Args = cb.Command[:j], cb.Command[j+1:]
+	}
+	if expiry != 0 {
+		cb.Expires = time.Unix(expiry, 0)
+		if !now.Before(cb.Expires) {
+			return cb, errCallbackExpired
+		}
+	}
+	return cb, nil
+}
+
+// callbackButton returns an inline keyboard button running data (a command, optionally
+// followed by its arguments) for the provided time
+func (bot *Bot) callbackButton(label, data string, ttl time.Duration) (tgbotapi.InlineKeyboardButton, error) {
+	cb := Callback{Command: data, Expires: time.Now().Add(ttl)}
+	if i := strings.Index(data, " "); i != -1 {
+		cb.Command, cb.Args = data[:i], data[i+1:]
+	}
+	signed, err := encodeCallback(bot.callbackKey, cb)
+	if err != nil {
+		return tgbotapi.InlineKeyboardButton{}, err
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(label, signed), nil
+}
+
+// signKeyboard returns a copy of the keyboard with the data of every button which isn't
+// signed yet (i.e. built using CreateMarkup) signed, expiring after callbackTTL
+func (bot *Bot) signKeyboard(kb tgbotapi.InlineKeyboardMarkup) (tgbotapi.InlineKeyboardMarkup, error) {
+	signed := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: make([][]tgbotapi.InlineKeyboardButton, len(kb.InlineKeyboard))}
+	for i, row := range kb.InlineKeyboard {
+		signed.InlineKeyboard[i] = make([]tgbotapi.InlineKeyboardButton, len(row))
+		for j, btn := range row {
+			if btn.CallbackData != nil {
+				if _, err := decodeCallback(bot.callbackKey, *btn.CallbackData, time.Now()); err != nil {
+					if btn, err = bot.callbackButton(btn.Text, *btn.CallbackData, callbackTTL); err != nil {
+						return kb, err
+					}
+				}
+			}
+			signed.InlineKeyboard[i][j] = btn
+		}
+	}
+	return signed, nil
+}
+
+// callbackAnswer is the answer to a callback query, which is only sent once
+type callbackAnswer struct {
+	mutex    sync.Mutex
+	text     string
+	alert    bool
+	answered bool
+}
+
+// SetCallbackAnswer sets the text shown to the user who pressed the button of a callback
+// query, as a toast or (if alert is set) an alert, once it has been handled. The text
+// isn't shown if the query was already answered (see callbackAnswerTimeout).
+func (ctx *BotContext) SetCallbackAnswer(text string, alert bool) {
+	if ctx.callbackAnswer == nil {
+		return
+	}
+	a := ctx.callbackAnswer
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.text, a.alert = text, alert
+}
+
+// takeCallbackAnswer returns the answer to the callback query of the context, unless it
+// has already been taken
+func (ctx *BotContext) takeCallbackAnswer() (tgbotapi.CallbackConfig, bool) {
+	if !ctx.IsCallBackQuery() || ctx.callbackAnswer == nil {
+		return tgbotapi.CallbackConfig{}, false
+	}
+	a := ctx.callbackAnswer
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.answered {
+		return tgbotapi.CallbackConfig{}, false
+	}
+	a.answered = true
+	return tgbotapi.CallbackConfig{CallbackQueryID: ctx.cbQuery.ID, Text: a.text, ShowAlert: a.alert}, true
+}
+
+// answerCallbackQuery answers the callback query of the context (once), which stops the
+// Telegram client of the user showing progress on the button
+func (bot *Bot) answerCallbackQuery(ctx *BotContext) {
+	answer, ok := ctx.takeCallbackAnswer()
+	if !ok {
+		return
+	}
+	_, err := bot.telegram.AnswerCallbackQuery(answer)
+	if err != nil {
+		log.Errorf("Bot.answerCallbackQuery: Error answering callback query %s: %v", ctx.cbQuery.ID, err)
+	}
+}
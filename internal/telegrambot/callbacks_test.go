@@ -0,0 +1,125 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+var testCallbackKey = bytes.Repeat([]byte{0x24}, 32)
+
+func Test_encodeDecodeCallback(t *testing.T) {
+	now := time.Unix(1537000000, 0)
+	tests := []Callback{
+		{Command: "balance"},
+		{Command: "users", Args: "2", Expires: now.Add(time.Hour)},
+		{Command: "node", Args: "03a1b2 refresh", Expires: now.Add(time.Minute)},
+	}
+	for _, cb := range tests {
+		data, err := encodeCallback(testCallbackKey, cb)
+		if err != nil {
+			t.Fatalf("encodeCallback(%+v): %v", cb, err)
+		}
+		if len(data) > callbackMaxSize || !strings.HasPrefix(data, callbackVersion) {
+			t.Errorf("Unexpected data %q", data)
+		}
+		got, err := decodeCallback(testCallbackKey, data, now)
+		if err != nil || *got != cb {
+			t.Errorf("decodeCallback(%q) expected %+v, got %+v, %v", data, cb, got, err)
+		}
+	}
+}
+
+func Test_decodeCallback_Invalid(t *testing.T) {
+	now := time.Unix(1537000000, 0)
+	data, err := encodeCallback(testCallbackKey, Callback{Command: "history", Args: "2", Expires: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"balance",
+		"history 2",
+		strings.Replace(data, "history 2", "history 3", 1),
+		strings.Replace(data, callbackVersion, "2", 1),
+		data[:len(data)-1],
+	} {
+		if _, err := decodeCallback(testCallbackKey, bad, now); err != errCallbackInvalid {
+			t.Errorf("decodeCallback(%q) expected errCallbackInvalid, got %v", bad, err)
+		}
+	}
+	if _, err := decodeCallback(bytes.Repeat([]byte{0x25}, 32), data, now); err != errCallbackInvalid {
+		t.Errorf("Expected errCallbackInvalid for another key, got %v", err)
+	}
+	if _, err := decodeCallback(testCallbackKey, data, now.Add(time.Hour)); err != errCallbackExpired {
+		t.Errorf("Expected errCallbackExpired, got %v", err)
+	}
+
+	// Telegram limits the data of a button to 64 bytes
+	if _, err := encodeCallback(testCallbackKey, Callback{Command: "node", Args: strings.Repeat("a", 66)}); err != errCallbackTooLong {
+		t.Errorf("Expected errCallbackTooLong, got %v", err)
+	}
+}
+
+func Test_signKeyboard(t *testing.T) {
+	bot := &Bot{callbackKey: testCallbackKey}
+	kb := CreateMultiLineMarkup("help", "|", "balance")
+	signedBtn, err := bot.callbackButton("confirm", "confirmwithdraw 7", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb.InlineKeyboard[1] = append(kb.InlineKeyboard[1], signedBtn)
+
+	signed, err := bot.signKeyboard(kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *kb.InlineKeyboard[0][0].CallbackData != "help" {
+		t.Errorf("Expected the keyboard not to change, got %q", *kb.InlineKeyboard[0][0].CallbackData)
+	}
+	expected := []Callback{{Command: "help"}, {Command: "balance"}, {Command: "confirmwithdraw", Args: "7"}}
+	var i int
+	for _, row := range signed.InlineKeyboard {
+		for _, btn := range row {
+			cb, err := decodeCallback(testCallbackKey, *btn.CallbackData, time.Now())
+			if err != nil || cb.Command != expected[i].Command || cb.Args != expected[i].Args {
+				t.Errorf("%d: Expected %+v, got %+v, %v", i, expected[i], cb, err)
+			}
+			i++
+		}
+	}
+	// Buttons which were already signed keep their expiry
+	if *signed.InlineKeyboard[1][1].CallbackData != *signedBtn.CallbackData {
+		t.Errorf("Expected the signed button not to change")
+	}
+}
+
+func Test_takeCallbackAnswer(t *testing.T) {
+	ctx := &BotContext{cbQuery: &tgbotapi.CallbackQuery{ID: "q1"}, callbackAnswer: &callbackAnswer{}}
+	ctx.SetCallbackAnswer("Done", true)
+
+	answer, ok := ctx.takeCallbackAnswer()
+	if !ok || answer.CallbackQueryID != "q1" || answer.Text != "Done" || !answer.ShowAlert {
+		t.Errorf("Unexpected answer: %+v, %v", answer, ok)
+	}
+	// A callback query is only answered once, i.e. by the deadline or after the handler
+	ctx.SetCallbackAnswer("Too late", false)
+	if _, ok := ctx.takeCallbackAnswer(); ok {
+		t.Error("Expected the callback query to be answered once")
+	}
+
+	// Messages aren't answered
+	msg := &BotContext{message: &tgbotapi.Message{}}
+	msg.SetCallbackAnswer("Ignored", false)
+	if _, ok := msg.takeCallbackAnswer(); ok {
+		t.Error("Expected no answer for a message")
+	}
+}
@@ -6,46 +6,19 @@
 package telegrambot
 
 import (
-	"bytes"
 	"context"
-	"database/sql"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"os/exec"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
-
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
 	"github.com/BigOokie/skywire-wing-commander/internal/utils"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
 	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
-
-func skycoinCliInput(x string) string {
-	path := "/home/josh/go/bin/skycoin-cli"
-	input := x //enter argument here to run
-	cmd := exec.Command(path, input)
-
-	var out bytes.Buffer
-	multi := io.MultiWriter(os.Stdout, &out)
-	cmd.Stdout = multi
-
-	if err := cmd.Run(); err != nil {
-		log.Fatalln(err)
-	}
-
-	//fmt.Printf(out.String())
-	return out.String()
-
-}
-
 func logSendError(from string, err error) {
 	log.Errorf("%s - Error: %v", from, err)
 }
@@ -53,11 +26,9 @@ func logSendError(from string, err error) {
 func getSendModeforContext(ctx *BotContext) string {
 	var mode string
 
-	if ctx.IsCallBackQuery() {
-		// we cannot "whisper" otherwise this will instruct the
-		// bot to talk to itself which is prohibuted. We must "yell"
-		mode = "yell"
-	} else if ctx.IsUserMessage() {
+	// Callback queries are answered in the private chat of the user who
+	// pressed the button (see Send), the same as regular messages
+	if ctx.IsCallBackQuery() || ctx.IsUserMessage() {
 		mode = "whisper"
 	}
 
@@ -68,7 +39,11 @@ func getSendModeforContext(ctx *BotContext) string {
 func (bot *Bot) handleCommandHelp(ctx *BotContext, command, args string) error {
 	log.Debugf("Handle command: %s args: %s", command, args)
 	bot.SendGAEvent("BotCommand", command, "Handle"+command)
-	err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", fmt.Sprintf(wcconst.MsgHelp, bot.config.Telegram.Admin))
+	msg := wcconst.MsgHelp
+	if ctx.User != nil && ctx.User.Role.AtLeast(store.RoleModerator) {
+		msg += "\n\n" + wcconst.MsgHelpAdmin
+	}
+	err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg)
 	if err != nil {
 		logSendError("Bot.handleCommandHelp", err)
 	}
@@ -134,260 +109,225 @@ func (bot *Bot) handleCommandGetUptimeLink(ctx *BotContext, command, args string
 	return err
 }
 
-// Cryptovinnie Handler for balamce command
+// Cryptovinnie Handler for balance command
 func (bot *Bot) handleCommandGetBalanceLink(ctx *BotContext, command, args string) error {
 	log.Debugf("Handle command: %s args: %s", command, args)
 
-	walletaddress := "7cpQ7t3PZZXvjTst8G7Uvs7XH4LeM8fBPD" //Change this to get wallet address from @username
-	websiteURL := "https://explorer.skycoin.net/api/balance?addrs=" + walletaddress
-	log.Debugf("Bot.websiteURL: %s", websiteURL)
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandGetBalanceLink", err)
+		}
+		return err
+	}
+
+	if ctx.User == nil {
+		return reply(wcconst.MsgSendSkyNoWallet)
+	}
 
-	res, _ := http.Get(websiteURL)
-	temp, _ := ioutil.ReadAll(res.Body) //JSON Body
-	c := bot.config.Coins
-	err1 := json.Unmarshal(temp, &c)
-	if err1 != nil {
-		panic(err1)
+	storectx := ctx.Context()
+	u, err := bot.lookupUser(storectx, ctx.User)
+	if err == store.ErrNotFound || (err == nil && u.Address == "") {
+		bot.SendGAEvent("BotCommand", command+"-nowallet", "Handle"+command)
+		return reply(wcconst.MsgSendSkyNoWallet)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandGetBalanceLink: Error getting wallet for %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
 	}
 
-	var addressBalance = fmt.Sprintf("%s%d%s%d", "Balance:", c.Confirmed.Coins, "\nCoinHours:", c.Confirmed.Hours) //Convert to string %s, %d for int
-	log.Debugf("Bot.getJSONBalance and coin hrs: %s", c.Confirmed.Coins, c.Confirmed.Hours)
-	bot.SendGAEvent("BotCommand", command+"-isrunning", "Handle"+command)        //send is running command
-	err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", addressBalance) //send message here
+	// The ledger balance is what the user can tip and withdraw. The on-chain balance
+	// of their address only changes with deposits, as tips never move coins on-chain.
+	ledgerBalance, err := bot.store.Ledger().Balance(storectx, store.UserAccount(u.ID))
 	if err != nil {
-		logSendError("Bot.handleCommandStart", err)
+		log.Errorf("Bot.handleCommandGetBalanceLink: Error getting ledger balance: %v", err)
+		return reply(wcconst.MsgErrorStore)
 	}
-	return err
+
+	balances, err := bot.wallet.GetBalance(storectx, u.Address)
+	if err != nil {
+		log.Errorf("Bot.handleCommandGetBalanceLink: Error getting balance: %v", err)
+		bot.SendGAEvent("BotCommand", command+"-walleterror", "Handle"+command)
+		return reply(wcconst.MsgErrorWallet)
+	}
+
+	addressBalance := fmt.Sprintf(wcconst.MsgBalance, wallet.FormatDroplets(uint64(ledgerBalance)),
+		wallet.FormatDroplets(balances.Confirmed.Coins), balances.Confirmed.Hours, u.Address)
+	log.Debugf("Bot.handleCommandGetBalanceLink: %s", addressBalance)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+	return reply(addressBalance)
 }
 
 // Cryptovinnie Handler for Create Address
 func (bot *Bot) handleCommandCreateAddressLink(ctx *BotContext, command, args string) error {
 	log.Debugf("Handle command: %s args: %s", command, args)
-	 
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		wcconst.host, wcconst.port, wcconst.user, wcconst.password, wcconst.dbname) //Connect to psql database.
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
 
-	err = db.Ping()
-	if err != nil {
-		panic(err)
-	}
-
-	success := "Successfully Connected to DB"
-	log.Debugf("Handle command: %s ", success)
-
-	sqlStatement := `SELECT id, public_wallet FROM users WHERE telegram_username=$1;`
-	var public_wallet string
-	var telegram_username string
-	var UserName = ctx.message.Chat.UserName
-	var chatid = ctx.message.Chat.ID
-
-	row := db.QueryRow(sqlStatement, UserName)
-	switch err := row.Scan(&telegram_username, &public_wallet); err {
-	case sql.ErrNoRows:
-		fmt.Println("No rows were returned!")       //User was not found in DB so create address
-		Input := skycoinCliInput("addressGenerate") //String to enter after skycoin-cli
-		AddrCreated := Input                        //Save created Address to AddrCreated
-		//Then Save created wallet to SQL DB
-		sqlStatement := `
-										INSERT INTO users (chatid, telegram_username, public_wallet, public_address, private_key)
-										VALUES ($1, $2, $3, $4, $5)
-										RETURNING id`
-		id := 0
-		err = db.QueryRow(sqlStatement, chatid, UserName, AddrCreated, AddrCreated, AddrCreated).Scan(&id) //Save variables to SQL table
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
 		if err != nil {
-			panic(err)
-		}
-		
-		//User address did not exist created address and added to SQL table. 
-		fmt.Println("New record ID is:", id)
-		log.Debugf("Bot.NewRecordIDis: %s", id)
-		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", AddrCreated) //send message here
-		log.Debugf("Bot.AddressCreatedis: %s", AddrCreated)
-		if err != nil {
-			logSendError("Bot.handleCommandStart", err)
+			logSendError("Bot.handleCommandCreateAddressLink", err)
 		}
 		return err
+	}
 
+	if ctx.User == nil || ctx.User.UserName == "" {
+		return reply(wcconst.MsgNoUserName)
 	}
-	//Address alread created and exists.
-	err1 := bot.Send(ctx, getSendModeforContext(ctx), "markdown", public_wallet) //send message here with public wallet address. 
-	log.Debugf("Bot.AddressCreatedis: %s", public_wallet)
-	if err1 != nil {
-		logSendError("Bot.handleCommandStart", err)
+
+	u, created, err := bot.getOrCreateUserWallet(ctx.Context(), ctx.User)
+	if err != nil {
+		log.Errorf("Bot.handleCommandCreateAddressLink: Error getting wallet for @%s: %v", ctx.User.UserName, err)
+		bot.SendGAEvent("BotCommand", command+"-error", "Handle"+command)
+		return reply(wcconst.MsgErrorStore)
 	}
-	return err1
 
+	if created {
+		bot.SendGAEvent("BotCommand", command+"-created", "Handle"+command)
+	} else {
+		bot.SendGAEvent("BotCommand", command, "Handle"+command)
+	}
+	log.Debugf("Bot.handleCommandCreateAddressLink: Address for %s: %s", u.UserName, u.Address)
+	return reply(fmt.Sprintf(wcconst.MsgAddress, u.Address))
+}
+
+// parseSendSkyArgs parses the arguments of the sendsky command: <amount> @user [memo].
+// The amount unit may also be provided as a separate argument, i.e. "10 sky @user".
+func parseSendSkyArgs(args string) (amount uint64, recipient, memo string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return 0, "", "", fmt.Errorf("expected an amount and a recipient")
+	}
+
+	amountstr, rest := fields[0], fields[1:]
+	switch strings.ToLower(rest[0]) {
+	case "sky", "drops", "droplets":
+		amountstr += rest[0]
+		rest = rest[1:]
+	}
+
+	if amount, err = wallet.ParseAmount(amountstr); err != nil {
+		return 0, "", "", err
+	}
+
+	if len(rest) == 0 || !strings.HasPrefix(rest[0], "@") || len(rest[0]) < 2 {
+		return 0, "", "", fmt.Errorf("expected a recipient of the form @user")
+	}
+
+	return amount, rest[0], strings.Join(rest[1:], " "), nil
 }
 
 // Cryptovinnie Handler for send skycommand
+// Sends SKY from the ledger balance of the requesting user to another Telegram user.
+// Tips settle instantly on the ledger, no on-chain transaction is made. A wallet is
+// created for the recipient if they don't have one yet.
 func (bot *Bot) handleCommandSendSky(ctx *BotContext, command, args string) error {
 	log.Debugf("Handle command: %s args: %s", command, args)
-	//1. Check user balance from telegram username 
-	//2. Check user @recipient has address in Database 
-	//	2a. If not create address for @recipient
-	//3. Compare amount to send / Avalilable balance. 
-	//4. Create raw transaction 
-	//5. Send tx details to User. 
-
-	//1. Check user balance from telegram username use CreateAddress()?
-	sqlStatement := `SELECT id, public_wallet FROM users WHERE telegram_username=$1;`
-	var public_wallet string
-	var telegram_username string
-	
-	var UserName = ctx.message.Chat.UserName
-	var chatid = ctx.message.Chat.ID
-	var RecipientUserName := "@Synth" //Get recipient @recipient out of message. 
-	var AmounttoSend := 1 //enter in arg from message here.
-
-	row := db.QueryRow(sqlStatement, UserName)
-	switch err := row.Scan(&telegram_username, &public_wallet); err {
-	case sql.ErrNoRows:
-		fmt.Println("No rows were returned!")       //User was not found in DB so create address
-		Input := skycoinCliInput("addressGenerate") //String to enter after skycoin-cli
-		AddrCreated := Input                        //Save created Address to AddrCreated
-		//Then Save created wallet to SQL DB
-		sqlStatement := `
-										INSERT INTO users (chatid, telegram_username, public_wallet, public_address, private_key)
-										VALUES ($1, $2, $3, $4, $5)
-										RETURNING id`
-		id := 0
-		err = db.QueryRow(sqlStatement, chatid, UserName, AddrCreated, AddrCreated, AddrCreated).Scan(&id) //Save variables to SQL table
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
 		if err != nil {
-			panic(err)
-		}
-		//User address did not exist created address and added to SQL table. 
-		fmt.Println("New record ID is:", id)
-		log.Debugf("Bot.NewRecordIDis: %s", id)
-		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", AddrCreated) //send message here
-		log.Debugf("Bot.AddressCreatedis: %s", AddrCreated)
-		if err != nil {
-			logSendError("Bot.handleCommandStart", err)
+			logSendError("Bot.handleCommandSendSky", err)
 		}
 		return err
-		var sendersPublicWallet := AddrCreated 
 	}
-	//Address alread created and exists.
-	var sendersPublicWallet := public_wallet 
-	err1 := bot.Send(ctx, getSendModeforContext(ctx), "markdown", public_wallet) //send message here
-	log.Debugf("Bot.AddressCreatedis: %s", public_wallet)
-	if err1 != nil {
-		logSendError("Bot.handleCommandStart", err)
+
+	amount, recipientName, memo, err := parseSendSkyArgs(args)
+	if err != nil {
+		log.Debugf("Bot.handleCommandSendSky: Invalid arguments %q: %v", args, err)
+		bot.SendGAEvent("BotCommand", command+"-usage", "Handle"+command)
+		return reply(wcconst.MsgSendSkyUsage)
 	}
-	return err1
 
-	//2. Check user @recipient has address in Database
-	row := db.QueryRow(sqlStatement, RecipientUserName)
-	switch err := row.Scan(&telegram_username, &public_wallet); err {
-		fmt.Println("No rows were returned!")       //User was not found in DB so create address
-		Input := skycoinCliInput("addressGenerate") //String to enter after skycoin-cli
-		RecipientAddrCreated := Input                        //Save created Address to AddrCreated
-		id := 0
-		err = db.QueryRow(sqlStatement, chatid, RecipientUserName, RecipientAddrCreated, RecipientAddrCreated, RecipientAddrCreated).Scan(&id) //Save variables to SQL table
-		if err != nil {
-			panic(err)
-		}
-		//User address did not exist created address and added to SQL table. 
-		fmt.Println("New record ID is:", id)
-		log.Debugf("Bot.NewRecordIDis: %s", id)
-		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", RecipientAddrCreated) //send message here Recipient did not have address. 
-		log.Debugf("Bot.RecipientAddressCreatedis: %s", RecipientAddrCreated)
-		if err != nil {
-			logSendError("Bot.handleCommandStart", err)
-		}
-		return err
-		var recipientPublicWallet := RecipientAddrCreated
+	if ctx.User == nil || ctx.User.UserName == "" {
+		return reply(wcconst.MsgNoUserName)
 	}
-	//@Recipient has valid address. 
-	err1 := bot.Send(ctx, getSendModeforContext(ctx), "markdown", public_wallet) //send message here of address 
-	log.Debugf("Bot.RecipientAddressis: %s", public_wallet)
-	if err1 != nil {
-		logSendError("Bot.handleCommandStart", err)
+	if strings.EqualFold(store.NormalizeUserName(ctx.User.UserName), store.NormalizeUserName(recipientName)) {
+		return reply(wcconst.MsgSendSkyToSelf)
+	}
+
+	storectx := ctx.Context()
+
+	// 1. Resolve the sender
+	sender, err := bot.lookupUser(storectx, ctx.User)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgSendSkyNoWallet)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandSendSky: Error getting wallet for @%s: %v", ctx.User.UserName, err)
+		return reply(wcconst.MsgErrorStore)
 	}
-	return err1
-	var recipientPublicWallet := public_wallet
-	
-	//3. Compare amount to send / Avalilable balance. 
-	walletaddress := sendersPublicWallet
-	websiteURL := "https://explorer.skycoin.net/api/balance?addrs=" + walletaddress
-	log.Debugf("Bot.websiteURL: %s", websiteURL)
-	res, _ := http.Get(websiteURL)
-	temp, _ := ioutil.ReadAll(res.Body) //JSON Body
-	c := bot.config.Coins
-	err1 := json.Unmarshal(temp, &c)
-	if err1 != nil {
-		panic(err1)
-	}
-	var ConfirmedSkyBalance := c.Confirmed.Coins
-	var ConfirmedBalanceHrs := c.Confirmed.Hours
-	var addressBalance = fmt.Sprintf("%s%d%s%d", "Balance:", ConfirmedSkyBalance, "\nCoinHours:", ConfirmedBalanceHrs) //Convert to string %s, %d for int
-	log.Debugf("Bot.SenderspublicWalletBalance: %s", ConfirmedSkyBalance)
-	log.Debugf("Bot.SenderspublicWalletBalanceHours: %s", ConfirmedBalanceHrs)
-	//bot.SendGAEvent("BotCommand", command+"-isrunning", "Handle"+command)        //send is running command
-	err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", addressBalance) //send message here
+
+	// 2. Resolve the recipient, creating their wallet if needed
+	recipient, err := bot.getOrCreateRecipientWallet(storectx, recipientName)
 	if err != nil {
-		logSendError("Bot.handleCommandStart", err)
+		log.Errorf("Bot.handleCommandSendSky: Error getting wallet for %s: %v", recipientName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	// 3. Move the SKY on the ledger and let the recipient know (if we can reach them)
+	err = bot.tip(storectx, sender, recipient, amount, memo)
+	if err == store.ErrInsufficientFunds {
+		bot.SendGAEvent("BotCommand", command+"-insufficient", "Handle"+command)
+		balance, _ := bot.store.Ledger().Balance(storectx, store.UserAccount(sender.ID))
+		return reply(fmt.Sprintf(wcconst.MsgSendSkyInsufficient, wallet.FormatDroplets(amount), wallet.FormatDroplets(uint64(balance))))
+	} else if msg, ok := bot.limitMessage(storectx, sender, err); ok {
+		bot.SendGAEvent("BotCommand", command+"-limit", "Handle"+command)
+		return reply(msg)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandSendSky: Error recording tip: %v", err)
+		return reply(wcconst.MsgErrorStore)
 	}
-	return err
-	
-	//4. If amount wanting to send is greater then Available balance send error. 
-		if AmounttoSend > ConfirmedSkyBalance {
-			balance := fmt.Sprintf("%s%d%s%d", "Unable to Send: " , AmounttoSend, "Spendable Amount is: ", ConfirmedSkyBalance) //Convert to string %s, %d for int
-			log.Debugf("Bot.Amounttosend: %s", balance)
-		}
-		else {
-		// Amount to spend is less then ConfirmedSkycoinBalance. 
-		// 5. Create transaction here and send 
-		InputString := fmt.Sprintf("%s%d%s%s%s%s%s%s", "Send -amount " , Amounttosend, "-sendto ", recipientPublicWallet, "-fromaddress ",sendersPublicWallet,"-changeaddress ",sendersPublicWallet)
-		log.Debugf("Bot.InputString: %s", InputString)
-		Input := skycoinCliInput(InputString) //String to enter after skycoin-cli "Sendto"
-		SendTransaction := Input                        											//Save transaction Json data. 
-		
-		ConfirmationTx := fmt.Sprintf("%s%d", "Transaction: " , SendTransaction) //Convert to string %s, %d for int
-		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", ConfirmationTx) //send message here Recipient did not have address. 
-		log.Debugf("Bot.ConfirmationTX: %s", ConfirmationTx)
-		if err != nil {
-			logSendError("Bot.handleCommandStart", err)
-		}
-		return err
-		}
-		//6. If recipient has address and ChatID that isnt 000 also send them a message 
-		
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
 
+	// 4. Send the receipt to the sender
+	senderBalance, _ := bot.store.Ledger().Balance(storectx, store.UserAccount(sender.ID))
+	return reply(fmt.Sprintf(wcconst.MsgSendSkyReceipt, wallet.FormatDroplets(amount), EscapeMarkdown(recipient.UserName),
+		wallet.FormatDroplets(uint64(senderBalance))) + memoMarkdown(memo))
 }
 
-
+// memoMarkdown returns the memo line of tip messages, if there is a memo
+func memoMarkdown(memo string) string {
+	if memo == "" {
+		return ""
+	}
+	return fmt.Sprintf(wcconst.MsgSendSkyMemo, EscapeMarkdown(memo))
 }
 
-
-	walletaddress := "7cpQ7t3PZZXvjTst8G7Uvs7XH4LeM8fBPD" //Change this to get wallet address from @username
-	websiteURL := "https://explorer.skycoin.net/api/balance?addrs=" + walletaddress
-	log.Debugf("Bot.websiteURL: %s", websiteURL)
-
-	res, _ := http.Get(websiteURL)
-	temp, _ := ioutil.ReadAll(res.Body) //JSON Body
-	c := bot.config.Coins
-	err1 := json.Unmarshal(temp, &c)
-	if err1 != nil {
-		panic(err1)
+// tip moves amount from the ledger balance of the sender to the recipient. The balance is
+// checked in the same database transaction, ErrInsufficientFunds is returned if the sender
+// can't cover the tip. The limits of the sender are checked in the same transaction, see
+// limitMessage for the errors returned. The recipient is sent a direct message if they have
+// talked to the Bot.
+func (bot *Bot) tip(ctx context.Context, sender, recipient *store.User, amount uint64, memo string) error {
+	l, err := bot.userLimits(ctx, sender.TelegramID)
+	if err != nil {
+		return err
+	}
+	if err := l.checkTip(amount); err != nil {
+		return err
 	}
 
-	var addressBalance = fmt.Sprintf("%s%d%s%d", "Balance:", c.Confirmed.Coins, "\nCoinHours:", c.Confirmed.Hours) //Convert to string %s, %d for int
-	log.Debugf("Bot.getJSONBalance and coin hrs: %s", c.Confirmed.Coins, c.Confirmed.Hours)
-	bot.SendGAEvent("BotCommand", command+"-isrunning", "Handle"+command)        //send is running command
-	err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", addressBalance) //send message here
+	err = bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:   store.TransferTip,
+		From:   store.UserAccount(sender.ID),
+		To:     store.UserAccount(recipient.ID),
+		Amount: amount,
+		Memo:   memo,
+		Limits: &store.TransferLimits{DailyCap: l.DailyCap, TipsPerMinute: l.TipsPerMinute},
+	})
 	if err != nil {
-		logSendError("Bot.handleCommandStart", err)
+		return err
 	}
-	return err
-}
-
+	log.Infof("Bot.tip: %s sent %s SKY to %s", userDisplayName(sender), wallet.FormatDroplets(amount), userDisplayName(recipient))
 
+	if recipient.ChatID != 0 {
+		recipientBalance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(recipient.ID))
+		msg := fmt.Sprintf(wcconst.MsgSendSkyReceived, EscapeMarkdown(userDisplayName(sender)), wallet.FormatDroplets(amount),
+			wallet.FormatDroplets(uint64(recipientBalance))) + memoMarkdown(memo)
+		if dmerr := bot.SendToChat(recipient.ChatID, "markdown", msg); dmerr != nil {
+			logSendError("Bot.tip", dmerr)
+		}
+	}
+	return nil
+}
 
 // Handler for start command
 func (bot *Bot) handleCommandStart(ctx *BotContext, command, args string) error {
@@ -405,7 +345,7 @@ func (bot *Bot) handleCommandStart(ctx *BotContext, command, args string) error
 	bot.SendGAEvent("BotCommand", command+"-notrunning", "Handle"+command)
 
 	log.Debug(wcconst.MsgMonitorStart)
-	cancelContext, cancelFunc := context.WithCancel(context.Background())
+	cancelContext, cancelFunc := context.WithCancel(bot.monitorContext)
 	monitorStatusMsgChan := make(chan string)
 
 	// Start the Event Monitor - provide cancelContext
@@ -509,47 +449,6 @@ func (bot *Bot) handleCommandShowMenu(ctx *BotContext, command, args string) err
 	return err
 }
 
-/*
-// Handler for nodes command
-func (bot *Bot) handleCommandListNodes(ctx *BotContext, command, args string) error {
-	log.Debugf("Handle command: %s args: %s", command, args)
-
-	if bot.skyMgrMonitor.GetConnectedNodeCount() == 0 {
-		log.Debug("Bot.handleCommandListNodes: No connected Nodes.")
-		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", "No connected Nodes.")
-		if err != nil {
-			logSendError("Bot.handleCommandListNodes", err)
-		}
-		return err
-	}
-
-	var replyKeyboard tgbotapi.InlineKeyboardMarkup
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	var btnrow []tgbotapi.InlineKeyboardButton
-	var btn tgbotapi.InlineKeyboardButton
-
-	// Iterate the connectedNodes and build a keyboard with one button
-	// containing the Node Key per row
-	for _, v := range bot.skyMgrMonitor.GetNodeKeyList() {
-		log.Debugf("Bot.handleCommandListNodes: Creating button for Node: %s", v)
-		btn = tgbotapi.NewInlineKeyboardButtonData(v, v)
-		btnrow = tgbotapi.NewInlineKeyboardRow(btn)
-		keyboard = append(keyboard, btnrow)
-	}
-
-	replyKeyboard = tgbotapi.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
-	}
-
-	err := bot.SendReplyInlineKeyboard(ctx, replyKeyboard, "Nodes")
-	if err != nil {
-		log.Error(err)
-	}
-
-	return err
-}
-*/
-
 // Handler for help DoUpdate
 func (bot *Bot) handleCommandDoUpdate(ctx *BotContext, command, args string) error {
 	log.Debugf("Handle command: %s args: %s", command, args)
@@ -587,7 +486,7 @@ func (bot *Bot) handleCommandDoUpdate(ctx *BotContext, command, args string) err
 
 func (bot *Bot) handleDirectMessageFallback(ctx *BotContext, text string) (bool, error) {
 	errmsg := fmt.Sprintf("Sorry, I only take commands. '%s' is not a command.\n\n%s", text, wcconst.MsgHelpShort)
-	log.Debugf(errmsg)
+	log.Debug(errmsg)
 	bot.SendGAEvent("BotCommandError", text, "HandleMessageFallback")
 	return true, bot.Reply(ctx, "markdown", errmsg)
 }
@@ -614,7 +513,7 @@ func (bot *Bot) monitorEventLoop(runctx context.Context, botctx *BotContext, sta
 			bot.SendGAEvent("BotMonitoring", "ReceiveMonitorStatusMessage", "Receive Monitor Status Message")
 			if msg != "" {
 				log.Debugf("Bot.monitorEventLoop: Status event: %s", msg)
-				err := bot.Send(botctx, getSendModeforContext(botctx), "markdown", msg)
+				err := bot.PostStatus(botctx, getSendModeforContext(botctx), "markdown", msg)
 				if err != nil {
 					logSendError("Bot.monitorEventLoop", err)
 				}
@@ -628,9 +527,9 @@ func (bot *Bot) monitorEventLoop(runctx context.Context, botctx *BotContext, sta
 			msg := bot.skyMgrMonitor.BuildConnectionStatusMsg(wcconst.MsgHeartbeat)
 			log.Debug(msg)
 			if msg != "" {
-				err := bot.Send(botctx, getSendModeforContext(botctx), "markdown", msg)
+				err := bot.PostStatus(botctx, getSendModeforContext(botctx), "markdown", msg)
 				if err != nil {
-					logSendError("Bot.handleCommandStatus", err)
+					logSendError("Bot.monitorEventLoop", err)
 				}
 			}
 
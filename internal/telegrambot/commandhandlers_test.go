@@ -0,0 +1,42 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"testing"
+)
+
+func Test_parseSendSkyArgs(t *testing.T) {
+	tests := []struct {
+		args      string
+		amount    uint64
+		recipient string
+		memo      string
+	}{
+		{"10 @Vinn1e", 10000000, "@Vinn1e", ""},
+		{"0.5 @Vinn1e thanks for the help", 500000, "@Vinn1e", "thanks for the help"},
+		{"1000drops @Vinn1e", 1000, "@Vinn1e", ""},
+		{"2 SKY @Vinn1e coffee", 2000000, "@Vinn1e", "coffee"},
+	}
+
+	for _, test := range tests {
+		amount, recipient, memo, err := parseSendSkyArgs(test.args)
+		if err != nil {
+			t.Errorf("parseSendSkyArgs(%q) returned error: %v", test.args, err)
+			continue
+		}
+		if amount != test.amount || recipient != test.recipient || memo != test.memo {
+			t.Errorf("parseSendSkyArgs(%q) = %d, %q, %q", test.args, amount, recipient, memo)
+		}
+	}
+}
+
+func Test_parseSendSkyArgs_Invalid(t *testing.T) {
+	for _, args := range []string{"", "10", "@Vinn1e 10", "10 Vinn1e", "10 @", "abc @Vinn1e", "0.0001 @Vinn1e", "10 sky"} {
+		if _, _, _, err := parseSendSkyArgs(args); err == nil {
+			t.Errorf("parseSendSkyArgs(%q) expected an error", args)
+		}
+	}
+}
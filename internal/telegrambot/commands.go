@@ -5,11 +5,13 @@
 
 package telegrambot
 
+import "github.com/BigOokie/skywire-wing-commander/internal/store"
+
 // Command struct is used to define a Telegram Bot command, including
-// if its an Admin only command, the string command (i.e. `/start`) and
-// the function that will handle the command
+// the least privileged role permitted to use it, the string command (i.e. `/start`)
+// and the function that will handle the command
 type Command struct {
-	Admin       bool
+	Role        store.Role
 	Command     string
 	Handlerfunc CommandHandler
 }
@@ -19,88 +21,220 @@ type Commands []Command
 
 func (bot *Bot) setCommandHandlers() {
 	for _, command := range commands {
-		if command.Admin {
-			bot.adminCommandHandlers[command.Command] = command.Handlerfunc
-		} else {
-			bot.commandHandlers[command.Command] = command.Handlerfunc
-		}
+		bot.commandHandlers[command.Command] = command
+	}
+
+	for _, command := range groupCommands {
+		bot.groupCommandHandlers[command.Command] = command
 	}
 
 	bot.AddPrivateMessageHandler((*Bot).handleDirectMessageFallback)
-	bot.AddGroupMessageHandler((*Bot).handleDirectMessageFallback)
+	bot.AddPrivateMessageHandler((*Bot).handleConversationMessage)
+	bot.AddGroupMessageHandler((*Bot).handleGroupMessageFallback)
 }
 
 var commands = Commands{
 	Command{
-		false,
+		store.RoleUser,
 		"help",
 		(*Bot).handleCommandHelp,
 	},
 	Command{
-		false,
+		store.RoleUser,
 		"about",
 		(*Bot).handleCommandAbout,
 	},
 	Command{
-		false,
+		store.RoleAdmin,
 		"start",
 		(*Bot).handleCommandStart,
 	},
 	Command{
-		false,
+		store.RoleAdmin,
 		"stop",
 		(*Bot).handleCommandStop,
 	},
 	Command{
-		false,
+		store.RoleModerator,
 		"status",
 		(*Bot).handleCommandStatus,
 	},
 	Command{
-		false,
+		store.RoleAdmin,
 		"showconfig",
 		(*Bot).handleCommandShowConfig,
 	},
 	Command{
-		false,
+		store.RoleAdmin,
 		"checkupdate",
 		(*Bot).handleCommandCheckUpdate,
 	},
 	Command{
-		false,
+		store.RoleAdmin,
 		"update",
 		(*Bot).handleCommandDoUpdate,
 	},
 	Command{
-		false,
+		store.RoleModerator,
 		"uptime",
 		(*Bot).handleCommandGetUptimeLink,
 	},
 	Command{
-		false,
+		store.RoleModerator,
+		"nodes",
+		(*Bot).handleCommandNodes,
+	},
+	Command{
+		store.RoleModerator,
+		"node",
+		(*Bot).handleCommandNode,
+	},
+	Command{
+		store.RoleAdmin,
+		"mute",
+		(*Bot).handleCommandMute,
+	},
+	Command{
+		store.RoleAdmin,
+		"unmute",
+		(*Bot).handleCommandMute,
+	},
+	Command{
+		store.RoleModerator,
+		"users",
+		(*Bot).handleCommandUsers,
+	},
+	Command{
+		store.RoleModerator,
+		"whois",
+		(*Bot).handleCommandWhois,
+	},
+	Command{
+		store.RoleModerator,
+		"ban",
+		(*Bot).handleCommandBan,
+	},
+	Command{
+		store.RoleModerator,
+		"unban",
+		(*Bot).handleCommandUnban,
+	},
+	Command{
+		store.RoleAdmin,
+		"promote",
+		(*Bot).handleCommandPromote,
+	},
+	Command{
+		store.RoleAdmin,
+		"demote",
+		(*Bot).handleCommandDemote,
+	},
+	Command{
+		store.RoleAdmin,
+		"setlimit",
+		(*Bot).handleCommandSetLimit,
+	},
+	Command{
+		store.RoleAdmin,
+		"reset2fa",
+		(*Bot).handleCommandResetTwoFactor,
+	},
+	Command{
+		store.RoleUser,
 		"balance",
 		(*Bot).handleCommandGetBalanceLink,
 	},
 	Command{
-		false,
+		store.RoleUser,
 		"createaddress",
 		(*Bot).handleCommandCreateAddressLink,
 	},
 	Command{
-		false,
+		store.RoleUser,
 		"sendsky",
 		(*Bot).handleCommandSendSky,
 	},
-	/*
-		Command{
-			false,
-			"listnodes",
-			(*Bot).handleCommandListNodes,
-		},
-	*/
 	Command{
-		false,
+		store.RoleUser,
+		"withdraw",
+		(*Bot).handleCommandWithdraw,
+	},
+	Command{
+		store.RoleUser,
+		"confirmwithdraw",
+		(*Bot).handleCommandConfirmWithdraw,
+	},
+	Command{
+		store.RoleUser,
+		"cancelwithdraw",
+		(*Bot).handleCommandCancelWithdraw,
+	},
+	Command{
+		store.RoleUser,
+		"limits",
+		(*Bot).handleCommandLimits,
+	},
+	Command{
+		store.RoleUser,
+		"history",
+		(*Bot).handleCommandHistory,
+	},
+	Command{
+		store.RoleUser,
+		"export",
+		(*Bot).handleCommandExport,
+	},
+	Command{
+		store.RoleUser,
+		"2fa",
+		(*Bot).handleCommandTwoFactor,
+	},
+	Command{
+		store.RoleUser,
+		"cancel",
+		(*Bot).handleCommandCancel,
+	},
+	Command{
+		store.RoleUser,
 		"menu",
 		(*Bot).handleCommandShowMenu,
 	},
 }
+
+// welcomeCommand replaces /start for the users who can't start monitoring. Telegram sends
+// /start when a user first opens the chat with the Bot.
+var welcomeCommand = Command{
+	store.RoleUser,
+	"start",
+	(*Bot).handleCommandHelp,
+}
+
+// addUserCommand adds the sender of a message forwarded by an admin as a user (see
+// isAddUserForward). It is never sent as a command.
+var addUserCommand = Command{
+	store.RoleAdmin,
+	"adduser",
+	(*Bot).handleForwardedMessageFrom,
+}
+
+// groupCommands are the commands accepted in the group chats where tipping is enabled
+var groupCommands = Commands{
+	Command{
+		store.RoleUser,
+		"sendsky",
+		(*Bot).handleGroupCommandSendSky,
+	},
+	Command{
+		store.RoleUser,
+		"tip",
+		(*Bot).handleGroupCommandTip,
+	},
+}
+
+// conversationSteps are the steps of the multi-step commands, by name (see Expect).
+// The names are stored with the conversations, so steps must not be renamed.
+var conversationSteps = map[string]ConversationStep{
+	"withdraw-amount":  (*Bot).stepWithdrawAmount,
+	"withdraw-address": (*Bot).stepWithdrawAddress,
+	"2fa-code":         (*Bot).stepTwoFactorCode,
+}
@@ -0,0 +1,203 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+// conversationTimeout is how long a conversation waits for the reply of the user
+const conversationTimeout = 5 * time.Minute
+
+// ConversationStep provides an interface specification for the handlers of the steps of
+// multi-step commands. The handler is passed the conversation and the reply of the user.
+// The conversation has ended when the handler runs, it calls Expect to wait for another reply.
+type ConversationStep func(*Bot, *BotContext, *store.Conversation, string) error
+
+// Expect makes the next private message (other than a command) of the user the reply to
+// step of the conversation started by command. data is stored with the conversation so
+// the later steps can use the earlier replies. The conversation is stored, so it carries
+// on after a restart, and ends if the user doesn't reply within timeout or uses /cancel.
+// Any earlier conversation of the user is replaced.
+func (bot *Bot) Expect(ctx *BotContext, command, step string, data map[string]string, timeout time.Duration) error {
+	if data == nil {
+		data = make(map[string]string)
+	}
+	return bot.store.Conversations().Save(ctx.Context(), &store.Conversation{
+		TelegramID: ctx.User.ID,
+		Command:    command,
+		Step:       step,
+		Data:       data,
+		ExpiresAt:  time.Now().Add(timeout),
+	})
+}
+
+// takeConversation ends the conversation of the user and returns it, along with whether
+// it had expired. store.ErrNotFound is returned if the user isn't in a conversation.
+func (bot *Bot) takeConversation(ctx context.Context, telegramID int, now time.Time) (*store.Conversation, bool, error) {
+	repo := bot.store.Conversations()
+	c, err := repo.Get(ctx, telegramID)
+	if err != nil {
+		return nil, false, err
+	}
+	// If two replies arrive at once only one of them gets the conversation
+	if err := repo.Delete(ctx, telegramID); err != nil {
+		return nil, false, err
+	}
+	return c, c.Expired(now), nil
+}
+
+// handleConversationMessage routes a private message to the step of the conversation
+// the user is in, instead of the fallback handler
+func (bot *Bot) handleConversationMessage(ctx *BotContext, text string) (bool, error) {
+	c, expired, err := bot.takeConversation(ctx.Context(), ctx.User.ID, time.Now())
+	if err == store.ErrNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get conversation: %v", err)
+	}
+
+	if expired {
+		log.Debugf("Bot.handleConversationMessage: /%s of %s expired at %v", c.Command, ctx.User.NameAndTags(), c.ExpiresAt)
+		err := bot.Send(ctx, "whisper", "markdown", fmt.Sprintf(wcconst.MsgConversationExpired, c.Command))
+		if err != nil {
+			logSendError("Bot.handleConversationMessage", err)
+		}
+		return false, err
+	}
+
+	step, found := conversationSteps[c.Step]
+	if !found {
+		// i.e. the step was removed by an upgrade
+		log.Warnf("Bot.handleConversationMessage: Unknown step %q of /%s", c.Step, c.Command)
+		return true, nil
+	}
+	log.Debugf("Bot.handleConversationMessage: Step %q of /%s for %s", c.Step, c.Command, ctx.User.NameAndTags())
+	return false, step(bot, ctx, c, strings.TrimSpace(text))
+}
+
+// cancelConversation ends the conversation of the user, and drops any command waiting
+// for their two-factor code. The message to send to the user is returned.
+func (bot *Bot) cancelConversation(ctx *BotContext) (string, error) {
+	var cancelled []string
+	if p := bot.twoFactor.takePending(ctx.User.ID, time.Now()); p != nil {
+		cancelled = append(cancelled, "/"+p.command)
+	}
+
+	c, expired, err := bot.takeConversation(ctx.Context(), ctx.User.ID, time.Now())
+	if err != nil && err != store.ErrNotFound {
+		return "", err
+	}
+	if err == nil && !expired {
+		cancelled = append(cancelled, "/"+c.Command)
+	}
+
+	if len(cancelled) == 0 {
+		return wcconst.MsgConversationNone, nil
+	}
+	return fmt.Sprintf(wcconst.MsgConversationCancelled, strings.Join(cancelled, " and ")), nil
+}
+
+// Handler for cancel command
+func (bot *Bot) handleCommandCancel(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg, err := bot.cancelConversation(ctx)
+	if err != nil {
+		log.Errorf("Bot.handleCommandCancel: Error cancelling the conversation of %s: %v", ctx.User.NameAndTags(), err)
+		msg = wcconst.MsgErrorStore
+	}
+	if err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg); err != nil {
+		logSendError("Bot.handleCommandCancel", err)
+		return err
+	}
+	return nil
+}
+
+// pruneConversations removes the conversations which expired while the Bot wasn't running
+func (bot *Bot) pruneConversations() {
+	n, err := bot.store.Conversations().DeleteExpired(context.Background(), time.Now())
+	if err != nil {
+		log.Errorf("Bot.pruneConversations: %v", err)
+	} else if n > 0 {
+		log.Infof("Bot.pruneConversations: Removed %d expired conversation(s)", n)
+	}
+}
+
+// askWithdrawAmount starts the conversation of /withdraw without arguments
+func (bot *Bot) askWithdrawAmount(ctx *BotContext, command string) error {
+	if err := bot.Expect(ctx, command, "withdraw-amount", nil, conversationTimeout); err != nil {
+		log.Errorf("Bot.askWithdrawAmount: Error starting conversation: %v", err)
+		return bot.Send(ctx, "whisper", "markdown", wcconst.MsgErrorStore)
+	}
+	return bot.Ask(ctx, wcconst.MsgWithdrawAskAmount)
+}
+
+// stepWithdrawAmount handles the amount of /withdraw and asks for the address
+func (bot *Bot) stepWithdrawAmount(ctx *BotContext, c *store.Conversation, text string) error {
+	amount := "your whole balance"
+	if !strings.EqualFold(text, "all") {
+		droplets, err := wallet.ParseAmount(text)
+		if err != nil {
+			if err := bot.Expect(ctx, c.Command, c.Step, c.Data, conversationTimeout); err != nil {
+				return err
+			}
+			return bot.Ask(ctx, fmt.Sprintf(wcconst.MsgWithdrawInvalidAmount, EscapeMarkdown(text)))
+		}
+		amount = wallet.FormatDroplets(droplets) + " SKY"
+	}
+
+	c.Data["amount"] = text
+	if err := bot.Expect(ctx, c.Command, "withdraw-address", c.Data, conversationTimeout); err != nil {
+		return err
+	}
+	return bot.Ask(ctx, fmt.Sprintf(wcconst.MsgWithdrawAskAddress, amount))
+}
+
+// stepWithdrawAddress handles the address of /withdraw and runs it with the collected arguments
+func (bot *Bot) stepWithdrawAddress(ctx *BotContext, c *store.Conversation, text string) error {
+	if err := wallet.ValidateAddress(text); err != nil {
+		if err := bot.Expect(ctx, c.Command, c.Step, c.Data, conversationTimeout); err != nil {
+			return err
+		}
+		return bot.Ask(ctx, fmt.Sprintf(wcconst.MsgWithdrawInvalidAddress, EscapeMarkdown(text))+"\n"+wcconst.MsgConversationRetry)
+	}
+
+	err := bot.handleCommand(ctx, c.Command, c.Data["amount"]+" "+text)
+	if err == errNotPermitted {
+		return bot.Send(ctx, "whisper", "markdown", fmt.Sprintf(wcconst.MsgNotPermitted, c.Command))
+	}
+	return err
+}
+
+// stepTwoFactorCode handles the first code entered after /2fa setup. The user is asked for
+// another code until two-factor authentication is on or they are locked out.
+func (bot *Bot) stepTwoFactorCode(ctx *BotContext, c *store.Conversation, text string) error {
+	if fields := strings.Fields(text); len(fields) != 1 {
+		if err := bot.Expect(ctx, c.Command, c.Step, c.Data, conversationTimeout); err != nil {
+			return err
+		}
+		return bot.Ask(ctx, wcconst.MsgTwoFactorAskCode)
+	}
+
+	if err := bot.handleCommand(ctx, c.Command, text); err != nil {
+		return err
+	}
+	tf, err := bot.store.TwoFactor().Get(ctx.Context(), ctx.User.ID)
+	if err != nil || tf.Enabled || time.Now().Before(tf.LockedUntil) {
+		return nil
+	}
+	return bot.Expect(ctx, c.Command, c.Step, c.Data, conversationTimeout)
+}
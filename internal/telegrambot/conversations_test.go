@@ -0,0 +1,109 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+)
+
+func Test_handleConversationMessage(t *testing.T) {
+	bot := newTestAdminBot(t)
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+
+	var replies []string
+	conversationSteps["test-step"] = func(bot *Bot, ctx *BotContext, c *store.Conversation, text string) error {
+		replies = append(replies, c.Data["first"]+","+text)
+		c.Data["first"] = text
+		if len(replies) == 1 {
+			return bot.Expect(ctx, c.Command, c.Step, c.Data, time.Minute)
+		}
+		return nil
+	}
+	defer delete(conversationSteps, "test-step")
+
+	// Messages are passed on to the fallback when the user isn't in a conversation
+	if next, err := bot.handleConversationMessage(alice, "hello"); err != nil || !next {
+		t.Errorf("Expected the next handler, got %v, %v", next, err)
+	}
+
+	if err := bot.Expect(alice, "test", "test-step", map[string]string{"first": "start"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{" one ", "two"} {
+		if next, err := bot.handleConversationMessage(alice, text); err != nil || next {
+			t.Errorf("Expected the step to handle %q, got %v, %v", text, next, err)
+		}
+	}
+	if len(replies) != 2 || replies[0] != "start,one" || replies[1] != "one,two" {
+		t.Errorf("Unexpected replies %q", replies)
+	}
+	// The second step didn't wait for another reply
+	if next, err := bot.handleConversationMessage(alice, "three"); err != nil || !next {
+		t.Errorf("Expected the conversation to have ended, got %v, %v", next, err)
+	}
+
+	// Steps removed by an upgrade are ignored
+	if err := bot.Expect(alice, "test", "removed-step", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := bot.handleConversationMessage(alice, "four"); err != nil || !next {
+		t.Errorf("Expected the next handler, got %v, %v", next, err)
+	}
+	if len(replies) != 2 {
+		t.Errorf("Unexpected replies %q", replies)
+	}
+}
+
+func Test_takeConversation(t *testing.T) {
+	bot := newTestAdminBot(t)
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+	ctx := context.Background()
+
+	if err := bot.Expect(alice, "withdraw", "withdraw-amount", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	c, expired, err := bot.takeConversation(ctx, 1002, time.Now().Add(2*time.Minute))
+	if err != nil || !expired || c.Command != "withdraw" || c.Step != "withdraw-amount" {
+		t.Errorf("Expected an expired conversation, got %+v, %v, %v", c, expired, err)
+	}
+	if _, _, err := bot.takeConversation(ctx, 1002, time.Now()); err != store.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func Test_cancelConversation(t *testing.T) {
+	bot := newTestAdminBot(t)
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+
+	if msg, err := bot.cancelConversation(alice); err != nil || msg != wcconst.MsgConversationNone {
+		t.Errorf("Expected MsgConversationNone, got %q, %v", msg, err)
+	}
+
+	if err := bot.Expect(alice, "withdraw", "withdraw-address", map[string]string{"amount": "1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	bot.twoFactor.setPending(1002, &pendingTwoFactor{ctx: alice, command: "confirmwithdraw", expires: time.Now().Add(time.Minute)})
+	msg, err := bot.cancelConversation(alice)
+	if err != nil || msg != fmt.Sprintf(wcconst.MsgConversationCancelled, "/confirmwithdraw and /withdraw") {
+		t.Errorf("Expected MsgConversationCancelled, got %q, %v", msg, err)
+	}
+	if _, err := bot.store.Conversations().Get(context.Background(), 1002); err != store.ErrNotFound {
+		t.Errorf("Expected the conversation to have ended, got %v", err)
+	}
+
+	// Expired conversations can't be cancelled
+	if err := bot.Expect(alice, "withdraw", "withdraw-amount", nil, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := bot.cancelConversation(alice); err != nil || msg != wcconst.MsgConversationNone {
+		t.Errorf("Expected MsgConversationNone, got %q, %v", msg, err)
+	}
+}
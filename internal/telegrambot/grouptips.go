@@ -0,0 +1,214 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+// isTippingGroup reports whether tipping has been enabled for the group chat
+func (bot *Bot) isTippingGroup(chatID int64) bool {
+	for _, id := range bot.config.Telegram.GroupChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTipArgs parses the arguments of the tip command: <amount> [memo].
+// The amount unit may also be provided as a separate argument, i.e. "10 sky".
+func parseTipArgs(args string) (amount uint64, memo string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return 0, "", fmt.Errorf("expected an amount")
+	}
+
+	amountstr, rest := fields[0], fields[1:]
+	if len(rest) > 0 {
+		switch strings.ToLower(rest[0]) {
+		case "sky", "drops", "droplets":
+			amountstr += rest[0]
+			rest = rest[1:]
+		}
+	}
+
+	if amount, err = wallet.ParseAmount(amountstr); err != nil {
+		return 0, "", err
+	}
+	return amount, strings.Join(rest, " "), nil
+}
+
+// tgUserDisplayName returns the name used to mention a Telegram user in a group
+func tgUserDisplayName(u *User) string {
+	if u.UserName != "" {
+		return "@" + u.UserName
+	}
+	return u.FirstName
+}
+
+// isCommandForMe reports whether a group command is addressed to the Bot. Commands
+// addressed to another bot in the group (i.e. /tip@otherbot) are ignored.
+func (bot *Bot) isCommandForMe(ctx *BotContext) bool {
+	command := ctx.message.CommandWithAt()
+	i := strings.Index(command, "@")
+	if i == -1 {
+		return true
+	}
+	return bot.telegram != nil && strings.EqualFold(command[i+1:], bot.telegram.Self.UserName)
+}
+
+// getOrCreateTelegramRecipient returns the stored user for a Telegram user who hasn't necessarily
+// talked to the Bot (i.e. the author of a message replied to with /tip), creating a wallet for them
+// if they don't have one yet. Unlike lookupUser, the chat ID is left alone as the Bot can't send a
+// direct message to users until they start a private chat.
+func (bot *Bot) getOrCreateTelegramRecipient(ctx context.Context, tgUser *User) (*store.User, error) {
+	users := bot.store.Users()
+
+	u, err := users.GetByTelegramID(ctx, tgUser.ID)
+	if err != store.ErrNotFound {
+		return u, err
+	}
+
+	username := store.NormalizeUserName(tgUser.UserName)
+	if username != "" {
+		u, err = users.GetByUserName(ctx, username)
+		if err == nil && u.TelegramID == 0 {
+			// Claim the wallet created for their username by /sendsky
+			u.TelegramID = tgUser.ID
+			return u, users.Update(ctx, u)
+		} else if err == nil {
+			// The username now belongs to someone else, don't take it over
+			username = ""
+		} else if err != store.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	u = &store.User{TelegramID: tgUser.ID, UserName: username}
+	err = bot.newUserWallet(ctx, u)
+	if err == store.ErrDuplicate {
+		// Created concurrently
+		return users.GetByTelegramID(ctx, tgUser.ID)
+	}
+	return u, err
+}
+
+// groupTip sends a tip from the user sending the group message (ctx) to the recipient returned by
+// getRecipient and announces it in the group. The recipient is only resolved (and their wallet
+// created) once the sender is known to have a wallet. Balances are only ever sent by direct message.
+func (bot *Bot) groupTip(ctx *BotContext, command, recipientName string, getRecipient func(context.Context) (*store.User, error), amount uint64, memo string) error {
+	storectx := ctx.Context()
+
+	reply := func(text string) error {
+		err := bot.Reply(ctx, "markdown", text)
+		if err != nil {
+			logSendError("Bot.groupTip", err)
+		}
+		return err
+	}
+
+	sender, err := bot.store.Users().GetByTelegramID(storectx, ctx.User.ID)
+	if err == store.ErrNotFound {
+		return reply(fmt.Sprintf(wcconst.MsgGroupNoWallet, EscapeMarkdown(tgUserDisplayName(ctx.User))))
+	} else if err != nil {
+		log.Errorf("Bot.groupTip: Error getting wallet for %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	recipient, err := getRecipient(storectx)
+	if err != nil {
+		log.Errorf("Bot.groupTip: Error getting wallet for %s: %v", recipientName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	if sender.ID == recipient.ID {
+		return reply(wcconst.MsgSendSkyToSelf)
+	}
+
+	err = bot.tip(storectx, sender, recipient, amount, memo)
+	if err == store.ErrInsufficientFunds {
+		bot.SendGAEvent("BotCommand", command+"-group-insufficient", "HandleGroup"+command)
+		return reply(fmt.Sprintf(wcconst.MsgGroupInsufficient, EscapeMarkdown(tgUserDisplayName(ctx.User))))
+	} else if msg, ok := bot.limitMessage(storectx, sender, err); ok {
+		bot.SendGAEvent("BotCommand", command+"-group-limit", "HandleGroup"+command)
+		return reply(msg)
+	} else if err != nil {
+		log.Errorf("Bot.groupTip: Error recording tip: %v", err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	bot.SendGAEvent("BotCommand", command+"-group", "HandleGroup"+command)
+
+	if sender.ChatID != 0 {
+		senderBalance, _ := bot.store.Ledger().Balance(storectx, store.UserAccount(sender.ID))
+		msg := fmt.Sprintf(wcconst.MsgSendSkyReceipt, wallet.FormatDroplets(amount), EscapeMarkdown(recipientName),
+			wallet.FormatDroplets(uint64(senderBalance))) + memoMarkdown(memo)
+		if dmerr := bot.SendToChat(sender.ChatID, "markdown", msg); dmerr != nil {
+			logSendError("Bot.groupTip", dmerr)
+		}
+	}
+
+	return reply(fmt.Sprintf(wcconst.MsgGroupTip, EscapeMarkdown(tgUserDisplayName(ctx.User)), EscapeMarkdown(recipientName),
+		wallet.FormatDroplets(amount)) + memoMarkdown(memo))
+}
+
+// Handler for the sendsky command in a tipping group: /sendsky <amount> @user [memo]
+func (bot *Bot) handleGroupCommandSendSky(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle group command: %s args: %s", command, args)
+
+	amount, recipientName, memo, err := parseSendSkyArgs(args)
+	if err != nil {
+		log.Debugf("Bot.handleGroupCommandSendSky: Invalid arguments %q: %v", args, err)
+		return bot.Reply(ctx, "markdown", wcconst.MsgGroupSendSkyUsage)
+	}
+	if bot.telegram != nil && strings.EqualFold(store.NormalizeUserName(recipientName), "@"+bot.telegram.Self.UserName) {
+		return bot.Reply(ctx, "markdown", wcconst.MsgGroupTipBot)
+	}
+
+	getRecipient := func(storectx context.Context) (*store.User, error) {
+		return bot.getOrCreateRecipientWallet(storectx, recipientName)
+	}
+	return bot.groupTip(ctx, command, store.NormalizeUserName(recipientName), getRecipient, amount, memo)
+}
+
+// Handler for the tip command in a tipping group. The tip is sent to the author
+// of the message being replied to: /tip <amount> [memo]
+func (bot *Bot) handleGroupCommandTip(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle group command: %s args: %s", command, args)
+
+	re := ctx.message.ReplyToMessage
+	amount, memo, err := parseTipArgs(args)
+	if err != nil || re == nil || re.From == nil {
+		log.Debugf("Bot.handleGroupCommandTip: Invalid arguments %q: %v", args, err)
+		return bot.Reply(ctx, "markdown", wcconst.MsgGroupTipUsage)
+	}
+	if re.From.IsBot {
+		return bot.Reply(ctx, "markdown", wcconst.MsgGroupTipBot)
+	}
+
+	tgRecipient := &User{
+		ID:        re.From.ID,
+		UserName:  re.From.UserName,
+		FirstName: re.From.FirstName,
+		LastName:  re.From.LastName,
+	}
+	getRecipient := func(storectx context.Context) (*store.User, error) {
+		return bot.getOrCreateTelegramRecipient(storectx, tgRecipient)
+	}
+	return bot.groupTip(ctx, command, tgUserDisplayName(tgRecipient), getRecipient, amount, memo)
+}
+
+func (bot *Bot) handleGroupMessageFallback(ctx *BotContext, text string) (bool, error) {
+	log.Debugf("Bot.handleGroupMessageFallback: %s", text)
+	bot.SendGAEvent("BotCommandError", "group", "HandleGroupMessageFallback")
+	return true, bot.Reply(ctx, "markdown", wcconst.MsgGroupHelp)
+}
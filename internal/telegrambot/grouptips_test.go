@@ -0,0 +1,162 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+)
+
+func Test_parseTipArgs(t *testing.T) {
+	tests := []struct {
+		args   string
+		amount uint64
+		memo   string
+	}{
+		{"10", 10000000, ""},
+		{"1.5 thanks for the help", 1500000, "thanks for the help"},
+		{"10 sky", 10000000, ""},
+		{"5000 drops coffee", 5000, "coffee"},
+	}
+
+	for _, tc := range tests {
+		amount, memo, err := parseTipArgs(tc.args)
+		if err != nil {
+			t.Errorf("parseTipArgs(%q) error: %v", tc.args, err)
+			continue
+		}
+		if amount != tc.amount || memo != tc.memo {
+			t.Errorf("parseTipArgs(%q) = %d, %q, expected %d, %q", tc.args, amount, memo, tc.amount, tc.memo)
+		}
+	}
+
+	for _, args := range []string{"", "abc", "@bob 10", "0.0001"} {
+		if _, _, err := parseTipArgs(args); err == nil {
+			t.Errorf("parseTipArgs(%q) expected an error", args)
+		}
+	}
+}
+
+func Test_isTippingGroup(t *testing.T) {
+	bot := &Bot{config: wcconfig.Config{Telegram: wcconfig.TelegramParameters{GroupChatIDs: []int64{-1001, -1002}}}}
+	if !bot.isTippingGroup(-1002) {
+		t.Error("Expected -1002 to be a tipping group")
+	}
+	if bot.isTippingGroup(-1003) {
+		t.Error("Expected -1003 not to be a tipping group")
+	}
+
+	bot = &Bot{}
+	if bot.isTippingGroup(-1001) {
+		t.Error("Expected no tipping groups when none are configured")
+	}
+}
+
+func Test_getOrCreateTelegramRecipient(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice := users[0]
+
+	// A wallet created for a username is claimed by its Telegram user
+	u, err := bot.getOrCreateTelegramRecipient(ctx, &User{ID: 1001, UserName: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != alice.ID || u.TelegramID != 1001 || u.ChatID != 0 {
+		t.Errorf("Expected alice's wallet to be claimed, got %+v", u)
+	}
+
+	// A username which belongs to someone else is not taken over
+	u, err = bot.getOrCreateTelegramRecipient(ctx, &User{ID: 1002, UserName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID == alice.ID || u.TelegramID != 1002 || u.UserName != "" || u.Address == "" {
+		t.Errorf("Expected a new wallet without a username, got %+v", u)
+	}
+
+	// Users without a username get a wallet, which is found again by Telegram ID
+	u, err = bot.getOrCreateTelegramRecipient(ctx, &User{ID: 1003, FirstName: "Carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := bot.getOrCreateTelegramRecipient(ctx, &User{ID: 1003, FirstName: "Carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != u.ID || again.Address != u.Address {
+		t.Errorf("Expected the same wallet, got %+v and %+v", u, again)
+	}
+}
+
+func Test_lookupUser_MergesPlaceholder(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+
+	if _, err := bot.lookupUser(ctx, &User{ID: 1001, UserName: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:   store.TransferDeposit,
+		From:   store.AccountOnChain,
+		To:     store.UserAccount(bob.ID),
+		Amount: 1000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice takes over the username of bob, who never talked to the Bot
+	u, err := bot.lookupUser(ctx, &User{ID: 1001, UserName: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != alice.ID || u.UserName != "@bob" {
+		t.Errorf("Unexpected user: %+v", u)
+	}
+	claimed, err := bot.store.Users().GetByUserName(ctx, "bob")
+	if err != nil || claimed.ID != alice.ID {
+		t.Errorf("Expected the username to belong to alice, got %+v (%v)", claimed, err)
+	}
+	balance, err := bot.store.Ledger().Balance(ctx, store.UserAccount(alice.ID))
+	if err != nil || balance != 1000000 {
+		t.Errorf("Expected alice's balance to be 1000000, got %d (%v)", balance, err)
+	}
+}
+
+func Test_tip(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+
+	err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:   store.TransferDeposit,
+		From:   store.AccountOnChain,
+		To:     store.UserAccount(alice.ID),
+		Amount: 2000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bot.tip(ctx, alice, bob, 1500000, "thanks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.tip(ctx, alice, bob, 1000000, ""); err != store.ErrInsufficientFunds {
+		t.Errorf("Expected ErrInsufficientFunds, got %v", err)
+	}
+
+	balance, err := bot.store.Ledger().Balance(ctx, store.UserAccount(bob.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 1500000 {
+		t.Errorf("Expected bob's balance to be 1500000, got %d", balance)
+	}
+}
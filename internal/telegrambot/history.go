@@ -0,0 +1,312 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// historyPageSize is the number of movements listed on each page of /history
+	historyPageSize = 10
+	// historyExportBatch is the number of movements read from the ledger at a time by /export
+	historyExportBatch = 100
+)
+
+// Types of the movements shown by /history and /export
+const (
+	historyTipSent     = "tip sent"
+	historyTipReceived = "tip received"
+	historyDeposit     = "deposit"
+	historyWithdrawal  = "withdrawal"
+	historyRefund      = "refund"
+)
+
+// historyEntry describes a movement of the balance of a user. Amount is in droplets and
+// is negative for movements out of the balance. TxID and Status are set for on-chain
+// movements, Counterparty is the other user of a tip or the address of a withdrawal.
+type historyEntry struct {
+	Time         time.Time
+	Type         string
+	Amount       int64
+	Counterparty string
+	Memo         string
+	TxID         string
+	Status       string
+}
+
+// historyFormatAmount formats the amount of an entry in SKY with its sign
+func historyFormatAmount(amount int64) string {
+	if amount < 0 {
+		return "-" + wallet.FormatDroplets(uint64(-amount))
+	}
+	return "+" + wallet.FormatDroplets(uint64(amount))
+}
+
+// historyReferenceID returns the transaction ID of a withdrawal ledger reference
+func historyReferenceID(reference, prefix string) (int64, bool) {
+	if !strings.HasPrefix(reference, prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(reference, prefix), 10, 64)
+	return id, err == nil
+}
+
+// historyEntries describes the ledger movements of a user. The names of the users tipped
+// are cached in names (by user ID) as they are usually repeated.
+func (bot *Bot) historyEntries(ctx context.Context, movements []store.Movement, names map[int64]string) ([]historyEntry, error) {
+	entries := make([]historyEntry, 0, len(movements))
+	for _, m := range movements {
+		e := historyEntry{Time: m.CreatedAt, Amount: m.Amount}
+
+		switch m.Kind {
+		case store.TransferTip:
+			e.Type, e.Memo = historyTipReceived, m.Memo
+			if m.Amount < 0 {
+				e.Type = historyTipSent
+			}
+			id := m.Counterparty.UserID()
+			name, ok := names[id]
+			if !ok {
+				u, err := bot.store.Users().Get(ctx, id)
+				if err != nil && err != store.ErrNotFound {
+					return nil, err
+				}
+				name = string(m.Counterparty)
+				if err == nil {
+					name = userDisplayName(u)
+				}
+				names[id] = name
+			}
+			e.Counterparty = name
+
+		case store.TransferDeposit:
+			// The txid of a deposit is stored as its memo
+			e.Type, e.TxID, e.Status = historyDeposit, m.Memo, store.TxStatusConfirmed
+
+		case store.TransferWithdrawal:
+			e.Type = historyWithdrawal
+			id, ok := historyReferenceID(m.Reference, "withdrawal:")
+			if !ok {
+				e.Type = historyRefund
+				id, ok = historyReferenceID(m.Reference, "withdrawal-refund:")
+			}
+			if !ok {
+				e.Memo = m.Memo
+				break
+			}
+			t, err := bot.store.Transactions().Get(ctx, id)
+			if err == store.ErrNotFound {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			e.Counterparty, e.TxID, e.Status = t.ToAddress, t.TxID, t.Status
+
+		default:
+			e.Type, e.Memo = m.Kind, m.Memo
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// historyPage returns the text and inline keyboard of a page (from 1) of the history of the user
+func (bot *Bot) historyPage(ctx context.Context, u *store.User, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	// Ask for one extra movement to find out if there is a next page
+	movements, err := bot.store.Ledger().Movements(ctx, store.UserAccount(u.ID), (page-1)*historyPageSize, historyPageSize+1)
+	if err != nil {
+		return "", nil, err
+	}
+	hasNext := len(movements) > historyPageSize
+	if hasNext {
+		movements = movements[:historyPageSize]
+	}
+	entries, err := bot.historyEntries(ctx, movements, make(map[int64]string))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, wcconst.MsgHistoryHeader, page)
+	if len(entries) == 0 {
+		b.WriteString(wcconst.MsgHistoryNone)
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&b, wcconst.MsgHistoryLine, formatTime(e.Time), e.Type, historyFormatAmount(e.Amount))
+		if e.Counterparty != "" {
+			fmt.Fprintf(&b, wcconst.MsgHistoryCounterparty, EscapeMarkdown(e.Counterparty))
+		}
+		if e.Memo != "" {
+			fmt.Fprintf(&b, wcconst.MsgHistoryMemo, EscapeMarkdown(e.Memo))
+		}
+		if e.TxID != "" {
+			fmt.Fprintf(&b, wcconst.MsgHistoryTxID, e.TxID)
+		}
+		if e.Status != "" && e.Status != store.TxStatusConfirmed {
+			fmt.Fprintf(&b, wcconst.MsgHistoryStatus, e.Status)
+		}
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("« prev", fmt.Sprintf("history %d", page-1)))
+	}
+	if hasNext {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("next »", fmt.Sprintf("history %d", page+1)))
+	}
+	if len(row) == 0 {
+		return b.String(), nil, nil
+	}
+	kb := tgbotapi.NewInlineKeyboardMarkup(row)
+	return b.String(), &kb, nil
+}
+
+// historyCSV returns the full history of the user as CSV, newest first
+func (bot *Bot) historyCSV(ctx context.Context, u *store.User) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "type", "amount", "counterparty", "memo", "txid", "status"})
+
+	names := make(map[int64]string)
+	for offset := 0; ; offset += historyExportBatch {
+		movements, err := bot.store.Ledger().Movements(ctx, store.UserAccount(u.ID), offset, historyExportBatch)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := bot.historyEntries(ctx, movements, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			w.Write([]string{e.Time.UTC().Format(time.RFC3339), e.Type, historyFormatAmount(e.Amount),
+				e.Counterparty, e.Memo, e.TxID, e.Status})
+		}
+		if len(movements) < historyExportBatch {
+			break
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// historyUser returns the stored user with a wallet for /history and /export. If the user
+// doesn't have a wallet, the message to send to them is returned instead.
+func (bot *Bot) historyUser(ctx context.Context, tgUser *User) (*store.User, string, error) {
+	if tgUser == nil {
+		return nil, wcconst.MsgSendSkyNoWallet, nil
+	}
+	u, err := bot.lookupUser(ctx, tgUser)
+	if err == store.ErrNotFound || (err == nil && u.Address == "") {
+		return nil, wcconst.MsgSendSkyNoWallet, nil
+	}
+	return u, "", err
+}
+
+// Handler for history command
+// Shows a page of the tips, deposits and withdrawals of the user, with buttons to page through them.
+func (bot *Bot) handleCommandHistory(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandHistory", err)
+		}
+		return err
+	}
+
+	page := 1
+	if args != "" {
+		var err error
+		if page, err = strconv.Atoi(strings.TrimSpace(args)); err != nil || page < 1 {
+			return reply(wcconst.MsgHistoryUsage)
+		}
+	}
+
+	storectx := ctx.Context()
+	u, msg, err := bot.historyUser(storectx, ctx.User)
+	if err != nil {
+		log.Errorf("Bot.handleCommandHistory: Error getting wallet for %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
+	} else if msg != "" {
+		return reply(msg)
+	}
+
+	text, kb, err := bot.historyPage(storectx, u, page)
+	if err != nil {
+		log.Errorf("Bot.handleCommandHistory: Error getting history of %s: %v", userDisplayName(u), err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	switch {
+	case ctx.IsCallBackQuery():
+		err = bot.EditMessage(ctx, kb, text)
+	case kb != nil:
+		err = bot.SendReplyInlineKeyboard(ctx, *kb, text)
+	default:
+		err = bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+	}
+	if err != nil {
+		logSendError("Bot.handleCommandHistory", err)
+	}
+	return err
+}
+
+// Handler for export command
+// Sends the full history of the user as a CSV document.
+func (bot *Bot) handleCommandExport(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandExport", err)
+		}
+		return err
+	}
+
+	storectx := ctx.Context()
+	u, msg, err := bot.historyUser(storectx, ctx.User)
+	if err != nil {
+		log.Errorf("Bot.handleCommandExport: Error getting wallet for %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
+	} else if msg != "" {
+		return reply(msg)
+	}
+
+	data, err := bot.historyCSV(storectx, u)
+	if err != nil {
+		log.Errorf("Bot.handleCommandExport: Error exporting history of %s: %v", userDisplayName(u), err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	name := fmt.Sprintf("history-%s.csv", time.Now().UTC().Format("20060102"))
+	if err := bot.SendDocument(ctx, name, data, wcconst.MsgExportCaption); err != nil {
+		logSendError("Bot.handleCommandExport", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+)
+
+// newTestHistoryBot creates a Bot where @alice deposited 10 SKY, tipped @bob 2 SKY,
+// was tipped back 0.5 SKY and withdrew 3 SKY twice, the second time failing and being refunded
+func newTestHistoryBot(t *testing.T) (*Bot, *store.User) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+	ledger := bot.store.Ledger()
+
+	transfers := []*store.Transfer{
+		{Kind: store.TransferDeposit, From: store.AccountOnChain, To: store.UserAccount(alice.ID), Amount: 10000000, Memo: "deposittxid", Reference: "output1"},
+		{Kind: store.TransferTip, From: store.UserAccount(alice.ID), To: store.UserAccount(bob.ID), Amount: 2000000, Memo: "for the *node*"},
+		{Kind: store.TransferTip, From: store.UserAccount(bob.ID), To: store.UserAccount(alice.ID), Amount: 500000},
+	}
+	for _, status := range []string{store.TxStatusConfirmed, store.TxStatusFailed} {
+		tx := &store.Transaction{
+			UserID:    alice.ID,
+			Kind:      store.TxKindWithdrawal,
+			Status:    status,
+			TxID:      "withdrawaltxid-" + status,
+			ToAddress: testWithdrawAddress,
+			Coins:     3000000,
+		}
+		if err := bot.store.Transactions().Create(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		transfers = append(transfers, &store.Transfer{Kind: store.TransferWithdrawal, From: store.UserAccount(alice.ID), To: store.AccountOnChain,
+			Amount: 3000000, Memo: testWithdrawAddress, Reference: withdrawalReference(tx.ID)})
+		if status == store.TxStatusFailed {
+			transfers = append(transfers, &store.Transfer{Kind: store.TransferWithdrawal, From: store.AccountOnChain, To: store.UserAccount(alice.ID),
+				Amount: 3000000, Memo: "refund", Reference: withdrawalRefundReference(tx.ID)})
+		}
+	}
+	for _, tr := range transfers {
+		if err := ledger.Transfer(ctx, tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bot, alice
+}
+
+func Test_historyPage(t *testing.T) {
+	bot, alice := newTestHistoryBot(t)
+
+	text, kb, err := bot.historyPage(context.Background(), alice, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb != nil {
+		t.Errorf("Expected no buttons, got %+v", kb)
+	}
+	for _, s := range []string{
+		fmt.Sprintf(wcconst.MsgHistoryHeader, 1),
+		"*refund* +3 SKY\n  " + testWithdrawAddress + "\n  `withdrawaltxid-failed` (failed)",
+		"*withdrawal* -3 SKY\n  " + testWithdrawAddress + "\n  `withdrawaltxid-confirmed`\n",
+		"*tip received* +0.5 SKY\n  @bob\n",
+		"*tip sent* -2 SKY\n  @bob\n  _for the \\*node\\*_",
+		"*deposit* +10 SKY\n  `deposittxid`",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("Expected %q in %q", s, text)
+		}
+	}
+	if i, j := strings.Index(text, "*deposit*"), strings.Index(text, "*tip sent*"); i < j {
+		t.Errorf("Expected the newest movements first: %q", text)
+	}
+
+	// Add enough tips for a second page
+	for i := 0; i < historyPageSize; i++ {
+		err := bot.store.Ledger().Transfer(context.Background(), &store.Transfer{
+			Kind:   store.TransferTip,
+			From:   store.UserAccount(alice.ID),
+			To:     store.UserAccount(alice.ID + 1),
+			Amount: 1000,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	text, kb, err = bot.historyPage(context.Background(), alice, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(text, "*tip sent*") != historyPageSize {
+		t.Errorf("Unexpected first page: %q", text)
+	}
+	if kb == nil || len(kb.InlineKeyboard[0]) != 1 || *kb.InlineKeyboard[0][0].CallbackData != "history 2" {
+		t.Errorf("Expected a next button only, got %+v", kb)
+	}
+
+	text, kb, err = bot.historyPage(context.Background(), alice, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "*deposit*") || kb == nil || *kb.InlineKeyboard[0][0].CallbackData != "history 1" {
+		t.Errorf("Unexpected second page: %q %+v", text, kb)
+	}
+
+	text, _, err = bot.historyPage(context.Background(), alice, 3)
+	if err != nil || !strings.Contains(text, wcconst.MsgHistoryNone) {
+		t.Errorf("Unexpected third page: %q, %v", text, err)
+	}
+}
+
+func Test_historyCSV(t *testing.T) {
+	bot, alice := newTestHistoryBot(t)
+
+	data, err := bot.historyCSV(context.Background(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 7 {
+		t.Fatalf("Expected a header and 6 movements, got %q", records)
+	}
+	if h := strings.Join(records[0], ","); h != "time,type,amount,counterparty,memo,txid,status" {
+		t.Errorf("Unexpected header %q", h)
+	}
+
+	expected := [][]string{
+		{historyRefund, "+3", testWithdrawAddress, "", "withdrawaltxid-failed", store.TxStatusFailed},
+		{historyWithdrawal, "-3", testWithdrawAddress, "", "withdrawaltxid-failed", store.TxStatusFailed},
+		{historyWithdrawal, "-3", testWithdrawAddress, "", "withdrawaltxid-confirmed", store.TxStatusConfirmed},
+		{historyTipReceived, "+0.5", "@bob", "", "", ""},
+		{historyTipSent, "-2", "@bob", "for the *node*", "", ""},
+		{historyDeposit, "+10", "", "", "deposittxid", store.TxStatusConfirmed},
+	}
+	for i, e := range expected {
+		r := records[i+1]
+		if r[0] == "" || strings.Join(r[1:], ",") != strings.Join(e, ",") {
+			t.Errorf("%d: Expected %q, got %q", i, e, r)
+		}
+	}
+
+	// Users without movements get just the header
+	data, err = bot.historyCSV(context.Background(), &store.User{ID: 99})
+	if err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("Unexpected export: %q, %v", data, err)
+	}
+}
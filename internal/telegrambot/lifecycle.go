@@ -0,0 +1,170 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/depositmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// signOffTimeout is how long the signing off message has to be sent, after the messages
+// queued before it
+const signOffTimeout = 5 * time.Second
+
+// Run runs the Bot until ctx is done (i.e. the process is signalled to terminate), and then
+// shuts it down (see shutdown), giving the updates and messages in progress until timeout
+// to complete. The monitors are stopped as soon as ctx is done. The Bot can't be run again.
+func (bot *Bot) Run(ctx context.Context, timeout time.Duration) error {
+	log.Infoln("BOT: Starting.")
+	defer log.Infoln("BOT: Stopped")
+	bot.SendGAEvent("AppInit", "BotStart", "Bot Starting")
+
+	updates, done, err := bot.receiveUpdates()
+	if err != nil {
+		bot.store.Close()
+		return fmt.Errorf("failed to receive Telegram updates: %v", err)
+	}
+	bot.startMonitors(ctx)
+	bot.pruneConversations()
+
+	log.Infoln("Skywire Wing Commander Telegram Bot - Ready for duty.")
+	bot.dispatchUpdates(ctx, updates, done)
+
+	bot.shutdown(updates, timeout)
+	return nil
+}
+
+// dispatchUpdates hands the updates to the workers until ctx is done or the updates stop
+func (bot *Bot) dispatchUpdates(ctx context.Context, updates <-chan tgbotapi.Update, done <-chan struct{}) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			//bot.SendGAEvent("BotMessages", "HandleUpdates", "Handle Updates Loop")
+			if err := bot.workers.Submit(ctx, update); err == errChatQueueFull {
+				log.Warnf("Bot.dispatchUpdates: Dropped update %d of chat %d: %v", update.UpdateID, updateChatID(&update), err)
+			} else if err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drainUpdates hands the updates waiting in updates to the workers, once no more are
+// received. The webhook has already accepted them, so Telegram won't send them again.
+func (bot *Bot) drainUpdates(ctx context.Context, updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := bot.workers.Submit(ctx, update); err != nil {
+				log.Warnf("Bot.drainUpdates: Dropped update %d of chat %d: %v", update.UpdateID, updateChatID(&update), err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// startMonitors starts watching the user addresses for deposits and the withdrawals for
+// confirmations (in the background). The monitors, including the Sky Manager monitor
+// started with /start, stop when ctx is done.
+func (bot *Bot) startMonitors(ctx context.Context) {
+	var monitorCtx context.Context
+	monitorCtx, bot.cancelMonitors = context.WithCancel(ctx)
+	bot.monitorContext = monitorCtx
+
+	if bot.config.Deposits.IntervalSec <= 0 {
+		log.Warnln("Bot.startMonitors: Deposit monitor disabled (deposits.intervalsec = 0)")
+		return
+	}
+	depositChan := make(chan depositmon.Deposit)
+	bot.monitors.Add(3)
+	go func() {
+		defer bot.monitors.Done()
+		bot.depositEventLoop(monitorCtx, depositChan)
+	}()
+	go func() {
+		defer bot.monitors.Done()
+		bot.depositMonitor.RunDepositMonitor(monitorCtx, bot.cancelMonitors, depositChan, bot.config.Deposits.IntervalSec)
+	}()
+	go func() {
+		defer bot.monitors.Done()
+		bot.withdrawalEventLoop(monitorCtx, bot.config.Deposits.IntervalSec)
+	}()
+}
+
+// stopMonitors stops the deposit and withdrawal monitors and (if it was started with /start)
+// the Sky Manager monitor, and waits for them to return
+func (bot *Bot) stopMonitors() {
+	if bot.skyMgrMonitor.IsRunning() {
+		bot.skyMgrMonitor.StopManagerMonitor()
+	}
+	if bot.cancelMonitors != nil {
+		bot.cancelMonitors()
+	}
+	bot.monitors.Wait()
+}
+
+// shutdown stops the Bot in order, so nothing is left half done:
+//  1. stop receiving updates (and remove the webhook)
+//  2. finish handling the updates already received, including those still waiting in
+//     updates, and send the queued messages, until timeout (handlers still running are
+//     then cancelled)
+//  3. wait for the monitors to stop, so they don't start anything new
+//  4. send the signing off message
+//  5. close the database
+func (bot *Bot) shutdown(updates <-chan tgbotapi.Update, timeout time.Duration) {
+	log.Infoln("Skywire Wing Commander Telegram Bot - Signing off.")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if bot.webhook != nil {
+		bot.stopWebhook()
+	} else {
+		bot.telegram.StopReceivingUpdates()
+	}
+	bot.drainUpdates(ctx, updates)
+
+	if err := bot.workers.Shutdown(ctx); err != nil {
+		log.Warnf("Bot.shutdown: Updates still being handled after %v: %v", timeout, err)
+	}
+	bot.cancelHandlers()
+	if err := bot.outbox.Flush(ctx); err != nil {
+		log.Warnf("Bot.shutdown: Messages still waiting to be sent after %v: %v", timeout, err)
+	}
+
+	bot.stopMonitors()
+
+	if err := bot.PostStatus(nil, "yell", "markdown", fmt.Sprintf(wcconst.MsgSigningOff, wcconst.BotAppVersion)); err != nil {
+		logSendError("Bot.shutdown", err)
+	}
+	signOffCtx, signOffCancel := context.WithTimeout(context.Background(), signOffTimeout)
+	defer signOffCancel()
+	if err := bot.outbox.Shutdown(signOffCtx); err != nil {
+		log.Warnf("Bot.shutdown: Messages not sent: %v", err)
+	}
+	stats := bot.outbox.Stats()
+	log.Infof("Bot.shutdown: Sent %d message(s), %d retried, %d coalesced, %d dead", stats.Sent, stats.Retried, stats.Coalesced, stats.Dead)
+
+	if err := bot.store.Close(); err != nil {
+		log.Errorf("Bot.shutdown: Error closing database: %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// errTipTooSmall is returned by tip when the amount is below the minimum tip of the sender
+	errTipTooSmall = errors.New("tip is below the minimum")
+	// errTipTooLarge is returned by tip when the amount is above the maximum tip of the sender
+	errTipTooLarge = errors.New("tip is above the maximum")
+)
+
+// limitNames are the limits which can be overridden for a user with /setlimit
+var limitNames = []string{store.LimitMinTip, store.LimitMaxTip, store.LimitDailyCap, store.LimitTipsPerMinute}
+
+// limits holds the limits on the SKY a user can send. Amounts are in droplets and a
+// limit of 0 is unlimited. Overridden holds the names of the limits set by an admin.
+type limits struct {
+	MinTip           uint64
+	MaxTip           uint64
+	DailyCap         uint64
+	HourlyOutflowCap uint64
+	TipsPerMinute    int
+	Overridden       map[string]bool
+}
+
+// parseLimitValue parses the value of a limit: a number of tips for tipsperminute, otherwise
+// an amount in SKY or droplets. An empty value, "0" or "none" is unlimited.
+func parseLimitValue(name, value string) (uint64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "0" || value == "none" {
+		return 0, nil
+	}
+	if name == store.LimitTipsPerMinute {
+		return strconv.ParseUint(value, 10, 32)
+	}
+	return wallet.ParseAmount(value)
+}
+
+// parseLimits parses the [limits] section of config.toml
+func parseLimits(p wcconfig.LimitParameters) (limits, error) {
+	var l limits
+	var err error
+	for _, v := range []struct {
+		name  string
+		value string
+		dest  *uint64
+	}{
+		{store.LimitMinTip, p.MinTip, &l.MinTip},
+		{store.LimitMaxTip, p.MaxTip, &l.MaxTip},
+		{store.LimitDailyCap, p.DailyCap, &l.DailyCap},
+		{"hourlyoutflowcap", p.HourlyOutflowCap, &l.HourlyOutflowCap},
+	} {
+		if *v.dest, err = parseLimitValue(v.name, v.value); err != nil {
+			return limits{}, fmt.Errorf("invalid %s %q: %v", v.name, v.value, err)
+		}
+	}
+	if p.TipsPerMinute < 0 {
+		return limits{}, fmt.Errorf("invalid tipsperminute %d", p.TipsPerMinute)
+	}
+	l.TipsPerMinute = p.TipsPerMinute
+	if l.MaxTip > 0 && l.MinTip > l.MaxTip {
+		return limits{}, fmt.Errorf("mintip %q is above maxtip %q", p.MinTip, p.MaxTip)
+	}
+	return l, nil
+}
+
+// get returns the value of the named limit
+func (l limits) get(name string) uint64 {
+	switch name {
+	case store.LimitMinTip:
+		return l.MinTip
+	case store.LimitMaxTip:
+		return l.MaxTip
+	case store.LimitDailyCap:
+		return l.DailyCap
+	case store.LimitTipsPerMinute:
+		return uint64(l.TipsPerMinute)
+	}
+	return 0
+}
+
+// set changes the value of the named limit
+func (l *limits) set(name string, value uint64) {
+	switch name {
+	case store.LimitMinTip:
+		l.MinTip = value
+	case store.LimitMaxTip:
+		l.MaxTip = value
+	case store.LimitDailyCap:
+		l.DailyCap = value
+	case store.LimitTipsPerMinute:
+		l.TipsPerMinute = int(value)
+	}
+}
+
+// format returns the value of the named limit shown to users
+func (l limits) format(name string) string {
+	value := l.get(name)
+	var s string
+	switch {
+	case value == 0:
+		s = wcconst.MsgLimitNone
+	case name == store.LimitTipsPerMinute:
+		s = strconv.FormatUint(value, 10)
+	default:
+		s = wallet.FormatDroplets(value) + " SKY"
+	}
+	if l.Overridden[name] {
+		s += wcconst.MsgLimitOverridden
+	}
+	return s
+}
+
+// checkTip checks the amount of a tip against the minimum and maximum tip
+func (l limits) checkTip(amount uint64) error {
+	switch {
+	case l.MinTip > 0 && amount < l.MinTip:
+		return errTipTooSmall
+	case l.MaxTip > 0 && amount > l.MaxTip:
+		return errTipTooLarge
+	}
+	return nil
+}
+
+// userLimits returns the limits of the user with the provided Telegram ID: the configured
+// limits, replaced by any limits an admin has overridden for the user
+func (bot *Bot) userLimits(ctx context.Context, telegramID int) (limits, error) {
+	l := bot.limits
+	l.Overridden = make(map[string]bool)
+	if telegramID == 0 {
+		return l, nil
+	}
+
+	overrides, err := bot.store.Limits().List(ctx, telegramID)
+	if err != nil {
+		return l, err
+	}
+	for _, o := range overrides {
+		l.set(o.Name, o.Value)
+		l.Overridden[o.Name] = true
+	}
+	return l, nil
+}
+
+// limitMessage returns the message explaining why a tip or withdrawal by the user was
+// rejected, if err is caused by one of their limits
+func (bot *Bot) limitMessage(ctx context.Context, u *store.User, err error) (string, bool) {
+	switch err {
+	case errTipTooSmall, errTipTooLarge, store.ErrDailyCapExceeded, store.ErrTipRateExceeded, store.ErrOutflowCapExceeded:
+	default:
+		return "", false
+	}
+
+	l, lerr := bot.userLimits(ctx, u.TelegramID)
+	if lerr != nil {
+		log.Errorf("Bot.limitMessage: Error getting limits of %s: %v", userDisplayName(u), lerr)
+	}
+	log.Infof("Bot.limitMessage: %s: %v", userDisplayName(u), err)
+
+	switch err {
+	case errTipTooSmall:
+		return fmt.Sprintf(wcconst.MsgLimitMinTip, wallet.FormatDroplets(l.MinTip)), true
+	case errTipTooLarge:
+		return fmt.Sprintf(wcconst.MsgLimitMaxTip, wallet.FormatDroplets(l.MaxTip)), true
+	case store.ErrDailyCapExceeded:
+		return fmt.Sprintf(wcconst.MsgLimitDailyCap, wallet.FormatDroplets(l.DailyCap)), true
+	case store.ErrTipRateExceeded:
+		return fmt.Sprintf(wcconst.MsgLimitTipRate, l.TipsPerMinute), true
+	}
+	return wcconst.MsgLimitOutflowCap, true
+}
+
+// Handler for limits command
+// Shows the limits of the user and how much they have sent in the last 24 hours.
+func (bot *Bot) handleCommandLimits(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandLimits", err)
+		}
+		return err
+	}
+
+	storectx := ctx.Context()
+	l, err := bot.userLimits(storectx, ctx.User.ID)
+	if err != nil {
+		log.Errorf("Bot.handleCommandLimits: Error getting limits of %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	var sent uint64
+	u, err := bot.lookupUser(storectx, ctx.User)
+	if err == nil {
+		sent, err = bot.store.Ledger().Sent(storectx, store.UserAccount(u.ID), time.Now().Add(-store.DailyCapWindow))
+	}
+	if err != nil && err != store.ErrNotFound {
+		log.Errorf("Bot.handleCommandLimits: Error getting the amount sent by %d: %v", ctx.User.ID, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	return reply(fmt.Sprintf(wcconst.MsgLimits, l.format(store.LimitMinTip), l.format(store.LimitMaxTip),
+		l.format(store.LimitDailyCap), wallet.FormatDroplets(sent), l.format(store.LimitTipsPerMinute)))
+}
+
+// setLimit shows or overrides the limits of the member named by the first argument:
+// /setlimit <@user|id> [limit] [amount|none|default]. Changes are recorded in the audit log.
+// The message to send to the user is returned.
+func (bot *Bot) setLimit(ctx *BotContext, args string) (string, error) {
+	storectx := ctx.Context()
+
+	fields := strings.Fields(args)
+	if len(fields) != 1 && len(fields) != 3 {
+		return wcconst.MsgSetLimitUsage, nil
+	}
+
+	target, err := bot.resolveMember(storectx, fields[0])
+	if err == errInvalidTarget {
+		return wcconst.MsgSetLimitUsage, nil
+	} else if err == store.ErrNotFound {
+		return fmt.Sprintf(wcconst.MsgAdminUnknownUser, EscapeMarkdown(fields[0])), nil
+	} else if err != nil {
+		return "", err
+	}
+	name := memberName(target)
+
+	if len(fields) == 1 {
+		l, err := bot.userLimits(storectx, target.TelegramID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(wcconst.MsgSetLimitUser, EscapeMarkdown(name), l.format(store.LimitMinTip), l.format(store.LimitMaxTip),
+			l.format(store.LimitDailyCap), l.format(store.LimitTipsPerMinute)), nil
+	}
+
+	limit, value := strings.ToLower(fields[1]), strings.ToLower(fields[2])
+	valid := false
+	for _, n := range limitNames {
+		valid = valid || n == limit
+	}
+	if !valid {
+		return wcconst.MsgSetLimitUsage, nil
+	}
+
+	if value == "default" {
+		err := bot.store.Limits().Delete(storectx, target.TelegramID, limit)
+		if err == store.ErrNotFound {
+			return fmt.Sprintf(wcconst.MsgSetLimitNotSet, limit, EscapeMarkdown(name)), nil
+		} else if err != nil {
+			return "", err
+		}
+		bot.audit(ctx, store.AuditLimit, name, limit+" = default")
+		log.Infof("Bot.setLimit: %s reset the %s limit of %s", ctx.User.NameAndTags(), limit, name)
+		return fmt.Sprintf(wcconst.MsgSetLimitDefault, limit, EscapeMarkdown(name)), nil
+	}
+
+	amount, err := parseLimitValue(limit, value)
+	if err != nil {
+		return fmt.Sprintf(wcconst.MsgSetLimitInvalidValue, EscapeMarkdown(fields[2]), limit), nil
+	}
+	err = bot.store.Limits().Set(storectx, &store.LimitOverride{
+		TelegramID: target.TelegramID,
+		Name:       limit,
+		Value:      amount,
+		UpdatedBy:  ctx.User.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var l limits
+	l.set(limit, amount)
+	bot.audit(ctx, store.AuditLimit, name, fmt.Sprintf("%s = %s", limit, l.format(limit)))
+	log.Infof("Bot.setLimit: %s set the %s limit of %s to %s", ctx.User.NameAndTags(), limit, name, l.format(limit))
+	return fmt.Sprintf(wcconst.MsgSetLimitSet, limit, EscapeMarkdown(name), l.format(limit)), nil
+}
+
+// Handler for setlimit command
+func (bot *Bot) handleCommandSetLimit(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg, err := bot.setLimit(ctx, args)
+	if err != nil {
+		log.Errorf("Bot.handleCommandSetLimit: Error handling /%s %s: %v", command, args, err)
+		msg = wcconst.MsgErrorStore
+	}
+	if senderr := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg); senderr != nil {
+		logSendError("Bot.handleCommandSetLimit", senderr)
+		return senderr
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+)
+
+func Test_parseLimits(t *testing.T) {
+	l, err := parseLimits(wcconfig.LimitParameters{MinTip: "1000drops", MaxTip: "0.1", DailyCap: "10", HourlyOutflowCap: "0", TipsPerMinute: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.MinTip != 1000 || l.MaxTip != 100000 || l.DailyCap != 10000000 || l.HourlyOutflowCap != 0 || l.TipsPerMinute != 5 {
+		t.Errorf("Unexpected limits: %+v", l)
+	}
+
+	if l, err := parseLimits(wcconfig.LimitParameters{}); err != nil || l.MaxTip != 0 {
+		t.Errorf("Expected no limits, got %+v, %v", l, err)
+	}
+
+	for _, p := range []wcconfig.LimitParameters{
+		{MaxTip: "abc"},
+		{DailyCap: "0.0001"},
+		{MinTip: "1", MaxTip: "0.1"},
+		{TipsPerMinute: -1},
+	} {
+		if _, err := parseLimits(p); err == nil {
+			t.Errorf("Expected an error for %+v", p)
+		}
+	}
+}
+
+func Test_tip_Limits(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+	alice.TelegramID = 1002
+	if err := bot.store.Users().Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	bot.limits = limits{MinTip: 10000, MaxTip: 1000000, DailyCap: 2000000, TipsPerMinute: 3}
+
+	err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:   store.TransferDeposit,
+		From:   store.AccountOnChain,
+		To:     store.UserAccount(alice.ID),
+		Amount: 10000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount uint64
+		err    error
+		msg    string
+	}{
+		{1000, errTipTooSmall, fmt.Sprintf(wcconst.MsgLimitMinTip, "0.01")},
+		{1001000, errTipTooLarge, fmt.Sprintf(wcconst.MsgLimitMaxTip, "1")},
+		{1000000, nil, ""},
+		{1000000, nil, ""},
+		{1000, errTipTooSmall, fmt.Sprintf(wcconst.MsgLimitMinTip, "0.01")},
+		{10000, store.ErrDailyCapExceeded, fmt.Sprintf(wcconst.MsgLimitDailyCap, "2")},
+	}
+	for i, tc := range tests {
+		err := bot.tip(ctx, alice, bob, tc.amount, "")
+		if err != tc.err {
+			t.Errorf("%d: Expected %v, got %v", i, tc.err, err)
+		}
+		msg, ok := bot.limitMessage(ctx, alice, err)
+		if ok != (tc.msg != "") || msg != tc.msg {
+			t.Errorf("%d: Unexpected message %q", i, msg)
+		}
+	}
+
+	// An admin can raise the limits of a user
+	for name, value := range map[string]uint64{store.LimitMaxTip: 0, store.LimitDailyCap: 6000000} {
+		if err := bot.store.Limits().Set(ctx, &store.LimitOverride{TelegramID: 1002, Name: name, Value: value, UpdatedBy: 1000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bot.tip(ctx, alice, bob, 3000000, ""); err != nil {
+		t.Errorf("Expected the overridden limits to apply, got %v", err)
+	}
+	if err := bot.tip(ctx, alice, bob, 10000, ""); err != store.ErrTipRateExceeded {
+		t.Errorf("Expected ErrTipRateExceeded, got %v", err)
+	}
+	if msg, _ := bot.limitMessage(ctx, alice, store.ErrTipRateExceeded); msg != fmt.Sprintf(wcconst.MsgLimitTipRate, 3) {
+		t.Errorf("Unexpected message %q", msg)
+	}
+
+	// Limits don't change other errors
+	if _, ok := bot.limitMessage(ctx, alice, store.ErrInsufficientFunds); ok {
+		t.Error("Expected ErrInsufficientFunds not to be a limit error")
+	}
+
+	balance, err := bot.store.Ledger().Balance(ctx, store.UserAccount(bob.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 5000000 {
+		t.Errorf("Expected bob's balance to be 5000000, got %d", balance)
+	}
+}
+
+func Test_setLimit(t *testing.T) {
+	bot := newTestAdminBot(t)
+	bot.limits = limits{MaxTip: 100000, DailyCap: 10000000, TipsPerMinute: 5}
+	owner := &BotContext{User: &User{ID: 1000, UserName: "owner", Role: store.RoleAdmin}}
+
+	tests := []struct {
+		args string
+		msg  string
+	}{
+		{"@alice maxtip 5", fmt.Sprintf(wcconst.MsgSetLimitSet, "maxtip", "@alice (1002)", "5 SKY")},
+		{"1002 DailyCap none", fmt.Sprintf(wcconst.MsgSetLimitSet, "dailycap", "@alice (1002)", wcconst.MsgLimitNone)},
+		{"@alice tipsperminute 10", fmt.Sprintf(wcconst.MsgSetLimitSet, "tipsperminute", "@alice (1002)", "10")},
+		{"@alice tipsperminute default", fmt.Sprintf(wcconst.MsgSetLimitDefault, "tipsperminute", "@alice (1002)")},
+		{"@alice tipsperminute default", fmt.Sprintf(wcconst.MsgSetLimitNotSet, "tipsperminute", "@alice (1002)")},
+		{"@alice", fmt.Sprintf(wcconst.MsgSetLimitUser, "@alice (1002)", wcconst.MsgLimitNone, "5 SKY"+wcconst.MsgLimitOverridden,
+			wcconst.MsgLimitNone+wcconst.MsgLimitOverridden, "5")},
+		{"@bob", fmt.Sprintf(wcconst.MsgSetLimitUser, "@bob (1003)", wcconst.MsgLimitNone, "0.1 SKY", "10 SKY", "5")},
+		{"@alice maxtip abc", fmt.Sprintf(wcconst.MsgSetLimitInvalidValue, "abc", "maxtip")},
+		{"@alice hourlyoutflowcap 5", wcconst.MsgSetLimitUsage},
+		{"@alice maxtip", wcconst.MsgSetLimitUsage},
+		{"@nobody maxtip 5", fmt.Sprintf(wcconst.MsgAdminUnknownUser, "@nobody")},
+	}
+	for i, tc := range tests {
+		msg, err := bot.setLimit(owner, tc.args)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if msg != tc.msg {
+			t.Errorf("%d: /setlimit %s: expected %q, got %q", i, tc.args, tc.msg, msg)
+		}
+	}
+
+	l, err := bot.userLimits(context.Background(), 1002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.MaxTip != 5000000 || l.DailyCap != 0 || l.TipsPerMinute != 5 || !l.Overridden[store.LimitMaxTip] {
+		t.Errorf("Unexpected limits: %+v", l)
+	}
+
+	entries, err := bot.store.AuditLog().List(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].Action != store.AuditLimit || entries[0].Details != "tipsperminute = default" ||
+		entries[3].Details != "maxtip = 5 SKY" {
+		t.Errorf("Unexpected audit entries: %+v", entries)
+	}
+}
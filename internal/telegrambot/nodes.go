@@ -0,0 +1,272 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
+	"github.com/BigOokie/skywire-wing-commander/internal/skymgrmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// The buttons of /nodes select a Node by a prefix of its key, as the command and arguments
+// of a button can only be 48 bytes (see encodeCallback), which is too short for a full key.
+const (
+	// nodeKeyPrefixLen is the usual length of the key prefix of a button
+	nodeKeyPrefixLen = 16
+	// nodeKeyPrefixMax is the longest key prefix which fits in a button
+	nodeKeyPrefixMax = 48 - len("node ")
+)
+
+// formatBytes formats a byte count using binary units, i.e. 1.5 MiB
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// formatAge formats a duration to the two most significant units, i.e. 3h 12m
+func formatAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	secs := int64(d / time.Second)
+	switch {
+	case secs < 60:
+		return fmt.Sprintf("%ds", secs)
+	case secs < 60*60:
+		return fmt.Sprintf("%dm %ds", secs/60, secs%60)
+	case secs < 24*60*60:
+		return fmt.Sprintf("%dh %dm", secs/(60*60), secs/60%60)
+	}
+	return fmt.Sprintf("%dd %dh", secs/(24*60*60), secs/(60*60)%24)
+}
+
+// nodeKeyPrefix returns the prefix of the key of a Node used to select it with a button,
+// which is lengthened (as far as it fits) until no other Node has the prefix
+func nodeKeyPrefix(key string, nodes skynode.NodeInfoSlice) string {
+	n := nodeKeyPrefixLen
+	for ; n < len(key) && n < nodeKeyPrefixMax; n++ {
+		unique := true
+		for _, other := range nodes {
+			if other.Key != key && strings.HasPrefix(other.Key, key[:n]) {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			break
+		}
+	}
+	if n >= len(key) {
+		return key
+	}
+	return key[:n]
+}
+
+// nodeButtons returns a keyboard with a button showing the details of each Node, followed
+// by the buttons of the last row
+func nodeButtons(nodes skynode.NodeInfoSlice, last ...tgbotapi.InlineKeyboardButton) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, n := range nodes {
+		prefix := nodeKeyPrefix(n.Key, nodes)
+		label := fmt.Sprintf("%s… (%s)", prefix, n.Conntype)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "node "+prefix)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(append(rows, last)...)
+}
+
+// nodesPage returns the text and inline keyboard listing the connected Nodes, as of now
+func nodesPage(nodes skynode.NodeInfoSlice, now time.Time) (string, tgbotapi.InlineKeyboardMarkup) {
+	var b strings.Builder
+	if len(nodes) == 0 {
+		b.WriteString(wcconst.MsgNodesNone)
+	} else {
+		fmt.Fprintf(&b, wcconst.MsgNodesHeader, len(nodes))
+	}
+	fmt.Fprintf(&b, wcconst.MsgNodesUpdated, now.UTC().Format("15:04:05 MST"))
+
+	return b.String(), nodeButtons(nodes, tgbotapi.NewInlineKeyboardButtonData("🔄 Refresh", "nodes"))
+}
+
+// nodePage returns the text and inline keyboard of the details of the connected Node with
+// the key prefix, as of now. If several Nodes have the prefix they are listed instead.
+// The apps of the Node are requested with getApps.
+func nodePage(nodes skynode.NodeInfoSlice, prefix string, now time.Time,
+	getApps func(key string) ([]skymanager.App, error)) (string, tgbotapi.InlineKeyboardMarkup) {
+	var matches skynode.NodeInfoSlice
+	for _, n := range nodes {
+		if strings.HasPrefix(n.Key, prefix) {
+			matches = append(matches, n)
+		}
+	}
+	back := tgbotapi.NewInlineKeyboardButtonData("« Nodes", "nodes")
+
+	var b strings.Builder
+	switch len(matches) {
+	case 0:
+		fmt.Fprintf(&b, wcconst.MsgNodeNotFound, prefix)
+		return b.String(), tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(back))
+	case 1:
+	default:
+		fmt.Fprintf(&b, wcconst.MsgNodeAmbiguous, len(matches), prefix)
+		return b.String(), nodeButtons(matches, back)
+	}
+
+	// The Manager reports the times of a connection as the seconds elapsed since then
+	n := matches[0]
+	fmt.Fprintf(&b, wcconst.MsgNodeDetails, n.Key, EscapeMarkdown(n.Conntype), formatBytes(n.SendBytes), formatBytes(n.RecvBytes),
+		formatAge(time.Duration(n.LastAckTime)*time.Second), formatAge(time.Duration(n.StartTime)*time.Second))
+	fmt.Fprintf(&b, wcconst.MsgNodeApps, formatApps(n.Key, getApps))
+	fmt.Fprintf(&b, wcconst.MsgNodesUpdated, now.UTC().Format("15:04:05 MST"))
+
+	refresh := tgbotapi.NewInlineKeyboardButtonData("🔄 Refresh", "node "+nodeKeyPrefix(n.Key, nodes))
+	return b.String(), tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(refresh, back))
+}
+
+// formatApps returns the names of the apps of the Node with the key, as requested with getApps
+func formatApps(key string, getApps func(key string) ([]skymanager.App, error)) string {
+	apps, err := getApps(key)
+	if err != nil {
+		log.Warnf("Bot.formatApps: Error getting the apps of Node %s: %v", key, err)
+		return wcconst.MsgNodeAppsError
+	}
+	if len(apps) == 0 {
+		return wcconst.MsgNodeAppsNone
+	}
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		names = append(names, EscapeMarkdown(app.Name()))
+	}
+	return strings.Join(names, ", ")
+}
+
+// isNodeKeyPrefix reports whether s can be the start of a Node key (a hex encoded public key)
+func isNodeKeyPrefix(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// showNodePage sends a page of /nodes or /node, or edits the message when a button is pressed
+func (bot *Bot) showNodePage(ctx *BotContext, kb tgbotapi.InlineKeyboardMarkup, text string) error {
+	if ctx.IsCallBackQuery() {
+		return bot.EditMessage(ctx, &kb, text)
+	}
+	return bot.SendReplyInlineKeyboard(ctx, kb, text)
+}
+
+// Handler for nodes command
+// Lists the Nodes connected to the Manager, with a button to show the details of each Node.
+func (bot *Bot) handleCommandNodes(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	nodes, err := bot.skyMgrMonitor.GetNodes(ctx.Context())
+	if err != nil {
+		log.Errorf("Bot.handleCommandNodes: %v", err)
+		return bot.Send(ctx, getSendModeforContext(ctx), "markdown", wcconst.MsgErrorGetNodes)
+	}
+
+	text, kb := nodesPage(nodes, time.Now())
+	if err := bot.showNodePage(ctx, kb, text); err != nil {
+		logSendError("Bot.handleCommandNodes", err)
+		return err
+	}
+	return nil
+}
+
+// Handler for node command: /node <key prefix>
+// Shows the details of a connected Node, with a button to refresh them in place.
+func (bot *Bot) handleCommandNode(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	prefix := strings.ToLower(strings.TrimSpace(args))
+	if !isNodeKeyPrefix(prefix) {
+		return bot.Send(ctx, getSendModeforContext(ctx), "markdown", wcconst.MsgNodeUsage)
+	}
+
+	nodes, err := bot.skyMgrMonitor.GetNodes(ctx.Context())
+	if err != nil {
+		log.Errorf("Bot.handleCommandNode: %v", err)
+		return bot.Send(ctx, getSendModeforContext(ctx), "markdown", wcconst.MsgErrorGetNodes)
+	}
+
+	getApps := func(key string) ([]skymanager.App, error) {
+		return bot.skyMgrMonitor.GetNodeApps(ctx.Context(), key)
+	}
+	text, kb := nodePage(nodes, prefix, time.Now(), getApps)
+	if err := bot.showNodePage(ctx, kb, text); err != nil {
+		logSendError("Bot.handleCommandNode", err)
+		return err
+	}
+	return nil
+}
+
+// muteEvents mutes (or unmutes) the kind of monitor event named by args, and returns the
+// reply to /mute or /unmute. /mute without arguments lists the kinds and if they are muted.
+func muteEvents(smm *skymgrmon.SkyManagerMonitor, command, args string, mute bool) string {
+	name := strings.ToLower(strings.TrimSpace(args))
+	if name == "" && mute {
+		var b strings.Builder
+		for _, kind := range skymgrmon.EventKinds {
+			icon := "🔔"
+			if smm.IsMuted(kind) {
+				icon = "🔇"
+			}
+			fmt.Fprintf(&b, wcconst.MsgMuteLine, icon, kind)
+		}
+		return fmt.Sprintf(wcconst.MsgMuteList, b.String())
+	}
+
+	kind, err := skymgrmon.ParseEventKind(name)
+	if err != nil {
+		names := make([]string, 0, len(skymgrmon.EventKinds))
+		for _, kind := range skymgrmon.EventKinds {
+			names = append(names, string(kind))
+		}
+		return fmt.Sprintf(wcconst.MsgMuteUsage, command, strings.Join(names, ", "))
+	}
+	smm.SetMuted(kind, mute)
+	if mute {
+		return fmt.Sprintf(wcconst.MsgMuted, kind)
+	}
+	return fmt.Sprintf(wcconst.MsgUnmuted, kind)
+}
+
+// Handler for mute and unmute commands: /mute [event], /unmute <event>
+// Stops (or starts again) reporting a kind of monitor event, until the bot is restarted.
+func (bot *Bot) handleCommandMute(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg := muteEvents(bot.skyMgrMonitor, command, args, command == "mute")
+	if err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg); err != nil {
+		logSendError("Bot.handleCommandMute", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
+	"github.com/BigOokie/skywire-wing-commander/internal/skymgrmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/skynode"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+var testNodes = skynode.NodeInfoSlice{
+	{Key: "02b9d1cab7467771ce2bc8fd7c7340bba0c2a511004650064bcb368386263694fd", Conntype: "TCP",
+		SendBytes: 1536, RecvBytes: 3 << 20, LastAckTime: 4, StartTime: 7500},
+	{Key: "02b9d1cab7467771ffffffffffffffffffffffffffffffffffffffffffffffffff", Conntype: "TCP"},
+	{Key: "03aa6c2b9f0e6f26e1e5d2a6b8c3b1f4e6d1c2b3a4958677869a0b1c2d3e4f5a6b", Conntype: "UDP"},
+}
+
+// testApps returns the apps of the first test Node, and an error for the others
+func testApps(key string) ([]skymanager.App, error) {
+	if key != testNodes[0].Key {
+		return nil, skymanager.ErrNodeNotFound
+	}
+	return []skymanager.App{{Key: "app1", Attributes: []string{"sockss"}}, {Key: "app2", Attributes: []string{"ssh_c"}}}, nil
+}
+
+// keyboardData returns the callback data of every button of the keyboard
+func keyboardData(kb tgbotapi.InlineKeyboardMarkup) []string {
+	var data []string
+	for _, row := range kb.InlineKeyboard {
+		for _, btn := range row {
+			data = append(data, *btn.CallbackData)
+		}
+	}
+	return data
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := map[int]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1536:          "1.5 KiB",
+		3 << 20:       "3.0 MiB",
+		5<<30 + 1<<29: "5.5 GiB",
+	}
+	for n, expected := range tests {
+		if s := formatBytes(n); s != expected {
+			t.Errorf("formatBytes(%d) expected %q, got %q", n, expected, s)
+		}
+	}
+}
+
+func Test_formatAge(t *testing.T) {
+	tests := map[time.Duration]string{
+		-time.Second:                       "0s",
+		45 * time.Second:                   "45s",
+		125 * time.Second:                  "2m 5s",
+		2*time.Hour + 5*time.Minute:        "2h 5m",
+		50*time.Hour + 30*time.Minute:      "2d 2h",
+		time.Minute + 500*time.Millisecond: "1m 0s",
+	}
+	for d, expected := range tests {
+		if s := formatAge(d); s != expected {
+			t.Errorf("formatAge(%v) expected %q, got %q", d, expected, s)
+		}
+	}
+}
+
+func Test_nodesPage(t *testing.T) {
+	now := time.Date(2018, 9, 15, 12, 34, 56, 0, time.UTC)
+	text, kb := nodesPage(testNodes, now)
+	if !strings.HasPrefix(text, "*Connected Nodes* (3)") || !strings.Contains(text, "Updated 12:34:56 UTC") {
+		t.Errorf("Unexpected text %q", text)
+	}
+	data := keyboardData(kb)
+	// The prefixes of the Nodes sharing the first 16 characters of their keys are lengthened
+	expected := []string{"node 02b9d1cab7467771c", "node 02b9d1cab7467771f", "node 03aa6c2b9f0e6f26", "nodes"}
+	if strings.Join(data, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected buttons %v, got %v", expected, data)
+	}
+	// Every button must fit in the signed callback data
+	for _, d := range data {
+		if _, err := encodeCallback([]byte("key"), Callback{Command: d, Expires: now.Add(callbackTTL)}); err != nil {
+			t.Errorf("Button %q too long: %v", d, err)
+		}
+	}
+
+	if text, kb := nodesPage(nil, now); !strings.HasPrefix(text, "No Nodes") || len(keyboardData(kb)) != 1 {
+		t.Errorf("Expected no Nodes with a refresh button, got %q", text)
+	}
+}
+
+func Test_nodePage(t *testing.T) {
+	now := time.Date(2018, 9, 15, 12, 34, 56, 0, time.UTC)
+
+	text, kb := nodePage(testNodes, "03aa", now, testApps)
+	if !strings.Contains(text, "`"+testNodes[2].Key+"`") || !strings.Contains(text, "*Type:* UDP") ||
+		!strings.Contains(text, "*Apps:* _unavailable_") {
+		t.Errorf("Unexpected details %q", text)
+	}
+	if data := keyboardData(kb); len(data) != 2 || data[0] != "node 03aa6c2b9f0e6f26" || data[1] != "nodes" {
+		t.Errorf("Expected refresh and back buttons, got %v", data)
+	}
+
+	text, _ = nodePage(testNodes, "02b9d1cab7467771ce", now, testApps)
+	for _, s := range []string{"*Apps:* sockss, ssh\\_c\n", "*Sent:* 1.5 KiB", "*Received:* 3.0 MiB", "*Last ack:* 4s ago", "*Connected for:* 2h 5m"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("Expected %q in %q", s, text)
+		}
+	}
+
+	// A prefix shared by several Nodes lists them
+	text, kb = nodePage(testNodes, "02b9", now, testApps)
+	if !strings.HasPrefix(text, "2 connected Nodes") || len(keyboardData(kb)) != 3 {
+		t.Errorf("Expected the matching Nodes to be listed, got %q %v", text, keyboardData(kb))
+	}
+
+	text, kb = nodePage(testNodes, "ff", now, testApps)
+	if !strings.HasPrefix(text, "No connected Node") || len(keyboardData(kb)) != 1 {
+		t.Errorf("Expected no Node to be found, got %q", text)
+	}
+}
+
+func Test_muteEvents(t *testing.T) {
+	smm := skymgrmon.NewMonitor("", "")
+
+	if msg := muteEvents(smm, "mute", " AppStopped ", true); !strings.Contains(msg, "appstopped events are now muted") {
+		t.Errorf("Unexpected reply %q", msg)
+	}
+	if !smm.IsMuted(skymgrmon.EventAppStopped) || smm.IsMuted(skymgrmon.EventAppStarted) {
+		t.Error("Expected only appstopped to be muted")
+	}
+	if msg := muteEvents(smm, "mute", "", true); !strings.Contains(msg, "🔇 `appstopped`") || !strings.Contains(msg, "🔔 `appstarted`") {
+		t.Errorf("Expected the kinds to be listed, got %q", msg)
+	}
+
+	if msg := muteEvents(smm, "unmute", "appstopped", false); !strings.Contains(msg, "no longer muted") || smm.IsMuted(skymgrmon.EventAppStopped) {
+		t.Errorf("Expected appstopped to be unmuted, got %q", msg)
+	}
+	for _, args := range []string{"", "nosuchevent"} {
+		if msg := muteEvents(smm, "unmute", args, false); !strings.HasPrefix(msg, "*Usage:* /unmute") {
+			t.Errorf("Expected the usage for %q, got %q", args, msg)
+		}
+	}
+}
+
+func Test_isNodeKeyPrefix(t *testing.T) {
+	for s, expected := range map[string]bool{"02b9": true, testNodes[0].Key: true, "": false, "02 b9": false, "`x`": false} {
+		if isNodeKeyPrefix(s) != expected {
+			t.Errorf("isNodeKeyPrefix(%q) expected %v", s, expected)
+		}
+	}
+}
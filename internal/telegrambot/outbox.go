@@ -0,0 +1,421 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// outboxMinBackoff is the wait before retrying a message which failed with a network error
+	outboxMinBackoff = time.Second
+	// outboxMaxBackoff is the longest wait between the retries of a message
+	outboxMaxBackoff = 30 * time.Second
+)
+
+var (
+	// errOutboxFull is returned when queueSize messages are already waiting to be sent
+	errOutboxFull = errors.New("outbound message queue full")
+	// errOutboxStopped is returned once the outbox is shutting down
+	errOutboxStopped = errors.New("outbound message queue stopped")
+)
+
+// retryAfterPattern matches the description of a flood error when uploading files, which
+// (unlike other requests) isn't returned as a tgbotapi.Error
+var retryAfterPattern = regexp.MustCompile(`Too Many Requests: retry after (\d+)`)
+
+// tokenBucket limits the rate of messages to rate per second, allowing bursts of up to burst
+// messages. It isn't safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refill adds the tokens earned since the bucket was last used
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// wait returns how long until a token is available (0 if one is available now)
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take uses a token, which must be available (see wait)
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+// full reports whether the bucket has refilled completely, i.e. it can be dropped
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// outboundMessage is a message waiting in the outbox. result is nil for posted messages.
+type outboundMessage struct {
+	chatID   int64
+	msg      tgbotapi.Chattable
+	key      string
+	attempts int
+	result   chan outboxResult
+}
+
+// outboxResult is the result of sending a delivered message
+type outboxResult struct {
+	message tgbotapi.Message
+	err     error
+}
+
+// outboxChat holds the messages waiting to be sent to a chat. Only the first message of a
+// chat is sent at a time, so messages arrive in order.
+type outboxChat struct {
+	queue   []*outboundMessage
+	bucket  *tokenBucket
+	busy    bool
+	retryAt time.Time
+}
+
+// outboxStats counts the messages handled by the outbox
+type outboxStats struct {
+	Sent      int
+	Retried   int
+	Coalesced int
+	Dead      int
+}
+
+// outbox sends the messages of the Bot within the rate limits of Telegram: globalpersec
+// messages per second overall, chatpersec messages per second to each private chat and
+// grouppermin messages per minute to each group. Messages which fail because of a network
+// error or a flood limit (honouring the retry_after of Telegram) are retried up to maxattempts
+// times. Messages which can't be sent are dead, they are logged and counted.
+type outbox struct {
+	send       func(tgbotapi.Chattable) (tgbotapi.Message, error)
+	config     wcconfig.OutboxParameters
+	mutex      sync.Mutex
+	global     *tokenBucket
+	chats      map[int64]*outboxChat
+	queued     int
+	stats      outboxStats
+	stopped    bool
+	closed     bool
+	wake       chan struct{}
+	quit       chan struct{}
+	idle       chan struct{}
+	idleOnce   sync.Once
+	quitOnce   sync.Once
+	inProgress sync.WaitGroup
+}
+
+// newOutbox starts an outbox sending messages using send
+func newOutbox(config wcconfig.OutboxParameters, send func(tgbotapi.Chattable) (tgbotapi.Message, error)) *outbox {
+	o := &outbox{
+		send:   send,
+		config: config,
+		global: newTokenBucket(config.GlobalPerSec, config.Burst, time.Now()),
+		chats:  make(map[int64]*outboxChat),
+		wake:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		idle:   make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// Deliver sends the message to the chat after the messages already waiting for the chat,
+// and waits until it has been sent or is dead
+func (o *outbox) Deliver(chatID int64, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	m := &outboundMessage{chatID: chatID, msg: msg, result: make(chan outboxResult, 1)}
+	if err := o.enqueue(m); err != nil {
+		return tgbotapi.Message{}, err
+	}
+	r := <-m.result
+	return r.message, r.err
+}
+
+// Post queues the message for the chat without waiting for it to be sent. A message with
+// the same (non-empty) key still waiting for the chat, i.e. a repeated status message, is
+// sent instead.
+func (o *outbox) Post(chatID int64, msg tgbotapi.Chattable, key string) error {
+	return o.enqueue(&outboundMessage{chatID: chatID, msg: msg, key: key})
+}
+
+// enqueue adds the message to the queue of its chat
+func (o *outbox) enqueue(m *outboundMessage) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.stopped {
+		return errOutboxStopped
+	}
+
+	chat := o.chats[m.chatID]
+	if chat == nil {
+		rate := o.config.ChatPerSec
+		if m.chatID < 0 {
+			rate = o.config.GroupPerMin / 60
+		}
+		chat = &outboxChat{bucket: newTokenBucket(rate, o.config.Burst, time.Now())}
+		o.chats[m.chatID] = chat
+	}
+	if m.key != "" {
+		for _, waiting := range chat.queue {
+			if waiting.key == m.key {
+				o.stats.Coalesced++
+				return nil
+			}
+		}
+	}
+	if o.queued >= o.config.QueueSize {
+		return errOutboxFull
+	}
+
+	chat.queue = append(chat.queue, m)
+	o.queued++
+	o.signal()
+	return nil
+}
+
+// signal wakes the dispatcher to look for messages which can be sent
+func (o *outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run starts sending each message once the rate limits allow it, until the outbox is stopped
+func (o *outbox) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		o.mutex.Lock()
+		m, wait := o.next(time.Now())
+		o.mutex.Unlock()
+		if m != nil {
+			go o.deliver(m)
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			expired = timer.C
+		}
+		select {
+		case <-o.wake:
+		case <-expired:
+		case <-o.quit:
+			return
+		}
+	}
+}
+
+// next returns the next message which can be sent, taking its tokens. Otherwise it returns
+// how long until a message might be sent (0 if no message is waiting).
+func (o *outbox) next(now time.Time) (*outboundMessage, time.Duration) {
+	if o.closed {
+		return nil, 0
+	}
+	var wait time.Duration
+	soonest := func(d time.Duration) {
+		if wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	for chatID, chat := range o.chats {
+		if chat.busy {
+			continue
+		}
+		if len(chat.queue) == 0 {
+			// The bucket is kept until it has refilled, so the chat stays limited
+			if chat.bucket.full(now) {
+				delete(o.chats, chatID)
+			}
+			continue
+		}
+		if now.Before(chat.retryAt) {
+			soonest(chat.retryAt.Sub(now))
+			continue
+		}
+		if d := chat.bucket.wait(now); d > 0 {
+			soonest(d)
+			continue
+		}
+		if d := o.global.wait(now); d > 0 {
+			soonest(d)
+			continue
+		}
+
+		o.global.take()
+		chat.bucket.take()
+		chat.busy = true
+		chat.queue[0].attempts++
+		o.inProgress.Add(1)
+		return chat.queue[0], 0
+	}
+	return nil, wait
+}
+
+// deliver sends the message, and either retries it later or removes it from the queue
+func (o *outbox) deliver(m *outboundMessage) {
+	defer o.inProgress.Done()
+	message, err := o.send(m.msg)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	chat := o.chats[m.chatID]
+	chat.busy = false
+	defer o.signal()
+
+	if err != nil && m.attempts < o.config.MaxAttempts {
+		if delay, retry := retryDelay(err, m.attempts); retry {
+			log.Warnf("outbox.deliver: Retrying message to chat %d in %v (attempt %d): %v", m.chatID, delay, m.attempts, err)
+			o.stats.Retried++
+			chat.retryAt = time.Now().Add(delay)
+			return
+		}
+	}
+
+	chat.queue = chat.queue[1:]
+	o.queued--
+	if err != nil {
+		o.stats.Dead++
+		log.Errorf("outbox.deliver: Dead message to chat %d after %d attempt(s): %v", m.chatID, m.attempts, err)
+	} else {
+		o.stats.Sent++
+	}
+	if m.result != nil {
+		m.result <- outboxResult{message, err}
+	}
+	if o.stopped && o.queued == 0 {
+		o.idleOnce.Do(func() { close(o.idle) })
+	}
+}
+
+// retryDelay returns how long to wait before retrying a message which failed with err, and
+// whether it should be retried at all. Errors returned by Telegram (other than flood limits)
+// are permanent, i.e. the user blocked the Bot.
+func retryDelay(err error, attempts int) (time.Duration, bool) {
+	if tgerr, ok := err.(tgbotapi.Error); ok {
+		if tgerr.RetryAfter > 0 {
+			return time.Duration(tgerr.RetryAfter) * time.Second, true
+		}
+		return 0, false
+	}
+	if match := retryAfterPattern.FindStringSubmatch(err.Error()); match != nil {
+		seconds, _ := strconv.Atoi(match[1])
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	switch err.(type) {
+	case *url.Error, net.Error:
+		backoff := outboxMinBackoff << uint(attempts-1)
+		if backoff > outboxMaxBackoff || backoff <= 0 {
+			backoff = outboxMaxBackoff
+		}
+		return backoff, true
+	}
+	return 0, false
+}
+
+// Stats returns the number of messages sent, retried, coalesced and dead so far
+func (o *outbox) Stats() outboxStats {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.stats
+}
+
+// Flush waits until the messages queued so far have been sent (or are dead) or ctx is done
+func (o *outbox) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		o.mutex.Lock()
+		queued := o.queued
+		o.mutex.Unlock()
+		if queued == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Shutdown stops accepting messages and waits until the messages already queued have been
+// sent or ctx is done. The messages still waiting are dead.
+func (o *outbox) Shutdown(ctx context.Context) error {
+	o.mutex.Lock()
+	o.stopped = true
+	if o.queued == 0 {
+		o.idleOnce.Do(func() { close(o.idle) })
+	}
+	o.mutex.Unlock()
+
+	var err error
+	select {
+	case <-o.idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	o.mutex.Lock()
+	o.closed = true
+	o.mutex.Unlock()
+	o.quitOnce.Do(func() { close(o.quit) })
+	o.inProgress.Wait()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for chatID, chat := range o.chats {
+		for _, m := range chat.queue {
+			o.stats.Dead++
+			log.Errorf("outbox.Shutdown: Dead message to chat %d: not sent before shutdown", chatID)
+			if m.result != nil {
+				m.result <- outboxResult{err: errOutboxStopped}
+			}
+		}
+		delete(o.chats, chatID)
+	}
+	o.queued = 0
+	return err
+}
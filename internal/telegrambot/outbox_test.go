@@ -0,0 +1,219 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// testOutboxParameters allows 20 messages per second to each chat, without bursts
+var testOutboxParameters = wcconfig.OutboxParameters{
+	GlobalPerSec: 100,
+	ChatPerSec:   20,
+	GroupPerMin:  1200,
+	Burst:        1,
+	MaxAttempts:  3,
+	QueueSize:    10,
+}
+
+// testSender records the messages sent by an outbox, failing with the queued errors first
+type testSender struct {
+	mutex  sync.Mutex
+	errs   []error
+	texts  []string
+	times  []time.Time
+	before chan struct{}
+}
+
+func (s *testSender) send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if s.before != nil {
+		<-s.before
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return tgbotapi.Message{}, err
+	}
+	msg := c.(tgbotapi.MessageConfig)
+	s.texts = append(s.texts, msg.Text)
+	s.times = append(s.times, time.Now())
+	return tgbotapi.Message{Text: msg.Text}, nil
+}
+
+func Test_tokenBucket(t *testing.T) {
+	now := time.Unix(1537000000, 0)
+	b := newTokenBucket(2, 2, now)
+	for i := 0; i < 2; i++ {
+		if d := b.wait(now); d != 0 {
+			t.Fatalf("Expected a token, got a wait of %v", d)
+		}
+		b.take()
+	}
+	if d := b.wait(now); d != 500*time.Millisecond {
+		t.Errorf("Expected a wait of 500ms, got %v", d)
+	}
+	if d := b.wait(now.Add(500 * time.Millisecond)); d != 0 || b.full(now.Add(500*time.Millisecond)) {
+		t.Errorf("Expected one token, got a wait of %v", d)
+	}
+	if !b.full(now.Add(time.Second)) {
+		t.Errorf("Expected the bucket to refill")
+	}
+}
+
+func Test_outbox_RateLimit(t *testing.T) {
+	s := &testSender{}
+	o := newOutbox(testOutboxParameters, s.send)
+
+	var wg sync.WaitGroup
+	for _, text := range []string{"one", "two", "three"} {
+		if err := o.Post(1, tgbotapi.NewMessage(1, text), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if msg, err := o.Deliver(2, tgbotapi.NewMessage(2, "other")); err != nil || msg.Text != "other" {
+			t.Errorf("Expected the message to be delivered, got %+v, %v", msg, err)
+		}
+	}()
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := o.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var chat1 []time.Time
+	var order []string
+	for i, text := range s.texts {
+		if text != "other" {
+			order = append(order, text)
+			chat1 = append(chat1, s.times[i])
+		}
+	}
+	if len(order) != 3 || order[0] != "one" || order[1] != "two" || order[2] != "three" {
+		t.Fatalf("Expected the messages of the chat in order, got %v", s.texts)
+	}
+	// 20 messages per second, with some slack for the scheduler
+	for i := 1; i < len(chat1); i++ {
+		if gap := chat1[i].Sub(chat1[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("Expected the messages of the chat 50ms apart, got %v", gap)
+		}
+	}
+	if stats := o.Stats(); stats.Sent != 4 || stats.Dead != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if err := o.Post(1, tgbotapi.NewMessage(1, "late"), ""); err != errOutboxStopped {
+		t.Errorf("Expected errOutboxStopped, got %v", err)
+	}
+}
+
+func Test_outbox_Retry(t *testing.T) {
+	flood := tgbotapi.Error{Message: "Too Many Requests: retry after 1", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+	s := &testSender{errs: []error{flood}}
+	o := newOutbox(testOutboxParameters, s.send)
+	defer o.Shutdown(context.Background())
+
+	start := time.Now()
+	if _, err := o.Deliver(1, tgbotapi.NewMessage(1, "hello")); err != nil {
+		t.Fatalf("Expected the message to be retried, got %v", err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("Expected retry_after to be honoured, retried after %v", waited)
+	}
+
+	// Other errors returned by Telegram aren't retried
+	blocked := tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}
+	s.mutex.Lock()
+	s.errs = []error{blocked}
+	s.mutex.Unlock()
+	if _, err := o.Deliver(1, tgbotapi.NewMessage(1, "hello")); err != blocked {
+		t.Errorf("Expected the error of Telegram, got %v", err)
+	}
+	if stats := o.Stats(); stats.Sent != 1 || stats.Retried != 1 || stats.Dead != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func Test_outbox_Coalesce(t *testing.T) {
+	s := &testSender{before: make(chan struct{})}
+	o := newOutbox(testOutboxParameters, s.send)
+
+	// The first message is being sent while the others wait
+	for _, text := range []string{"busy", "node down", "node down", "node up", "node down"} {
+		if err := o.Post(1, tgbotapi.NewMessage(1, text), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(s.before)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := o.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.texts) != 3 || s.texts[1] != "node down" || s.texts[2] != "node up" {
+		t.Errorf("Expected the repeated status messages to be coalesced, got %v", s.texts)
+	}
+	if stats := o.Stats(); stats.Coalesced != 2 {
+		t.Errorf("Expected 2 coalesced messages, got %+v", stats)
+	}
+}
+
+func Test_outbox_Shutdown(t *testing.T) {
+	params := testOutboxParameters
+	params.ChatPerSec = 0.001
+	s := &testSender{}
+	o := newOutbox(params, s.send)
+
+	for _, text := range []string{"sent", "dead"} {
+		if err := o.Post(1, tgbotapi.NewMessage(1, text), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := o.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if stats := o.Stats(); stats.Sent != 1 || stats.Dead != 1 {
+		t.Errorf("Expected the waiting message to be dead, got %+v", stats)
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	network := &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: errors.New("connection refused")}
+	tests := []struct {
+		err      error
+		attempts int
+		delay    time.Duration
+		retry    bool
+	}{
+		{tgbotapi.Error{Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}, 1, 7 * time.Second, true},
+		{tgbotapi.Error{Message: "Bad Request: chat not found"}, 1, 0, false},
+		{errors.New("Too Many Requests: retry after 12"), 1, 12 * time.Second, true},
+		{network, 1, time.Second, true},
+		{network, 3, 4 * time.Second, true},
+		{network, 10, outboxMaxBackoff, true},
+		{errors.New("Bad Request: file is too big"), 1, 0, false},
+	}
+	for i, tc := range tests {
+		if delay, retry := retryDelay(tc.err, tc.attempts); delay != tc.delay || retry != tc.retry {
+			t.Errorf("%d: retryDelay(%v) expected %v, %v, got %v, %v", i, tc.err, tc.delay, tc.retry, delay, retry)
+		}
+	}
+}
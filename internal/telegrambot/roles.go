@@ -0,0 +1,52 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"errors"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// errCommandNotFound is returned by handleCommand for unknown commands
+	errCommandNotFound = errors.New("command not found")
+	// errNotPermitted is returned by handleCommand when the role of the user doesn't permit the command
+	errNotPermitted = errors.New("command not permitted")
+)
+
+// isOwner reports whether the Telegram user owns the Bot. The owner is the user of the
+// private chat configured by chatid in the [telegram] section of config.toml (the ID of a
+// private chat is the ID of the user), and is always an admin.
+func (bot *Bot) isOwner(telegramID int) bool {
+	return bot.config.Telegram.ChatID != 0 && int64(telegramID) == bot.config.Telegram.ChatID
+}
+
+// loadMember records activity by the user interacting with the Bot and sets their stored role.
+// New users are given the user role, except for the owner who is made an admin.
+func (bot *Bot) loadMember(ctx *BotContext) error {
+	storectx := ctx.Context()
+	m := &store.Member{
+		TelegramID: ctx.User.ID,
+		UserName:   ctx.User.UserName,
+		FirstName:  ctx.User.FirstName,
+	}
+	if err := bot.store.Members().Touch(storectx, m); err != nil {
+		return err
+	}
+
+	if bot.isOwner(m.TelegramID) && m.Role != store.RoleAdmin {
+		if err := bot.store.Members().SetRole(storectx, m.TelegramID, store.RoleAdmin); err != nil {
+			return err
+		}
+		log.Infof("Bot.loadMember: %s owns the Bot and has been made an admin", ctx.User.NameAndTags())
+		m.Role = store.RoleAdmin
+	}
+
+	ctx.User.Role = m.Role
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+)
+
+func Test_loadMember(t *testing.T) {
+	bot := &Bot{
+		config: wcconfig.Config{Telegram: wcconfig.TelegramParameters{ChatID: 1001}},
+		store:  store.NewMemoryStore(),
+	}
+
+	// The owner is made an admin
+	ctx := &BotContext{User: &User{ID: 1001, UserName: "owner"}}
+	if err := bot.loadMember(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.User.Role != store.RoleAdmin {
+		t.Errorf("Expected the owner to be an admin, got %q", ctx.User.Role)
+	}
+
+	// Everyone else starts as a user
+	ctx = &BotContext{User: &User{ID: 1002, UserName: "alice"}}
+	if err := bot.loadMember(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.User.Role != store.RoleUser {
+		t.Errorf("Expected a user, got %q", ctx.User.Role)
+	}
+
+	// Roles are keyed by Telegram ID, a changed username keeps the role
+	if err := bot.store.Members().SetRole(context.Background(), 1002, store.RoleBanned); err != nil {
+		t.Fatal(err)
+	}
+	ctx = &BotContext{User: &User{ID: 1002, UserName: "owner"}}
+	if err := bot.loadMember(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.User.Role != store.RoleBanned {
+		t.Errorf("Expected banned, got %q", ctx.User.Role)
+	}
+}
+
+func Test_handleCommand_Permissions(t *testing.T) {
+	var ran []string
+	handler := func(bot *Bot, ctx *BotContext, command, args string) error {
+		ran = append(ran, command)
+		return nil
+	}
+	bot := &Bot{commandHandlers: map[string]Command{
+		"balance": {store.RoleUser, "balance", handler},
+		"status":  {store.RoleModerator, "status", handler},
+		"update":  {store.RoleAdmin, "update", handler},
+	}}
+
+	tests := []struct {
+		role    store.Role
+		command string
+		err     error
+	}{
+		{store.RoleUser, "balance", nil},
+		{store.RoleUser, "status", errNotPermitted},
+		{store.RoleModerator, "status", nil},
+		{store.RoleModerator, "update", errNotPermitted},
+		{store.RoleAdmin, "update", nil},
+		{store.RoleBanned, "balance", errNotPermitted},
+		{"", "balance", errNotPermitted},
+		{store.RoleAdmin, "unknown", errCommandNotFound},
+	}
+
+	for _, tc := range tests {
+		ran = nil
+		ctx := &BotContext{User: &User{ID: 1, Role: tc.role}}
+		err := bot.handleCommand(ctx, tc.command, "")
+		if err != tc.err {
+			t.Errorf("%q /%s: expected %v, got %v", tc.role, tc.command, tc.err, err)
+		}
+		if (err == nil) != (len(ran) == 1) {
+			t.Errorf("%q /%s: handler ran %v", tc.role, tc.command, ran)
+		}
+	}
+	ran = nil
+	if err := bot.handleCommand(&BotContext{}, "balance", ""); err != errNotPermitted {
+		t.Errorf("Unknown user /balance: expected %v, got %v", errNotPermitted, err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("Unknown user /balance: handler ran %v", ran)
+	}
+}
+
+func Test_privateCommand_Start(t *testing.T) {
+	start := Command{store.RoleAdmin, "start", (*Bot).handleCommandStart}
+	bot := &Bot{commandHandlers: map[string]Command{"start": start}}
+
+	tests := []struct {
+		role   store.Role
+		expect store.Role
+	}{
+		{store.RoleAdmin, store.RoleAdmin},
+		{store.RoleModerator, store.RoleUser},
+		{store.RoleUser, store.RoleUser},
+		{store.RoleBanned, store.RoleUser},
+	}
+	for _, tc := range tests {
+		cmd, found := bot.privateCommand(&User{ID: 1, Role: tc.role}, "start")
+		if !found || cmd.Role != tc.expect {
+			t.Errorf("%q /start: expected the %q command, got %q", tc.role, tc.expect, cmd.Role)
+		}
+	}
+}
@@ -6,11 +6,20 @@
 package telegrambot
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/BigOokie/skywire-wing-commander/internal/depositmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/keyvault"
+	"github.com/BigOokie/skywire-wing-commander/internal/skycoinapi"
+	"github.com/BigOokie/skywire-wing-commander/internal/skymanager"
 	"github.com/BigOokie/skywire-wing-commander/internal/skymgrmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
 	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
 	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
 	"github.com/cloudfoundry/jibber_jabber"
@@ -24,18 +33,51 @@ type Bot struct {
 	config                 wcconfig.Config
 	telegram               *tgbotapi.BotAPI
 	skyMgrMonitor          *skymgrmon.SkyManagerMonitor
-	commandHandlers        map[string]CommandHandler
-	adminCommandHandlers   map[string]CommandHandler
+	depositMonitor         *depositmon.DepositMonitor
+	wallet                 wallet.WalletBackend
+	store                  store.Store
+	vault                  *keyvault.Vault
+	seed                   string
+	callbackKey            []byte
+	limits                 limits
+	twoFactor              twoFactorState
+	twoFactorLargeTip      uint64
+	withdrawalLock         sync.Mutex
+	commandHandlers        map[string]Command
+	groupCommandHandlers   map[string]Command
 	privateMessageHandlers []MessageHandler
 	groupMessageHandlers   []MessageHandler
 	gaclient               *ga.Client
+	webhook                *webhookServer
+	workers                *updateWorkers
+	outbox                 *outbox
+	handlerContext         context.Context
+	cancelHandlers         func()
+	monitorContext         context.Context
+	cancelMonitors         func()
+	monitors               sync.WaitGroup
 }
 
-// BotContext provides context for Bot Messages
+// BotContext provides context for Bot Messages. twoFactorVerified is set once the
+// user has entered the two-factor code of the command (see needsTwoFactor). The
+// callback query is answered with callbackAnswer (see SetCallbackAnswer). The
+// handler of the update must finish before context is done (see Context).
 type BotContext struct {
-	message *tgbotapi.Message
-	cbQuery *tgbotapi.CallbackQuery
-	User    *User
+	message           *tgbotapi.Message
+	cbQuery           *tgbotapi.CallbackQuery
+	User              *User
+	twoFactorVerified bool
+	callbackAnswer    *callbackAnswer
+	context           context.Context
+}
+
+// Context returns the context of the handler of the update, which is cancelled when
+// the handler times out. Store and wallet calls made by handlers use it.
+func (ctx *BotContext) Context() context.Context {
+	if ctx == nil || ctx.context == nil {
+		return context.Background()
+	}
+	return ctx.context
 }
 
 // IsCallBackQuery will evaluate the BotContext and determine if it is a CallBackQueyr or not
@@ -54,14 +96,14 @@ type CommandHandler func(*Bot, *BotContext, string, string) error
 // MessageHandler provides an interface specification for message handlers
 type MessageHandler func(*Bot, *BotContext, string) (bool, error)
 
-// User is a structure to model the Telegram Bot user that is being interacted with
+// User is a structure to model the Telegram Bot user that is being interacted with.
+// The Role is loaded from the store (see loadMember) before commands are handled.
 type User struct {
-	ID        int    `json:"id"`
-	UserName  string `db:"username" json:"username,omitempty"`
-	FirstName string `db:"first_name" json:"first_name,omitempty"`
-	LastName  string `db:"last_name" json:"last_name,omitempty"`
-	Banned    bool   `json:"banned"`
-	Admin     bool   `json:"admin"`
+	ID        int        `json:"id"`
+	UserName  string     `db:"username" json:"username,omitempty"`
+	FirstName string     `db:"first_name" json:"first_name,omitempty"`
+	LastName  string     `db:"last_name" json:"last_name,omitempty"`
+	Role      store.Role `json:"role"`
 
 	//exists bool
 }
@@ -69,11 +111,8 @@ type User struct {
 // NameAndTags is a helper function to append namess and tags to users within the group
 func (u *User) NameAndTags() string {
 	var tags []string
-	if u.Banned {
-		tags = append(tags, "banned")
-	}
-	if u.Admin {
-		tags = append(tags, "admin")
+	if u.Role != "" && u.Role != store.RoleUser {
+		tags = append(tags, string(u.Role))
 	}
 
 	// If username is hidden use userid
@@ -89,106 +128,69 @@ func (u *User) NameAndTags() string {
 	return identifier
 }
 
-/*
-// Exists determines if the current user exists or not
-func (u *User) Exists() bool {
-	return u.exists
-}
-*/
-
-/*
-func (bot *Bot) enableUser(u *User) ([]string, error) {
-	var actions []string
-	if !u.Exists() {
-		actions = append(actions, "created")
-	}
-	if u.Banned {
-		u.Banned = false
-		actions = append(actions, "unbanned")
-	}
-	//if !u.Enlisted {
-	//	u.Enlisted = true
-	//	actions = append(actions, "enlisted")
-	//}
-	//if len(actions) > 0 {
-	//	if err := bot.db.PutUser(u); err != nil {
-	//		return nil, fmt.Errorf("failed to change user status: %v", err)
-	//	}
-	//}
-	return actions, nil
-}
-*/
-
-/*
-func (bot *Bot) handleForwardedMessageFrom(ctx *Context, id int) error {
-	args := tgbotapi.ChatConfigWithUser{bot.config.ChatID, "", id}
-	member, err := bot.telegram.GetChatMember(args)
-	if err != nil {
-		return fmt.Errorf("failed to get chat member from telegram: %v", err)
-	}
-
-	if !member.IsMember() && !member.IsCreator() && !member.IsAdministrator() {
-		return bot.Reply(ctx, "that user is not a member of the chat")
+// handleCommand runs the handler of a private chat command. errCommandNotFound is returned
+// for unknown commands and errNotPermitted if the role of the user doesn't permit the command.
+func (bot *Bot) handleCommand(ctx *BotContext, command, args string) error {
+	cmd, found := bot.privateCommand(ctx.User, command)
+	if !found {
+		return errCommandNotFound
 	}
+	return bot.runCommand(ctx, cmd, command, args)
+}
 
-	user := member.User
-	log.Printf("forwarded from user: %#v", user)
-	dbuser := bot.db.GetUser(user.ID)
-	if dbuser == nil {
-		dbuser = &User{
-			ID:        user.ID,
-			UserName:  user.UserName,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-		}
+// privateCommand returns the private chat command run for the user. Users who can't start
+// monitoring get the welcome instead of /start.
+func (bot *Bot) privateCommand(user *User, command string) (Command, bool) {
+	cmd, found := bot.commandHandlers[command]
+	if found && command == welcomeCommand.Command && user != nil && !user.Role.AtLeast(cmd.Role) {
+		return welcomeCommand, true
 	}
-
-	return bot.enableUserVerbosely(ctx, dbuser)
+	return cmd, found
 }
-*/
 
-func (bot *Bot) handleCommand(ctx *BotContext, command, args string) error {
-	if !ctx.User.Banned {
-		handler, found := bot.commandHandlers[command]
-		if found {
-			return handler(bot, ctx, command, args)
-		}
+// runCommand runs the handler of cmd if the role of the user permits it. Commands which
+// need a two-factor code wait for the user to send it with /2fa.
+func (bot *Bot) runCommand(ctx *BotContext, cmd Command, command, args string) error {
+	if ctx.User == nil {
+		log.Infof("Bot.runCommand: /%s is not permitted for an unknown user", command)
+		return errNotPermitted
 	}
-
-	if ctx.User.Admin {
-		handler, found := bot.adminCommandHandlers[command]
-		if found {
-			return handler(bot, ctx, command, args)
-		}
+	if !ctx.User.Role.AtLeast(cmd.Role) {
+		log.Infof("Bot.runCommand: /%s is not permitted for %s", command, ctx.User.NameAndTags())
+		return errNotPermitted
 	}
-
-	return fmt.Errorf("Command not found: %s", command)
+	if bot.needsTwoFactor(ctx, cmd, command, args) {
+		return bot.requestTwoFactor(ctx, cmd, command, args)
+	}
+	return cmd.Handlerfunc(bot, ctx, command, args)
 }
 
 func (bot *Bot) handlePrivateMessage(ctx *BotContext) error {
-	/*
-		if ctx.User.Admin {
-			// let admin force add users by forwarding their messages
-			if u := ctx.message.ForwardFrom; u != nil {
-				if err := bot.handleForwardedMessageFrom(ctx, u.ID); err != nil {
-					return fmt.Errorf("failed to add user %s: %v", u.String(), err)
-				}
-				return nil
-			}
+	// let admins add users by forwarding their messages. Like the other admin
+	// commands it needs a two-factor code
+	if forward, err := bot.isAddUserForward(ctx); err != nil {
+		return err
+	} else if forward {
+		if err := bot.runCommand(ctx, addUserCommand, addUserCommand.Command, ""); err != nil {
+			return fmt.Errorf("failed to add user %s: %v", ctx.message.ForwardFrom.String(), err)
 		}
-	*/
+		return nil
+	}
 	if ctx.message.IsCommand() {
 		cmd, args := ctx.message.Command(), ctx.message.CommandArguments()
 		err := bot.handleCommand(ctx, cmd, args)
-		if err != nil {
+		switch err {
+		case errCommandNotFound:
 			errmsg := fmt.Sprintf("Sorry,'/%s' is an unknown command.\n\n%s", cmd, wcconst.MsgHelpShort)
 
 			//log.Debugf("Command: '/%s %s' failed: %v", cmd, args, err)
-			log.Debugf(errmsg)
+			log.Debug(errmsg)
 			//return bot.Reply(ctx, "markdown", fmt.Sprintf("Command failed: %v", err))
 			return bot.Reply(ctx, "markdown", errmsg)
+		case errNotPermitted:
+			return bot.Reply(ctx, "markdown", fmt.Sprintf(wcconst.MsgNotPermitted, cmd))
 		}
-		return nil
+		return err
 	}
 
 	for i := len(bot.privateMessageHandlers) - 1; i >= 0; i-- {
@@ -251,12 +253,13 @@ func (bot *Bot) handleUserLeft(ctx *Context, user *tgbotapi.User) error {
 }
 */
 
-/*
+// removeMyName removes mentions of the Bot from the text of a group message.
+// The returned bool reports whether the Bot was mentioned.
 func (bot *Bot) removeMyName(text string) (string, bool) {
 	var removed bool
 	var words []string
 	for _, word := range strings.Fields(text) {
-		if word == "@"+bot.telegram.Self.UserName {
+		if strings.EqualFold(word, "@"+bot.telegram.Self.UserName) {
 			removed = true
 			continue
 		}
@@ -264,9 +267,8 @@ func (bot *Bot) removeMyName(text string) (string, bool) {
 	}
 	return strings.Join(words, " "), removed
 }
-*/
 
-/*
+// isReplyToMe reports whether the message is a reply to a message sent by the Bot
 func (bot *Bot) isReplyToMe(ctx *BotContext) bool {
 	if re := ctx.message.ReplyToMessage; re != nil {
 		if u := re.From; u != nil {
@@ -277,45 +279,58 @@ func (bot *Bot) isReplyToMe(ctx *BotContext) bool {
 	}
 	return false
 }
-*/
 
-/*
+// handleGroupMessage handles messages in the group chats where tipping is enabled.
+// Only the group commands are accepted, everything else is ignored unless the Bot
+// is mentioned or replied to.
 func (bot *Bot) handleGroupMessage(ctx *BotContext) error {
-	var gerr error
+	if ctx.User == nil {
+		return nil
+	}
 
-		if u := ctx.message.NewChatMembers; u != nil {
-			for _, user := range *u {
-				if err := bot.handleUserJoin(ctx, &user); err != nil {
-					gerr = err
-				}
-			}
+	if ctx.message.IsCommand() {
+		if !bot.isCommandForMe(ctx) {
+			return nil
 		}
-
-		if u := ctx.message.LeftChatMember; u != nil {
-			if err := bot.handleUserLeft(ctx, u); err != nil {
-				gerr = err
-			}
+		cmd, args := ctx.message.Command(), ctx.message.CommandArguments()
+		command, found := bot.groupCommandHandlers[cmd]
+		if !found {
+			// Don't clutter the group with errors for commands meant for someone else
+			log.Debugf("Bot.handleGroupMessage: Ignoring group command /%s", cmd)
+			return nil
+		}
+		if err := bot.loadMember(ctx); err != nil {
+			return err
 		}
+		err := bot.runCommand(ctx, command, cmd, args)
+		if err == errNotPermitted {
+			// Banned users are ignored
+			return nil
+		}
+		return err
+	}
 
-	if ctx.User != nil {
-		msgWithoutName, mentioned := bot.removeMyName(ctx.message.Text)
-
-		if mentioned || bot.isReplyToMe(ctx) {
-			for i := len(bot.groupMessageHandlers) - 1; i >= 0; i-- {
-				handler := bot.groupMessageHandlers[i]
-				next, err := handler(bot, ctx, msgWithoutName)
-				if err != nil {
-					return fmt.Errorf("group message handler failed: %v", err)
-				}
-				if !next {
-					break
-				}
+	msgWithoutName, mentioned := bot.removeMyName(ctx.message.Text)
+	if mentioned || bot.isReplyToMe(ctx) {
+		if err := bot.loadMember(ctx); err != nil {
+			return err
+		}
+		if ctx.User.Role == store.RoleBanned {
+			return nil
+		}
+		for i := len(bot.groupMessageHandlers) - 1; i >= 0; i-- {
+			handler := bot.groupMessageHandlers[i]
+			next, err := handler(bot, ctx, msgWithoutName)
+			if err != nil {
+				return fmt.Errorf("group message handler failed: %v", err)
+			}
+			if !next {
+				break
 			}
 		}
 	}
-	return gerr
+	return nil
 }
-*/
 
 // SendReplyInlineKeyboard will send a reply using the provided inline keyboard
 func (bot *Bot) SendReplyInlineKeyboard(ctx *BotContext, kb tgbotapi.InlineKeyboardMarkup, text string) error {
@@ -332,9 +347,31 @@ func (bot *Bot) SendReplyInlineKeyboard(ctx *BotContext, kb tgbotapi.InlineKeybo
 		msg = tgbotapi.NewMessage(int64(ctx.message.From.ID), text)
 	}
 	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = kb
 
-	_, err := bot.telegram.Send(msg)
+	var err error
+	if msg.ReplyMarkup, err = bot.signKeyboard(kb); err != nil {
+		return err
+	}
+	return bot.deliver(msg.ChatID, msg)
+}
+
+// EditMessage will replace the text and inline keyboard of the message of a callback query.
+// The keyboard is removed if kb is nil. Editing a message without changing it (i.e. pressing
+// a refresh button twice) isn't an error.
+func (bot *Bot) EditMessage(ctx *BotContext, kb *tgbotapi.InlineKeyboardMarkup, text string) error {
+	edit := tgbotapi.NewEditMessageText(ctx.message.Chat.ID, ctx.message.MessageID, text)
+	edit.ParseMode = "Markdown"
+	if kb != nil {
+		signed, err := bot.signKeyboard(*kb)
+		if err != nil {
+			return err
+		}
+		edit.ReplyMarkup = &signed
+	}
+	err := bot.deliver(edit.ChatID, edit)
+	if tgerr, ok := err.(tgbotapi.Error); ok && strings.Contains(tgerr.Message, "message is not modified") {
+		return nil
+	}
 	return err
 }
 
@@ -342,17 +379,38 @@ func (bot *Bot) SendReplyInlineKeyboard(ctx *BotContext, kb tgbotapi.InlineKeybo
 // The mode, format and text parameters are used to constuct the message and
 // determine its format and delivery
 func (bot *Bot) Send(ctx *BotContext, mode, format, text string) error {
+	msg, err := bot.newMessage(ctx, mode, format, text)
+	if err != nil {
+		return err
+	}
+	return bot.deliver(msg.ChatID, msg)
+}
+
+// PostStatus will queue a status message (see Send) without waiting for it to be sent.
+// A status message with the same text still waiting to be sent is only sent once.
+func (bot *Bot) PostStatus(ctx *BotContext, mode, format, text string) error {
+	msg, err := bot.newMessage(ctx, mode, format, text)
+	if err != nil {
+		return err
+	}
+	return bot.outbox.Post(msg.ChatID, msg, text)
+}
+
+// newMessage constructs the message sent by Send
+func (bot *Bot) newMessage(ctx *BotContext, mode, format, text string) (tgbotapi.MessageConfig, error) {
 	var msg tgbotapi.MessageConfig
 	switch mode {
 	case "whisper":
-		msg = tgbotapi.NewMessage(int64(ctx.message.From.ID), text)
+		// The message of a callback query was sent by the Bot itself,
+		// so the user is taken from the context rather than the message
+		msg = tgbotapi.NewMessage(int64(ctx.User.ID), text)
 	case "reply":
 		msg = tgbotapi.NewMessage(ctx.message.Chat.ID, text)
 		msg.ReplyToMessageID = ctx.message.MessageID
 	case "yell":
 		msg = tgbotapi.NewMessage(bot.config.Telegram.ChatID, text)
 	default:
-		return fmt.Errorf("unsupported message mode: %s", mode)
+		return msg, fmt.Errorf("unsupported message mode: %s", mode)
 	}
 	switch format {
 	case "markdown":
@@ -362,10 +420,27 @@ func (bot *Bot) Send(ctx *BotContext, mode, format, text string) error {
 	case "text":
 		msg.ParseMode = ""
 	default:
-		return fmt.Errorf("unsupported message format: %s", format)
+		return msg, fmt.Errorf("unsupported message format: %s", format)
 	}
-	_, err := bot.telegram.Send(msg)
-	return err
+	return msg, nil
+}
+
+// SendDocument will upload data as a document named name to the user of the BotContext.
+// The caption is formatted as markdown.
+func (bot *Bot) SendDocument(ctx *BotContext, name string, data []byte, caption string) error {
+	doc := tgbotapi.NewDocumentUpload(int64(ctx.User.ID), tgbotapi.FileBytes{Name: name, Bytes: data})
+	doc.Caption = caption
+	doc.ParseMode = "Markdown"
+	return bot.deliver(doc.ChatID, doc)
+}
+
+// SendPhoto will upload data as a photo named name to the user of the BotContext.
+// The caption is formatted as markdown.
+func (bot *Bot) SendPhoto(ctx *BotContext, name string, data []byte, caption string) error {
+	photo := tgbotapi.NewPhotoUpload(int64(ctx.User.ID), tgbotapi.FileBytes{Name: name, Bytes: data})
+	photo.Caption = caption
+	photo.ParseMode = "Markdown"
+	return bot.deliver(photo.ChatID, photo)
 }
 
 /*
@@ -395,22 +470,34 @@ func (bot *Bot) ReplyAboutEvent(ctx *Context, text string, event *Event) error {
 }
 */
 
-/*
+// Ask will send a question (formatted as markdown) to the user of the BotContext in their
+// private chat, and have their Telegram client open a reply to it. The reply is handled
+// by the step of the conversation registered with Expect.
 func (bot *Bot) Ask(ctx *BotContext, text string) error {
-	msg := tgbotapi.NewMessage(ctx.message.Chat.ID, text)
+	msg := tgbotapi.NewMessage(int64(ctx.User.ID), text)
+	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = tgbotapi.ForceReply{
 		ForceReply: true,
 		Selective:  true,
 	}
-	msg.ReplyToMessageID = ctx.message.MessageID
-	_, err := bot.telegram.Send(msg)
+	if ctx.IsUserMessage() && ctx.message.Chat.IsPrivate() {
+		msg.ReplyToMessageID = ctx.message.MessageID
+	}
+	err := bot.deliver(msg.ChatID, msg)
+	if err != nil {
+		logSendError("Bot.Ask", err)
+	}
 	return err
 }
-*/
 
 // SendNewMessage will send a new message without requiring a BotContext.
 func (bot *Bot) SendNewMessage(format, text string) error {
-	msg := tgbotapi.NewMessage(bot.config.Telegram.ChatID, text)
+	return bot.SendToChat(bot.config.Telegram.ChatID, format, text)
+}
+
+// SendToChat will send a new message to the specified chat (i.e. a direct message to a user)
+func (bot *Bot) SendToChat(chatID int64, format, text string) error {
+	msg := tgbotapi.NewMessage(chatID, text)
 
 	switch format {
 	case "markdown":
@@ -422,7 +509,12 @@ func (bot *Bot) SendNewMessage(format, text string) error {
 	default:
 		return fmt.Errorf("unsupported message format: %s", format)
 	}
-	_, err := bot.telegram.Send(msg)
+	return bot.deliver(chatID, msg)
+}
+
+// deliver sends the message to the chat through the outbox, within the rate limits of Telegram
+func (bot *Bot) deliver(chatID int64, c tgbotapi.Chattable) error {
+	_, err := bot.outbox.Deliver(chatID, c)
 	return err
 }
 
@@ -433,57 +525,67 @@ func (bot *Bot) Reply(ctx *BotContext, format, text string) error {
 }
 
 func (bot *Bot) handleMessage(ctx *BotContext) error {
-	// Check to ensure the User sending the message is registered in the Bots config
-	// as the Admin user. Ignore any message or command from anyone else
-	// Fixed #10
-	if fmt.Sprintf("@%s", ctx.message.Chat.UserName) != bot.config.Telegram.Admin {
-		log.Debugf("Bot.handleMessage: Ignoring message from non-owner user chat %d (%s)", ctx.message.Chat.ID, "@"+ctx.message.Chat.UserName)
-		return nil
+	// Group chats are only handled if tipping has been enabled for them
+	if ctx.message.Chat.IsGroup() || ctx.message.Chat.IsSuperGroup() {
+		if !bot.isTippingGroup(ctx.message.Chat.ID) {
+			log.Debugf("Bot.handleMessage: Ignoring message from group chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.Title)
+			return nil
+		}
+		log.Debug("Bot.handleMessage: handleGroupMessage")
+		return bot.handleGroupMessage(ctx)
 	}
 
 	// If this is NOT a prive chat then DONT respond
-	if !ctx.message.Chat.IsPrivate() {
+	if !ctx.message.Chat.IsPrivate() || ctx.User == nil {
 		log.Debugf("Bot.handleMessage: Unknown chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.UserName)
 		return nil
 	}
 
+	// Banned users are ignored
+	if err := bot.loadMember(ctx); err != nil {
+		return err
+	}
+	if ctx.User.Role == store.RoleBanned {
+		log.Debugf("Bot.handleMessage: Ignoring message from banned user %s", ctx.User.NameAndTags())
+		return nil
+	}
+
 	log.Debug("Bot.handleMessage: handlePrivateMessage")
 	return bot.handlePrivateMessage(ctx)
-
-	/*
-		if (ctx.message.Chat.IsGroup() || ctx.message.Chat.IsSuperGroup()) && ctx.message.Chat.ID == bot.config.Telegram.ChatID {
-			log.Debug("Bot.handleMessage - handleGroupMessage")
-			//return bot.handleGroupMessage(ctx)
-			log.Printf("unknown chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.UserName)
-			return nil
-		} else if ctx.message.Chat.IsPrivate() {
-			log.Debug("Bot.handleMessage - handlePrivateMessage")
-			return bot.handlePrivateMessage(ctx)
-		} else {
-			log.Debugf("unknown chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.UserName)
-			return nil
-		}
-	*/
 }
 
 func (bot *Bot) handleCallbackQuery(ctx *BotContext) error {
-	// Check to ensure the User sending the message is registered in the Bots config
-	// as the Admin user. Ignore any message or command from anyone else
-	// Fixed #10
-	if fmt.Sprintf("@%s", ctx.message.Chat.UserName) != bot.config.Telegram.Admin {
-		log.Debugf("Bot.handleCallbackQuery: Ignoring message from non-owner user chat %d (%s)", ctx.message.Chat.ID, "@"+ctx.message.Chat.UserName)
+	// If this is NOT a prive chat then DONT respond
+	if !ctx.message.Chat.IsPrivate() || ctx.User == nil {
+		log.Debugf("Bot.handleCallbackQuery: Unknown chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.UserName)
 		return nil
 	}
 
-	// If this is NOT a prive chat then DONT respond
-	if !ctx.message.Chat.IsPrivate() {
-		log.Debugf("Bot.handleCallbackQuery: Unknown chat %d (%s)", ctx.message.Chat.ID, ctx.message.Chat.UserName)
+	// Banned users are ignored
+	if err := bot.loadMember(ctx); err != nil {
+		return err
+	}
+	if ctx.User.Role == store.RoleBanned {
+		log.Debugf("Bot.handleCallbackQuery: Ignoring callback from banned user %s", ctx.User.NameAndTags())
 		return nil
 	}
 
 	//log.Debug("Bot.handleMessage: handlePrivateMessage")
 	//return bot.handlePrivateMessage(ctx)
-	return bot.handleCommand(ctx, ctx.cbQuery.Data, "")
+
+	// The callback data is the signed command, optionally followed by its arguments (i.e. "users 2")
+	cb, err := decodeCallback(bot.callbackKey, ctx.cbQuery.Data, time.Now())
+	if err != nil {
+		log.Debugf("Bot.handleCallbackQuery: Ignoring callback %q from %s: %v", ctx.cbQuery.Data, ctx.User.NameAndTags(), err)
+		ctx.SetCallbackAnswer(wcconst.MsgCallbackExpired, true)
+		return nil
+	}
+	err = bot.handleCommand(ctx, cb.Command, cb.Args)
+	if err == errNotPermitted {
+		ctx.SetCallbackAnswer(fmt.Sprintf(wcconst.MsgCallbackNotPermitted, cb.Command), true)
+		return nil
+	}
+	return err
 }
 
 // initGAClient will initialise the GA client and send the first event
@@ -523,28 +625,115 @@ func (bot *Bot) SendGAEvent(category, action, label string) {
 
 // NewBot will create a new instance of a Bot struct based on the passed Config structure
 // which supplies runtime configuration for the bot.
-func NewBot(config wcconfig.Config) (*Bot, error) {
+func NewBot(config wcconfig.Config) (_ *Bot, err error) {
 	var bot = Bot{
 		config:               wcconfig.Config{},
-		commandHandlers:      make(map[string]CommandHandler),
-		adminCommandHandlers: make(map[string]CommandHandler),
+		commandHandlers:      make(map[string]Command),
+		groupCommandHandlers: make(map[string]Command),
 	}
 	bot.config = config
-	var err error
 
 	if config.WingCommander.AnalyticsEnabled {
 		bot.initGAClient()
 	}
 
 	bot.skyMgrMonitor = skymgrmon.NewMonitor(config.SkyManager.Address, config.SkyManager.DiscoveryAddress)
+	bot.skyMgrMonitor.SetManagerClient(skymanager.NewClient(config.SkyManager.Address, config.SkyManager.Password,
+		config.SkyManager.TimeoutSec))
+	for _, name := range config.Monitor.MutedEvents {
+		kind, err := skymgrmon.ParseEventKind(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid monitor mutedevents %q: %v", name, err)
+		}
+		bot.skyMgrMonitor.SetMuted(kind, true)
+	}
+
+	switch config.Wallet.Backend {
+	case "cli":
+		bot.wallet = wallet.NewCLIBackend(config.Wallet.CLIPath, config.Wallet.CLITimeoutSec)
+	case "node", "":
+		bot.wallet = wallet.NewNodeBackend(skycoinapi.NewClient(config.SkycoinNode.Address, config.SkycoinNode.TimeoutSec))
+	default:
+		return nil, fmt.Errorf("Unsupported wallet backend: %s", config.Wallet.Backend)
+	}
+
+	if bot.limits, err = parseLimits(config.Limits); err != nil {
+		return nil, fmt.Errorf("Invalid limits: %v", err)
+	}
+
+	if bot.twoFactorLargeTip, err = parseLimitValue("largetip", config.TwoFactor.LargeTip); err != nil {
+		return nil, fmt.Errorf("Invalid twofactor largetip %q: %v", config.TwoFactor.LargeTip, err)
+	}
+	if config.WingCommander.TwoFactorEnabled && config.TwoFactor.MaxFailures < 1 {
+		return nil, fmt.Errorf("Invalid twofactor maxfailures %d: must be at least 1", config.TwoFactor.MaxFailures)
+	}
+
+	if t := config.Telegram; t.Workers < 1 || t.QueueSize < 1 || t.ChatQueueSize < 1 {
+		return nil, fmt.Errorf("Invalid telegram workers %d, queuesize %d or chatqueuesize %d: must be at least 1",
+			t.Workers, t.QueueSize, t.ChatQueueSize)
+	}
+
+	if o := config.Telegram.Outbox; o.GlobalPerSec <= 0 || o.ChatPerSec <= 0 || o.GroupPerMin <= 0 || o.Burst < 1 ||
+		o.MaxAttempts < 1 || o.QueueSize < 1 {
+		return nil, fmt.Errorf("Invalid telegram outbox: the rates, burst, maxattempts and queuesize must be positive")
+	}
+
+	if wh := config.Telegram.Webhook; wh.Enabled {
+		if err = checkWebhookParameters(wh); err != nil {
+			return nil, fmt.Errorf("Invalid telegram webhook: %v", err)
+		}
+		bot.webhook = newWebhookServer(wh.Listen, wh.Path, wh.SecretToken)
+	}
+
+	masterKey, err := keyvault.LoadMasterKey(config.Wallet.MasterKeyEnv, config.Wallet.MasterKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load master key: %v", err)
+	}
+	if bot.vault, err = keyvault.New(masterKey); err != nil {
+		return nil, fmt.Errorf("Failed to load master key: %v", err)
+	}
+	bot.callbackKey = bot.vault.DeriveKey(callbackKeyPurpose)
+
+	if bot.store, err = store.Open(config.SQLdatabase); err != nil {
+		return nil, fmt.Errorf("Failed to open database: %v", err)
+	}
+	// Close the database if the Bot can't be created
+	defer func() {
+		if err != nil {
+			bot.store.Close()
+		}
+	}()
+
+	// Bring the database schema up to date. This refuses to start if the
+	// database has been migrated by a newer version of Wing Commander
+	if _, err = bot.store.Migrate(context.Background()); err != nil {
+		return nil, fmt.Errorf("Failed to migrate database: %v", err)
+	}
+
+	// Encrypt any secret keys stored in plaintext by earlier versions. This
+	// also checks the configured master key matches the stored keys
+	sealed, err := keyvault.SealSecretKeys(context.Background(), bot.store.Addresses(), bot.vault)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt stored secret keys: %v", err)
+	}
+	if sealed > 0 {
+		log.Infof("Encrypted %d secret key(s) stored in plaintext", sealed)
+	}
+
+	if bot.seed, err = bot.loadWalletSeed(context.Background()); err != nil {
+		return nil, fmt.Errorf("Failed to load wallet seed: %v", err)
+	}
+
+	bot.depositMonitor = depositmon.NewMonitor(bot.store, bot.wallet, config.Deposits.Confirmations)
 
 	if bot.telegram, err = tgbotapi.NewBotAPI(config.Telegram.APIKey); err != nil {
 		return nil, fmt.Errorf("Failed to initialize Telegram API: %v", err)
 	}
 
 	bot.telegram.Debug = config.Telegram.Debug
+	bot.outbox = newOutbox(config.Telegram.Outbox, bot.telegram.Send)
 
-	chat, err := bot.telegram.GetChat(tgbotapi.ChatConfig{config.Telegram.ChatID, ""})
+	chat, err := bot.telegram.GetChat(tgbotapi.ChatConfig{ChatID: config.Telegram.ChatID})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get chat info from Telegram: %v", err)
 	}
@@ -557,10 +746,16 @@ func NewBot(config wcconfig.Config) (*Bot, error) {
 	log.Printf("Bot Chat: %s %d %s", chat.Type, chat.ID, chat.Title)
 
 	bot.setCommandHandlers()
+
+	// Updates are handled in the background, so a slow handler doesn't hold up other chats.
+	// The handlers still running when the Bot has to stop are cancelled (see shutdown)
+	bot.handlerContext, bot.cancelHandlers = context.WithCancel(context.Background())
+	bot.workers = newUpdateWorkers(config.Telegram.Workers, config.Telegram.QueueSize,
+		config.Telegram.ChatQueueSize, bot.processUpdate)
 	return &bot, nil
 }
 
-func (bot *Bot) handleUpdate(update *tgbotapi.Update) error {
+func (bot *Bot) handleUpdate(parent context.Context, update *tgbotapi.Update) error {
 	log.Debugln("Bot.handleUpdate: Start")
 	defer log.Debugln("Bot.handleUpdate: End")
 	var err error
@@ -574,13 +769,26 @@ func (bot *Bot) handleUpdate(update *tgbotapi.Update) error {
 
 	// Setup the bot context based on the type of message we are handling
 	if update.Message != nil {
-		ctx = BotContext{message: update.Message}
+		ctx = BotContext{message: update.Message, context: parent}
 	} else if update.CallbackQuery != nil {
 		ctx = BotContext{message: update.CallbackQuery.Message,
-			cbQuery: update.CallbackQuery}
+			cbQuery: update.CallbackQuery, callbackAnswer: &callbackAnswer{}, context: parent}
+	}
+	if ctx.message == nil {
+		log.Debugln("Bot.handleUpdate: Ignoring update without a message")
+		// i.e. the button of an inline mode message, which still has to be answered
+		bot.answerCallbackQuery(&ctx)
+		return err
 	}
 
-	if u := ctx.message.From; u != nil {
+	// The message of a callback query was sent by the Bot, so the
+	// user is taken from the callback query itself
+	from := ctx.message.From
+	if ctx.cbQuery != nil {
+		from = ctx.cbQuery.From
+	}
+
+	if u := from; u != nil {
 		ctx.User = &User{
 			ID:        u.ID,
 			UserName:  u.UserName,
@@ -592,7 +800,12 @@ func (bot *Bot) handleUpdate(update *tgbotapi.Update) error {
 	if update.CallbackQuery != nil {
 		log.Debugln("Bot.handleUpdate: handleCallbackQuery")
 		bot.SendGAEvent("BotMessageHandler", "CallbackQuery", "CallbackQuery Handler")
+		// Every callback query is answered, even if it failed or was ignored. Slow
+		// handlers are answered at callbackAnswerTimeout, while Telegram still accepts it
+		deadline := time.AfterFunc(callbackAnswerTimeout, func() { bot.answerCallbackQuery(&ctx) })
 		err = bot.handleCallbackQuery(&ctx)
+		deadline.Stop()
+		bot.answerCallbackQuery(&ctx)
 	} else {
 		log.Debugln("Bot.handleUpdate: handleMessage")
 		bot.SendGAEvent("BotMessageHandler", "Message", "Message Handler")
@@ -603,16 +816,32 @@ func (bot *Bot) handleUpdate(update *tgbotapi.Update) error {
 		log.Errorf("Bot.handleUpdate: Error %v", err)
 	}
 
-	log.Debugf("Bot.handleUpdate: SendMainMenuMessage")
-	//_ = bot.SendMainMenuMessage(&ctx)
+	// Show the menu to the user in private chats after /start. /menu sends it itself
+	if showsMainMenu(update) && ctx.User != nil && ctx.User.Role.AtLeast(store.RoleUser) {
+		log.Debugf("Bot.handleUpdate: SendMainMenuMessage")
+		if menuerr := bot.SendMainMenuMessage(&ctx); menuerr != nil {
+			logSendError("Bot.handleUpdate", menuerr)
+		}
+	}
 	return err
 }
 
-// SendMainMenuMessage will send a main menu message
+// showsMainMenu reports whether the main menu is sent after the update has been handled.
+// Only /start in a private chat shows it, so the menu isn't sent after every command or button.
+func showsMainMenu(update *tgbotapi.Update) bool {
+	msg := update.Message
+	return update.CallbackQuery == nil && msg != nil && msg.Chat != nil && msg.Chat.IsPrivate() &&
+		msg.IsCommand() && msg.Command() == "start"
+}
+
+// SendMainMenuMessage will send a main menu message. The monitoring buttons are only
+// shown to admins.
 func (bot *Bot) SendMainMenuMessage(ctx *BotContext) error {
 	var menuKB tgbotapi.InlineKeyboardMarkup
 
-	if bot.skyMgrMonitor.IsRunning() {
+	if ctx != nil && ctx.User != nil && !ctx.User.Role.AtLeast(store.RoleAdmin) {
+		menuKB = CreateMultiLineMarkup("help", "createaddress", "balance")
+	} else if bot.skyMgrMonitor.IsRunning() {
 		// Monitor is running
 		menuKB = CreateMultiLineMarkup("stop", "|", "status", "balance", "|", "help", "about", "update")
 	} else {
@@ -622,31 +851,23 @@ func (bot *Bot) SendMainMenuMessage(ctx *BotContext) error {
 	return bot.SendReplyInlineKeyboard(ctx, menuKB, "*Menu*")
 }
 
-// Start will start the Bot running - the main duty being to monitor for and handle messages
-func (bot *Bot) Start() {
-	log.Infoln("BOT: Starting.")
-	defer log.Infoln("BOT: Stopped")
-	bot.SendGAEvent("AppInit", "BotStart", "Bot Starting")
+// receiveUpdates returns the channel of the updates sent to the Bot, either by long polling
+// or (if enabled) the webhook. The done channel is closed when the webhook is shut down,
+// it is nil when polling.
+func (bot *Bot) receiveUpdates() (<-chan tgbotapi.Update, <-chan struct{}, error) {
+	if bot.webhook != nil {
+		if err := bot.startWebhook(); err != nil {
+			return nil, nil, err
+		}
+		return bot.webhook.Updates(), bot.webhook.Done(), nil
+	}
 
+	bot.removeStaleWebhook()
 	update := tgbotapi.NewUpdate(0)
 	update.Timeout = 60
-
-	// Start the Bot Running (in the background)
-	log.Infoln("Skywire Wing Commander Telegram Bot - Ready for duty.")
-	defer log.Infoln("Skywire Wing Commander Telegram Bot - Signing off.")
-
 	updates, err := bot.telegram.GetUpdatesChan(update)
 	if err != nil {
-		log.Fatalf("Bot.Start: Failed to create Telegram updates channel: %v", err)
-	}
-
-	for update := range updates {
-		//bot.SendGAEvent("BotMessages", "HandleUpdates", "Handle Updates Loop")
-		if err := bot.handleUpdate(&update); err != nil {
-			log.Errorf("Bot.Start: Error: %v", err)
-		}
-		if err := bot.SendMainMenuMessage(nil); err != nil {
-			log.Errorf("Bot.Start: Error: %v", err)
-		}
+		return nil, nil, err
 	}
+	return updates, nil, nil
 }
@@ -0,0 +1,472 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// twoFactorPendingTimeout is how long a command waits for the two-factor code of the user
+	twoFactorPendingTimeout = 2 * time.Minute
+	// twoFactorPeriod is the TOTP time step (in seconds)
+	twoFactorPeriod = 30
+	// twoFactorQRSize is the width and height (in pixels) of the QR code sent by /2fa setup
+	twoFactorQRSize = 256
+)
+
+var (
+	// errTwoFactorNotSetUp is returned by verifyTwoFactor when the user hasn't set up a second factor
+	errTwoFactorNotSetUp = errors.New("two-factor authentication is not set up")
+	// errTwoFactorLocked is returned by verifyTwoFactor while the user is locked out
+	errTwoFactorLocked = errors.New("two-factor authentication is locked")
+	// errTwoFactorInvalid is returned by verifyTwoFactor for a wrong (or reused) code
+	errTwoFactorInvalid = errors.New("invalid two-factor code")
+)
+
+// twoFactorOpts are the TOTP parameters understood by every authenticator app
+var twoFactorOpts = totp.ValidateOpts{Period: twoFactorPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+
+// pendingTwoFactor is a command waiting for the two-factor code of the user
+type pendingTwoFactor struct {
+	ctx     *BotContext
+	cmd     Command
+	command string
+	args    string
+	expires time.Time
+}
+
+// twoFactorState holds the commands waiting for a two-factor code and the time until
+// which moderators and admins can use their commands without a new code, by Telegram ID
+type twoFactorState struct {
+	mutex    sync.Mutex
+	pending  map[int]*pendingTwoFactor
+	sessions map[int]time.Time
+}
+
+// setPending replaces the command waiting for the code of the user
+func (s *twoFactorState) setPending(telegramID int, p *pendingTwoFactor) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending == nil {
+		s.pending = make(map[int]*pendingTwoFactor)
+	}
+	s.pending[telegramID] = p
+}
+
+// takePending removes and returns the command waiting for the code of the user, if it hasn't expired
+func (s *twoFactorState) takePending(telegramID int, now time.Time) *pendingTwoFactor {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := s.pending[telegramID]
+	delete(s.pending, telegramID)
+	if p == nil || now.After(p.expires) {
+		return nil
+	}
+	return p
+}
+
+// startSession lets the user run moderator and admin commands without a code until the provided time
+func (s *twoFactorState) startSession(telegramID int, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = make(map[int]time.Time)
+	}
+	s.sessions[telegramID] = until
+}
+
+// hasSession reports whether the user can run moderator and admin commands without a code
+func (s *twoFactorState) hasSession(telegramID int, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return now.Before(s.sessions[telegramID])
+}
+
+// endSession requires a new code for the next moderator or admin command of the user
+func (s *twoFactorState) endSession(telegramID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, telegramID)
+}
+
+// needsTwoFactor reports whether the command needs a two-factor code before it runs:
+// confirming a withdrawal, tips of at least the large tip amount and the moderator
+// and admin commands (unless the user entered a code in the last adminsessionmin minutes).
+func (bot *Bot) needsTwoFactor(ctx *BotContext, cmd Command, command, args string) bool {
+	if !bot.config.WingCommander.TwoFactorEnabled || ctx.twoFactorVerified {
+		return false
+	}
+
+	var amount uint64
+	var err error
+	switch command {
+	case "confirmwithdraw":
+		return true
+	case "sendsky":
+		amount, _, _, err = parseSendSkyArgs(args)
+	case "tip":
+		amount, _, err = parseTipArgs(args)
+	default:
+		return cmd.Role.AtLeast(store.RoleModerator) && !bot.twoFactor.hasSession(ctx.User.ID, time.Now())
+	}
+	// Invalid tips are rejected by the command
+	return err == nil && bot.twoFactorLargeTip > 0 && amount >= bot.twoFactorLargeTip
+}
+
+// requestTwoFactor holds the command until the user sends their two-factor code with /2fa.
+// Users who haven't set up a second factor are told to do so. The request is always sent
+// in the private chat of the user, even for commands used in a group.
+func (bot *Bot) requestTwoFactor(ctx *BotContext, cmd Command, command, args string) error {
+	msg := fmt.Sprintf(wcconst.MsgTwoFactorRequired, command, int(twoFactorPendingTimeout/time.Minute))
+
+	tf, err := bot.store.TwoFactor().Get(ctx.Context(), ctx.User.ID)
+	switch {
+	case err == store.ErrNotFound || (err == nil && !tf.Enabled):
+		msg = fmt.Sprintf(wcconst.MsgTwoFactorSetupFirst, command)
+	case err != nil:
+		log.Errorf("Bot.requestTwoFactor: Error getting second factor of %s: %v", ctx.User.NameAndTags(), err)
+		msg = wcconst.MsgErrorStore
+	default:
+		bot.twoFactor.setPending(ctx.User.ID, &pendingTwoFactor{
+			ctx:     ctx,
+			cmd:     cmd,
+			command: command,
+			args:    args,
+			expires: time.Now().Add(twoFactorPendingTimeout),
+		})
+		log.Infof("Bot.requestTwoFactor: /%s by %s is waiting for a two-factor code", command, ctx.User.NameAndTags())
+	}
+
+	if err := bot.Send(ctx, "whisper", "markdown", msg); err != nil {
+		logSendError("Bot.requestTwoFactor", err)
+		return err
+	}
+	return nil
+}
+
+// verifyTwoFactor checks the code against the second factor of the user. Codes of the
+// previous and next time steps are accepted to allow for clock drift, but each code
+// can only be used once. Wrong codes are counted, and maxfailures wrong codes lock
+// the user out for lockoutmin minutes. The second factor is enabled by its first valid code.
+// For a valid code the second factor is returned as it was before the code was checked.
+func (bot *Bot) verifyTwoFactor(ctx context.Context, telegramID int, code string, now time.Time) (*store.TwoFactor, error) {
+	repo := bot.store.TwoFactor()
+	tf, err := repo.Get(ctx, telegramID)
+	if err == store.ErrNotFound {
+		return nil, errTwoFactorNotSetUp
+	} else if err != nil {
+		return nil, err
+	}
+	if now.Before(tf.LockedUntil) {
+		return tf, errTwoFactorLocked
+	}
+
+	secret, err := bot.vault.Open(tf.Secret, store.TwoFactorSecretID(telegramID))
+	if err != nil {
+		return nil, err
+	}
+
+	counter := int64(-1)
+	code = strings.TrimSpace(code)
+	for skew := -1; skew <= 1 && len(code) == int(otp.DigitsSix); skew++ {
+		t := now.Add(time.Duration(skew*twoFactorPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, twoFactorOpts)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			counter = t.Unix() / twoFactorPeriod
+		}
+	}
+
+	if counter >= 0 {
+		err := repo.RecordSuccess(ctx, telegramID, counter)
+		if err == nil {
+			return tf, nil
+		} else if err != store.ErrCodeReused {
+			return nil, err
+		}
+	}
+
+	tf, err = repo.RecordFailure(ctx, telegramID, bot.config.TwoFactor.MaxFailures, now.Add(bot.config.TwoFactor.LockoutMin))
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(tf.LockedUntil) {
+		log.Warnf("Bot.verifyTwoFactor: %d is locked out until %v after %d wrong codes", telegramID, tf.LockedUntil,
+			bot.config.TwoFactor.MaxFailures)
+		return tf, errTwoFactorLocked
+	}
+	return tf, errTwoFactorInvalid
+}
+
+// twoFactorErrorMessage returns the message to send to the user when verifyTwoFactor fails
+func (bot *Bot) twoFactorErrorMessage(tf *store.TwoFactor, err error) string {
+	switch err {
+	case errTwoFactorNotSetUp:
+		return wcconst.MsgTwoFactorNotSetUp
+	case errTwoFactorLocked:
+		return fmt.Sprintf(wcconst.MsgTwoFactorLocked, formatTime(tf.LockedUntil))
+	case errTwoFactorInvalid:
+		return fmt.Sprintf(wcconst.MsgTwoFactorInvalid, bot.config.TwoFactor.MaxFailures-tf.Failures)
+	}
+	return wcconst.MsgErrorStore
+}
+
+// setupTwoFactor creates a new TOTP secret for the user and stores it sealed with the
+// master key. The second factor is enabled once the user enters their first code.
+// Users who already have an enabled second factor must disable it first, so a
+// hijacked Telegram account can't replace it.
+func (bot *Bot) setupTwoFactor(ctx context.Context, tgUser *User) (*otp.Key, string, error) {
+	tf, err := bot.store.TwoFactor().Get(ctx, tgUser.ID)
+	if err == nil && tf.Enabled {
+		return nil, wcconst.MsgTwoFactorAlreadySetUp, nil
+	} else if err != nil && err != store.ErrNotFound {
+		return nil, "", err
+	}
+
+	account := tgUser.UserName
+	if account == "" {
+		account = strconv.Itoa(tgUser.ID)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      bot.config.TwoFactor.Issuer,
+		AccountName: account,
+		Period:      twoFactorPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sealed, err := bot.vault.Seal(key.Secret(), store.TwoFactorSecretID(tgUser.ID))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := bot.store.TwoFactor().Save(ctx, &store.TwoFactor{TelegramID: tgUser.ID, Secret: sealed}); err != nil {
+		return nil, "", err
+	}
+	log.Infof("Bot.setupTwoFactor: %s is setting up two-factor authentication", tgUser.NameAndTags())
+	return key, "", nil
+}
+
+// confirmTwoFactor checks a code sent with /2fa <code>. A valid code enables the second
+// factor of the user, lets moderators and admins use their commands for adminsessionmin
+// minutes, and releases the command waiting for the code (if any), which is returned.
+// The message to send to the user is returned.
+func (bot *Bot) confirmTwoFactor(ctx *BotContext, code string) (string, *pendingTwoFactor, error) {
+	now := time.Now()
+	tf, err := bot.verifyTwoFactor(ctx.Context(), ctx.User.ID, code, now)
+	switch err {
+	case nil:
+	case errTwoFactorNotSetUp, errTwoFactorLocked, errTwoFactorInvalid:
+		if err == errTwoFactorLocked {
+			// Don't let a locked out user run the command later
+			bot.twoFactor.takePending(ctx.User.ID, now)
+			bot.twoFactor.endSession(ctx.User.ID)
+		}
+		return bot.twoFactorErrorMessage(tf, err), nil, nil
+	default:
+		return "", nil, err
+	}
+
+	if ctx.User.Role.AtLeast(store.RoleModerator) {
+		bot.twoFactor.startSession(ctx.User.ID, now.Add(bot.config.TwoFactor.AdminSessionMin))
+	}
+	if p := bot.twoFactor.takePending(ctx.User.ID, now); p != nil {
+		// The command runs with the current role of the user, within the time of this handler
+		p.ctx.twoFactorVerified = true
+		p.ctx.User.Role = ctx.User.Role
+		p.ctx.context = ctx.context
+		return fmt.Sprintf(wcconst.MsgTwoFactorAccepted, p.command), p, nil
+	}
+	if !tf.Enabled {
+		return wcconst.MsgTwoFactorEnabled, nil, nil
+	}
+	return wcconst.MsgTwoFactorValid, nil, nil
+}
+
+// disableTwoFactor removes the second factor of the user, which needs a valid code
+func (bot *Bot) disableTwoFactor(ctx *BotContext, code string) (string, error) {
+	tf, err := bot.verifyTwoFactor(ctx.Context(), ctx.User.ID, code, time.Now())
+	switch err {
+	case nil:
+	case errTwoFactorNotSetUp, errTwoFactorLocked, errTwoFactorInvalid:
+		return bot.twoFactorErrorMessage(tf, err), nil
+	default:
+		return "", err
+	}
+	if err := bot.store.TwoFactor().Delete(ctx.Context(), ctx.User.ID); err != nil {
+		return "", err
+	}
+	bot.twoFactor.endSession(ctx.User.ID)
+	log.Infof("Bot.disableTwoFactor: %s disabled two-factor authentication", ctx.User.NameAndTags())
+	return wcconst.MsgTwoFactorDisabled, nil
+}
+
+// twoFactorStatus returns the message describing the second factor of the user
+func (bot *Bot) twoFactorStatus(ctx context.Context, telegramID int) (string, error) {
+	tf, err := bot.store.TwoFactor().Get(ctx, telegramID)
+	switch {
+	case err == store.ErrNotFound || (err == nil && !tf.Enabled):
+		return wcconst.MsgTwoFactorStatusOff, nil
+	case err != nil:
+		return "", err
+	case time.Now().Before(tf.LockedUntil):
+		return fmt.Sprintf(wcconst.MsgTwoFactorLocked, formatTime(tf.LockedUntil)), nil
+	}
+	return wcconst.MsgTwoFactorStatusOn, nil
+}
+
+// resetTwoFactor removes the second factor of the member named by args, i.e. when they
+// have lost their phone. The reset is recorded in the audit log.
+func (bot *Bot) resetTwoFactor(ctx *BotContext, args string) (string, error) {
+	storectx := ctx.Context()
+	target, err := bot.resolveMember(storectx, strings.TrimSpace(args))
+	if err == errInvalidTarget {
+		return wcconst.MsgResetTwoFactorUsage, nil
+	} else if err == store.ErrNotFound {
+		return fmt.Sprintf(wcconst.MsgAdminUnknownUser, EscapeMarkdown(args)), nil
+	} else if err != nil {
+		return "", err
+	}
+	name := memberName(target)
+
+	err = bot.store.TwoFactor().Delete(storectx, target.TelegramID)
+	if err == store.ErrNotFound {
+		return fmt.Sprintf(wcconst.MsgResetTwoFactorNotSetUp, EscapeMarkdown(name)), nil
+	} else if err != nil {
+		return "", err
+	}
+	bot.twoFactor.endSession(target.TelegramID)
+	bot.audit(ctx, store.AuditResetTwoFactor, name, "")
+	log.Infof("Bot.resetTwoFactor: %s reset the second factor of %s", ctx.User.NameAndTags(), name)
+	return fmt.Sprintf(wcconst.MsgResetTwoFactorDone, EscapeMarkdown(name)), nil
+}
+
+// Handler for 2fa command
+// /2fa shows whether two-factor authentication is on, /2fa setup sends a new secret as an
+// otpauth URI and QR code, /2fa <code> enables it or confirms a waiting command, and
+// /2fa disable <code> turns it off.
+func (bot *Bot) handleCommandTwoFactor(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s", command)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandTwoFactor", err)
+		}
+		return err
+	}
+
+	if !bot.config.WingCommander.TwoFactorEnabled {
+		return reply(wcconst.MsgTwoFactorUnavailable)
+	}
+
+	var msg string
+	var pending *pendingTwoFactor
+	var err error
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		msg, err = bot.twoFactorStatus(ctx.Context(), ctx.User.ID)
+
+	case len(fields) == 1 && strings.ToLower(fields[0]) == "setup":
+		var key *otp.Key
+		key, msg, err = bot.setupTwoFactor(ctx.Context(), ctx.User)
+		if err != nil || key == nil {
+			break
+		}
+		// The secret is only ever sent in the private chat of the user
+		if err := bot.Send(ctx, "whisper", "markdown", fmt.Sprintf(wcconst.MsgTwoFactorSetup, key.URL(), key.Secret())); err != nil {
+			logSendError("Bot.handleCommandTwoFactor", err)
+			return err
+		}
+		img, err := key.Image(twoFactorQRSize, twoFactorQRSize)
+		if err != nil {
+			log.Errorf("Bot.handleCommandTwoFactor: Error creating QR code: %v", err)
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			log.Errorf("Bot.handleCommandTwoFactor: Error encoding QR code: %v", err)
+			return nil
+		}
+		if err := bot.SendPhoto(ctx, "2fa.png", buf.Bytes(), wcconst.MsgTwoFactorQRCaption); err != nil {
+			logSendError("Bot.handleCommandTwoFactor", err)
+			return err
+		}
+		// The first code can be sent as a reply instead of using /2fa <code>
+		if err := bot.Expect(ctx, command, "2fa-code", nil, conversationTimeout); err != nil {
+			log.Errorf("Bot.handleCommandTwoFactor: Error starting conversation: %v", err)
+		}
+		return nil
+
+	case len(fields) == 2 && strings.ToLower(fields[0]) == "disable":
+		msg, err = bot.disableTwoFactor(ctx, fields[1])
+
+	case len(fields) == 1:
+		msg, pending, err = bot.confirmTwoFactor(ctx, fields[0])
+
+	default:
+		msg = wcconst.MsgTwoFactorUsage
+	}
+
+	if err != nil {
+		log.Errorf("Bot.handleCommandTwoFactor: %v", err)
+		msg = wcconst.MsgErrorStore
+	}
+	if err := reply(msg); err != nil || pending == nil {
+		return err
+	}
+
+	log.Infof("Bot.handleCommandTwoFactor: Running /%s for %s", pending.command, ctx.User.NameAndTags())
+	err = bot.runCommand(pending.ctx, pending.cmd, pending.command, pending.args)
+	if err == errNotPermitted {
+		return reply(fmt.Sprintf(wcconst.MsgNotPermitted, pending.command))
+	}
+	return err
+}
+
+// Handler for reset2fa command
+func (bot *Bot) handleCommandResetTwoFactor(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	msg, err := bot.resetTwoFactor(ctx, args)
+	if err != nil {
+		log.Errorf("Bot.handleCommandResetTwoFactor: Error handling /%s %s: %v", command, args, err)
+		msg = wcconst.MsgErrorStore
+	}
+	if senderr := bot.Send(ctx, getSendModeforContext(ctx), "markdown", msg); senderr != nil {
+		logSendError("Bot.handleCommandResetTwoFactor", senderr)
+		return senderr
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/keyvault"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	"github.com/pquerna/otp/totp"
+)
+
+// newTestTwoFactorBot creates the Bot of newTestAdminBot with two-factor authentication
+// enabled, a lockout after 3 wrong codes and a large tip amount of 10 SKY
+func newTestTwoFactorBot(t *testing.T) *Bot {
+	bot := newTestAdminBot(t)
+	vault, err := keyvault.New(bytes.Repeat([]byte{0x42}, keyvault.MasterKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.vault = vault
+	bot.config.WingCommander.TwoFactorEnabled = true
+	bot.config.TwoFactor.Issuer = "Wing Commander"
+	bot.config.TwoFactor.MaxFailures = 3
+	bot.config.TwoFactor.LockoutMin = 15 * time.Minute
+	bot.config.TwoFactor.AdminSessionMin = 10 * time.Minute
+	bot.twoFactorLargeTip = 10000000
+	return bot
+}
+
+// setupTestTwoFactor sets up and enables the second factor of the user, returning the secret
+func setupTestTwoFactor(t *testing.T, bot *Bot, ctx *BotContext) string {
+	key, msg, err := bot.setupTwoFactor(context.Background(), ctx.User)
+	if err != nil || key == nil {
+		t.Fatalf("setupTwoFactor: %q, %v", msg, err)
+	}
+	code, err := totp.GenerateCode(key.Secret(), time.Now().Add(-30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err := bot.confirmTwoFactor(ctx, code); err != nil || msg != wcconst.MsgTwoFactorEnabled {
+		t.Fatalf("Expected the second factor to be enabled, got %q, %v", msg, err)
+	}
+	return key.Secret()
+}
+
+func Test_confirmTwoFactor(t *testing.T) {
+	bot := newTestTwoFactorBot(t)
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+
+	if msg, _, err := bot.confirmTwoFactor(alice, "123456"); err != nil || msg != wcconst.MsgTwoFactorNotSetUp {
+		t.Errorf("Expected MsgTwoFactorNotSetUp, got %q, %v", msg, err)
+	}
+	secret := setupTestTwoFactor(t, bot, alice)
+
+	if msg, err := bot.twoFactorStatus(context.Background(), 1002); err != nil || msg != wcconst.MsgTwoFactorStatusOn {
+		t.Errorf("Expected MsgTwoFactorStatusOn, got %q, %v", msg, err)
+	}
+	if _, msg, err := bot.setupTwoFactor(context.Background(), alice.User); err != nil || msg != wcconst.MsgTwoFactorAlreadySetUp {
+		t.Errorf("Expected MsgTwoFactorAlreadySetUp, got %q, %v", msg, err)
+	}
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err := bot.confirmTwoFactor(alice, code); err != nil || msg != wcconst.MsgTwoFactorValid {
+		t.Errorf("Expected MsgTwoFactorValid, got %q, %v", msg, err)
+	}
+	// A code can only be used once
+	if msg, _, err := bot.confirmTwoFactor(alice, code); err != nil || msg != fmt.Sprintf(wcconst.MsgTwoFactorInvalid, 2) {
+		t.Errorf("Expected MsgTwoFactorInvalid, got %q, %v", msg, err)
+	}
+	if msg, _, err := bot.confirmTwoFactor(alice, "abc"); err != nil || msg != fmt.Sprintf(wcconst.MsgTwoFactorInvalid, 1) {
+		t.Errorf("Expected MsgTwoFactorInvalid, got %q, %v", msg, err)
+	}
+
+	// The third wrong code locks the user out, even for valid codes
+	if _, _, err := bot.confirmTwoFactor(alice, "000000"); err != nil {
+		t.Fatal(err)
+	}
+	code, err = totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := bot.verifyTwoFactor(context.Background(), 1002, code, time.Now())
+	if err != errTwoFactorLocked || !tf.LockedUntil.After(time.Now().Add(14*time.Minute)) {
+		t.Errorf("Expected a lockout, got %+v, %v", tf, err)
+	}
+	if msg, err := bot.disableTwoFactor(alice, code); err != nil || msg != bot.twoFactorErrorMessage(tf, errTwoFactorLocked) {
+		t.Errorf("Expected MsgTwoFactorLocked, got %q, %v", msg, err)
+	}
+
+	// After the lockout the code works again
+	later := time.Now().Add(16 * time.Minute)
+	code, err = totp.GenerateCode(secret, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.verifyTwoFactor(context.Background(), 1002, code, later); err != nil {
+		t.Errorf("Expected the code to be accepted, got %v", err)
+	}
+}
+
+func Test_needsTwoFactor(t *testing.T) {
+	bot := newTestTwoFactorBot(t)
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+	mod := &BotContext{User: &User{ID: 1001, UserName: "mod", Role: store.RoleModerator}}
+	userCmd := Command{Role: store.RoleUser}
+	modCmd := Command{Role: store.RoleModerator}
+
+	tests := []struct {
+		ctx      *BotContext
+		cmd      Command
+		command  string
+		args     string
+		expected bool
+	}{
+		{alice, userCmd, "confirmwithdraw", "", true},
+		{alice, userCmd, "tip", "10", true},
+		{alice, userCmd, "tip", "9.999", false},
+		{alice, userCmd, "sendsky", "25 @bob", true},
+		{alice, userCmd, "sendsky", "1 @bob", false},
+		{alice, userCmd, "tip", "lots", false},
+		{alice, userCmd, "balance", "", false},
+		{mod, modCmd, "ban", "@alice", true},
+		{mod, userCmd, "history", "", false},
+		{mod, addUserCommand, addUserCommand.Command, "", true},
+	}
+	for i, tc := range tests {
+		if got := bot.needsTwoFactor(tc.ctx, tc.cmd, tc.command, tc.args); got != tc.expected {
+			t.Errorf("%d: needsTwoFactor(/%s %s) expected %v, got %v", i, tc.command, tc.args, tc.expected, got)
+		}
+	}
+
+	// A valid code starts a session for moderators and releases the waiting command
+	setupTestTwoFactor(t, bot, mod)
+	if bot.needsTwoFactor(mod, modCmd, "ban", "@alice") {
+		t.Errorf("Expected no code to be needed during the session")
+	}
+	bot.twoFactor.endSession(1001)
+
+	groupCtx := &BotContext{User: &User{ID: 1001, UserName: "mod", Role: store.RoleModerator}}
+	bot.twoFactor.setPending(1001, &pendingTwoFactor{ctx: groupCtx, cmd: modCmd, command: "ban", args: "@alice",
+		expires: time.Now().Add(twoFactorPendingTimeout)})
+	secret, err := bot.store.TwoFactor().Get(context.Background(), 1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := bot.vault.Open(secret.Secret, store.TwoFactorSecretID(1001))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCode(key, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, p, err := bot.confirmTwoFactor(mod, code)
+	if err != nil || p == nil || p.ctx != groupCtx || msg != fmt.Sprintf(wcconst.MsgTwoFactorAccepted, "ban") {
+		t.Fatalf("Expected the pending command, got %q, %+v, %v", msg, p, err)
+	}
+	if !groupCtx.twoFactorVerified || bot.needsTwoFactor(groupCtx, modCmd, "ban", "@alice") {
+		t.Errorf("Expected the pending command not to need another code")
+	}
+
+	// Nothing needs a code when two-factor authentication is off
+	bot.config.WingCommander.TwoFactorEnabled = false
+	if bot.needsTwoFactor(alice, userCmd, "confirmwithdraw", "") {
+		t.Errorf("Expected no code to be needed")
+	}
+}
+
+func Test_resetTwoFactor(t *testing.T) {
+	bot := newTestTwoFactorBot(t)
+	owner := &BotContext{User: &User{ID: 1000, UserName: "owner", Role: store.RoleAdmin}}
+	alice := &BotContext{User: &User{ID: 1002, UserName: "alice", Role: store.RoleUser}}
+
+	if msg, err := bot.resetTwoFactor(owner, ""); err != nil || msg != wcconst.MsgResetTwoFactorUsage {
+		t.Errorf("Expected MsgResetTwoFactorUsage, got %q, %v", msg, err)
+	}
+	if msg, err := bot.resetTwoFactor(owner, "@alice"); err != nil || msg != fmt.Sprintf(wcconst.MsgResetTwoFactorNotSetUp, "@alice (1002)") {
+		t.Errorf("Expected MsgResetTwoFactorNotSetUp, got %q, %v", msg, err)
+	}
+
+	setupTestTwoFactor(t, bot, alice)
+	if msg, err := bot.resetTwoFactor(owner, "@alice"); err != nil || msg != fmt.Sprintf(wcconst.MsgResetTwoFactorDone, "@alice (1002)") {
+		t.Errorf("Expected MsgResetTwoFactorDone, got %q, %v", msg, err)
+	}
+	if _, err := bot.store.TwoFactor().Get(context.Background(), 1002); err != store.ErrNotFound {
+		t.Errorf("Expected the second factor to be removed, got %v", err)
+	}
+
+	entries, err := bot.store.AuditLog().List(context.Background(), 0, 10)
+	if err != nil || len(entries) != 1 || entries[0].Action != store.AuditResetTwoFactor {
+		t.Errorf("Expected the reset to be audited, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/depositmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/keyvault"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadWalletSeed reads the sealed wallet seed from the configured seed file and checks
+// it can be decrypted. A new seed is created the first time the Bot starts, but never
+// once addresses have been derived, as they could no longer be recovered.
+func (bot *Bot) loadWalletSeed(ctx context.Context) (string, error) {
+	seedFile := bot.config.Wallet.SeedFile
+	sealed, err := keyvault.ReadSeedFile(seedFile)
+	if err == nil {
+		_, err = bot.vault.OpenSeed(sealed)
+		return sealed, err
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	derived, err := bot.store.Addresses().ListDerived(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(derived) > 0 {
+		return "", fmt.Errorf("seed file %s is missing but %d address(es) have been derived from it, restore it from a backup", seedFile, len(derived))
+	}
+
+	seed, err := wallet.NewSeed()
+	if err != nil {
+		return "", err
+	}
+	if sealed, err = bot.vault.SealSeed(seed); err != nil {
+		return "", err
+	}
+	if err = keyvault.WriteSeedFile(seedFile, sealed); err != nil {
+		return "", err
+	}
+	log.Warnf("Bot.loadWalletSeed: Created a new wallet seed in %s. Back it up together with the master key.", seedFile)
+	return sealed, nil
+}
+
+// deriveAddress derives the address at index from the wallet seed.
+// The seed is only decrypted for the duration of the call.
+func (bot *Bot) deriveAddress(index uint32) (wallet.Address, error) {
+	seed, err := bot.vault.OpenSeed(bot.seed)
+	if err != nil {
+		return wallet.Address{}, err
+	}
+	return wallet.DeriveAddress(seed, index)
+}
+
+// signingAddress returns the wallet of the stored user in the form used by the wallet
+// backend to sign transactions. The secret key is only derived (or decrypted, for
+// standalone addresses) here, so the result should be used immediately and not kept.
+func (bot *Bot) signingAddress(u *store.User) (wallet.Address, error) {
+	if u.DerivationIndex != 0 {
+		addr, err := bot.deriveAddress(u.DerivationIndex)
+		if err != nil {
+			return wallet.Address{}, err
+		}
+		if addr.Address != u.Address {
+			return wallet.Address{}, fmt.Errorf("address derived at index %d does not match the stored address %s", u.DerivationIndex, u.Address)
+		}
+		return addr, nil
+	}
+
+	secretKey, err := bot.vault.Open(u.SecretKey, u.Address)
+	if err != nil {
+		return wallet.Address{}, err
+	}
+	return wallet.Address{
+		Address:   u.Address,
+		PublicKey: u.PublicKey,
+		SecretKey: secretKey,
+	}, nil
+}
+
+// lookupUser finds the stored user for the Telegram user interacting with the Bot.
+// Users are identified by their numeric Telegram ID. If the Telegram ID is unknown
+// the user is looked up by username, which claims wallets created for them as the
+// recipient of /sendsky. Changed usernames and chat IDs are updated in the store.
+func (bot *Bot) lookupUser(ctx context.Context, tgUser *User) (*store.User, error) {
+	users := bot.store.Users()
+
+	u, err := users.GetByTelegramID(ctx, tgUser.ID)
+	if err == store.ErrNotFound && tgUser.UserName != "" {
+		u, err = users.GetByUserName(ctx, tgUser.UserName)
+		if err == nil && u.TelegramID != 0 && u.TelegramID != tgUser.ID {
+			// The username now belongs to someone else
+			return nil, store.ErrNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chatID := int64(tgUser.ID)
+	username := store.NormalizeUserName(tgUser.UserName)
+	if u.TelegramID != tgUser.ID || u.ChatID != chatID || (username != "" && u.UserName != username) {
+		u.TelegramID = tgUser.ID
+		u.ChatID = chatID
+		if username != "" {
+			u.UserName = username
+		}
+		err := users.Update(ctx, u)
+		if err == store.ErrDuplicate {
+			err = bot.mergePlaceholder(ctx, u)
+		}
+		if err != nil {
+			log.Errorf("Bot.lookupUser: Error updating %s: %v", u.UserName, err)
+		}
+	}
+	return u, nil
+}
+
+// mergePlaceholder merges the placeholder created by /sendsky for the username taken
+// over by u into u, so the SKY sent to the username before is credited to u and later
+// tips to the username reach u. Usernames held by other users are not merged.
+func (bot *Bot) mergePlaceholder(ctx context.Context, u *store.User) error {
+	users := bot.store.Users()
+	placeholder, err := users.GetByUserName(ctx, u.UserName)
+	if err != nil {
+		return err
+	}
+	if placeholder.TelegramID != 0 {
+		return store.ErrDuplicate
+	}
+	if err := users.MergePlaceholder(ctx, u, placeholder.ID); err != nil {
+		return err
+	}
+	log.Infof("Bot.mergePlaceholder: Merged placeholder %d into user %d (%s)", placeholder.ID, u.ID, u.UserName)
+	return nil
+}
+
+// userDisplayName returns the name of a stored user for use in messages and logs.
+// Users who were tipped in a group may not have a username.
+func userDisplayName(u *store.User) string {
+	if u.UserName != "" {
+		return u.UserName
+	}
+	return fmt.Sprintf("user %d", u.TelegramID)
+}
+
+// newUserWallet derives a new address from the wallet seed and stores it for the user.
+// Only the derivation index is stored, the secret key is derived when needed.
+func (bot *Bot) newUserWallet(ctx context.Context, u *store.User) error {
+	index, err := bot.store.Addresses().ReserveDerivationIndex(ctx)
+	if err != nil {
+		return err
+	}
+	addr, err := bot.deriveAddress(index)
+	if err != nil {
+		return err
+	}
+
+	u.Address = addr.Address
+	u.PublicKey = addr.PublicKey
+	u.SecretKey = ""
+	u.DerivationIndex = index
+	if err := bot.store.Users().Create(ctx, u); err != nil {
+		return err
+	}
+
+	log.Debugf("Bot.newUserWallet: Derived address %s (index %d) for %s", u.Address, index, u.UserName)
+	return nil
+}
+
+// getOrCreateUserWallet returns the stored user for the Telegram user interacting with
+// the Bot, creating a wallet for them if they don't have one yet.
+// The returned bool reports whether a new wallet was created.
+func (bot *Bot) getOrCreateUserWallet(ctx context.Context, tgUser *User) (*store.User, bool, error) {
+	u, err := bot.lookupUser(ctx, tgUser)
+	if err != store.ErrNotFound {
+		return u, false, err
+	}
+
+	u = &store.User{
+		TelegramID: tgUser.ID,
+		ChatID:     int64(tgUser.ID),
+		UserName:   tgUser.UserName,
+	}
+	if err := bot.newUserWallet(ctx, u); err != nil {
+		return nil, false, err
+	}
+	return u, true, nil
+}
+
+// getOrCreateRecipientWallet returns the stored user with the provided username, creating a
+// wallet for them if they don't have one yet. The Telegram ID of new recipients is unknown
+// until they talk to the Bot.
+func (bot *Bot) getOrCreateRecipientWallet(ctx context.Context, username string) (*store.User, error) {
+	u, err := bot.store.Users().GetByUserName(ctx, username)
+	if err != store.ErrNotFound {
+		return u, err
+	}
+
+	u = &store.User{UserName: username}
+	err = bot.newUserWallet(ctx, u)
+	if err == store.ErrDuplicate {
+		// Created concurrently
+		return bot.store.Users().GetByUserName(ctx, username)
+	}
+	return u, err
+}
+
+// depositEventLoop sends a direct message to the user for each deposit credited by the
+// DepositMonitor, until runctx is cancelled
+func (bot *Bot) depositEventLoop(runctx context.Context, depositChan <-chan depositmon.Deposit) {
+	for {
+		select {
+		case d := <-depositChan:
+			log.Debugf("Bot.depositEventLoop: Deposit event: %+v", d)
+			bot.SendGAEvent("BotDeposits", "ReceiveDeposit", "Receive Deposit")
+			if d.ChatID == 0 {
+				continue
+			}
+
+			balance, err := bot.store.Ledger().Balance(runctx, store.UserAccount(d.UserID))
+			if err != nil {
+				log.Errorf("Bot.depositEventLoop: %v", err)
+			}
+			msg := fmt.Sprintf(wcconst.MsgDepositReceived, wallet.FormatDroplets(d.Coins), d.TxID,
+				wallet.FormatDroplets(uint64(balance)))
+			if err := bot.SendToChat(d.ChatID, "markdown", msg); err != nil {
+				logSendError("Bot.depositEventLoop", err)
+			}
+
+		case <-runctx.Done():
+			log.Debugln("Bot.depositEventLoop - Done event.")
+			return
+		}
+	}
+}
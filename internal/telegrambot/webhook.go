@@ -0,0 +1,249 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// webhookSecretTokenHeader is the header Telegram sends the secret token of the webhook in
+	webhookSecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// webhookMinSecretPath is the shortest last path segment accepted as a secret, when no secret token is set
+	webhookMinSecretPath = 16
+	// webhookMaxBody is the largest update (in bytes) accepted by the webhook
+	webhookMaxBody = 1 << 20
+	// webhookQueueSize is the number of updates the webhook holds before Telegram has to retry
+	webhookQueueSize = 100
+	// webhookTimeout is the read and write timeout of the webhook listener
+	webhookTimeout = 10 * time.Second
+	// webhookShutdownTimeout is how long the webhook waits for requests in progress when it is stopped
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+// webhookSecretToken matches the secret tokens accepted by Telegram
+var webhookSecretToken = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// checkWebhookParameters checks the webhook configuration. Updates must either be posted
+// with a secret token or to a secret path, so nobody else can post updates to the Bot.
+func checkWebhookParameters(p wcconfig.WebhookParameters) error {
+	u, err := url.Parse(p.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute https URL", p.URL)
+	}
+	if p.Listen == "" {
+		return errors.New("listen must be set")
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("path %q must start with /", p.Path)
+	}
+	if p.SecretToken != "" && !webhookSecretToken.MatchString(p.SecretToken) {
+		return errors.New("secrettoken must be 1-256 characters: A-Z, a-z, 0-9, _ and -")
+	}
+	if p.SecretToken == "" && len(p.Path[strings.LastIndex(p.Path, "/")+1:]) < webhookMinSecretPath {
+		return fmt.Errorf("set secrettoken or end path with a secret of at least %d characters", webhookMinSecretPath)
+	}
+	if (p.CertFile == "") != (p.KeyFile == "") {
+		return errors.New("certfile and keyfile must both be set to serve HTTPS")
+	}
+	if p.PublishCert && p.CertFile == "" {
+		return errors.New("publishcert needs certfile")
+	}
+	if p.MaxConnections < 0 || p.MaxConnections > 100 {
+		return fmt.Errorf("maxconnections %d must be between 1 and 100 (or 0 for the Telegram default)", p.MaxConnections)
+	}
+	return nil
+}
+
+// webhookServer receives the updates Telegram posts to the webhook of the Bot
+type webhookServer struct {
+	path      string
+	token     string
+	updates   chan tgbotapi.Update
+	done      chan struct{}
+	closeOnce sync.Once
+	server    *http.Server
+}
+
+// newWebhookServer creates a webhookServer accepting updates posted to path with the
+// secret token (if set). It doesn't listen until Serve is called.
+func newWebhookServer(listen, path, token string) *webhookServer {
+	s := &webhookServer{
+		path:    path,
+		token:   token,
+		updates: make(chan tgbotapi.Update, webhookQueueSize),
+		done:    make(chan struct{}),
+	}
+	s.server = &http.Server{
+		Addr:         listen,
+		Handler:      s,
+		ReadTimeout:  webhookTimeout,
+		WriteTimeout: webhookTimeout,
+	}
+	return s
+}
+
+// Updates returns the channel of the updates posted to the webhook
+func (s *webhookServer) Updates() <-chan tgbotapi.Update {
+	return s.updates
+}
+
+// Done returns a channel which is closed once the webhook has been shut down
+func (s *webhookServer) Done() <-chan struct{} {
+	return s.done
+}
+
+// ServeHTTP accepts an update posted by Telegram
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != s.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretTokenHeader)), []byte(s.token)) != 1 {
+		log.Warnf("webhookServer.ServeHTTP: Rejected update from %s with an invalid secret token", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBody)).Decode(&update); err != nil {
+		log.Warnf("webhookServer.ServeHTTP: Invalid update from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	// Telegram retries updates which aren't accepted
+	select {
+	case s.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-s.done:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+// Serve accepts updates on the listener until Shutdown is called. HTTPS is served
+// when certFile and keyFile are set.
+func (s *webhookServer) Serve(ln net.Listener, certFile, keyFile string) error {
+	var err error
+	if certFile != "" {
+		err = s.server.ServeTLS(ln, certFile, keyFile)
+	} else {
+		err = s.server.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting updates and waits for the requests in progress until ctx is done
+func (s *webhookServer) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.server.Shutdown(ctx)
+}
+
+// webhookURL returns the URL Telegram posts updates to
+func webhookURL(p wcconfig.WebhookParameters) string {
+	return strings.TrimSuffix(p.URL, "/") + p.Path
+}
+
+// setWebhook registers the webhook with Telegram. The request is made directly
+// (rather than using tgbotapi.SetWebhook) to include the secret token.
+func (bot *Bot) setWebhook() error {
+	p := bot.config.Telegram.Webhook
+	params := map[string]string{"url": webhookURL(p)}
+	if p.SecretToken != "" {
+		params["secret_token"] = p.SecretToken
+	}
+	if p.MaxConnections != 0 {
+		params["max_connections"] = strconv.Itoa(p.MaxConnections)
+	}
+
+	if p.PublishCert {
+		_, err := bot.telegram.UploadFile("setWebhook", params, "certificate", p.CertFile)
+		return err
+	}
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	_, err := bot.telegram.MakeRequest("setWebhook", values)
+	return err
+}
+
+// startWebhook listens for updates and registers the webhook with Telegram
+func (bot *Bot) startWebhook() error {
+	p := bot.config.Telegram.Webhook
+	ln, err := net.Listen("tcp", p.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", p.Listen, err)
+	}
+	go func() {
+		if err := bot.webhook.Serve(ln, p.CertFile, p.KeyFile); err != nil {
+			log.Errorf("Bot.startWebhook: Webhook listener failed: %v", err)
+		}
+	}()
+
+	if err := bot.setWebhook(); err != nil {
+		bot.webhook.Shutdown(context.Background())
+		return fmt.Errorf("failed to register webhook: %v", err)
+	}
+	log.Infof("Bot.startWebhook: Receiving updates at %s on %s", p.URL, p.Listen)
+	return nil
+}
+
+// stopWebhook removes the webhook from Telegram and stops the listener
+func (bot *Bot) stopWebhook() {
+	if _, err := bot.telegram.RemoveWebhook(); err != nil {
+		log.Errorf("Bot.stopWebhook: Failed to remove webhook: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if err := bot.webhook.Shutdown(ctx); err != nil {
+		log.Errorf("Bot.stopWebhook: %v", err)
+	}
+}
+
+// removeStaleWebhook removes a webhook left registered by an earlier run in webhook
+// mode, as Telegram doesn't return updates to long polling while a webhook is set
+func (bot *Bot) removeStaleWebhook() {
+	info, err := bot.telegram.GetWebhookInfo()
+	if err != nil {
+		log.Errorf("Bot.removeStaleWebhook: Failed to get webhook info: %v", err)
+		return
+	}
+	if info.URL == "" {
+		return
+	}
+	log.Warnln("Bot.removeStaleWebhook: Removing the webhook registered with Telegram to poll for updates")
+	if _, err := bot.telegram.RemoveWebhook(); err != nil {
+		log.Errorf("Bot.removeStaleWebhook: Failed to remove webhook: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconfig"
+)
+
+func Test_checkWebhookParameters(t *testing.T) {
+	valid := wcconfig.WebhookParameters{
+		Enabled:     true,
+		URL:         "https://bot.example.com",
+		Listen:      "127.0.0.1:8443",
+		Path:        "/telegram",
+		SecretToken: "s3cret_Token-1",
+	}
+	if err := checkWebhookParameters(valid); err != nil {
+		t.Errorf("Expected valid parameters, got %v", err)
+	}
+	secretPath := valid
+	secretPath.SecretToken = ""
+	secretPath.Path = "/telegram/0123456789abcdef"
+	if err := checkWebhookParameters(secretPath); err != nil {
+		t.Errorf("Expected a secret path to be accepted, got %v", err)
+	}
+
+	tests := []func(p *wcconfig.WebhookParameters){
+		func(p *wcconfig.WebhookParameters) { p.URL = "http://bot.example.com" },
+		func(p *wcconfig.WebhookParameters) { p.URL = "bot.example.com" },
+		func(p *wcconfig.WebhookParameters) { p.Listen = "" },
+		func(p *wcconfig.WebhookParameters) { p.Path = "telegram" },
+		func(p *wcconfig.WebhookParameters) { p.SecretToken = "not allowed!" },
+		func(p *wcconfig.WebhookParameters) { p.SecretToken = strings.Repeat("a", 257) },
+		func(p *wcconfig.WebhookParameters) { p.SecretToken = "" },
+		func(p *wcconfig.WebhookParameters) { p.CertFile = "cert.pem" },
+		func(p *wcconfig.WebhookParameters) { p.PublishCert = true },
+		func(p *wcconfig.WebhookParameters) { p.MaxConnections = 101 },
+	}
+	for i, modify := range tests {
+		p := valid
+		modify(&p)
+		if err := checkWebhookParameters(p); err == nil {
+			t.Errorf("%d: Expected %+v to be rejected", i, p)
+		}
+	}
+}
+
+func Test_webhookServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newWebhookServer(ln.Addr().String(), "/telegram", "s3cret")
+	go s.Serve(ln, "", "")
+	defer s.Shutdown(context.Background())
+	url := "http://" + ln.Addr().String()
+
+	post := func(method, path, token, body string) int {
+		req, err := http.NewRequest(method, url+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set(webhookSecretTokenHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	update := `{"update_id":42,"message":{"message_id":7,"from":{"id":1002,"username":"alice"},` +
+		`"chat":{"id":1002,"type":"private"},"date":1537000000,"text":"/balance"}}`
+	tests := []struct {
+		method, path, token, body string
+		expected                  int
+	}{
+		{"POST", "/telegram", "wrong", update, http.StatusForbidden},
+		{"POST", "/telegram", "", update, http.StatusForbidden},
+		{"POST", "/other", "s3cret", update, http.StatusNotFound},
+		{"GET", "/telegram", "s3cret", "", http.StatusMethodNotAllowed},
+		{"POST", "/telegram", "s3cret", "{not json", http.StatusBadRequest},
+		{"POST", "/telegram", "s3cret", update, http.StatusOK},
+	}
+	for i, tc := range tests {
+		if got := post(tc.method, tc.path, tc.token, tc.body); got != tc.expected {
+			t.Errorf("%d: %s %s expected %d, got %d", i, tc.method, tc.path, tc.expected, got)
+		}
+	}
+
+	select {
+	case u := <-s.Updates():
+		if u.UpdateID != 42 || u.Message == nil || u.Message.Text != "/balance" || u.Message.From.ID != 1002 {
+			t.Errorf("Unexpected update %+v", u)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the update to be received")
+	}
+	select {
+	case u := <-s.Updates():
+		t.Errorf("Expected only the accepted update, got %+v", u)
+	default:
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.Done():
+	default:
+		t.Errorf("Expected Done to be closed after Shutdown")
+	}
+}
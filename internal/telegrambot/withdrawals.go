@@ -0,0 +1,637 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/depositmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+	"github.com/BigOokie/skywire-wing-commander/internal/wcconst"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// withdrawalConfirmTimeout is how long a withdrawal waits for the user to confirm it
+	withdrawalConfirmTimeout = 5 * time.Minute
+	// withdrawalSendTimeout is how long a confirmed withdrawal has to be built, broadcast and recorded
+	withdrawalSendTimeout = 2 * time.Minute
+	// withdrawalBroadcastTimeout is how long after it was created a broadcast withdrawal which
+	// is unknown to the node is failed and refunded
+	withdrawalBroadcastTimeout = 24 * time.Hour
+	// withdrawalPageSize is the number of withdrawals checked by each request of the status loop
+	withdrawalPageSize = 100
+	// userPageSize is the number of users whose outputs are requested at once
+	userPageSize = 100
+)
+
+// errBroadcastUnknown is returned when a withdrawal was recorded as broadcast but it isn't
+// known whether the node received it. updateWithdrawals settles it using its txid.
+var errBroadcastUnknown = errors.New("withdrawal may not have been broadcast")
+
+// parseWithdrawArgs parses the arguments of /withdraw in the form `<amount|all> <address>`.
+// all is true (and amount 0) when the whole balance should be withdrawn.
+func parseWithdrawArgs(args string) (amount uint64, all bool, address string, err error) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return 0, false, "", fmt.Errorf("expected an amount and an address")
+	}
+
+	address = fields[1]
+	if strings.EqualFold(fields[0], "all") {
+		return 0, true, address, nil
+	}
+	amount, err = wallet.ParseAmount(fields[0])
+	return amount, false, address, err
+}
+
+// withdrawalReference returns the ledger reference of the debit made for a withdrawal
+func withdrawalReference(id int64) string {
+	return "withdrawal:" + strconv.FormatInt(id, 10)
+}
+
+// withdrawalRefundReference returns the ledger reference of the refund of a failed withdrawal
+func withdrawalRefundReference(id int64) string {
+	return "withdrawal-refund:" + strconv.FormatInt(id, 10)
+}
+
+// hotWalletOutputs returns the unspent outputs held by every user address which can be
+// spent by a withdrawal (see depositmon.Spendable), and the users owning them (by address).
+// Withdrawals are paid from all the user addresses, as the balance of a user is not tied
+// to the coins held by their own address.
+func (bot *Bot) hotWalletOutputs(ctx context.Context) ([]wallet.Output, map[string]*store.User, error) {
+	var outputs []wallet.Output
+	owners := make(map[string]*store.User)
+	for offset := 0; ; offset += userPageSize {
+		users, err := bot.store.Users().List(ctx, offset, userPageSize)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var addrs []string
+		for i := range users {
+			if users[i].Address != "" {
+				owners[users[i].Address] = &users[i]
+				addrs = append(addrs, users[i].Address)
+			}
+		}
+		if len(addrs) > 0 {
+			outs, err := bot.wallet.GetOutputs(ctx, addrs...)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, out := range outs.Outputs {
+				spendable, err := depositmon.Spendable(ctx, bot.store, out)
+				if err != nil {
+					return nil, nil, err
+				}
+				if spendable {
+					outputs = append(outputs, out)
+				}
+			}
+		}
+
+		if len(users) < userPageSize {
+			return outputs, owners, nil
+		}
+	}
+}
+
+// pendingWithdrawal returns the latest withdrawal of the user waiting to be confirmed
+func (bot *Bot) pendingWithdrawal(ctx context.Context, u *store.User) (*store.Transaction, error) {
+	pending, err := bot.store.Transactions().List(ctx, store.TransactionFilter{
+		UserID: u.ID,
+		Kind:   store.TxKindWithdrawal,
+		Status: store.TxStatusPending,
+	}, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return &pending[0], nil
+}
+
+// cancelPendingWithdrawals cancels the withdrawals of the user which are waiting to be confirmed
+func (bot *Bot) cancelPendingWithdrawals(ctx context.Context, u *store.User) error {
+	for {
+		t, err := bot.pendingWithdrawal(ctx, u)
+		if err == store.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		t.Status = store.TxStatusCancelled
+		if err := bot.store.Transactions().UpdateStatus(ctx, t, store.TxStatusPending); err != nil {
+			return err
+		}
+	}
+}
+
+// sendWithdrawal debits the balance of the user, then builds, signs and broadcasts the
+// withdrawal transaction. If the transaction can't be sent the debit is refunded and the
+// withdrawal is marked failed. If the node may have received it despite an error, the
+// withdrawal is left broadcast and errBroadcastUnknown is returned. The caller must hold bot.withdrawalLock, as withdrawals
+// spend the outputs shared by every user address.
+func (bot *Bot) sendWithdrawal(ctx context.Context, u *store.User, t *store.Transaction) error {
+	l, err := bot.userLimits(ctx, u.TelegramID)
+	if err != nil {
+		return err
+	}
+
+	// The ledger reference makes the debit idempotent, a withdrawal is only debited once.
+	// The daily cap of the user and the hourly outflow cap are checked with the debit.
+	err = bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:      store.TransferWithdrawal,
+		From:      store.UserAccount(u.ID),
+		To:        store.AccountOnChain,
+		Amount:    t.Coins,
+		Memo:      t.ToAddress,
+		Reference: withdrawalReference(t.ID),
+		Limits:    &store.TransferLimits{DailyCap: l.DailyCap, HourlyOutflowCap: l.HourlyOutflowCap},
+	})
+	if err == store.ErrDuplicate {
+		return store.ErrStatusChanged
+	} else if err == store.ErrInsufficientFunds || err == store.ErrDailyCapExceeded || err == store.ErrOutflowCapExceeded {
+		bot.failWithdrawal(ctx, t, store.TxStatusPending, err, false)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	tx, err := bot.buildWithdrawal(ctx, t)
+	if err != nil {
+		bot.failWithdrawal(ctx, t, store.TxStatusPending, err, true)
+		return err
+	}
+
+	// The txid is recorded before the transaction is broadcast, so the change of the
+	// withdrawal is never credited as a deposit
+	t.TxID = tx.TxID
+	t.Status = store.TxStatusBroadcast
+	if tx.Fee > 0 {
+		t.Hours = tx.Fee
+	}
+	if err := bot.store.Transactions().UpdateStatus(ctx, t, store.TxStatusPending); err != nil {
+		bot.failWithdrawal(ctx, t, store.TxStatusPending, err, true)
+		return err
+	}
+
+	txid, err := bot.wallet.BroadcastTransaction(ctx, tx.RawTx)
+	if _, rejected := err.(*wallet.RejectedError); rejected {
+		bot.failWithdrawal(ctx, t, store.TxStatusBroadcast, err, true)
+		return err
+	} else if err != nil {
+		log.Errorf("Bot.sendWithdrawal: Withdrawal %d (txid %s) may not have been broadcast: %v", t.ID, t.TxID, err)
+		return errBroadcastUnknown
+	}
+	if txid != t.TxID {
+		log.Errorf("Bot.sendWithdrawal: Withdrawal %d was broadcast as txid %s, expected %s", t.ID, txid, t.TxID)
+	}
+	log.Infof("Bot.sendWithdrawal: %s withdrew %s SKY to %s (txid %s)", u.UserName, wallet.FormatDroplets(t.Coins), t.ToAddress, t.TxID)
+	return nil
+}
+
+// buildWithdrawal creates and signs the withdrawal transaction. The outputs are selected
+// from the spendable outputs of all the user addresses, and the change is returned to
+// the address of the user (the FromAddress of the withdrawal).
+func (bot *Bot) buildWithdrawal(ctx context.Context, t *store.Transaction) (*wallet.Transaction, error) {
+	outputs, owners, err := bot.hotWalletOutputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := wallet.SelectOutputs(outputs, t.Coins)
+	if err != nil {
+		return nil, err
+	}
+
+	var from []wallet.Address
+	var hashes []string
+	signed := make(map[string]bool)
+	for _, out := range selected {
+		hashes = append(hashes, out.Hash)
+		if signed[out.Address] {
+			continue
+		}
+		owner, found := owners[out.Address]
+		if !found {
+			return nil, fmt.Errorf("no user owns output address %s", out.Address)
+		}
+		addr, err := bot.signingAddress(owner)
+		if err != nil {
+			return nil, err
+		}
+		from = append(from, addr)
+		signed[out.Address] = true
+	}
+
+	return bot.wallet.CreateTransaction(ctx, wallet.TxRequest{
+		From:          from,
+		To:            t.ToAddress,
+		Coins:         t.Coins,
+		ChangeAddress: t.FromAddress,
+		Outputs:       hashes,
+	})
+}
+
+// failWithdrawal marks the withdrawal (with the from status) failed, refunding the balance
+// of the user if it was debited. The error recording the failure is returned.
+func (bot *Bot) failWithdrawal(ctx context.Context, t *store.Transaction, from string, cause error, refund bool) error {
+	log.Errorf("Bot.failWithdrawal: Withdrawal %d failed: %v", t.ID, cause)
+
+	if refund {
+		err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+			Kind:      store.TransferWithdrawal,
+			From:      store.AccountOnChain,
+			To:        store.UserAccount(t.UserID),
+			Amount:    t.Coins,
+			Memo:      "refund",
+			Reference: withdrawalRefundReference(t.ID),
+		})
+		if err != nil && err != store.ErrDuplicate {
+			log.Errorf("Bot.failWithdrawal: Error refunding withdrawal %d: %v", t.ID, err)
+		}
+	}
+
+	t.Status = store.TxStatusFailed
+	t.Error = cause.Error()
+	err := bot.store.Transactions().UpdateStatus(ctx, t, from)
+	if err != nil {
+		log.Errorf("Bot.failWithdrawal: Error recording failure of withdrawal %d: %v", t.ID, err)
+	}
+	return err
+}
+
+// Handler for withdraw command
+func (bot *Bot) handleCommandWithdraw(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandWithdraw", err)
+		}
+		return err
+	}
+
+	// Without arguments the amount and address are asked for one at a time
+	if strings.TrimSpace(args) == "" && ctx.User != nil {
+		bot.SendGAEvent("BotCommand", command+"-ask", "Handle"+command)
+		return bot.askWithdrawAmount(ctx, command)
+	}
+
+	amount, all, address, err := parseWithdrawArgs(args)
+	if err != nil {
+		log.Debugf("Bot.handleCommandWithdraw: Invalid arguments %q: %v", args, err)
+		bot.SendGAEvent("BotCommand", command+"-usage", "Handle"+command)
+		return reply(wcconst.MsgWithdrawUsage)
+	}
+	if err := wallet.ValidateAddress(address); err != nil {
+		log.Debugf("Bot.handleCommandWithdraw: Invalid address %q: %v", address, err)
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawInvalidAddress, EscapeMarkdown(address)))
+	}
+
+	if ctx.User == nil {
+		return reply(wcconst.MsgSendSkyNoWallet)
+	}
+
+	storectx := ctx.Context()
+	u, err := bot.lookupUser(storectx, ctx.User)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgSendSkyNoWallet)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error getting wallet for @%s: %v", ctx.User.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	if address == u.Address {
+		return reply(wcconst.MsgWithdrawToSelf)
+	}
+
+	balance, err := bot.store.Ledger().Balance(storectx, store.UserAccount(u.ID))
+	if err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error getting balance of %s: %v", u.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	if all {
+		amount = wallet.TruncateDroplets(uint64(balance))
+	}
+	if amount == 0 || amount > uint64(balance) {
+		bot.SendGAEvent("BotCommand", command+"-insufficient", "Handle"+command)
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawInsufficient, wallet.FormatDroplets(amount), wallet.FormatDroplets(uint64(balance))))
+	}
+
+	// The daily cap is checked again when the withdrawal is confirmed, this lets the user
+	// know before they confirm
+	l, err := bot.userLimits(storectx, u.TelegramID)
+	if err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error getting limits of %s: %v", u.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	if l.DailyCap > 0 {
+		sent, err := bot.store.Ledger().Sent(storectx, store.UserAccount(u.ID), time.Now().Add(-store.DailyCapWindow))
+		if err != nil {
+			log.Errorf("Bot.handleCommandWithdraw: Error getting the amount sent by %s: %v", u.UserName, err)
+			return reply(wcconst.MsgErrorStore)
+		}
+		if sent+amount > l.DailyCap {
+			bot.SendGAEvent("BotCommand", command+"-limit", "Handle"+command)
+			msg, _ := bot.limitMessage(storectx, u, store.ErrDailyCapExceeded)
+			return reply(msg)
+		}
+	}
+
+	// Estimate the coin hours burned by the transaction from the outputs it would spend
+	outputs, _, err := bot.hotWalletOutputs(storectx)
+	if err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error getting outputs: %v", err)
+		return reply(wcconst.MsgErrorWallet)
+	}
+	selected, err := wallet.SelectOutputs(outputs, amount)
+	if err != nil {
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawUnavailable, wallet.FormatDroplets(amount)))
+	}
+	burn, err := wallet.HoursBurn(selected)
+	if err != nil {
+		return reply(wcconst.MsgWithdrawNoHours)
+	}
+
+	// Only the latest withdrawal can be confirmed
+	bot.withdrawalLock.Lock()
+	defer bot.withdrawalLock.Unlock()
+	if err := bot.cancelPendingWithdrawals(storectx, u); err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error cancelling pending withdrawals of %s: %v", u.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	t := &store.Transaction{
+		UserID:      u.ID,
+		Kind:        store.TxKindWithdrawal,
+		Status:      store.TxStatusPending,
+		FromAddress: u.Address,
+		ToAddress:   address,
+		Coins:       amount,
+		Hours:       burn,
+	}
+	if err := bot.store.Transactions().Create(storectx, t); err != nil {
+		log.Errorf("Bot.handleCommandWithdraw: Error recording withdrawal: %v", err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+
+	// The buttons only work for this withdrawal, until it expires
+	id := strconv.FormatInt(t.ID, 10)
+	confirm, err := bot.callbackButton("confirmwithdraw", "confirmwithdraw "+id, withdrawalConfirmTimeout)
+	if err != nil {
+		return err
+	}
+	cancel, err := bot.callbackButton("cancelwithdraw", "cancelwithdraw "+id, withdrawalConfirmTimeout)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf(wcconst.MsgWithdrawConfirm, wallet.FormatDroplets(amount), address, burn, int(withdrawalConfirmTimeout/time.Minute))
+	err = bot.SendReplyInlineKeyboard(ctx, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(confirm, cancel)), msg)
+	if err != nil {
+		logSendError("Bot.handleCommandWithdraw", err)
+	}
+	return err
+}
+
+// Handler for confirmwithdraw command
+func (bot *Bot) handleCommandConfirmWithdraw(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandConfirmWithdraw", err)
+		}
+		return err
+	}
+
+	if ctx.User == nil {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	}
+
+	bot.withdrawalLock.Lock()
+	defer bot.withdrawalLock.Unlock()
+
+	// A confirmed withdrawal isn't interrupted by the handler timeout, so it is never
+	// left between being broadcast and being recorded
+	storectx, cancel := context.WithTimeout(context.Background(), withdrawalSendTimeout)
+	defer cancel()
+	u, err := bot.lookupUser(storectx, ctx.User)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandConfirmWithdraw: Error getting wallet for @%s: %v", ctx.User.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	t, err := bot.pendingWithdrawal(storectx, u)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandConfirmWithdraw: Error getting pending withdrawal of %s: %v", u.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	// The button of an earlier withdrawal doesn't confirm a later one
+	if args != "" && args != strconv.FormatInt(t.ID, 10) {
+		return reply(wcconst.MsgWithdrawReplaced)
+	}
+
+	if time.Since(t.CreatedAt) > withdrawalConfirmTimeout {
+		t.Status = store.TxStatusCancelled
+		if err := bot.store.Transactions().UpdateStatus(storectx, t, store.TxStatusPending); err != nil {
+			log.Errorf("Bot.handleCommandConfirmWithdraw: Error cancelling withdrawal %d: %v", t.ID, err)
+		}
+		return reply(wcconst.MsgWithdrawExpired)
+	}
+
+	err = bot.sendWithdrawal(storectx, u, t)
+	switch {
+	case err == store.ErrStatusChanged:
+		return reply(wcconst.MsgWithdrawInProgress)
+	case err == errBroadcastUnknown:
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawUnknown, wallet.FormatDroplets(t.Coins), t.ToAddress, t.TxID))
+	case err == store.ErrInsufficientFunds:
+		balance, _ := bot.store.Ledger().Balance(storectx, store.UserAccount(u.ID))
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawInsufficient, wallet.FormatDroplets(t.Coins), wallet.FormatDroplets(uint64(balance))))
+	case err == store.ErrDailyCapExceeded || err == store.ErrOutflowCapExceeded:
+		bot.SendGAEvent("BotCommand", command+"-limit", "Handle"+command)
+		msg, _ := bot.limitMessage(storectx, u, err)
+		return reply(msg)
+	case err != nil:
+		bot.SendGAEvent("BotCommand", command+"-failed", "Handle"+command)
+		return reply(fmt.Sprintf(wcconst.MsgWithdrawFailed, wallet.FormatDroplets(t.Coins)))
+	}
+
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+	balance, _ := bot.store.Ledger().Balance(storectx, store.UserAccount(u.ID))
+	return reply(fmt.Sprintf(wcconst.MsgWithdrawBroadcast, wallet.FormatDroplets(t.Coins), t.ToAddress, t.TxID, t.Hours,
+		wallet.FormatDroplets(uint64(balance))))
+}
+
+// Handler for cancelwithdraw command
+func (bot *Bot) handleCommandCancelWithdraw(ctx *BotContext, command, args string) error {
+	log.Debugf("Handle command: %s args: %s", command, args)
+
+	reply := func(text string) error {
+		err := bot.Send(ctx, getSendModeforContext(ctx), "markdown", text)
+		if err != nil {
+			logSendError("Bot.handleCommandCancelWithdraw", err)
+		}
+		return err
+	}
+
+	if ctx.User == nil {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	}
+
+	bot.withdrawalLock.Lock()
+	defer bot.withdrawalLock.Unlock()
+
+	storectx := ctx.Context()
+	u, err := bot.lookupUser(storectx, ctx.User)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	} else if err != nil {
+		log.Errorf("Bot.handleCommandCancelWithdraw: Error getting wallet for @%s: %v", ctx.User.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+
+	t, err := bot.pendingWithdrawal(storectx, u)
+	if err == store.ErrNotFound {
+		return reply(wcconst.MsgWithdrawNothingPending)
+	} else if err == nil && args != "" && args != strconv.FormatInt(t.ID, 10) {
+		return reply(wcconst.MsgWithdrawReplaced)
+	}
+	if err := bot.cancelPendingWithdrawals(storectx, u); err != nil {
+		log.Errorf("Bot.handleCommandCancelWithdraw: Error cancelling withdrawals of %s: %v", u.UserName, err)
+		return reply(wcconst.MsgErrorStore)
+	}
+	bot.SendGAEvent("BotCommand", command, "Handle"+command)
+	ctx.SetCallbackAnswer(wcconst.MsgWithdrawCancelled, false)
+	return reply(wcconst.MsgWithdrawCancelled)
+}
+
+// updateWithdrawals marks broadcast withdrawals which are now in a block as confirmed,
+// letting the user know, and cancels withdrawals which were never confirmed by the user.
+// Broadcast withdrawals still unknown to the node after withdrawalBroadcastTimeout never
+// reached the network, so they are failed and refunded.
+func (bot *Bot) updateWithdrawals(ctx context.Context) error {
+	txns := bot.store.Transactions()
+
+	broadcast, err := bot.listWithdrawals(ctx, store.TxStatusBroadcast)
+	if err != nil {
+		return err
+	}
+	for i := range broadcast {
+		t := &broadcast[i]
+		msg := wcconst.MsgWithdrawConfirmed
+		status, err := bot.wallet.GetTransaction(ctx, t.TxID)
+		switch {
+		case err == wallet.ErrTxNotFound && time.Since(t.CreatedAt) > withdrawalBroadcastTimeout:
+			cause := fmt.Errorf("transaction %s not known to the node after %v", t.TxID, withdrawalBroadcastTimeout)
+			if err := bot.failWithdrawal(ctx, t, store.TxStatusBroadcast, cause, true); err != nil {
+				continue
+			}
+			msg = wcconst.MsgWithdrawRefunded
+		case err == wallet.ErrTxNotFound:
+			log.Warnf("Bot.updateWithdrawals: Withdrawal %d (txid %s) is not known to the node", t.ID, t.TxID)
+			continue
+		case err != nil:
+			return err
+		case !status.Confirmed:
+			continue
+		default:
+			t.Status = store.TxStatusConfirmed
+			if err := txns.UpdateStatus(ctx, t, store.TxStatusBroadcast); err == store.ErrStatusChanged {
+				continue
+			} else if err != nil {
+				return err
+			}
+			log.Infof("Bot.updateWithdrawals: Withdrawal %d (txid %s) confirmed", t.ID, t.TxID)
+		}
+
+		u, err := bot.store.Users().Get(ctx, t.UserID)
+		if err != nil || u.ChatID == 0 {
+			continue
+		}
+		msg = fmt.Sprintf(msg, wallet.FormatDroplets(t.Coins), t.ToAddress, t.TxID)
+		if err := bot.SendToChat(u.ChatID, "markdown", msg); err != nil {
+			logSendError("Bot.updateWithdrawals", err)
+		}
+	}
+
+	bot.withdrawalLock.Lock()
+	defer bot.withdrawalLock.Unlock()
+
+	pending, err := bot.listWithdrawals(ctx, store.TxStatusPending)
+	if err != nil {
+		return err
+	}
+	for i := range pending {
+		t := &pending[i]
+		if time.Since(t.CreatedAt) <= withdrawalConfirmTimeout {
+			continue
+		}
+		t.Status = store.TxStatusCancelled
+		if err := txns.UpdateStatus(ctx, t, store.TxStatusPending); err != nil && err != store.ErrStatusChanged {
+			return err
+		}
+	}
+	return nil
+}
+
+// listWithdrawals returns every withdrawal with the status, requesting withdrawalPageSize
+// at a time. Withdrawals moved to a later page by new withdrawals are only returned once.
+func (bot *Bot) listWithdrawals(ctx context.Context, status string) ([]store.Transaction, error) {
+	filter := store.TransactionFilter{Kind: store.TxKindWithdrawal, Status: status}
+	var withdrawals []store.Transaction
+	seen := make(map[int64]bool)
+	for offset := 0; ; offset += withdrawalPageSize {
+		page, err := bot.store.Transactions().List(ctx, filter, offset, withdrawalPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range page {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				withdrawals = append(withdrawals, t)
+			}
+		}
+		if len(page) < withdrawalPageSize {
+			return withdrawals, nil
+		}
+	}
+}
+
+// withdrawalEventLoop checks the status of the withdrawals every interval, until runctx is cancelled
+func (bot *Bot) withdrawalEventLoop(runctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := bot.updateWithdrawals(runctx); err != nil {
+				log.Errorf("Bot.withdrawalEventLoop: %v", err)
+			}
+
+		case <-runctx.Done():
+			log.Debugln("Bot.withdrawalEventLoop - Done event.")
+			return
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/depositmon"
+	"github.com/BigOokie/skywire-wing-commander/internal/keyvault"
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"github.com/BigOokie/skywire-wing-commander/internal/wallet"
+)
+
+const testWithdrawAddress = "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+
+// newTestWalletBot creates a Bot with an in-memory store and a fake wallet
+// backend, and two users holding derived addresses
+func newTestWalletBot(t *testing.T) (*Bot, *wallet.FakeBackend, []*store.User) {
+	vault, err := keyvault.New(bytes.Repeat([]byte{0x42}, keyvault.MasterKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := vault.SealSeed("test seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := wallet.NewFakeBackend()
+	bot := &Bot{
+		store:  store.NewMemoryStore(),
+		wallet: fake,
+		vault:  vault,
+		seed:   seed,
+	}
+
+	var users []*store.User
+	for _, name := range []string{"@alice", "@bob"} {
+		u := &store.User{UserName: name}
+		if err := bot.newUserWallet(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+	return bot, fake, users
+}
+
+func Test_parseWithdrawArgs(t *testing.T) {
+	amount, all, address, err := parseWithdrawArgs("1.5 " + testWithdrawAddress)
+	if err != nil || amount != 1500000 || all || address != testWithdrawAddress {
+		t.Errorf("Unexpected result: %d, %v, %q, %v", amount, all, address, err)
+	}
+
+	amount, all, address, err = parseWithdrawArgs("ALL " + testWithdrawAddress)
+	if err != nil || amount != 0 || !all || address != testWithdrawAddress {
+		t.Errorf("Unexpected result: %d, %v, %q, %v", amount, all, address, err)
+	}
+
+	for _, args := range []string{"", "10", testWithdrawAddress, "abc " + testWithdrawAddress, "0.0001 " + testWithdrawAddress, "10 addr extra"} {
+		if _, _, _, err := parseWithdrawArgs(args); err == nil {
+			t.Errorf("parseWithdrawArgs(%q) expected an error", args)
+		}
+	}
+}
+
+func Test_sendWithdrawal(t *testing.T) {
+	ctx := context.Background()
+	bot, fake, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+
+	// Alice's balance is held on-chain by Bob's address
+	for _, deposit := range []struct {
+		hash string
+		user *store.User
+	}{{"out1", alice}, {"out3", bob}} {
+		err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+			Kind:      store.TransferDeposit,
+			From:      store.AccountOnChain,
+			To:        store.UserAccount(deposit.user.ID),
+			Amount:    5000000,
+			Reference: "output:" + deposit.hash,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.SetBalance(bob.Address, wallet.Balance{Coins: 5000000, Hours: 10})
+	fake.AddOutput(wallet.Output{Hash: "out1", Address: bob.Address, Coins: 5000000, Hours: 10})
+	// A deposit which hasn't been credited yet, and an output spent by an unconfirmed
+	// transaction, can't be spent
+	fake.AddOutput(wallet.Output{Hash: "out2", Address: bob.Address, Coins: 9000000, Hours: 10})
+	fake.AddOutput(wallet.Output{Hash: "out3", Address: bob.Address, Coins: 9000000, Hours: 10, Spending: true})
+	outputs, _, err := bot.hotWalletOutputs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 1 || outputs[0].Hash != "out1" {
+		t.Errorf("Expected only out1 to be spendable, got %+v", outputs)
+	}
+
+	withdrawal := &store.Transaction{
+		UserID:      alice.ID,
+		Kind:        store.TxKindWithdrawal,
+		Status:      store.TxStatusPending,
+		FromAddress: alice.Address,
+		ToAddress:   testWithdrawAddress,
+		Coins:       2000000,
+		Hours:       5,
+	}
+	if err := bot.store.Transactions().Create(ctx, withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bot.sendWithdrawal(ctx, alice, withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := bot.store.Transactions().Get(ctx, withdrawal.ID)
+	if stored.Status != store.TxStatusBroadcast || stored.TxID == "" {
+		t.Errorf("Unexpected withdrawal: %+v", stored)
+	}
+	if outs, _ := fake.GetOutputs(ctx, bob.Address); len(outs.Outputs) != 2 || outs.Outputs[0].Hash != "out2" {
+		t.Errorf("Expected only out1 to be spent, got %+v", outs.Outputs)
+	}
+	// The change of the withdrawal can be spent
+	change := wallet.Output{Hash: "out4", TxID: stored.TxID, Address: alice.Address, Coins: 3000000}
+	if spendable, err := depositmon.Spendable(ctx, bot.store, change); err != nil || !spendable {
+		t.Errorf("Expected the change to be spendable, got %v, %v", spendable, err)
+	}
+	if balance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(alice.ID)); balance != 3000000 {
+		t.Errorf("Unexpected balance after withdrawal: %d", balance)
+	}
+	if b, _ := fake.GetBalance(ctx, testWithdrawAddress); b.Confirmed.Coins != 2000000 {
+		t.Errorf("Unexpected withdrawn coins: %d", b.Confirmed.Coins)
+	}
+	if b, _ := fake.GetBalance(ctx, alice.Address); b.Confirmed.Coins != 3000000 {
+		t.Errorf("Expected the change to be returned to Alice's address, got %d", b.Confirmed.Coins)
+	}
+
+	// A withdrawal is only sent once
+	if err := bot.sendWithdrawal(ctx, alice, withdrawal); err != store.ErrStatusChanged {
+		t.Errorf("Expected ErrStatusChanged, got %v", err)
+	}
+
+	if err := bot.updateWithdrawals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := bot.store.Transactions().Get(ctx, withdrawal.ID); stored.Status != store.TxStatusConfirmed {
+		t.Errorf("Expected the withdrawal to be confirmed, got %s", stored.Status)
+	}
+}
+
+func Test_sendWithdrawal_Failed(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+	alice := users[0]
+
+	// The balance isn't backed by any outputs, so the transaction can't be built
+	err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:   store.TransferDeposit,
+		From:   store.AccountOnChain,
+		To:     store.UserAccount(alice.ID),
+		Amount: 5000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withdrawal := &store.Transaction{
+		UserID:      alice.ID,
+		Kind:        store.TxKindWithdrawal,
+		Status:      store.TxStatusPending,
+		FromAddress: alice.Address,
+		ToAddress:   testWithdrawAddress,
+		Coins:       2000000,
+	}
+	if err := bot.store.Transactions().Create(ctx, withdrawal); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bot.sendWithdrawal(ctx, alice, withdrawal); err != wallet.ErrInsufficientBalance {
+		t.Errorf("Expected ErrInsufficientBalance, got %v", err)
+	}
+	stored, _ := bot.store.Transactions().Get(ctx, withdrawal.ID)
+	if stored.Status != store.TxStatusFailed || stored.Error == "" {
+		t.Errorf("Unexpected withdrawal: %+v", stored)
+	}
+	if balance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(alice.ID)); balance != 5000000 {
+		t.Errorf("Expected the withdrawal to be refunded, balance is %d", balance)
+	}
+}
+
+// newTestWithdrawal credits a deposit of 5 SKY held by an output of the user's address,
+// and creates a pending withdrawal of coins by the user
+func newTestWithdrawal(t *testing.T, bot *Bot, fake *wallet.FakeBackend, u *store.User, coins uint64, createdAt time.Time) *store.Transaction {
+	ctx := context.Background()
+	err := bot.store.Ledger().Transfer(ctx, &store.Transfer{
+		Kind:      store.TransferDeposit,
+		From:      store.AccountOnChain,
+		To:        store.UserAccount(u.ID),
+		Amount:    5000000,
+		Reference: "output:out-" + u.Address,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetBalance(u.Address, wallet.Balance{Coins: 5000000, Hours: 10})
+	fake.AddOutput(wallet.Output{Hash: "out-" + u.Address, Address: u.Address, Coins: 5000000, Hours: 10})
+
+	withdrawal := &store.Transaction{
+		UserID:      u.ID,
+		Kind:        store.TxKindWithdrawal,
+		Status:      store.TxStatusPending,
+		FromAddress: u.Address,
+		ToAddress:   testWithdrawAddress,
+		Coins:       coins,
+		CreatedAt:   createdAt,
+	}
+	if err := bot.store.Transactions().Create(ctx, withdrawal); err != nil {
+		t.Fatal(err)
+	}
+	return withdrawal
+}
+
+func Test_sendWithdrawal_Rejected(t *testing.T) {
+	ctx := context.Background()
+	bot, fake, users := newTestWalletBot(t)
+	alice := users[0]
+	withdrawal := newTestWithdrawal(t, bot, fake, alice, 2000000, time.Now())
+
+	fake.FailNextBroadcast(&wallet.RejectedError{Reason: "invalid transaction"})
+	if _, ok := bot.sendWithdrawal(ctx, alice, withdrawal).(*wallet.RejectedError); !ok {
+		t.Error("Expected a RejectedError")
+	}
+	if stored, _ := bot.store.Transactions().Get(ctx, withdrawal.ID); stored.Status != store.TxStatusFailed {
+		t.Errorf("Expected the withdrawal to fail, got %s", stored.Status)
+	}
+	if balance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(alice.ID)); balance != 5000000 {
+		t.Errorf("Expected the withdrawal to be refunded, balance is %d", balance)
+	}
+}
+
+func Test_sendWithdrawal_BroadcastUnknown(t *testing.T) {
+	ctx := context.Background()
+	bot, fake, users := newTestWalletBot(t)
+	alice, bob := users[0], users[1]
+	recent := newTestWithdrawal(t, bot, fake, alice, 2000000, time.Now())
+	old := newTestWithdrawal(t, bot, fake, bob, 2000000, time.Now().Add(-withdrawalBroadcastTimeout-time.Minute))
+
+	// The node may have received the transactions, so they aren't refunded yet
+	for _, w := range []struct {
+		u *store.User
+		t *store.Transaction
+	}{{alice, recent}, {bob, old}} {
+		fake.FailNextBroadcast(errors.New("connection reset by peer"))
+		if err := bot.sendWithdrawal(ctx, w.u, w.t); err != errBroadcastUnknown {
+			t.Errorf("Expected errBroadcastUnknown, got %v", err)
+		}
+		if stored, _ := bot.store.Transactions().Get(ctx, w.t.ID); stored.Status != store.TxStatusBroadcast || stored.TxID == "" {
+			t.Errorf("Unexpected withdrawal: %+v", stored)
+		}
+		if balance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(w.u.ID)); balance != 3000000 {
+			t.Errorf("Unexpected balance: %d", balance)
+		}
+	}
+
+	// Neither transaction reached the node. Only the old withdrawal is refunded.
+	if err := bot.updateWithdrawals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := bot.store.Transactions().Get(ctx, recent.ID); stored.Status != store.TxStatusBroadcast {
+		t.Errorf("Expected the recent withdrawal to stay broadcast, got %s", stored.Status)
+	}
+	if stored, _ := bot.store.Transactions().Get(ctx, old.ID); stored.Status != store.TxStatusFailed {
+		t.Errorf("Expected the old withdrawal to fail, got %s", stored.Status)
+	}
+	if balance, _ := bot.store.Ledger().Balance(ctx, store.UserAccount(bob.ID)); balance != 5000000 {
+		t.Errorf("Expected the old withdrawal to be refunded, balance is %d", balance)
+	}
+}
+
+func Test_updateWithdrawals_Pages(t *testing.T) {
+	ctx := context.Background()
+	bot, _, users := newTestWalletBot(t)
+
+	// Every expired withdrawal is cancelled, not only the first page
+	expired := time.Now().Add(-withdrawalConfirmTimeout - time.Minute)
+	for i := 0; i < withdrawalPageSize+5; i++ {
+		err := bot.store.Transactions().Create(ctx, &store.Transaction{
+			UserID:    users[0].ID,
+			Kind:      store.TxKindWithdrawal,
+			Status:    store.TxStatusPending,
+			ToAddress: testWithdrawAddress,
+			Coins:     1000000,
+			CreatedAt: expired,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bot.updateWithdrawals(ctx); err != nil {
+		t.Fatal(err)
+	}
+	filter := store.TransactionFilter{Kind: store.TxKindWithdrawal, Status: store.TxStatusPending}
+	if pending, _ := bot.store.Transactions().List(ctx, filter, 0, withdrawalPageSize); len(pending) != 0 {
+		t.Errorf("Expected every withdrawal to be cancelled, %d pending", len(pending))
+	}
+}
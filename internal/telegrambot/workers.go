@@ -0,0 +1,177 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+var (
+	// errChatQueueFull is returned by updateWorkers.Submit when the chat has too many updates waiting
+	errChatQueueFull = errors.New("too many updates waiting in the chat")
+	// errWorkersStopped is returned by updateWorkers.Submit once the workers are shutting down
+	errWorkersStopped = errors.New("update workers stopped")
+)
+
+// updateWorkers handles updates concurrently, using a fixed number of workers. The updates
+// of each chat are handled one at a time in the order they were received, so the updates
+// of a slow chat only hold up that chat. Submit blocks while queueSize updates are waiting
+// (i.e. the Bot stops reading updates), and drops updates of a chat with chatQueueSize
+// updates waiting.
+type updateWorkers struct {
+	mutex         sync.Mutex
+	chats         map[int64][]tgbotapi.Update
+	ready         chan int64
+	slots         chan struct{}
+	chatQueueSize int
+	stopped       bool
+	pending       sync.WaitGroup
+	quit          chan struct{}
+	quitOnce      sync.Once
+	handle        func(*tgbotapi.Update)
+}
+
+// newUpdateWorkers starts workers goroutines handling the submitted updates with handle
+func newUpdateWorkers(workers, queueSize, chatQueueSize int, handle func(*tgbotapi.Update)) *updateWorkers {
+	w := &updateWorkers{
+		chats: make(map[int64][]tgbotapi.Update),
+		// Every chat in ready has at least one update waiting, so it never holds more than queueSize chats
+		ready:         make(chan int64, queueSize),
+		slots:         make(chan struct{}, queueSize),
+		chatQueueSize: chatQueueSize,
+		quit:          make(chan struct{}),
+		handle:        handle,
+	}
+	for i := 0; i < workers; i++ {
+		go w.run()
+	}
+	return w
+}
+
+// updateChatID returns the ID of the chat of an update, or 0 for updates without a chat
+func updateChatID(update *tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	}
+	return 0
+}
+
+// Submit queues the update to be handled after the earlier updates of its chat. While the
+// queue is full it waits until ctx is done.
+func (w *updateWorkers) Submit(ctx context.Context, update tgbotapi.Update) error {
+	select {
+	case w.slots <- struct{}{}:
+	case <-w.quit:
+		return errWorkersStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	chatID := updateChatID(&update)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.stopped {
+		<-w.slots
+		return errWorkersStopped
+	}
+	queue, busy := w.chats[chatID]
+	if len(queue) >= w.chatQueueSize {
+		<-w.slots
+		return errChatQueueFull
+	}
+	w.pending.Add(1)
+	w.chats[chatID] = append(queue, update)
+	if !busy {
+		w.ready <- chatID
+	}
+	return nil
+}
+
+// run handles the next update of the ready chats until the workers are stopped
+func (w *updateWorkers) run() {
+	for {
+		select {
+		case chatID := <-w.ready:
+			w.mutex.Lock()
+			update := w.chats[chatID][0]
+			w.mutex.Unlock()
+
+			w.handle(&update)
+
+			// The chat stays busy until its last update has been handled
+			w.mutex.Lock()
+			if queue := w.chats[chatID][1:]; len(queue) > 0 {
+				w.chats[chatID] = queue
+				w.ready <- chatID
+			} else {
+				delete(w.chats, chatID)
+			}
+			w.mutex.Unlock()
+			<-w.slots
+			w.pending.Done()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// Shutdown stops accepting updates and waits until the queued updates have been handled or
+// ctx is done, before stopping the workers. Updates still waiting are dropped.
+func (w *updateWorkers) Shutdown(ctx context.Context) error {
+	w.mutex.Lock()
+	w.stopped = true
+	w.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		w.pending.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	w.quitOnce.Do(func() { close(w.quit) })
+	return err
+}
+
+// processUpdate handles an update within the handler timeout. A panic in a handler is
+// logged, instead of taking down the Bot.
+func (bot *Bot) processUpdate(update *tgbotapi.Update) {
+	ctx := bot.handlerContext
+	if timeout := bot.config.Telegram.HandlerTimeoutSec; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Bot.processUpdate: Panic handling update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Warnf("Bot.processUpdate: Update %d timed out after %v", update.UpdateID, time.Since(start))
+		}
+	}()
+
+	if err := bot.handleUpdate(ctx, update); err != nil {
+		log.Errorf("Bot.processUpdate: Error: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package telegrambot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/store"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// testChatUpdate returns an update with a message in the chat
+func testChatUpdate(updateID int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: updateID, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func Test_updateWorkers_Order(t *testing.T) {
+	var mutex sync.Mutex
+	handled := make(map[int64][]int)
+	release := make(chan struct{})
+
+	w := newUpdateWorkers(4, 100, 50, func(update *tgbotapi.Update) {
+		// Chat 1 is slow, which mustn't hold up chat 2
+		if update.Message.Chat.ID == 1 {
+			<-release
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled[update.Message.Chat.ID] = append(handled[update.Message.Chat.ID], update.UpdateID)
+	})
+
+	for i := 0; i < 20; i++ {
+		if err := w.Submit(context.Background(), testChatUpdate(i, int64(1+i%2))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mutex.Lock()
+		n := len(handled[2])
+		mutex.Unlock()
+		if n == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected chat 2 to be handled while chat 1 is busy, got %d updates", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the queue to drain, got %v", err)
+	}
+	for chatID, start := range map[int64]int{1: 0, 2: 1} {
+		if len(handled[chatID]) != 10 {
+			t.Fatalf("Expected 10 updates of chat %d, got %v", chatID, handled[chatID])
+		}
+		for i, id := range handled[chatID] {
+			if id != start+2*i {
+				t.Errorf("Expected the updates of chat %d in order, got %v", chatID, handled[chatID])
+				break
+			}
+		}
+	}
+
+	if err := w.Submit(context.Background(), testChatUpdate(20, 1)); err != errWorkersStopped {
+		t.Errorf("Expected errWorkersStopped after Shutdown, got %v", err)
+	}
+}
+
+func Test_updateWorkers_Full(t *testing.T) {
+	release := make(chan struct{})
+	w := newUpdateWorkers(1, 3, 2, func(update *tgbotapi.Update) { <-release })
+
+	for i := 0; i < 2; i++ {
+		if err := w.Submit(context.Background(), testChatUpdate(i, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Submit(context.Background(), testChatUpdate(2, 1)); err != errChatQueueFull {
+		t.Errorf("Expected errChatQueueFull, got %v", err)
+	}
+	if err := w.Submit(context.Background(), testChatUpdate(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queue is full, so Submit waits for an update to be handled
+	submitted := make(chan error)
+	go func() { submitted <- w.Submit(context.Background(), testChatUpdate(4, 3)) }()
+	select {
+	case err := <-submitted:
+		t.Fatalf("Expected Submit to wait while the queue is full, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if err := <-submitted; err != nil {
+		t.Errorf("Expected the update to be queued, got %v", err)
+	}
+
+	// Updates which aren't handled in time are dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	close(release)
+}
+
+func Test_drainUpdates(t *testing.T) {
+	var mutex sync.Mutex
+	var handled []int
+	bot := &Bot{workers: newUpdateWorkers(2, 10, 10, func(update *tgbotapi.Update) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, update.UpdateID)
+	})}
+
+	// The updates accepted by the webhook, but not yet dispatched, are still handled
+	updates := make(chan tgbotapi.Update, 5)
+	for i := 0; i < 3; i++ {
+		updates <- testChatUpdate(i, 1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	bot.drainUpdates(ctx, updates)
+	if err := bot.workers.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(handled) != 3 || handled[0] != 0 || handled[2] != 2 {
+		t.Errorf("Expected the 3 waiting updates to be handled in order, got %v", handled)
+	}
+}
+
+func Test_processUpdate(t *testing.T) {
+	bot := newTestAdminBot(t)
+	bot.config.Telegram.GroupChatIDs = []int64{-100}
+	bot.config.Telegram.HandlerTimeoutSec = 10 * time.Millisecond
+
+	handlerErr := make(chan error, 1)
+	bot.groupCommandHandlers = map[string]Command{
+		"panic": {store.RoleUser, "panic", func(*Bot, *BotContext, string, string) error {
+			panic("handler crashed")
+		}},
+		"slow": {store.RoleUser, "slow", func(bot *Bot, ctx *BotContext, command, args string) error {
+			<-ctx.Context().Done()
+			handlerErr <- ctx.Context().Err()
+			return nil
+		}},
+	}
+	command := func(name string) *tgbotapi.Update {
+		return &tgbotapi.Update{Message: &tgbotapi.Message{
+			From:     &tgbotapi.User{ID: 1002, UserName: "alice"},
+			Chat:     &tgbotapi.Chat{ID: -100, Type: "supergroup"},
+			Text:     "/" + name,
+			Entities: &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(name) + 1}},
+		}}
+	}
+
+	// A panic is recovered
+	bot.processUpdate(command("panic"))
+
+	bot.processUpdate(command("slow"))
+	select {
+	case err := <-handlerErr:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected the handler to time out, got %v", err)
+		}
+	default:
+		t.Errorf("Expected the handler to run")
+	}
+}
+
+func Test_showsMainMenu(t *testing.T) {
+	message := func(chatType, text string) *tgbotapi.Message {
+		msg := &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1, Type: chatType}, Text: text}
+		if strings.HasPrefix(text, "/") {
+			msg.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(text)}}
+		}
+		return msg
+	}
+
+	tests := []struct {
+		update tgbotapi.Update
+		expect bool
+	}{
+		{tgbotapi.Update{Message: message("private", "/start")}, true},
+		{tgbotapi.Update{Message: message("private", "/balance")}, false},
+		{tgbotapi.Update{Message: message("private", "/menu")}, false},
+		{tgbotapi.Update{Message: message("private", "hello")}, false},
+		{tgbotapi.Update{Message: message("supergroup", "/start")}, false},
+		{tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Message: message("private", "/start")}}, false},
+	}
+	for _, tc := range tests {
+		if actual := showsMainMenu(&tc.update); actual != tc.expect {
+			t.Errorf("%+v: expected %v, got %v", tc.update.Message, tc.expect, actual)
+		}
+	}
+}
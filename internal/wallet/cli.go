@@ -0,0 +1,325 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skycoinapi"
+	log "github.com/sirupsen/logrus"
+	"github.com/skycoin/skycoin/src/coin"
+)
+
+var (
+	// ErrCLINotFound is returned when the configured skycoin-cli binary can not be found
+	ErrCLINotFound = errors.New("skycoin-cli binary not found")
+	// ErrCLITimeout is returned when a skycoin-cli command does not complete within the configured timeout
+	ErrCLITimeout = errors.New("skycoin-cli command timed out")
+)
+
+// CLIError is returned when a skycoin-cli command fails to execute
+type CLIError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+// Error satisfies the error interface for the CLIError type
+func (e *CLIError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("skycoin-cli %s failed (exit code %d): %s", e.Command, e.ExitCode, e.Stderr)
+	}
+	return fmt.Sprintf("skycoin-cli %s failed: %v", e.Command, e.Err)
+}
+
+// Timeout reports whether the command failed because it exceeded the configured timeout
+func (e *CLIError) Timeout() bool {
+	return e.Err == ErrCLITimeout
+}
+
+// CLIBackend is a WalletBackend which executes the skycoin-cli binary
+type CLIBackend struct {
+	path    string
+	timeout time.Duration
+}
+
+// NewCLIBackend creates a CLIBackend which will execute the skycoin-cli binary found at path.
+// Each command will be terminated if it runs for longer than timeout.
+func NewCLIBackend(path string, timeout time.Duration) *CLIBackend {
+	return &CLIBackend{
+		path:    path,
+		timeout: timeout,
+	}
+}
+
+// run executes the skycoin-cli binary with the provided args and returns its standard output
+func (c *CLIBackend) run(ctx context.Context, args ...string) ([]byte, error) {
+	log.Debugf("CLIBackend.run: %s %s", c.path, args[0])
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+
+	clierr := &CLIError{
+		Command: args[0],
+		Stderr:  strings.TrimSpace(stderr.String()),
+		Err:     err,
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		clierr.Err = ErrCLITimeout
+		return nil, clierr
+	}
+
+	switch e := err.(type) {
+	case *exec.ExitError:
+		if status, ok := e.Sys().(interface{ ExitStatus() int }); ok {
+			clierr.ExitCode = status.ExitStatus()
+		}
+	case *exec.Error:
+		if e.Err == exec.ErrNotFound {
+			clierr.Err = ErrCLINotFound
+		}
+	case *os.PathError:
+		if os.IsNotExist(e.Err) {
+			clierr.Err = ErrCLINotFound
+		}
+	}
+	return nil, clierr
+}
+
+// cliWallet models the wallet JSON produced and consumed by skycoin-cli
+type cliWallet struct {
+	Meta    map[string]string `json:"meta"`
+	Entries []Address         `json:"entries"`
+}
+
+// cliBalance models a single balance entry as reported by skycoin-cli
+type cliBalance struct {
+	Coins string `json:"coins"`
+	Hours string `json:"hours"`
+}
+
+func (b cliBalance) toBalance() (Balance, error) {
+	coins, err := ParseDroplets(b.Coins)
+	if err != nil {
+		return Balance{}, err
+	}
+	hours, err := strconv.ParseUint(b.Hours, 10, 64)
+	if err != nil {
+		return Balance{}, fmt.Errorf("invalid coin hours %q", b.Hours)
+	}
+	return Balance{Coins: coins, Hours: hours}, nil
+}
+
+// GenerateAddress creates a new address using `skycoin-cli addressGen`
+func (c *CLIBackend) GenerateAddress(ctx context.Context) (Address, error) {
+	out, err := c.run(ctx, "addressGen", "--num", "1", "--hide-secret=false")
+	if err != nil {
+		return Address{}, err
+	}
+
+	var w cliWallet
+	if err := json.Unmarshal(out, &w); err != nil {
+		return Address{}, fmt.Errorf("failed to decode addressGen output: %v", err)
+	}
+	if len(w.Entries) != 1 || w.Entries[0].Address == "" {
+		return Address{}, fmt.Errorf("addressGen returned %d addresses, expected 1", len(w.Entries))
+	}
+	return w.Entries[0], nil
+}
+
+// GetBalance returns the balance of the provided addresses using `skycoin-cli addressBalance`
+func (c *CLIBackend) GetBalance(ctx context.Context, addrs ...string) (Balances, error) {
+	var result Balances
+	if len(addrs) == 0 {
+		return result, nil
+	}
+
+	out, err := c.run(ctx, append([]string{"addressBalance"}, addrs...)...)
+	if err != nil {
+		return result, err
+	}
+
+	var resp struct {
+		Confirmed cliBalance `json:"confirmed"`
+		Expected  cliBalance `json:"expected"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return result, fmt.Errorf("failed to decode addressBalance output: %v", err)
+	}
+
+	if result.Confirmed, err = resp.Confirmed.toBalance(); err != nil {
+		return result, err
+	}
+	if result.Predicted, err = resp.Expected.toBalance(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// GetOutputs returns the confirmed unspent outputs of the provided addresses using `skycoin-cli addressOutputs`
+func (c *CLIBackend) GetOutputs(ctx context.Context, addrs ...string) (*Outputs, error) {
+	if len(addrs) == 0 {
+		return &Outputs{}, nil
+	}
+
+	out, err := c.run(ctx, append([]string{"addressOutputs"}, addrs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Outputs skycoinapi.OutputsResponse `json:"outputs"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode addressOutputs output: %v", err)
+	}
+	return outputsFromResponse(&resp.Outputs)
+}
+
+// CreateTransaction creates and signs a transaction using `skycoin-cli createRawTransaction`.
+// The keys of the source addresses are written to a temporary wallet file which is
+// removed once the command has completed.
+func (c *CLIBackend) CreateTransaction(ctx context.Context, req TxRequest) (*Transaction, error) {
+	if err := validateTxRequest(req); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "wcwallet")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	walletFile := filepath.Join(dir, "tx.wlt")
+	wlt, err := json.Marshal(cliWallet{
+		Meta: map[string]string{
+			"coin":      "skycoin",
+			"filename":  "tx.wlt",
+			"type":      "deterministic",
+			"version":   "0.2",
+			"encrypted": "false",
+		},
+		Entries: req.From,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(walletFile, wlt, 0600); err != nil {
+		return nil, err
+	}
+
+	args := []string{"createRawTransaction", "--wallet-file", walletFile, "--json"}
+	if req.ChangeAddress != "" {
+		args = append(args, "--change-address", req.ChangeAddress)
+	}
+	if len(req.From) == 1 {
+		args = append(args, "--address", req.From[0].Address)
+	}
+	args = append(args, req.To, FormatDroplets(req.Coins))
+
+	out, err := c.run(ctx, args...)
+	if err != nil {
+		if clierr, ok := err.(*CLIError); ok && strings.Contains(clierr.Stderr, "balance is not sufficient") {
+			return nil, ErrInsufficientBalance
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		RawTx string `json:"rawtx"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode createRawTransaction output: %v", err)
+	}
+
+	// skycoin-cli selects the outputs of the source addresses itself, the transaction is
+	// rejected if it spends any other than the requested outputs
+	txn, err := coin.DeserializeTransactionHex(resp.RawTx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %v", err)
+	}
+	inputs := make([]string, 0, len(txn.In))
+	for _, in := range txn.In {
+		inputs = append(inputs, in.Hex())
+	}
+	if err := checkInputs(req, inputs); err != nil {
+		return nil, err
+	}
+	return &Transaction{TxID: txn.Hash().Hex(), RawTx: resp.RawTx}, nil
+}
+
+// BroadcastTransaction injects a signed transaction using `skycoin-cli broadcastTransaction`.
+// skycoin-cli doesn't tell whether the node received the transaction when it fails, so its
+// errors are never reported as a RejectedError.
+func (c *CLIBackend) BroadcastTransaction(ctx context.Context, rawtx string) (string, error) {
+	out, err := c.run(ctx, "broadcastTransaction", rawtx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GetTransaction looks up a transaction using `skycoin-cli transaction`
+func (c *CLIBackend) GetTransaction(ctx context.Context, txid string) (*TxStatus, error) {
+	out, err := c.run(ctx, "transaction", txid)
+	if err != nil {
+		if clierr, ok := err.(*CLIError); ok && strings.Contains(clierr.Stderr, "not found") {
+			return nil, ErrTxNotFound
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		Transaction struct {
+			Status struct {
+				Confirmed   bool   `json:"confirmed"`
+				Unconfirmed bool   `json:"unconfirmed"`
+				Height      uint64 `json:"height"`
+				BlockSeq    uint64 `json:"block_seq"`
+			} `json:"status"`
+			Txn struct {
+				TxID string `json:"txid"`
+			} `json:"txn"`
+		} `json:"transaction"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction output: %v", err)
+	}
+
+	status := resp.Transaction.Status
+	return &TxStatus{
+		TxID:        resp.Transaction.Txn.TxID,
+		Confirmed:   status.Confirmed,
+		Unconfirmed: status.Unconfirmed,
+		Height:      status.Height,
+		BlockSeq:    status.BlockSeq,
+	}, nil
+}
@@ -0,0 +1,102 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package wallet
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFakeCLI writes a shell script standing in for skycoin-cli and returns its path
+func writeFakeCLI(t *testing.T, script string) (string, func()) {
+	dir, err := ioutil.TempDir("", "wcclitest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "skycoin-cli")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func Test_CLIBackend_GenerateAddress(t *testing.T) {
+	path, cleanup := writeFakeCLI(t, `echo '{"meta":{},"entries":[{"address":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","public_key":"pub","secret_key":"sec"}]}'`)
+	defer cleanup()
+
+	addr, err := NewCLIBackend(path, time.Second).GenerateAddress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Address != "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv" || addr.PublicKey != "pub" || addr.SecretKey != "sec" {
+		t.Errorf("Unexpected address: %+v", addr)
+	}
+}
+
+func Test_CLIBackend_GetBalance(t *testing.T) {
+	path, cleanup := writeFakeCLI(t, `echo '{"confirmed":{"coins":"1.500000","hours":"20"},"spendable":{"coins":"1.500000","hours":"20"},"expected":{"coins":"1.000000","hours":"12"}}'`)
+	defer cleanup()
+
+	b, err := NewCLIBackend(path, time.Second).GetBalance(context.Background(), "addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed.Coins != 1500000 || b.Confirmed.Hours != 20 {
+		t.Errorf("Unexpected confirmed balance: %+v", b.Confirmed)
+	}
+	if b.Predicted.Coins != 1000000 || b.Predicted.Hours != 12 {
+		t.Errorf("Unexpected predicted balance: %+v", b.Predicted)
+	}
+}
+
+func Test_CLIBackend_CommandFailed(t *testing.T) {
+	path, cleanup := writeFakeCLI(t, `echo "something went wrong" >&2; exit 3`)
+	defer cleanup()
+
+	_, err := NewCLIBackend(path, time.Second).BroadcastTransaction(context.Background(), "raw")
+	clierr, ok := err.(*CLIError)
+	if !ok {
+		t.Fatalf("Expected *CLIError, got: %v", err)
+	}
+	if clierr.ExitCode != 3 {
+		t.Errorf("Unexpected exit code: %d", clierr.ExitCode)
+	}
+	if clierr.Stderr != "something went wrong" {
+		t.Errorf("Unexpected stderr: %q", clierr.Stderr)
+	}
+}
+
+func Test_CLIBackend_Timeout(t *testing.T) {
+	path, cleanup := writeFakeCLI(t, `exec sleep 5`)
+	defer cleanup()
+
+	_, err := NewCLIBackend(path, 100*time.Millisecond).GenerateAddress(context.Background())
+	clierr, ok := err.(*CLIError)
+	if !ok {
+		t.Fatalf("Expected *CLIError, got: %v", err)
+	}
+	if !clierr.Timeout() {
+		t.Errorf("Expected a timeout error, got: %v", clierr)
+	}
+}
+
+func Test_CLIBackend_NotFound(t *testing.T) {
+	_, err := NewCLIBackend("/this/path/does/not/exist/skycoin-cli", time.Second).GenerateAddress(context.Background())
+	clierr, ok := err.(*CLIError)
+	if !ok {
+		t.Fatalf("Expected *CLIError, got: %v", err)
+	}
+	if clierr.Err != ErrCLINotFound {
+		t.Errorf("Expected ErrCLINotFound, got: %v", clierr.Err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DropletsPerCoin is the number of droplets in one SKY
+	DropletsPerCoin = 1000000
+	// dropletPrecision is the number of decimal places represented by droplets
+	dropletPrecision = 6
+	// MaxCoinDecimals is the number of decimal places a SKY amount may have in a transaction
+	MaxCoinDecimals = 3
+	// dropletsPerMinUnit is the smallest number of droplets which can be sent
+	dropletsPerMinUnit = 1000
+)
+
+// ParseDroplets converts a decimal SKY string (i.e. "1.5") into droplets
+func ParseDroplets(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("invalid coin amount: empty")
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > dropletPrecision {
+		return 0, fmt.Errorf("invalid coin amount %q: too many decimal places", s)
+	}
+	frac += strings.Repeat("0", dropletPrecision-len(frac))
+
+	w, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coin amount %q", s)
+	}
+	f, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coin amount %q", s)
+	}
+
+	if w > (^uint64(0)-f)/DropletsPerCoin {
+		return 0, fmt.Errorf("invalid coin amount %q: too large", s)
+	}
+	return w*DropletsPerCoin + f, nil
+}
+
+// FormatDroplets converts droplets into a decimal SKY string (i.e. "1.5")
+func FormatDroplets(d uint64) string {
+	whole := d / DropletsPerCoin
+	frac := d % DropletsPerCoin
+	if frac == 0 {
+		return strconv.FormatUint(whole, 10)
+	}
+	fracstr := fmt.Sprintf("%06d", frac)
+	return fmt.Sprintf("%d.%s", whole, strings.TrimRight(fracstr, "0"))
+}
+
+// TruncateDroplets rounds d down to the precision supported by transactions
+func TruncateDroplets(d uint64) uint64 {
+	return d - d%dropletsPerMinUnit
+}
+
+// ParseAmount converts a user supplied amount into droplets. Amounts are in SKY
+// unless suffixed with a unit, i.e. "1.5", "1.5sky", "1000drops" or "1000droplets".
+// Skycoin transactions only support MaxCoinDecimals decimal places, so amounts
+// with a finer precision are rejected.
+func ParseAmount(s string) (uint64, error) {
+	amount := strings.ToLower(strings.TrimSpace(s))
+
+	var droplets uint64
+	var err error
+	switch {
+	case strings.HasSuffix(amount, "droplets"), strings.HasSuffix(amount, "drops"):
+		amount = strings.TrimSuffix(strings.TrimSuffix(amount, "droplets"), "drops")
+		droplets, err = strconv.ParseUint(strings.TrimSpace(amount), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid droplet amount %q", s)
+		}
+	default:
+		amount = strings.TrimSpace(strings.TrimSuffix(amount, "sky"))
+		droplets, err = ParseDroplets(amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if droplets == 0 {
+		return 0, fmt.Errorf("invalid amount %q: must be greater than zero", s)
+	}
+	if droplets%dropletsPerMinUnit != 0 {
+		return 0, fmt.Errorf("invalid amount %q: at most %d decimal places are supported", s, MaxCoinDecimals)
+	}
+	return droplets, nil
+}
@@ -0,0 +1,235 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// fakeTx models a transaction created by the FakeBackend
+type fakeTx struct {
+	req       TxRequest
+	txid      string
+	broadcast bool
+}
+
+// FakeBackend is an in-memory WalletBackend intended for use in tests.
+// Addresses, balances and transactions only exist for the lifetime of the FakeBackend.
+type FakeBackend struct {
+	m        sync.Mutex
+	next     int
+	balances map[string]Balance
+	txns     map[string]*fakeTx
+	raw      map[string]*fakeTx
+	outputs  Outputs
+	failNext error
+}
+
+// NewFakeBackend creates an empty FakeBackend
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		balances: make(map[string]Balance),
+		txns:     make(map[string]*fakeTx),
+		raw:      make(map[string]*fakeTx),
+	}
+}
+
+// SetBalance sets the balance held by addr
+func (f *FakeBackend) SetBalance(addr string, b Balance) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.balances[addr] = b
+}
+
+// AddOutput adds an unspent output. Outputs don't change the fake balances.
+func (f *FakeBackend) AddOutput(out Output) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.outputs.Outputs = append(f.outputs.Outputs, out)
+}
+
+// FailNextBroadcast makes the next BroadcastTransaction return err without broadcasting
+func (f *FakeBackend) FailNextBroadcast(err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.failNext = err
+}
+
+// SetHeadSeq sets the sequence of the head block, which determines the confirmations of the outputs
+func (f *FakeBackend) SetHeadSeq(seq uint64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.outputs.HeadSeq = seq
+}
+
+// GenerateAddress creates a new fake address and key pair
+func (f *FakeBackend) GenerateAddress(ctx context.Context) (Address, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.next++
+	addr := Address{
+		Address:   fmt.Sprintf("fakeaddr%d", f.next),
+		PublicKey: fmt.Sprintf("fakepub%d", f.next),
+		SecretKey: fmt.Sprintf("fakesec%d", f.next),
+	}
+	f.balances[addr.Address] = Balance{}
+	return addr, nil
+}
+
+// GetBalance returns the combined balance of the provided addresses
+func (f *FakeBackend) GetBalance(ctx context.Context, addrs ...string) (Balances, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var total Balance
+	for _, addr := range addrs {
+		b := f.balances[addr]
+		total.Coins += b.Coins
+		total.Hours += b.Hours
+	}
+	return Balances{Confirmed: total, Predicted: total}, nil
+}
+
+// GetOutputs returns the outputs added using AddOutput which are held by the provided addresses
+func (f *FakeBackend) GetOutputs(ctx context.Context, addrs ...string) (*Outputs, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	result := &Outputs{HeadSeq: f.outputs.HeadSeq}
+	for _, out := range f.outputs.Outputs {
+		for _, addr := range addrs {
+			if out.Address == addr {
+				result.Outputs = append(result.Outputs, out)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// output returns the unspent output with the hash. The caller must hold the lock.
+func (f *FakeBackend) output(hash string) (Output, bool) {
+	for _, out := range f.outputs.Outputs {
+		if out.Hash == hash {
+			return out, true
+		}
+	}
+	return Output{}, false
+}
+
+// CreateTransaction creates a fake transaction. The combined balance of the source
+// addresses must cover the requested amount, and the requested outputs must be unspent.
+func (f *FakeBackend) CreateTransaction(ctx context.Context, req TxRequest) (*Transaction, error) {
+	if err := validateTxRequest(req); err != nil {
+		return nil, err
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var available uint64
+	for _, from := range req.From {
+		available += f.balances[from.Address].Coins
+	}
+	if available < req.Coins {
+		return nil, ErrInsufficientBalance
+	}
+	for _, hash := range req.Outputs {
+		if out, found := f.output(hash); !found || out.Spending {
+			return nil, fmt.Errorf("output %s is not unspent", hash)
+		}
+	}
+
+	f.next++
+	tx := &fakeTx{
+		req:  req,
+		txid: fmt.Sprintf("faketx%d", f.next),
+	}
+	rawtx := fmt.Sprintf("fakeraw%d", f.next)
+	f.raw[rawtx] = tx
+	return &Transaction{TxID: tx.txid, RawTx: rawtx}, nil
+}
+
+// BroadcastTransaction applies a transaction created by CreateTransaction to the
+// fake balances, removes the requested outputs and returns its txid
+func (f *FakeBackend) BroadcastTransaction(ctx context.Context, rawtx string) (string, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if err := f.failNext; err != nil {
+		f.failNext = nil
+		return "", err
+	}
+	tx, found := f.raw[rawtx]
+	if !found {
+		return "", &RejectedError{Reason: "invalid raw transaction: " + rawtx}
+	}
+	if tx.broadcast {
+		return tx.txid, nil
+	}
+
+	// Spend from each source address in turn, returning any change to the
+	// change address (or the first source address if none was provided)
+	remaining := tx.req.Coins
+	var change uint64
+	for _, from := range tx.req.From {
+		b := f.balances[from.Address]
+		if remaining > 0 {
+			spend := b.Coins
+			if spend > remaining {
+				change += spend - remaining
+				spend = remaining
+			}
+			remaining -= spend
+		} else {
+			change += b.Coins
+		}
+		b.Coins = 0
+		f.balances[from.Address] = b
+	}
+
+	changeAddr := tx.req.ChangeAddress
+	if changeAddr == "" {
+		changeAddr = tx.req.From[0].Address
+	}
+	cb := f.balances[changeAddr]
+	cb.Coins += change
+	f.balances[changeAddr] = cb
+
+	to := f.balances[tx.req.To]
+	to.Coins += tx.req.Coins
+	f.balances[tx.req.To] = to
+
+	spent := make(map[string]bool, len(tx.req.Outputs))
+	for _, hash := range tx.req.Outputs {
+		spent[hash] = true
+	}
+	unspent := f.outputs.Outputs[:0]
+	for _, out := range f.outputs.Outputs {
+		if !spent[out.Hash] {
+			unspent = append(unspent, out)
+		}
+	}
+	f.outputs.Outputs = unspent
+
+	tx.broadcast = true
+	f.txns[tx.txid] = tx
+	return tx.txid, nil
+}
+
+// GetTransaction returns the status of a broadcast transaction.
+// All broadcast transactions are reported as confirmed.
+func (f *FakeBackend) GetTransaction(ctx context.Context, txid string) (*TxStatus, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if _, found := f.txns[txid]; !found {
+		return nil, ErrTxNotFound
+	}
+	return &TxStatus{TxID: txid, Confirmed: true, Height: 1}, nil
+}
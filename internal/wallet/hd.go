@@ -0,0 +1,55 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// SeedSize is the number of random bytes in a seed created by NewSeed
+const SeedSize = 32
+
+// ErrEmptySeed is returned when an address is derived from an empty seed
+var ErrEmptySeed = errors.New("wallet seed is empty")
+
+// NewSeed creates a new random seed for deriving addresses, encoded as hex
+func NewSeed() (string, error) {
+	b := make([]byte, SeedSize)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// DeriveAddress derives the address and key pair at index from the seed.
+// Every index is derived independently from SHA256(seed || index), so an
+// address can be recovered from the seed and its index alone.
+func DeriveAddress(seed string, index uint32) (Address, error) {
+	if seed == "" {
+		return Address{}, ErrEmptySeed
+	}
+
+	b := make([]byte, len(seed)+4)
+	copy(b, seed)
+	binary.BigEndian.PutUint32(b[len(seed):], index)
+	childSeed := cipher.SumSHA256(b)
+
+	pub, sec, err := cipher.GenerateDeterministicKeyPair(childSeed[:])
+	if err != nil {
+		return Address{}, err
+	}
+	return Address{
+		Address:   cipher.AddressFromPubKey(pub).String(),
+		PublicKey: pub.Hex(),
+		SecretKey: sec.Hex(),
+	}, nil
+}
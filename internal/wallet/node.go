@@ -0,0 +1,208 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skycoinapi"
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/coin"
+)
+
+// NodeBackend is a WalletBackend which talks directly to the REST API of a Skycoin node.
+// Keys are generated locally and transactions are signed locally, the node never
+// sees any secret keys.
+type NodeBackend struct {
+	client *skycoinapi.Client
+}
+
+// NewNodeBackend creates a NodeBackend which uses the provided Skycoin node client
+func NewNodeBackend(client *skycoinapi.Client) *NodeBackend {
+	return &NodeBackend{
+		client: client,
+	}
+}
+
+// GenerateAddress creates a new random address and key pair
+func (n *NodeBackend) GenerateAddress(ctx context.Context) (Address, error) {
+	pub, sec := cipher.GenerateKeyPair()
+	return Address{
+		Address:   cipher.AddressFromPubKey(pub).String(),
+		PublicKey: pub.Hex(),
+		SecretKey: sec.Hex(),
+	}, nil
+}
+
+// GetBalance returns the combined balance of the provided addresses
+func (n *NodeBackend) GetBalance(ctx context.Context, addrs ...string) (Balances, error) {
+	var result Balances
+	if len(addrs) == 0 {
+		return result, nil
+	}
+
+	resp, err := n.client.Balance(ctx, addrs...)
+	if err != nil {
+		return result, err
+	}
+
+	result.Confirmed = Balance{Coins: resp.Confirmed.Coins, Hours: resp.Confirmed.Hours}
+	result.Predicted = Balance{Coins: resp.Predicted.Coins, Hours: resp.Predicted.Hours}
+	return result, nil
+}
+
+// GetOutputs returns the confirmed unspent outputs of the provided addresses
+func (n *NodeBackend) GetOutputs(ctx context.Context, addrs ...string) (*Outputs, error) {
+	if len(addrs) == 0 {
+		return &Outputs{}, nil
+	}
+
+	resp, err := n.client.Outputs(ctx, addrs...)
+	if err != nil {
+		return nil, err
+	}
+	return outputsFromResponse(resp)
+}
+
+// outputsFromResponse converts the outputs reported by the node (or skycoin-cli)
+func outputsFromResponse(resp *skycoinapi.OutputsResponse) (*Outputs, error) {
+	// The outgoing outputs are the head outputs spent by unconfirmed transactions
+	spending := make(map[string]bool, len(resp.OutgoingOutputs))
+	for _, o := range resp.OutgoingOutputs {
+		spending[o.Hash] = true
+	}
+
+	result := &Outputs{HeadSeq: resp.Head.BkSeq}
+	for _, o := range resp.HeadOutputs {
+		coins, err := ParseDroplets(o.Coins)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coins %q in output %s: %v", o.Coins, o.Hash, err)
+		}
+		result.Outputs = append(result.Outputs, Output{
+			Hash:     o.Hash,
+			TxID:     o.SourceTransaction,
+			Address:  o.Address,
+			Coins:    coins,
+			Hours:    o.Hours,
+			BlockSeq: o.BlockSeq,
+			Spending: spending[o.Hash],
+		})
+	}
+	return result, nil
+}
+
+// CreateTransaction asks the node to build a transaction from the source addresses (or
+// only the requested outputs) and then signs it locally using their secret keys.
+// Half of the available coin hours are shared with the recipient, the rest are burnt
+// or returned as change.
+func (n *NodeBackend) CreateTransaction(ctx context.Context, req TxRequest) (*Transaction, error) {
+	if err := validateTxRequest(req); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]cipher.SecKey, len(req.From))
+	addrs := make([]string, 0, len(req.From))
+	for _, from := range req.From {
+		sec, err := cipher.SecKeyFromHex(from.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key for address %s", from.Address)
+		}
+		keys[from.Address] = sec
+		addrs = append(addrs, from.Address)
+	}
+
+	createReq := skycoinapi.CreateTransactionRequest{
+		HoursSelection: skycoinapi.HoursSelection{
+			Type:        "auto",
+			Mode:        "share",
+			ShareFactor: "0.5",
+		},
+		ChangeAddress: req.ChangeAddress,
+		To: []skycoinapi.Receiver{
+			{Address: req.To, Coins: FormatDroplets(req.Coins)},
+		},
+	}
+	// The node accepts either the addresses or the outputs to spend
+	if len(req.Outputs) > 0 {
+		createReq.UxOuts = req.Outputs
+	} else {
+		createReq.Addresses = addrs
+	}
+	resp, err := n.client.CreateTransaction(ctx, createReq)
+	if err != nil {
+		if apierr, ok := err.(*skycoinapi.APIError); ok && apierr.StatusCode == http.StatusBadRequest &&
+			strings.Contains(apierr.Message, "balance is not sufficient") {
+			return nil, ErrInsufficientBalance
+		}
+		return nil, err
+	}
+
+	txn, err := coin.DeserializeTransactionHex(resp.EncodedTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %v", err)
+	}
+
+	// The inputs are signed in the order the node selected them
+	signKeys := make([]cipher.SecKey, 0, len(resp.Transaction.In))
+	inputs := make([]string, 0, len(resp.Transaction.In))
+	for _, in := range resp.Transaction.In {
+		sec, found := keys[in.Address]
+		if !found {
+			return nil, fmt.Errorf("no secret key for transaction input address %s", in.Address)
+		}
+		signKeys = append(signKeys, sec)
+		inputs = append(inputs, in.UxID)
+	}
+	if err := checkInputs(req, inputs); err != nil {
+		return nil, err
+	}
+
+	txn.Sigs = nil
+	txn.SignInputs(signKeys)
+	if err := txn.UpdateHeader(); err != nil {
+		return nil, err
+	}
+
+	rawtx, err := txn.SerializeHex()
+	if err != nil {
+		return nil, err
+	}
+	fee, _ := strconv.ParseUint(resp.Transaction.Fee, 10, 64)
+	return &Transaction{TxID: txn.Hash().Hex(), RawTx: rawtx, Fee: fee}, nil
+}
+
+// BroadcastTransaction injects a signed transaction into the network via the node.
+// The node responds with 400 Bad Request to transactions it refuses.
+func (n *NodeBackend) BroadcastTransaction(ctx context.Context, rawtx string) (string, error) {
+	txid, err := n.client.InjectTransaction(ctx, rawtx)
+	if apierr, ok := err.(*skycoinapi.APIError); ok && apierr.StatusCode == http.StatusBadRequest {
+		return "", &RejectedError{Reason: apierr.Message}
+	}
+	return txid, err
+}
+
+// GetTransaction looks up a transaction via the node
+func (n *NodeBackend) GetTransaction(ctx context.Context, txid string) (*TxStatus, error) {
+	resp, err := n.client.Transaction(ctx, txid)
+	if err != nil {
+		if apierr, ok := err.(*skycoinapi.APIError); ok && apierr.StatusCode == http.StatusNotFound {
+			return nil, ErrTxNotFound
+		}
+		return nil, err
+	}
+
+	return &TxStatus{
+		TxID:        resp.Transaction.Hash,
+		Confirmed:   resp.Status.Confirmed,
+		Unconfirmed: resp.Status.Unconfirmed,
+		Height:      resp.Status.Height,
+		BlockSeq:    resp.Status.BlockSeq,
+	}, nil
+}
@@ -0,0 +1,229 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+
+// Package wallet provides a pluggable backend for the Skycoin wallet operations
+// used by the Bot (address generation, balances and transactions).
+package wallet
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+var (
+	// ErrInsufficientBalance is returned when the source addresses of a transaction
+	// do not hold enough coins to cover the requested amount
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	// ErrTxNotFound is returned when a transaction can not be found
+	ErrTxNotFound = errors.New("transaction not found")
+	// ErrNoSourceAddress is returned when a transaction request has no source addresses
+	ErrNoSourceAddress = errors.New("no source address provided")
+	// ErrInsufficientHours is returned when the outputs spent by a transaction do not
+	// hold enough coin hours to pay the fee
+	ErrInsufficientHours = errors.New("insufficient coin hours")
+	// ErrUnrequestedInput is returned when a created transaction would spend an output
+	// which is not one of the Outputs of the request
+	ErrUnrequestedInput = errors.New("transaction spends an output which was not requested")
+)
+
+// RejectedError is returned by BroadcastTransaction when the transaction was refused, so it
+// can't have been broadcast. After any other error the transaction may have been broadcast.
+type RejectedError struct {
+	Reason string
+}
+
+// Error satisfies the error interface for the RejectedError type
+func (e *RejectedError) Error() string {
+	return "transaction rejected: " + e.Reason
+}
+
+// burnFactor is the fraction (1/burnFactor) of the coin hours of the spent outputs
+// that a Skycoin transaction must burn as its fee
+const burnFactor = 2
+
+// Address models a Skycoin address and its associated key pair.
+// SecretKey will be empty when the key is not known to the caller.
+type Address struct {
+	Address   string `json:"address"`
+	PublicKey string `json:"public_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// Balance models the coins (in droplets) and coin hours held by one or more addresses
+type Balance struct {
+	Coins uint64
+	Hours uint64
+}
+
+// Balances models the confirmed and predicted (including unconfirmed transactions)
+// balance for one or more addresses
+type Balances struct {
+	Confirmed Balance
+	Predicted Balance
+}
+
+// Spendable returns the number of coins (in droplets) that can be spent right now.
+// Coins are only spendable once confirmed and not already committed to an unconfirmed
+// outgoing transaction.
+func (b Balances) Spendable() uint64 {
+	if b.Predicted.Coins < b.Confirmed.Coins {
+		return b.Predicted.Coins
+	}
+	return b.Confirmed.Coins
+}
+
+// TxRequest describes a transaction to be created by a WalletBackend.
+// From must include the secret keys of the source addresses. When Outputs (the
+// hashes of outputs held by the source addresses) are provided, only they are spent.
+type TxRequest struct {
+	From          []Address
+	To            string
+	Coins         uint64
+	ChangeAddress string
+	Outputs       []string
+}
+
+// Transaction models a signed transaction that is ready to be broadcast.
+// TxID is known before the transaction is broadcast.
+// Fee is the number of coin hours burned by the transaction, when known.
+type Transaction struct {
+	TxID  string
+	RawTx string
+	Fee   uint64
+}
+
+// TxStatus models the state of a transaction known to the network
+type TxStatus struct {
+	TxID        string
+	Confirmed   bool
+	Unconfirmed bool
+	Height      uint64
+	BlockSeq    uint64
+}
+
+// Output models a confirmed unspent output held by an address. Coins are in droplets.
+// Spending is set when the output is spent by an unconfirmed transaction.
+type Output struct {
+	Hash     string
+	TxID     string
+	Address  string
+	Coins    uint64
+	Hours    uint64
+	BlockSeq uint64
+	Spending bool
+}
+
+// Outputs models the confirmed unspent outputs of one or more addresses together
+// with the sequence of the head block, which is used to count confirmations
+type Outputs struct {
+	HeadSeq uint64
+	Outputs []Output
+}
+
+// Confirmations returns the number of blocks confirming the output.
+// An output in the head block has 1 confirmation.
+func (o *Outputs) Confirmations(out Output) uint64 {
+	if out.BlockSeq > o.HeadSeq {
+		return 0
+	}
+	return o.HeadSeq - out.BlockSeq + 1
+}
+
+// SelectOutputs chooses the outputs (largest first) which cover the requested number of coins
+func SelectOutputs(outs []Output, coins uint64) ([]Output, error) {
+	sorted := make([]Output, len(outs))
+	copy(sorted, outs)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Coins == sorted[j].Coins {
+			return sorted[i].Hours > sorted[j].Hours
+		}
+		return sorted[i].Coins > sorted[j].Coins
+	})
+
+	var total uint64
+	for i, out := range sorted {
+		total += out.Coins
+		if total >= coins {
+			return sorted[:i+1], nil
+		}
+	}
+	return nil, ErrInsufficientBalance
+}
+
+// HoursBurn returns the number of coin hours burned by a transaction spending the outputs.
+// ErrInsufficientHours is returned if the outputs can't pay a fee.
+func HoursBurn(outs []Output) (uint64, error) {
+	var hours uint64
+	for _, out := range outs {
+		hours += out.Hours
+	}
+
+	burn := hours / burnFactor
+	if hours%burnFactor != 0 {
+		burn++
+	}
+	if burn == 0 {
+		return 0, ErrInsufficientHours
+	}
+	return burn, nil
+}
+
+// ValidateAddress checks addr is a valid Skycoin address, including its checksum
+func ValidateAddress(addr string) error {
+	_, err := cipher.DecodeBase58Address(addr)
+	return err
+}
+
+// WalletBackend provides an interface specification for the Skycoin wallet
+// operations the Bot relies on
+type WalletBackend interface {
+	// GenerateAddress creates a new address and key pair
+	GenerateAddress(ctx context.Context) (Address, error)
+	// GetBalance returns the combined balance of the provided addresses
+	GetBalance(ctx context.Context, addrs ...string) (Balances, error)
+	// GetOutputs returns the confirmed unspent outputs of the provided addresses
+	GetOutputs(ctx context.Context, addrs ...string) (*Outputs, error)
+	// CreateTransaction creates and signs a transaction for the provided request
+	CreateTransaction(ctx context.Context, req TxRequest) (*Transaction, error)
+	// BroadcastTransaction injects a signed transaction into the network and returns its txid.
+	// A *RejectedError is returned if the transaction was refused.
+	BroadcastTransaction(ctx context.Context, rawtx string) (string, error)
+	// GetTransaction looks up a transaction by its txid
+	GetTransaction(ctx context.Context, txid string) (*TxStatus, error)
+}
+
+// validateTxRequest performs the checks common to all backends before a transaction is created
+func validateTxRequest(req TxRequest) error {
+	if len(req.From) == 0 {
+		return ErrNoSourceAddress
+	}
+	if req.To == "" {
+		return errors.New("no destination address provided")
+	}
+	if req.Coins == 0 {
+		return errors.New("transaction amount must be greater than zero")
+	}
+	return nil
+}
+
+// checkInputs checks a created transaction only spends the Outputs of the request, if provided
+func checkInputs(req TxRequest, inputs []string) error {
+	if len(req.Outputs) == 0 {
+		return nil
+	}
+	requested := make(map[string]bool, len(req.Outputs))
+	for _, hash := range req.Outputs {
+		requested[hash] = true
+	}
+	for _, hash := range inputs {
+		if !requested[hash] {
+			return ErrUnrequestedInput
+		}
+	}
+	return nil
+}
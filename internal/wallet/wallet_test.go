@@ -0,0 +1,362 @@
+// Copyright © 2018 Cryptovinnie
+//
+// Use of this source code is governed by an MIT
+// license that can be found in the LICENSE file.
+package wallet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/BigOokie/skywire-wing-commander/internal/skycoinapi"
+)
+
+var _ WalletBackend = (*CLIBackend)(nil)
+var _ WalletBackend = (*FakeBackend)(nil)
+var _ WalletBackend = (*NodeBackend)(nil)
+
+func Test_ParseDroplets(t *testing.T) {
+	tests := map[string]uint64{
+		"1":        1000000,
+		"0.1":      100000,
+		".5":       500000,
+		"1.000001": 1000001,
+		"100":      100000000,
+		"0":        0,
+	}
+
+	for s, expect := range tests {
+		actual, err := ParseDroplets(s)
+		if err != nil {
+			t.Errorf("ParseDroplets(%q) returned error: %v", s, err)
+		}
+		if actual != expect {
+			t.Errorf("ParseDroplets(%q) = %d, expected %d", s, actual, expect)
+		}
+	}
+}
+
+func Test_ParseDroplets_Invalid(t *testing.T) {
+	for _, s := range []string{"", "abc", "1.0000001", "-1", "1.2.3", "99999999999999999999"} {
+		if _, err := ParseDroplets(s); err == nil {
+			t.Errorf("ParseDroplets(%q) expected an error", s)
+		}
+	}
+}
+
+func Test_FormatDroplets(t *testing.T) {
+	tests := map[uint64]string{
+		1000000: "1",
+		100000:  "0.1",
+		1000001: "1.000001",
+		0:       "0",
+		1500000: "1.5",
+	}
+
+	for d, expect := range tests {
+		if actual := FormatDroplets(d); actual != expect {
+			t.Errorf("FormatDroplets(%d) = %s, expected %s", d, actual, expect)
+		}
+	}
+}
+
+func Test_Balances_Spendable(t *testing.T) {
+	b := Balances{
+		Confirmed: Balance{Coins: 10},
+		Predicted: Balance{Coins: 4},
+	}
+	if b.Spendable() != 4 {
+		t.Errorf("Unexpected spendable balance: %d", b.Spendable())
+	}
+
+	b.Predicted.Coins = 20
+	if b.Spendable() != 10 {
+		t.Errorf("Unexpected spendable balance: %d", b.Spendable())
+	}
+}
+
+func Test_FakeBackend_SendFlow(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeBackend()
+
+	from, err := fake.GenerateAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := fake.GenerateAddress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.Address == to.Address {
+		t.Fatal("Expected unique addresses")
+	}
+
+	fake.SetBalance(from.Address, Balance{Coins: 5 * DropletsPerCoin, Hours: 10})
+
+	req := TxRequest{From: []Address{from}, To: to.Address, Coins: 2 * DropletsPerCoin}
+	tx, err := fake.CreateTransaction(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txid, err := fake.BroadcastTransaction(ctx, tx.RawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txid != tx.TxID {
+		t.Errorf("Unexpected txid: %s", txid)
+	}
+
+	b, err := fake.GetBalance(ctx, from.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed.Coins != 3*DropletsPerCoin {
+		t.Errorf("Unexpected sender balance: %d", b.Confirmed.Coins)
+	}
+
+	b, err = fake.GetBalance(ctx, to.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed.Coins != 2*DropletsPerCoin {
+		t.Errorf("Unexpected recipient balance: %d", b.Confirmed.Coins)
+	}
+
+	status, err := fake.GetTransaction(ctx, txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Confirmed {
+		t.Error("Expected transaction to be confirmed")
+	}
+}
+
+func Test_FakeBackend_InsufficientBalance(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeBackend()
+
+	from, _ := fake.GenerateAddress(ctx)
+	fake.SetBalance(from.Address, Balance{Coins: 1})
+
+	_, err := fake.CreateTransaction(ctx, TxRequest{From: []Address{from}, To: "somewhere", Coins: 2})
+	if err != ErrInsufficientBalance {
+		t.Errorf("Expected ErrInsufficientBalance, got: %v", err)
+	}
+}
+
+func Test_FakeBackend_TxNotFound(t *testing.T) {
+	fake := NewFakeBackend()
+	if _, err := fake.GetTransaction(context.Background(), "missing"); err != ErrTxNotFound {
+		t.Errorf("Expected ErrTxNotFound, got: %v", err)
+	}
+}
+
+func Test_NodeBackend_GetBalance(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"confirmed":{"coins":3000000,"hours":7},"predicted":{"coins":2000000,"hours":4}}`))
+	}))
+	defer srv.Close()
+
+	node := NewNodeBackend(skycoinapi.NewClient(srv.URL, time.Second))
+	b, err := node.GetBalance(context.Background(), "addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Confirmed.Coins != 3000000 || b.Confirmed.Hours != 7 {
+		t.Errorf("Unexpected confirmed balance: %+v", b.Confirmed)
+	}
+	if b.Spendable() != 2000000 {
+		t.Errorf("Unexpected spendable balance: %d", b.Spendable())
+	}
+}
+
+func Test_NodeBackend_GetOutputs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"head":{"seq":105},"head_outputs":[` +
+			`{"hash":"out1","src_tx":"tx1","address":"addr","coins":"1.5","hours":3,"block_seq":100},` +
+			`{"hash":"out2","src_tx":"tx2","address":"addr","coins":"0.001","hours":0,"block_seq":105}],` +
+			`"outgoing_outputs":[{"hash":"out2","src_tx":"tx2","address":"addr","coins":"0.001","hours":0,"block_seq":105}]}`))
+	}))
+	defer srv.Close()
+
+	node := NewNodeBackend(skycoinapi.NewClient(srv.URL, time.Second))
+	outputs, err := node.GetOutputs(context.Background(), "addr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs.Outputs) != 2 {
+		t.Fatalf("Expected 2 outputs, got %d", len(outputs.Outputs))
+	}
+
+	out := outputs.Outputs[0]
+	if out.Hash != "out1" || out.TxID != "tx1" || out.Coins != 1500000 || out.Hours != 3 || out.Spending {
+		t.Errorf("Unexpected output: %+v", out)
+	}
+	// The outgoing outputs are spent by an unconfirmed transaction
+	if !outputs.Outputs[1].Spending {
+		t.Errorf("Expected output %s to be spending", outputs.Outputs[1].Hash)
+	}
+	if c := outputs.Confirmations(out); c != 6 {
+		t.Errorf("Expected 6 confirmations, got %d", c)
+	}
+	if c := outputs.Confirmations(outputs.Outputs[1]); c != 1 {
+		t.Errorf("Expected 1 confirmation, got %d", c)
+	}
+}
+
+func Test_NodeBackend_BroadcastRejected(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"csrf_token":"token"}`))
+			return
+		}
+		http.Error(w, "Transaction violates soft constraint", status)
+	}))
+	defer srv.Close()
+
+	node := NewNodeBackend(skycoinapi.NewClient(srv.URL, time.Second))
+	if _, err := node.BroadcastTransaction(context.Background(), "raw"); err == nil {
+		t.Error("Expected an error")
+	} else if _, ok := err.(*RejectedError); !ok {
+		t.Errorf("Expected a RejectedError, got %v", err)
+	}
+
+	// The node may have received the transaction when it is unavailable
+	status = http.StatusServiceUnavailable
+	if _, err := node.BroadcastTransaction(context.Background(), "raw"); err == nil {
+		t.Error("Expected an error")
+	} else if _, ok := err.(*RejectedError); ok {
+		t.Errorf("Expected an error other than RejectedError, got %v", err)
+	}
+}
+
+func Test_NodeBackend_TxNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	node := NewNodeBackend(skycoinapi.NewClient(srv.URL, time.Second))
+	if _, err := node.GetTransaction(context.Background(), "missing"); err != ErrTxNotFound {
+		t.Errorf("Expected ErrTxNotFound, got: %v", err)
+	}
+}
+
+func Test_ParseAmount(t *testing.T) {
+	tests := map[string]uint64{
+		"10":           10000000,
+		"0.1":          100000,
+		"1.5SKY":       1500000,
+		"2 sky":        2000000,
+		"1000drops":    1000,
+		"5000droplets": 5000,
+		"0.001":        1000,
+	}
+
+	for s, expect := range tests {
+		actual, err := ParseAmount(s)
+		if err != nil {
+			t.Errorf("ParseAmount(%q) returned error: %v", s, err)
+		}
+		if actual != expect {
+			t.Errorf("ParseAmount(%q) = %d, expected %d", s, actual, expect)
+		}
+	}
+}
+
+func Test_ParseAmount_Invalid(t *testing.T) {
+	for _, s := range []string{"", "0", "abc", "0.0001", "1500drops", "1.5drops", "-1", "sky", "0drops"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) expected an error", s)
+		}
+	}
+}
+
+func Test_DeriveAddress(t *testing.T) {
+	seed, err := NewSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed) != SeedSize*2 {
+		t.Errorf("Unexpected seed length: %d", len(seed))
+	}
+
+	a1, err := DeriveAddress(seed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := DeriveAddress(seed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1 != again {
+		t.Errorf("Expected the same address for the same index: %+v != %+v", a1, again)
+	}
+
+	a2, _ := DeriveAddress(seed, 2)
+	if a2.Address == a1.Address || a2.SecretKey == a1.SecretKey {
+		t.Error("Expected a different address for a different index")
+	}
+
+	other, _ := NewSeed()
+	if a, _ := DeriveAddress(other, 1); a.Address == a1.Address {
+		t.Error("Expected a different address for a different seed")
+	}
+
+	if _, err := DeriveAddress("", 1); err != ErrEmptySeed {
+		t.Errorf("Expected ErrEmptySeed, got %v", err)
+	}
+}
+
+func Test_SelectOutputs(t *testing.T) {
+	outs := []Output{
+		{Hash: "a", Coins: 1000000, Hours: 10},
+		{Hash: "b", Coins: 5000000, Hours: 3},
+		{Hash: "c", Coins: 2000000, Hours: 4},
+	}
+
+	selected, err := SelectOutputs(outs, 6000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 2 || selected[0].Hash != "b" || selected[1].Hash != "c" {
+		t.Errorf("Unexpected outputs selected: %+v", selected)
+	}
+
+	burn, err := HoursBurn(selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if burn != 4 {
+		t.Errorf("Expected a burn of 4 coin hours, got %d", burn)
+	}
+
+	if _, err := SelectOutputs(outs, 9000000); err != ErrInsufficientBalance {
+		t.Errorf("Expected ErrInsufficientBalance, got %v", err)
+	}
+	if _, err := HoursBurn([]Output{{Coins: 1000000}}); err != ErrInsufficientHours {
+		t.Errorf("Expected ErrInsufficientHours, got %v", err)
+	}
+}
+
+func Test_ValidateAddress(t *testing.T) {
+	if err := ValidateAddress("2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"); err != nil {
+		t.Errorf("Expected a valid address, got %v", err)
+	}
+	for _, addr := range []string{"", "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qw", "0OIl", "2GgFvqoyk9Rjw"} {
+		if err := ValidateAddress(addr); err == nil {
+			t.Errorf("Expected %q to be invalid", addr)
+		}
+	}
+}
+
+func Test_TruncateDroplets(t *testing.T) {
+	if d := TruncateDroplets(1234567); d != 1234000 {
+		t.Errorf("Unexpected truncated droplets: %d", d)
+	}
+}
@@ -7,6 +7,7 @@ package wcconfig
 
 import (
 	"fmt"
+	"reflect"
 	"runtime"
 	"strings"
 	"time"
@@ -17,37 +18,18 @@ import (
 	viper "github.com/spf13/viper"
 )
 
-//Config of Coin API data.
-
-type Coins struct {
-	Confirmed struct {
-		Coins int
-		Hours int
-	} `json:"confirmed"`
-	Predicted struct {
-		Coins int
-		Hours int
-	} `json:"predicted"`
-	Addresses struct {
-	}
-}
-
-type SQLdatabase struct {
-	Host     string `mapstructure:"wingcommander"`
-	Port     int    `mapstructure:"port"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	Dbname   string `mapstructure:"dbname"`
-}
-
 // Config structure models the applications configuration structure
 type Config struct {
 	WingCommander WingCommanderParameters `mapstructure:"wingcommander"`
 	AppAnalytics  WingCommanderAnalytics  `mapstructure:"appanalytics"`
 	Telegram      TelegramParameters      `mapstructure:"telegram"`
 	Monitor       MonitorParameters       `mapstructure:"monitor"`
+	Deposits      DepositParameters       `mapstructure:"deposits"`
+	Limits        LimitParameters         `mapstructure:"limits"`
+	TwoFactor     TwoFactorParameters     `mapstructure:"twofactor"`
 	SkyManager    SkyManagerParameters    `mapstructure:"skymanager"`
-	Coins         Coins                   `mapstructure:"coins"`
+	Wallet        WalletParameters        `mapstructure:"wallet"`
+	SkycoinNode   SkycoinNodeParameters   `mapstructure:"skycoinnode"`
 	SQLdatabase   SQLdatabase             `mapstructure:"sqldatabase"`
 }
 
@@ -65,19 +47,99 @@ type WingCommanderAnalytics struct {
 }
 
 // TelegramParameters struct defines the configuration parameters that
-// are used to manage Wing Commander application integrationw it Telegram
+// are used to manage Wing Commander application integrationw it Telegram.
+// Tipping in group chats is only enabled in the chats listed in GroupChatIDs.
+// Updates are handled by Workers goroutines, with at most QueueSize updates (and
+// ChatQueueSize updates of each chat) waiting, and each handler is given HandlerTimeoutSec.
 type TelegramParameters struct {
-	APIKey string `mapstructure:"apikey"`
-	ChatID int64  `mapstructure:"chatid"`
-	Admin  string `mapstructure:"admin"`
-	Debug  bool   `mapstructure:"debug"`
+	APIKey            string            `mapstructure:"apikey"`
+	ChatID            int64             `mapstructure:"chatid"`
+	Admin             string            `mapstructure:"admin"`
+	Debug             bool              `mapstructure:"debug"`
+	GroupChatIDs      []int64           `mapstructure:"groupchatids"`
+	Workers           int               `mapstructure:"workers"`
+	QueueSize         int               `mapstructure:"queuesize"`
+	ChatQueueSize     int               `mapstructure:"chatqueuesize"`
+	HandlerTimeoutSec time.Duration     `mapstructure:"handlertimeoutsec"`
+	Webhook           WebhookParameters `mapstructure:"webhook"`
+	Outbox            OutboxParameters  `mapstructure:"outbox"`
+}
+
+// OutboxParameters struct defines the rate limits of the messages sent by the Bot:
+// GlobalPerSec messages per second overall, ChatPerSec per second to each private chat and
+// GroupPerMin per minute to each group chat, with bursts of up to Burst messages. Messages are
+// tried up to MaxAttempts times, and at most QueueSize messages wait to be sent.
+type OutboxParameters struct {
+	GlobalPerSec float64 `mapstructure:"globalpersec"`
+	ChatPerSec   float64 `mapstructure:"chatpersec"`
+	GroupPerMin  float64 `mapstructure:"grouppermin"`
+	Burst        int     `mapstructure:"burst"`
+	MaxAttempts  int     `mapstructure:"maxattempts"`
+	QueueSize    int     `mapstructure:"queuesize"`
+}
+
+// WebhookParameters struct defines the configuration of the (optional) webhook Telegram
+// posts updates to, instead of the Bot polling for them. URL is the public https address
+// of the webhook (i.e. of a reverse proxy) and Path the secret path updates are posted to.
+// Telegram sends SecretToken with every update. The embedded listener uses HTTPS when
+// CertFile and KeyFile are set, and PublishCert uploads a self-signed CertFile to Telegram.
+type WebhookParameters struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	URL            string `mapstructure:"url"`
+	Listen         string `mapstructure:"listen"`
+	Path           string `mapstructure:"path"`
+	SecretToken    string `mapstructure:"secrettoken"`
+	CertFile       string `mapstructure:"certfile"`
+	KeyFile        string `mapstructure:"keyfile"`
+	PublishCert    bool   `mapstructure:"publishcert"`
+	MaxConnections int    `mapstructure:"maxconnections"`
 }
 
 // SkyManagerParameters struct defines the configuration parameters that
-// are used to manage connectivity with the Skywire Manager
+// are used to manage connectivity with the Skywire Manager. Password is the
+// password of the Manager web UI, which is needed to use its API.
 type SkyManagerParameters struct {
-	Address          string `mapstructure:"address"`
-	DiscoveryAddress string `mapstructure:"discoveryaddress"`
+	Address          string        `mapstructure:"address"`
+	DiscoveryAddress string        `mapstructure:"discoveryaddress"`
+	Password         string        `mapstructure:"password"`
+	TimeoutSec       time.Duration `mapstructure:"timeoutsec"`
+}
+
+// WalletParameters struct defines the configuration parameters that
+// are used by the Skycoin wallet backend. The master key which encrypts the
+// stored secret keys and the wallet seed (held in SeedFile) is read from the
+// MasterKeyEnv environment variable, or from MasterKeyFile if the variable is not set.
+type WalletParameters struct {
+	Backend       string        `mapstructure:"backend"`
+	CLIPath       string        `mapstructure:"clipath"`
+	CLITimeoutSec time.Duration `mapstructure:"clitimeoutsec"`
+	MasterKeyEnv  string        `mapstructure:"masterkeyenv"`
+	MasterKeyFile string        `mapstructure:"masterkeyfile"`
+	SeedFile      string        `mapstructure:"seedfile"`
+}
+
+// SkycoinNodeParameters struct defines the configuration parameters that
+// are used to manage connectivity with the Skycoin node REST API
+type SkycoinNodeParameters struct {
+	Address    string        `mapstructure:"address"`
+	TimeoutSec time.Duration `mapstructure:"timeoutsec"`
+}
+
+// SQLdatabase struct defines the configuration parameters that
+// are used to connect to the database holding the users and their wallets.
+// Driver is one of "postgres", "sqlite3" (using the file at Path) or "memory".
+type SQLdatabase struct {
+	Driver             string        `mapstructure:"driver"`
+	Host               string        `mapstructure:"host"`
+	Port               int           `mapstructure:"port"`
+	User               string        `mapstructure:"user"`
+	Password           string        `mapstructure:"password"`
+	Dbname             string        `mapstructure:"dbname"`
+	SSLMode            string        `mapstructure:"sslmode"`
+	Path               string        `mapstructure:"path"`
+	MaxOpenConns       int           `mapstructure:"maxopenconns"`
+	MaxIdleConns       int           `mapstructure:"maxidleconns"`
+	ConnMaxLifetimeMin time.Duration `mapstructure:"connmaxlifetimemin"`
 }
 
 // MonitorParameters struct defines the configuration parameters that
@@ -86,6 +148,39 @@ type MonitorParameters struct {
 	IntervalSec            time.Duration `mapstructure:"intervalsec"`
 	HeartbeatIntMin        time.Duration `mapstructure:"heartbeatintmin"`
 	DiscoveryMonitorIntMin time.Duration `mapstructure:"discoverymonitorintmin"`
+	MutedEvents            []string      `mapstructure:"mutedevents"`
+}
+
+// DepositParameters struct defines the configuration parameters that
+// are used by the deposit monitor which polls the user addresses for incoming SKY.
+// A deposit is credited once it has Confirmations confirmations.
+type DepositParameters struct {
+	Confirmations uint64        `mapstructure:"confirmations"`
+	IntervalSec   time.Duration `mapstructure:"intervalsec"`
+}
+
+// LimitParameters struct defines the limits on the SKY users can send. Amounts are
+// given in SKY ("0.1") or droplets ("1000drops"). A limit of "0" (or 0) is unlimited.
+// DailyCap counts tips and withdrawals, HourlyOutflowCap counts the withdrawals of all users.
+type LimitParameters struct {
+	MinTip           string `mapstructure:"mintip"`
+	MaxTip           string `mapstructure:"maxtip"`
+	DailyCap         string `mapstructure:"dailycap"`
+	HourlyOutflowCap string `mapstructure:"hourlyoutflowcap"`
+	TipsPerMinute    int    `mapstructure:"tipsperminute"`
+}
+
+// TwoFactorParameters struct defines the configuration parameters of the TOTP second
+// factor, which is only required when TwoFactorEnabled is set in the [wingcommander] section.
+// Tips of at least LargeTip (in SKY or droplets, "0" for none) need a code, as do withdrawals
+// and the moderator and admin commands. A code lets a moderator or admin use their commands
+// for AdminSessionMin minutes. MaxFailures wrong codes lock the user out for LockoutMin minutes.
+type TwoFactorParameters struct {
+	Issuer          string        `mapstructure:"issuer"`
+	LargeTip        string        `mapstructure:"largetip"`
+	MaxFailures     int           `mapstructure:"maxfailures"`
+	LockoutMin      time.Duration `mapstructure:"lockoutmin"`
+	AdminSessionMin time.Duration `mapstructure:"adminsessionmin"`
 }
 
 // String is the stringer function for the Config struct
@@ -99,21 +194,93 @@ func (c *Config) String() string {
 		"[SkyManager]\n" +
 		"  address = %q\n" +
 		"  discoveryaddress = %q\n" +
+		"  timeoutsec = %v\n" +
+		"[Wallet]\n" +
+		"  backend = %q\n" +
+		"  clipath = %q\n" +
+		"  clitimeoutsec = %v\n" +
+		"  masterkeyenv = %q\n" +
+		"  masterkeyfile = %q\n" +
+		"  seedfile = %q\n" +
+		"[SkycoinNode]\n" +
+		"  address = %q\n" +
+		"  timeoutsec = %v\n" +
+		"[SQLdatabase]\n" +
+		"  driver = %q\n" +
+		"  host = %q\n" +
+		"  port = %v\n" +
+		"  user = %q\n" +
+		"  dbname = %q\n" +
+		"  sslmode = %q\n" +
+		"  path = %q\n" +
+		"  maxopenconns = %v\n" +
+		"  maxidleconns = %v\n" +
+		"  connmaxlifetimemin = %v\n" +
 		"[Telegram]\n" +
 		"  apikey = %q\n" +
 		"  chatid = %v\n" +
 		"  admin  = %q\n" +
 		"  debug  = %v\n" +
+		"  groupchatids = %v\n" +
+		"  workers = %v\n" +
+		"  queuesize = %v\n" +
+		"  chatqueuesize = %v\n" +
+		"  handlertimeoutsec = %v\n" +
+		"[Telegram.Webhook]\n" +
+		"  enabled = %v\n" +
+		"  url = %q\n" +
+		"  listen = %q\n" +
+		"  certfile = %q\n" +
+		"  keyfile = %q\n" +
+		"  publishcert = %v\n" +
+		"  maxconnections = %v\n" +
+		"[Telegram.Outbox]\n" +
+		"  globalpersec = %v\n" +
+		"  chatpersec = %v\n" +
+		"  grouppermin = %v\n" +
+		"  burst = %v\n" +
+		"  maxattempts = %v\n" +
+		"  queuesize = %v\n" +
 		"[Monitor]\n" +
 		"  intervalsec = %v\n" +
 		"  heartbeatintmin = %v\n" +
-		"  discoverymonitorintmin = %v\n"
+		"  discoverymonitorintmin = %v\n" +
+		"  mutedevents = %q\n" +
+		"[Deposits]\n" +
+		"  confirmations = %v\n" +
+		"  intervalsec = %v\n" +
+		"[Limits]\n" +
+		"  mintip = %q\n" +
+		"  maxtip = %q\n" +
+		"  dailycap = %q\n" +
+		"  hourlyoutflowcap = %q\n" +
+		"  tipsperminute = %v\n" +
+		"[TwoFactor]\n" +
+		"  issuer = %q\n" +
+		"  largetip = %q\n" +
+		"  maxfailures = %v\n" +
+		"  lockoutmin = %v\n" +
+		"  adminsessionmin = %v\n"
 
 	return fmt.Sprintf(resultstr, c.WingCommander.TwoFactorEnabled, c.WingCommander.AnalyticsEnabled,
 		c.AppAnalytics.ClientUUID, c.AppAnalytics.UserID,
-		c.SkyManager.Address, c.SkyManager.DiscoveryAddress,
-		c.Telegram.APIKey, c.Telegram.ChatID, c.Telegram.Admin, c.Telegram.Debug,
-		c.Monitor.IntervalSec, c.Monitor.HeartbeatIntMin, c.Monitor.DiscoveryMonitorIntMin)
+		c.SkyManager.Address, c.SkyManager.DiscoveryAddress, c.SkyManager.TimeoutSec,
+		c.Wallet.Backend, c.Wallet.CLIPath, c.Wallet.CLITimeoutSec, c.Wallet.MasterKeyEnv, c.Wallet.MasterKeyFile,
+		c.Wallet.SeedFile,
+		c.SkycoinNode.Address, c.SkycoinNode.TimeoutSec,
+		c.SQLdatabase.Driver, c.SQLdatabase.Host, c.SQLdatabase.Port, c.SQLdatabase.User, c.SQLdatabase.Dbname,
+		c.SQLdatabase.SSLMode, c.SQLdatabase.Path, c.SQLdatabase.MaxOpenConns, c.SQLdatabase.MaxIdleConns,
+		c.SQLdatabase.ConnMaxLifetimeMin,
+		c.Telegram.APIKey, c.Telegram.ChatID, c.Telegram.Admin, c.Telegram.Debug, c.Telegram.GroupChatIDs,
+		c.Telegram.Workers, c.Telegram.QueueSize, c.Telegram.ChatQueueSize, c.Telegram.HandlerTimeoutSec,
+		c.Telegram.Webhook.Enabled, c.Telegram.Webhook.URL, c.Telegram.Webhook.Listen, c.Telegram.Webhook.CertFile,
+		c.Telegram.Webhook.KeyFile, c.Telegram.Webhook.PublishCert, c.Telegram.Webhook.MaxConnections,
+		c.Telegram.Outbox.GlobalPerSec, c.Telegram.Outbox.ChatPerSec, c.Telegram.Outbox.GroupPerMin, c.Telegram.Outbox.Burst,
+		c.Telegram.Outbox.MaxAttempts, c.Telegram.Outbox.QueueSize,
+		c.Monitor.IntervalSec, c.Monitor.HeartbeatIntMin, c.Monitor.DiscoveryMonitorIntMin, c.Monitor.MutedEvents,
+		c.Deposits.Confirmations, c.Deposits.IntervalSec,
+		c.Limits.MinTip, c.Limits.MaxTip, c.Limits.DailyCap, c.Limits.HourlyOutflowCap, c.Limits.TipsPerMinute,
+		c.TwoFactor.Issuer, c.TwoFactor.LargeTip, c.TwoFactor.MaxFailures, c.TwoFactor.LockoutMin, c.TwoFactor.AdminSessionMin)
 }
 
 // PrintConfig will log debug information for the passed Config structure
@@ -124,7 +291,7 @@ func (c *Config) PrintConfig() {
 // IsEmpty will compare the current instance of Config against an empty instance
 // and return the result of the comparison
 func IsEmpty(c Config) bool {
-	return (c == (Config{}))
+	return reflect.DeepEqual(c, Config{})
 }
 
 // readConfig attempts to read configuration parameters from the provided
@@ -160,9 +327,17 @@ func LoadConfigParameters(filename, pathname string, defaults map[string]interfa
 	}
 
 	// Validate and adjust any configuration parameters
+	config.Telegram.HandlerTimeoutSec = config.Telegram.HandlerTimeoutSec * time.Second
 	config.Monitor.IntervalSec = config.Monitor.IntervalSec * time.Second
 	config.Monitor.HeartbeatIntMin = config.Monitor.HeartbeatIntMin * time.Minute
 	config.Monitor.DiscoveryMonitorIntMin = config.Monitor.DiscoveryMonitorIntMin * time.Minute
+	config.Deposits.IntervalSec = config.Deposits.IntervalSec * time.Second
+	config.Wallet.CLITimeoutSec = config.Wallet.CLITimeoutSec * time.Second
+	config.SkycoinNode.TimeoutSec = config.SkycoinNode.TimeoutSec * time.Second
+	config.SkyManager.TimeoutSec = config.SkyManager.TimeoutSec * time.Second
+	config.SQLdatabase.ConnMaxLifetimeMin = config.SQLdatabase.ConnMaxLifetimeMin * time.Minute
+	config.TwoFactor.LockoutMin = config.TwoFactor.LockoutMin * time.Minute
+	config.TwoFactor.AdminSessionMin = config.TwoFactor.AdminSessionMin * time.Minute
 
 	// Check if the Admin user is prefixed with `@`
 	if !strings.HasPrefix(config.Telegram.Admin, "@") {
@@ -22,27 +22,136 @@ func Test_ConfigString(t *testing.T) {
 		"[SkyManager]\n" +
 		"  address = \"127.0.0.1:8000\"\n" +
 		"  discoveryaddress = \"discovery.skycoin.net:8001\"\n" +
+		"  timeoutsec = 30s\n" +
+		"[Wallet]\n" +
+		"  backend = \"node\"\n" +
+		"  clipath = \"skycoin-cli\"\n" +
+		"  clitimeoutsec = 30s\n" +
+		"  masterkeyenv = \"WINGCOMMANDER_MASTER_KEY\"\n" +
+		"  masterkeyfile = \"master.key\"\n" +
+		"  seedfile = \"wallet.seed\"\n" +
+		"[SkycoinNode]\n" +
+		"  address = \"http://127.0.0.1:6420\"\n" +
+		"  timeoutsec = 30s\n" +
+		"[SQLdatabase]\n" +
+		"  driver = \"postgres\"\n" +
+		"  host = \"localhost\"\n" +
+		"  port = 5432\n" +
+		"  user = \"postgres\"\n" +
+		"  dbname = \"skycoinbot\"\n" +
+		"  sslmode = \"disable\"\n" +
+		"  path = \"wingcommander.db\"\n" +
+		"  maxopenconns = 10\n" +
+		"  maxidleconns = 5\n" +
+		"  connmaxlifetimemin = 30m0s\n" +
 		"[Telegram]\n" +
 		"  apikey = \"ABC123\"\n" +
 		"  chatid = 123456789\n" +
 		"  admin  = \"@TESTUSER\"\n" +
 		"  debug  = false\n" +
+		"  groupchatids = [-1001234567890]\n" +
+		"  workers = 8\n" +
+		"  queuesize = 1000\n" +
+		"  chatqueuesize = 20\n" +
+		"  handlertimeoutsec = 1m0s\n" +
+		"[Telegram.Webhook]\n" +
+		"  enabled = true\n" +
+		"  url = \"https://bot.example.com\"\n" +
+		"  listen = \"127.0.0.1:8443\"\n" +
+		"  certfile = \"\"\n" +
+		"  keyfile = \"\"\n" +
+		"  publishcert = false\n" +
+		"  maxconnections = 40\n" +
+		"[Telegram.Outbox]\n" +
+		"  globalpersec = 30\n" +
+		"  chatpersec = 1\n" +
+		"  grouppermin = 20\n" +
+		"  burst = 3\n" +
+		"  maxattempts = 5\n" +
+		"  queuesize = 1000\n" +
 		"[Monitor]\n" +
 		"  intervalsec = 10s\n" +
 		"  heartbeatintmin = 2h0m0s\n" +
-		"  discoverymonitorintmin = 2h0m0s\n"
+		"  discoverymonitorintmin = 2h0m0s\n" +
+		"  mutedevents = [\"appstarted\" \"connclosed\"]\n" +
+		"[Deposits]\n" +
+		"  confirmations = 3\n" +
+		"  intervalsec = 30s\n" +
+		"[Limits]\n" +
+		"  mintip = \"0.001\"\n" +
+		"  maxtip = \"0.1\"\n" +
+		"  dailycap = \"10\"\n" +
+		"  hourlyoutflowcap = \"100\"\n" +
+		"  tipsperminute = 5\n" +
+		"[TwoFactor]\n" +
+		"  issuer = \"Wing Commander\"\n" +
+		"  largetip = \"1\"\n" +
+		"  maxfailures = 5\n" +
+		"  lockoutmin = 15m0s\n" +
+		"  adminsessionmin = 10m0s\n"
 
 	var config Config
 	config.WingCommander.TwoFactorEnabled = false
 	config.SkyManager.Address = "127.0.0.1:8000"
 	config.SkyManager.DiscoveryAddress = "discovery.skycoin.net:8001"
+	config.SkyManager.Password = "manager-secret"
+	config.SkyManager.TimeoutSec = 30 * time.Second
+	config.Wallet.Backend = "node"
+	config.Wallet.CLIPath = "skycoin-cli"
+	config.Wallet.CLITimeoutSec = 30 * time.Second
+	config.Wallet.MasterKeyEnv = "WINGCOMMANDER_MASTER_KEY"
+	config.Wallet.MasterKeyFile = "master.key"
+	config.Wallet.SeedFile = "wallet.seed"
+	config.SkycoinNode.Address = "http://127.0.0.1:6420"
+	config.SkycoinNode.TimeoutSec = 30 * time.Second
+	config.SQLdatabase.Driver = "postgres"
+	config.SQLdatabase.Host = "localhost"
+	config.SQLdatabase.Port = 5432
+	config.SQLdatabase.User = "postgres"
+	config.SQLdatabase.Password = "secret"
+	config.SQLdatabase.Dbname = "skycoinbot"
+	config.SQLdatabase.SSLMode = "disable"
+	config.SQLdatabase.Path = "wingcommander.db"
+	config.SQLdatabase.MaxOpenConns = 10
+	config.SQLdatabase.MaxIdleConns = 5
+	config.SQLdatabase.ConnMaxLifetimeMin = 30 * time.Minute
 	config.Telegram.APIKey = "ABC123"
 	config.Telegram.ChatID = 123456789
 	config.Telegram.Admin = "@TESTUSER"
 	config.Telegram.Debug = false
+	config.Telegram.GroupChatIDs = []int64{-1001234567890}
+	config.Telegram.Workers = 8
+	config.Telegram.QueueSize = 1000
+	config.Telegram.ChatQueueSize = 20
+	config.Telegram.HandlerTimeoutSec = time.Minute
+	config.Telegram.Webhook.Enabled = true
+	config.Telegram.Webhook.URL = "https://bot.example.com"
+	config.Telegram.Webhook.Listen = "127.0.0.1:8443"
+	config.Telegram.Webhook.Path = "/secret-path"
+	config.Telegram.Webhook.SecretToken = "secret-token"
+	config.Telegram.Webhook.MaxConnections = 40
+	config.Telegram.Outbox.GlobalPerSec = 30
+	config.Telegram.Outbox.ChatPerSec = 1
+	config.Telegram.Outbox.GroupPerMin = 20
+	config.Telegram.Outbox.Burst = 3
+	config.Telegram.Outbox.MaxAttempts = 5
+	config.Telegram.Outbox.QueueSize = 1000
 	config.Monitor.IntervalSec = 10 * time.Second
 	config.Monitor.HeartbeatIntMin = 120 * time.Minute
 	config.Monitor.DiscoveryMonitorIntMin = 120 * time.Minute
+	config.Monitor.MutedEvents = []string{"appstarted", "connclosed"}
+	config.Deposits.Confirmations = 3
+	config.Deposits.IntervalSec = 30 * time.Second
+	config.Limits.MinTip = "0.001"
+	config.Limits.MaxTip = "0.1"
+	config.Limits.DailyCap = "10"
+	config.Limits.HourlyOutflowCap = "100"
+	config.Limits.TipsPerMinute = 5
+	config.TwoFactor.Issuer = "Wing Commander"
+	config.TwoFactor.LargeTip = "1"
+	config.TwoFactor.MaxFailures = 5
+	config.TwoFactor.LockoutMin = 15 * time.Minute
+	config.TwoFactor.AdminSessionMin = 10 * time.Minute
 
 	if diff := deep.Equal(config.String(), expectstr); diff != nil {
 		t.Error(diff)
@@ -27,28 +27,86 @@ const (
 		"  -v       display application version information.\n" +
 		"  -config  display application configuration information.\n" +
 		"  -help    display this message.\n" +
-		"  -about   display information about the application and its author.\n\n\n" +
-		MsgHelpShort
+		"  -about   display information about the application and its author.\n" +
+		"  -migrate apply database schema migrations and exit.\n" +
+		"  -rotate-master-key -new-master-key-file <file>\n" +
+		"           re-encrypt every stored secret key with the new master key and exit.\n" +
+		"           The new key may instead be provided by the master key environment\n" +
+		"           variable with a _NEW suffix (i.e. WINGCOMMANDER_MASTER_KEY_NEW).\n" +
+		"  -verify-derivations\n" +
+		"           check every stored address matches the address derived from the wallet seed and exit.\n\n\n" +
+		MsgHelpShort + "\n\n" + MsgHelpAdmin
 
 	// Bot command messages:
 	// Help message
 	MsgHelpShort = "*Telegram Usage:*\n" +
 		"- /help - show this message.\n" +
 		"- /about - show information and credits about my creator and any contributors.\n" +
+		"- /createaddress - create (or show) your Skycoin address.\n" +
+		"- /balance - show your balance and the on-chain balance of your address.\n" +
+		"- /sendsky <amount> @user [memo] - tip SKY to another Telegram user. Tips settle instantly without an on-chain transaction.\n" +
+		"- /tip <amount> [memo] - in a tipping group, reply to a message with this command to tip its author. /sendsky <amount> @user also works in tipping groups.\n" +
+		"- /withdraw <amount|all> <address> - send SKY from your balance to a Skycoin address. You'll be asked to confirm the withdrawal before it is sent. Use /withdraw on its own to be asked for the amount and address.\n" +
+		"- /cancel - cancel the command waiting for your reply.\n" +
+		"- /limits - show your tip limits and how much you have sent today.\n" +
+		"- /history [page] - show your tips, deposits and withdrawals.\n" +
+		"- /export - send your full history as a CSV file.\n" +
+		"- /2fa [setup|<code>|disable <code>] - set up two-factor authentication, or send the code a command is waiting for.\n" +
+		"- /menu - request the menu keyboard to be displayed."
+
+	// Help for the commands which need a moderator or admin role
+	MsgHelpAdmin = "*Moderator Commands:*\n" +
 		"- /status - request a status update. This provides the same information as the Heartbeat.\n" +
+		"- /nodes - list the Nodes connected to the Manager.\n" +
+		"- /node <key prefix> - show the details of a connected Node.\n" +
+		"- /uptime - dynamically generate a link to the Skywirenc.com site to check uptime for locally connected Nodes.\n" +
+		"- /users [page] - list the users of the Bot and their roles.\n" +
+		"- /whois <@user|id> - show the role, address, balance, join date and last activity of a user.\n" +
+		"- /ban <@user|id> - ignore every message from a user.\n" +
+		"- /unban <@user|id> - lift a ban.\n" +
+		"- Forward me a message from someone to add them as a user.\n" +
+		"\n" +
+		"*Admin Commands:*\n" +
+		"- /promote <@user|id> - promote a user to moderator, or a moderator to admin.\n" +
+		"- /demote <@user|id> - demote an admin to moderator, or a moderator to user.\n" +
+		"- /setlimit <@user|id> [limit] [amount|none|default] - show or override the limits of a user.\n" +
+		"- /reset2fa <@user|id> - remove the two-factor authentication of a user who has lost their authenticator.\n" +
 		"- /showconfig - display runtime configuration (from config.toml).\n" +
+		"- /mute [event] - list the kinds of monitor events, or stop reporting a kind of event.\n" +
+		"- /unmute <event> - report a kind of monitor event again.\n" +
 		"- /start - start activly monitoring your Skyminer. Once started, notifications will be sent to you for events that occur. A heartbeat will also be initiated to let you know if the bot and the Miner are still running.\n" +
 		"- /stop - stop monitoring your Skyminer. Once stopped, I won't send any more notifications.\n" +
 		"- /checkupdate - check GitHub for new updates.\n" +
-		"- /update - attempt to update *Wing Commander* to the latest version from GitHub source.\n" +
-		"- /uptime - dynamically generate a link to the Skywirenc.com site to check uptime for locally connected Nodes.\n" +
-		"- /menu - request the menu keyboard to be displayed."
+		"- /update - attempt to update *Wing Commander* to the latest version from GitHub source."
 
-	MsgHelp = "*Wing Commander* here. I will help you to manage and monitor your Skyminer and its Nodes.\n\n" +
-		MsgHelpShort +
-		"\n" +
-		"\n" +
-		"Note: I am bound to this chat. I will only respond to commands from my configured Admin (%s)."
+	MsgHelp = "*Wing Commander* here. I will help you to manage and monitor your Skyminer and its Nodes, and to send SKY to each other.\n\n" +
+		MsgHelpShort
+
+	MsgNotPermitted = "Sorry, you don't have permission to use '/%s'."
+
+	// Admin cmd messages. Every admin action is recorded in the audit log.
+	MsgAdminUsage         = "*Usage:* /%s <@user|Telegram user ID>"
+	MsgAdminUnknownUser   = "I don't know %s. They need to talk to me first, or use their Telegram user ID."
+	MsgAdminSelf          = "You can't change your own role."
+	MsgAdminOwner         = "The owner of the Bot is always an admin."
+	MsgAdminOutranked     = "You don't have permission to change the role of %s."
+	MsgAdminAlreadyBanned = "%s is already banned."
+	MsgAdminNotBanned     = "%s isn't banned."
+	MsgAdminPromoteBanned = "%s is banned. Use /unban first."
+	MsgAdminCantPromote   = "%s is already an admin."
+	MsgAdminCantDemote    = "%s can't be demoted any further. Use /ban to ban them."
+	MsgAdminRoleChanged   = "✅ %s is now *%s*."
+	MsgAdminForwardBot    = "Bots can't be added as users."
+	MsgAdminUserKnown     = "%s is already known, their role is *%s*."
+	MsgAdminUserAdded     = "✅ %s has been added as a user."
+	MsgUsersUsage         = "*Usage:* /users [page]"
+	MsgUsersHeader        = "*Users* (page %d)\n"
+	MsgUsersNone          = "No users."
+	MsgUsersLine          = "- %s *%s*, last active %s\n"
+	MsgWhoisMember        = "*User:* %s\n*Telegram ID:* `%d`\n*Role:* %s\n*Joined:* %s\n*Last active:* %s\n"
+	MsgWhoisNotMember     = "*User:* %s\n_Hasn't talked to me yet._\n"
+	MsgWhoisNoWallet      = "*Wallet:* none"
+	MsgWhoisWallet        = "*Address:* `%s`\n*Balance:* %s SKY\n*Wallet created:* %s"
 
 	// About cmd message
 	MsgAbout = "*Wing Commander (" + BotVersion + ")*\n" +
@@ -84,6 +142,153 @@ const (
 	MsgNodeConnected    = "*Node Connected:* %s\n\n" + MsgConnectedNodes
 	MsgNodeDisconnected = "‼ *Node Disconnected:* %s\n\n" + MsgConnectedNodes
 
+	// App and connection event messages
+	MsgAppStarted         = "✅ *%s started* on Node %s"
+	MsgAppStopped         = "⚠️ *%s stopped* on Node %s"
+	MsgConnInboundOpened  = "🔗 *New inbound connection* to %s on Node %s from %s on %s"
+	MsgConnInboundClosed  = "*Inbound connection closed* to %s on Node %s from %s on %s"
+	MsgConnOutboundOpened = "🔗 *New outbound connection* from %s on Node %s to %s on %s"
+	MsgConnOutboundClosed = "*Outbound connection closed* from %s on Node %s to %s on %s"
+
+	// Wallet messages
+	MsgErrorWallet = "⚠️ Sorry, there was a problem talking to the Skycoin wallet. Please try again later."
+	MsgBalance     = "*Balance:* %s SKY\n" +
+		"*On-chain:* %s SKY, %d coin hours\n" +
+		"*Address:* `%s`\n\n" +
+		"_Your balance is what you can tip and withdraw. Tips between Bot users settle instantly and don't change the on-chain balance._"
+	MsgErrorStore = "⚠️ Sorry, there was a problem accessing the wallet database. Please try again later."
+	MsgNoUserName = "You need to set a Telegram username before you can use the wallet."
+	MsgAddress    = "*Your Skycoin address:*\n`%s`"
+
+	// Send SKY cmd messages
+	MsgSendSkyUsage = "*Usage:* /sendsky <amount> @user [memo]\n" +
+		"Amounts are in SKY (i.e. `1.5`) or droplets (i.e. `1000drops`). At most 3 decimal places are supported."
+	MsgSendSkyNoWallet     = "You don't have a wallet yet. Use /createaddress to create one and deposit some SKY first."
+	MsgSendSkyToSelf       = "You can't send SKY to yourself."
+	MsgSendSkyInsufficient = "⚠️ Insufficient balance. You tried to send %s SKY but your balance is %s SKY."
+	MsgSendSkyReceipt      = "✅ *Sent* %s SKY to %s\n*Your balance:* %s SKY"
+	MsgSendSkyReceived     = "💰 %s sent you %s SKY\n*Your balance:* %s SKY"
+	MsgDepositReceived     = "💰 *Deposit received*\n*Amount:* %s SKY\n*TxID:* `%s`\n*Your balance:* %s SKY"
+	MsgSendSkyMemo         = "\n*Memo:* %s"
+
+	// Group tipping messages. Balances and addresses must never be sent to a group.
+	MsgGroupSendSkyUsage = "*Usage:* /sendsky <amount> @user [memo]"
+	MsgGroupTipUsage     = "*Usage:* reply to a message with /tip <amount> [memo] to tip its author."
+	MsgGroupTipBot       = "Bots can't be tipped."
+	MsgGroupNoWallet     = "%s, you don't have a wallet yet. Send me /createaddress in a private chat to create one and deposit some SKY first."
+	MsgGroupInsufficient = "⚠️ %s, your balance doesn't cover that tip. Send me /balance in a private chat to check it."
+	MsgGroupTip          = "✅ %s tipped %s %s SKY"
+	MsgGroupHelp         = "*Wing Commander* tipping:\n" +
+		"- reply to a message with /tip <amount> [memo] to tip its author.\n" +
+		"- /sendsky <amount> @user [memo] to tip a user by name.\n\n" +
+		"Talk to me in a private chat to create your wallet, deposit, check your balance and withdraw."
+
+	// Withdraw cmd messages
+	MsgWithdrawAskAmount     = "How much SKY do you want to withdraw? Reply with an amount (i.e. `1.5`) or `all`, or use /cancel."
+	MsgWithdrawAskAddress    = "Which Skycoin address do you want to withdraw %s to? Reply with the address, or use /cancel."
+	MsgWithdrawInvalidAmount = "⚠️ `%s` is not a valid amount. Reply with an amount (i.e. `1.5`) or `all`, or use /cancel."
+	MsgWithdrawUsage         = "*Usage:* /withdraw <amount|all> <skycoin address>\n" +
+		"Amounts are in SKY (i.e. `1.5`) or droplets (i.e. `1000drops`). At most 3 decimal places are supported."
+	MsgWithdrawInvalidAddress = "⚠️ `%s` is not a valid Skycoin address. Please check it and try again."
+	MsgWithdrawToSelf         = "That is your own deposit address. Please withdraw to an address in a wallet you control."
+	MsgWithdrawInsufficient   = "⚠️ Insufficient balance. You tried to withdraw %s SKY but your balance is %s SKY."
+	MsgWithdrawUnavailable    = "⚠️ Sorry, the wallet can't cover a withdrawal of %s SKY right now. Please try a smaller amount or try again later."
+	MsgWithdrawNoHours        = "⚠️ Sorry, the wallet doesn't hold enough coin hours to pay the transaction fee right now. Please try again later."
+	MsgWithdrawConfirm        = "*Withdraw* %s SKY\n*To:* `%s`\n*Coin hours burned:* %d (estimated)\n\n" +
+		"Please check the address carefully, withdrawals can't be reversed. Press *confirmwithdraw* within %d minutes to send."
+	MsgWithdrawNothingPending = "You don't have a withdrawal waiting to be confirmed. Use /withdraw to start one."
+	MsgWithdrawExpired        = "⌛ Your withdrawal wasn't confirmed in time and has been cancelled. Use /withdraw to start again."
+	MsgWithdrawReplaced       = "That withdrawal has been replaced by a later one. Use the buttons of your latest withdrawal."
+	MsgWithdrawCancelled      = "Your withdrawal has been cancelled."
+	MsgWithdrawInProgress     = "Your withdrawal is already being sent."
+	MsgWithdrawBroadcast      = "📤 *Withdrawal sent* %s SKY to `%s`\n*TxID:* `%s`\n*Coin hours burned:* %d\n*Your balance:* %s SKY\n\n" +
+		"I'll let you know once it has been confirmed."
+	MsgWithdrawFailed    = "⚠️ Sorry, your withdrawal of %s SKY failed and nothing was sent. Your balance has not changed."
+	MsgWithdrawConfirmed = "✅ *Withdrawal confirmed* %s SKY to `%s`\n*TxID:* `%s`"
+	MsgWithdrawUnknown   = "⏳ Your withdrawal of %s SKY to `%s` may not have reached the network.\n*TxID:* `%s`\n\n" +
+		"I'll let you know once it has been confirmed. If it never arrives your balance will be refunded."
+	MsgWithdrawRefunded = "⚠️ Your withdrawal of %s SKY to `%s` (TxID `%s`) never reached the network. Your balance has been refunded."
+
+	// Limit messages
+	MsgLimitMinTip     = "⚠️ The minimum tip is %s SKY."
+	MsgLimitMaxTip     = "⚠️ The maximum tip is %s SKY."
+	MsgLimitDailyCap   = "⚠️ Sorry, that would take you over your daily limit of %s SKY. Use /limits to see how much you have sent today."
+	MsgLimitTipRate    = "⚠️ Slow down! You can send up to %d tips a minute."
+	MsgLimitOutflowCap = "⚠️ Sorry, withdrawals are paused because the hourly withdrawal limit has been reached. Please try again later."
+	MsgLimits          = "*Your limits*\n*Min tip:* %s\n*Max tip:* %s\n*Daily limit:* %s (tips and withdrawals)\n" +
+		"*Sent in the last 24 hours:* %s SKY\n*Tips per minute:* %s"
+	MsgLimitNone            = "none"
+	MsgLimitOverridden      = " (set by an admin)"
+	MsgSetLimitUsage        = "*Usage:* /setlimit <@user|id> [mintip|maxtip|dailycap|tipsperminute] [amount|none|default]"
+	MsgSetLimitUser         = "*Limits of %s*\n*Min tip:* %s\n*Max tip:* %s\n*Daily limit:* %s\n*Tips per minute:* %s"
+	MsgSetLimitInvalidValue = "⚠️ %s isn't a valid value for %s. Use an amount, a number of tips, `none` or `default`."
+	MsgSetLimitSet          = "✅ The %s limit of %s is now %s."
+	MsgSetLimitDefault      = "✅ The %s limit of %s is back to the configured value."
+	MsgSetLimitNotSet       = "The %s limit of %s isn't overridden."
+
+	// History cmd messages
+	MsgHistoryUsage        = "*Usage:* /history [page]"
+	MsgHistoryHeader       = "*History* (page %d)\n"
+	MsgHistoryNone         = "Nothing yet. Your tips, deposits and withdrawals will be listed here."
+	MsgHistoryLine         = "\n%s *%s* %s SKY"
+	MsgHistoryCounterparty = "\n  %s"
+	MsgHistoryMemo         = "\n  _%s_"
+	MsgHistoryTxID         = "\n  `%s`"
+	MsgHistoryStatus       = " (%s)"
+	MsgExportCaption       = "Your tips, deposits and withdrawals. Amounts are in SKY."
+
+	// Node cmd messages
+	MsgNodeUsage     = "*Usage:* /node <key prefix>"
+	MsgNodesHeader   = "*Connected Nodes* (%d)\n"
+	MsgNodesNone     = "No Nodes are connected to the Manager."
+	MsgNodesUpdated  = "\n_Updated %s_"
+	MsgNodeNotFound  = "No connected Node has a key starting with `%s`."
+	MsgNodeAmbiguous = "%d connected Nodes have a key starting with `%s`. Pick one:\n"
+	MsgNodeDetails   = "*Node* `%s`\n*Type:* %s\n*Sent:* %s\n*Received:* %s\n*Last ack:* %s ago\n*Connected for:* %s\n"
+	MsgNodeApps      = "*Apps:* %s\n"
+	MsgNodeAppsNone  = "none"
+	MsgNodeAppsError = "_unavailable_"
+
+	// Mute cmd messages
+	MsgMuteUsage = "*Usage:* /%s <event>\nEvents: %s"
+	MsgMuteList  = "*Monitor events*\n%s\nUse /mute <event> or /unmute <event>. Muted events are reported again after a restart, unless they are listed in `mutedevents`."
+	MsgMuteLine  = "%s `%s`\n"
+	MsgMuted     = "🔇 %s events are now muted."
+	MsgUnmuted   = "🔔 %s events are no longer muted."
+
+	// Inline keyboard messages
+	MsgCallbackExpired      = "This button has expired. Please use the command again."
+	MsgCallbackNotPermitted = "Sorry, you aren't permitted to use /%s."
+
+	// Conversation messages
+	MsgConversationCancelled = "%s has been cancelled."
+	MsgConversationNone      = "There is nothing to cancel."
+	MsgConversationExpired   = "⌛ /%[1]s timed out waiting for your reply. Use /%[1]s to start again."
+	MsgConversationRetry     = "Reply again, or use /cancel."
+
+	// Two-factor authentication messages
+	MsgTwoFactorUsage       = "*Usage:* /2fa [setup|<code>|disable <code>]"
+	MsgTwoFactorUnavailable = "Two-factor authentication isn't enabled on this Bot."
+	MsgTwoFactorStatusOff   = "Two-factor authentication is *off*. Use /2fa setup to turn it on."
+	MsgTwoFactorStatusOn    = "Two-factor authentication is *on*. Use /2fa disable <code> to turn it off."
+	MsgTwoFactorSetup       = "🔐 *Two-factor authentication*\nAdd this account to your authenticator app by scanning the QR code below or opening:\n`%s`\n\n" +
+		"*Secret:* `%s`\n\nThen reply with the 6 digit code from the app (or send /2fa <code>) to turn it on. Don't share the secret with anyone."
+	MsgTwoFactorAskCode       = "Reply with the 6 digit code from your authenticator app, or use /cancel."
+	MsgTwoFactorQRCaption     = "Scan this QR code with your authenticator app."
+	MsgTwoFactorAlreadySetUp  = "Two-factor authentication is already on. Use /2fa disable <code> before setting it up again."
+	MsgTwoFactorEnabled       = "✅ Two-factor authentication is now *on*. Withdrawals, large tips and the moderator and admin commands will ask for a code."
+	MsgTwoFactorValid         = "✅ Code accepted."
+	MsgTwoFactorAccepted      = "✅ Code accepted, running /%s."
+	MsgTwoFactorDisabled      = "Two-factor authentication is now *off*."
+	MsgTwoFactorNotSetUp      = "You haven't set up two-factor authentication. Use /2fa setup to set it up."
+	MsgTwoFactorInvalid       = "⚠️ Wrong code. %d attempt(s) left before you are locked out."
+	MsgTwoFactorLocked        = "⛔ Too many wrong codes. Two-factor authentication is locked until %s."
+	MsgTwoFactorRequired      = "🔐 /%s needs your two-factor code. Send /2fa <code> within %d minutes to run it."
+	MsgTwoFactorSetupFirst    = "🔐 /%s needs two-factor authentication. Use /2fa setup to set it up first."
+	MsgResetTwoFactorUsage    = "*Usage:* /reset2fa <@user|id>"
+	MsgResetTwoFactorDone     = "✅ The two-factor authentication of %s has been removed."
+	MsgResetTwoFactorNotSetUp = "%s hasn't set up two-factor authentication."
+
 	// Start cmd messages
 	MsgMonitorAlreadyStarted = "️️*Wing Commander* Monitoring has already been started."
 	MsgMonitorStart          = "*Wing Commander* Monitoring starting..."
@@ -95,11 +300,8 @@ const (
 
 	// OS Interrupt Signals
 	MsgOSInteruptSig = "*Wing Commander* OS Interupt Signal Received. Exiting."
+	MsgOSForcedExit  = "*Wing Commander* Second OS Signal Received. Exiting without shutting down."
 
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "master"
-	dbname   = "skycoinbot"
-
+	// Shutdown messages
+	MsgSigningOff = "*Signing off: %s*"
 )